@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/table"
@@ -25,6 +30,7 @@ const (
 	viewTorrentDetails
 	viewSettings
 	viewAddTorrent
+	viewVerify
 )
 
 // Model represents the CLI application state
@@ -42,6 +48,9 @@ type Model struct {
 	selectedIdx   int
 	selectedInfo  string // Track selected torrent by info hash
 	torrentKeys   []string // Ordered list of info hashes
+	sortColumn    string   // Column the list is sorted by
+	sortDesc      bool     // Sort direction
+	displayMode   string   // Main list layout, see displayModes
 
 	// Components
 	mainTable   table.Model
@@ -51,6 +60,38 @@ type Model struct {
 	// Input state
 	inputMode   bool
 	inputPrompt string
+	addDir      string // download directory chosen with [A]
+
+	// Only torrents with this label are listed, cycled with [L]
+	labelFilter string
+
+	// Quit confirmation state
+	confirmQuit bool
+	noConfirm   bool
+
+	// Info hash of the torrent waiting for a delete confirmation, see [d]
+	confirmDelete string
+
+	// Persistence failure, reported once in the status line
+	persistErr    error
+	persistWarned bool
+
+	// Tracker scrape results by info hash, fetched when details are opened
+	scrapes map[string]scrapeMsg
+
+	// File pane of the details view, focused with [tab]; while focused,
+	// up/down move fileIdx instead of the torrent selection
+	fileFocus bool
+	fileIdx   int
+
+	// Result of the last recheck, shown in the verify view
+	verifyResult *engine.VerifyResult
+
+	// Engine events, refreshing the list as soon as torrents change
+	events <-chan engine.Event
+
+	// Torrents pushed by a daemon, replacing polling while it lasts
+	stream <-chan map[string]*engine.Torrent
 
 	// Error/success messages
 	statusMsg   string
@@ -107,6 +148,7 @@ func NewModel(e engine.EngineInterface) Model {
 		{Title: "Progress", Width: 10},
 		{Title: "Size", Width: 12},
 		{Title: "Down", Width: 12},
+		{Title: "Ratio", Width: 8},
 		{Title: "Status", Width: 10},
 	}
 
@@ -145,6 +187,8 @@ func NewModel(e engine.EngineInterface) Model {
 		engine:      e,
 		currentView: viewMain,
 		torrents:    make(map[string]*engine.Torrent),
+		sortColumn:  "name",
+		displayMode: displayTable,
 		mainTable:   t,
 		progressBar: prog,
 		textInput:   ti,
@@ -156,6 +200,8 @@ func NewModel(e engine.EngineInterface) Model {
 func (m Model) Init() tea.Cmd {
 	return tea.Batch(
 		tickCmd(),
+		eventCmd(m.events),
+		streamCmd(m.stream),
 		tea.EnterAltScreen,
 	)
 }
@@ -171,14 +217,90 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyMsg:
+		if m.confirmQuit {
+			return m.handleConfirmQuit(msg)
+		}
+		if m.confirmDelete != "" {
+			return m.handleConfirmDelete(msg)
+		}
 		if m.inputMode {
 			return m.handleInputMode(msg)
 		}
+		if m.currentView == viewTorrentDetails && m.fileFocus {
+			if next, ok := m.handleFileKey(msg); ok {
+				return next, nil
+			}
+		}
 		return m.handleKeyPress(msg)
 
 	case tickMsg:
-		m.updateTorrentStats()
+		if m.stream == nil {
+			m.updateTorrentStats()
+		}
+		m.checkPersistence()
 		return m, tickCmd()
+
+	case eventMsg:
+		m.updateTorrentStats()
+		if msg.Type == engine.EventDiskFull {
+			m.statusMsg = "Disk almost full: downloads paused until space is freed"
+			m.statusStyle = m.styles.Error
+		}
+		return m, eventCmd(m.events)
+
+	case streamMsg:
+		if msg == nil {
+			// the stream ended, back to polling
+			m.stream = nil
+			m.updateTorrentStats()
+			return m, nil
+		}
+		m.setTorrents(engine.SummarizeTorrents(msg), msg)
+		return m, streamCmd(m.stream)
+
+	case scrapeMsg:
+		if m.scrapes == nil {
+			m.scrapes = map[string]scrapeMsg{}
+		}
+		m.scrapes[msg.infohash] = msg
+		return m, nil
+
+	case addURLMsg:
+		switch {
+		case msg.err != nil:
+			m.statusMsg = fmt.Sprintf("Error adding torrent: %v", msg.err)
+			m.statusStyle = m.styles.Error
+		case msg.dir != "":
+			m.statusMsg = fmt.Sprintf("Torrent added to %s", msg.dir)
+			m.statusStyle = m.styles.Success
+		default:
+			m.statusMsg = "Torrent added successfully!"
+			m.statusStyle = m.styles.Success
+		}
+		m.updateTorrentStats()
+		return m, nil
+
+	case moveMsg:
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("Move failed: %v", msg.err)
+			m.statusStyle = m.styles.Error
+		} else {
+			m.statusMsg = fmt.Sprintf("Moved to %s", msg.dir)
+			m.statusStyle = m.styles.Success
+		}
+		m.updateTorrentStats()
+		return m, nil
+
+	case verifyMsg:
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("Verify failed: %v", msg.err)
+			m.statusStyle = m.styles.Error
+			return m, nil
+		}
+		m.statusMsg = ""
+		m.verifyResult = msg.result
+		m.currentView = viewVerify
+		return m, nil
 	}
 
 	// Update appropriate component based on mode
@@ -212,6 +334,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // View renders the UI
 func (m Model) View() string {
+	if m.confirmQuit {
+		return m.renderConfirmQuit()
+	}
+	if m.confirmDelete != "" {
+		return m.renderConfirmDelete()
+	}
 	if m.inputMode {
 		return m.renderInputMode()
 	}
@@ -223,6 +351,8 @@ func (m Model) View() string {
 		return m.renderDetailsView()
 	case viewSettings:
 		return m.renderSettingsView()
+	case viewVerify:
+		return m.renderVerifyView()
 	default:
 		return "Unknown view"
 	}
@@ -232,11 +362,19 @@ func (m Model) renderMainView() string {
 	title := m.styles.Title.Render("🌊 Intunja: V0.0.1")
 
 	config := m.engine.Config()
+	filter := ""
+	if m.labelFilter != "" {
+		filter = fmt.Sprintf(" | Label: %s", m.labelFilter)
+	}
 	subtitle := m.styles.Subtitle.Render(fmt.Sprintf(
-		"Active: %d torrents | Download Dir: %s | Port: %d",
-		len(m.torrents),
+		"Active: %s torrents | Ratio: %s | Download Dir: %s | Port: %s | Sort: %s %s%s",
+		formatTorrentCount(len(m.torrents), config.MaxTorrents),
+		formatRatio(engine.TotalRatio(m.torrents)),
 		config.DownloadDirectory,
-		config.IncomingPort,
+		formatListenPort(config.IncomingPort, m.engine),
+		m.sortColumn,
+		sortDirection(m.sortDesc),
+		filter,
 	))
 
 	// Build table rows with safety checks
@@ -249,6 +387,12 @@ func (m Model) renderMainView() string {
 		}
 
 		status := "Stopped"
+		if t.SeedingComplete {
+			status = "Seeded"
+		}
+		if t.PausedAll {
+			status = "Paused"
+		}
 		if t.Started {
 			status = "Active"
 		}
@@ -261,6 +405,7 @@ func (m Model) renderMainView() string {
 			fmt.Sprintf("%.1f%%", t.Percent),
 			formatBytes(t.Size),
 			formatBytes(int64(t.DownloadRate)) + "/s",
+			formatRatio(t.Ratio),
 			status,
 		})
 	}
@@ -269,10 +414,18 @@ func (m Model) renderMainView() string {
 	m.mainTable.SetRows(rows)
 
 	tableView := m.mainTable.View()
+	switch m.displayMode {
+	case displayCompact:
+		tableView = m.renderCompact()
+	case displayGrouped:
+		tableView = m.renderGrouped()
+	}
 
 	// Show message if no torrents
 	emptyMsg := ""
-	if len(rows) == 0 {
+	if len(rows) == 0 && m.labelFilter != "" {
+		emptyMsg = m.styles.Subtitle.Render(fmt.Sprintf("\nNo torrents labelled %q. Press [L] to change the filter.\n", m.labelFilter))
+	} else if len(rows) == 0 {
 		emptyMsg = m.styles.Subtitle.Render("\nNo active torrents. Press [m] to add a magnet link or [a] to add a torrent file.\n")
 	}
 
@@ -283,7 +436,7 @@ func (m Model) renderMainView() string {
 	}
 
 	help := m.styles.Help.Render(
-		"[a] Add  [m] Magnet  [Enter] Details  [s] Start  [p] Pause  [d] Delete  [c] Config  [q] Quit",
+		"[a] Add  [A] Add to dir  [m] Magnet  [Enter] Details  [s] Start  [p] Pause  [P/S] Pause/resume all  [M] Move  [y] Copy magnet  [d] Delete  [e] Labels  [L] Filter  [O/R] Sort/reverse  [t] Layout  [c] Config  [q] Quit",
 	)
 
 	return lipgloss.JoinVertical(
@@ -322,30 +475,38 @@ func (m Model) renderDetailsView() string {
 		fmt.Sprintf("Progress: %s %.1f%%", m.progressBar.ViewAs(float64(t.Percent)/100.0), t.Percent),
 		fmt.Sprintf("Size: %s", formatBytes(t.Size)),
 		fmt.Sprintf("Downloaded: %s", formatBytes(t.Downloaded)),
-		fmt.Sprintf("Download Rate: %s/s", formatBytes(int64(t.DownloadRate))),
-		fmt.Sprintf("Status: %s", map[bool]string{true: "Active", false: "Stopped"}[t.Started]),
+		fmt.Sprintf("Uploaded: %s (ratio %s)", formatBytes(t.Uploaded), formatRatio(t.Ratio)),
+		fmt.Sprintf("Download Rate: %s/s (limit %s)", formatBytes(int64(t.DownloadRate)), formatLimit(t.MaxDownloadRate)),
+		fmt.Sprintf("Upload Rate: %s/s (limit %s)", formatBytes(int64(t.UploadRate)), formatLimit(t.MaxUploadRate)),
+		fmt.Sprintf("Peers: S: %d / L: %d (%d from DHT)", t.Seeds, t.Leechers, t.DHTPeers),
+		fmt.Sprintf("Swarm: %s", m.describeScrape(key)),
+		fmt.Sprintf("Swarm Size: %s", m.describeSwarmSize(key)),
+		fmt.Sprintf("Added: %s", formatAgo(t.AddedAt)),
+		fmt.Sprintf("Completed: %s", formatAgo(t.CompletedAt)),
+		fmt.Sprintf("Status: %s", describeStatus(t)),
+		fmt.Sprintf("Private: %s", map[bool]string{true: "Yes (no DHT or PEX)", false: "No"}[t.Private]),
+		fmt.Sprintf("Sequential: %s", map[bool]string{true: "Yes (pieces in order)", false: "No"}[t.Sequential]),
+		fmt.Sprintf("Seed Only: %s", map[bool]string{true: "Yes (not downloading)", false: "No"}[t.SeedOnly]),
+		fmt.Sprintf("On Complete: %s", describePolicy(t.OnComplete, m.engine.Config().OnComplete)),
+		fmt.Sprintf("Directory: %s", downloadDir(t, m.engine.Config())),
+		fmt.Sprintf("Labels: %s", formatLabels(t.Labels)),
+		fmt.Sprintf("Debug Log: %s", map[bool]string{true: "On", false: "Off"}[m.engine.TorrentDebug(t.InfoHash)]),
 		"",
 		fmt.Sprintf("Files: %d", len(t.Files)),
 	)
 
-	// Show files if available
-	if len(t.Files) > 0 && len(t.Files) <= 20 {
-		info += "\n\nFiles:\n"
-		for i, f := range t.Files {
-			if i >= 10 {
-				info += fmt.Sprintf("  ... and %d more files\n", len(t.Files)-10)
-				break
-			}
-			if f != nil {
-				info += fmt.Sprintf("  [%.0f%%] %s (%s)\n",
-					f.Percent,
-					filepath.Base(f.Path),
-					formatBytes(f.Size))
-			}
-		}
+	for _, w := range t.Warnings {
+		info += "\n" + m.styles.Error.Render("Warning: "+w)
+	}
+
+	if len(t.Files) > 0 {
+		info += "\n\n" + m.renderFiles(t)
 	}
 
-	help := m.styles.Help.Render("[esc] Back  [s] Start  [p] Pause  [d] Delete")
+	help := m.styles.Help.Render("[esc] Back  [tab] Files  [s] Start  [p] Pause  [o] Seed only  [i] Sequential  [f] On complete  [r] Reannounce  [v] Verify  [y] Copy magnet  [l] Debug log  [d] Delete")
+	if m.fileFocus {
+		help = m.styles.Help.Render("[tab] Leave files  [up/down] Select  [space] Skip/download file  [g/G] First/last")
+	}
 
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
@@ -357,6 +518,47 @@ func (m Model) renderDetailsView() string {
 	)
 }
 
+// renderVerifyView shows the per-file result of the last recheck
+func (m Model) renderVerifyView() string {
+	r := m.verifyResult
+	if r == nil {
+		return m.styles.Error.Render("No verification result\n\nPress [Esc] to go back")
+	}
+
+	name := r.InfoHash
+	if t := m.torrents[r.InfoHash]; t != nil {
+		name = t.Name
+	}
+	title := m.styles.Title.Render("Verification: " + name)
+
+	summary := fmt.Sprintf("Valid pieces: %d / %d", r.ValidPieces, r.TotalPieces)
+	if r.ValidPieces == r.TotalPieces {
+		summary = m.styles.Success.Render(summary + " - all data is intact")
+	} else {
+		summary = m.styles.Error.Render(summary)
+	}
+
+	lines := []string{summary, ""}
+	for _, f := range r.Files {
+		line := fmt.Sprintf("  %s  good: %d  bad: %d", truncate(f.Path, 50), f.GoodPieces, f.BadPieces)
+		if f.BadPieces > 0 {
+			line = m.styles.Error.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	help := m.styles.Help.Render("[esc] Back")
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		title,
+		"",
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+		"",
+		help,
+	)
+}
+
 // renderSettingsView shows configuration
 func (m Model) renderSettingsView() string {
 	title := m.styles.Title.Render("⚙️  Configuration")
@@ -366,10 +568,24 @@ func (m Model) renderSettingsView() string {
 	settings := lipgloss.JoinVertical(
 		lipgloss.Left,
 		fmt.Sprintf("Download Directory: %s", config.DownloadDirectory),
-		fmt.Sprintf("Incoming Port: %d", config.IncomingPort),
+		fmt.Sprintf("Watch Directory: %s", orNone(config.WatchDirectory)),
+		fmt.Sprintf("Incoming Port: %s", formatListenPort(config.IncomingPort, m.engine)),
+		fmt.Sprintf("Port Forwarding: %s", formatPortForwarding(config.EnablePortForwarding, m.engine)),
 		fmt.Sprintf("Upload Enabled: %t", config.EnableUpload),
 		fmt.Sprintf("Seeding Enabled: %t", config.EnableSeeding),
+		fmt.Sprintf("DHT Enabled: %t", config.EnableDHT),
+		fmt.Sprintf("PEX Enabled: %t", config.EnablePEX),
+		"  (private torrents never use DHT or PEX)",
+		fmt.Sprintf("Proxy: %s", formatProxy(config.ProxyURL)),
+		fmt.Sprintf("Blocklist: %s", formatBlocklist(config.BlocklistPath, m.engine)),
 		fmt.Sprintf("Auto Start: %t", config.AutoStart),
+		fmt.Sprintf("Download Limit: %s", formatLimit(config.MaxDownloadRate)),
+		fmt.Sprintf("Upload Limit: %s", formatLimit(config.MaxUploadRate)),
+		fmt.Sprintf("Seed Ratio Limit: %s", formatRatioLimit(config.SeedRatioLimit)),
+		fmt.Sprintf("On Complete Command: %s", orNone(config.OnCompleteCommand)),
+		fmt.Sprintf("Min Free Space: %s", formatMinFreeSpace(config.MinFreeSpace)),
+		fmt.Sprintf("Idle Peer Timeout: %s", formatIdlePeerTimeout(config.IdlePeerTimeout)),
+		fmt.Sprintf("Max Torrents: %s", formatMaxTorrents(config.MaxTorrents)),
 		fmt.Sprintf("Encryption: %s", map[bool]string{true: "Disabled", false: "Enabled"}[config.DisableEncryption]),
 	)
 
@@ -414,14 +630,25 @@ func (m Model) renderInputMode() string {
 func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "q", "ctrl+c":
-		return m, tea.Quit
+		return m.quit()
 
 	case "a":
 		// Add torrent file
 		m.inputMode = true
-		m.inputPrompt = "Enter .torrent file path:"
+		m.inputPrompt = "Enter .torrent file path or URL:"
+		m.textInput.SetValue("")
+		m.textInput.Placeholder = "/path/to/file.torrent or https://example.com/file.torrent"
+		m.textInput.Focus()
+		m.statusMsg = ""
+		return m, textinput.Blink
+
+	case "A":
+		// Add torrent to a chosen directory, asked for first
+		m.inputMode = true
+		m.inputPrompt = "Enter download directory:"
+		m.addDir = ""
 		m.textInput.SetValue("")
-		m.textInput.Placeholder = "/path/to/file.torrent"
+		m.textInput.Placeholder = m.engine.Config().DownloadDirectory
 		m.textInput.Focus()
 		m.statusMsg = ""
 		return m, textinput.Blink
@@ -439,6 +666,8 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "enter":
 		if m.currentView == viewMain && len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
 			m.currentView = viewTorrentDetails
+			m.fileFocus, m.fileIdx = false, 0
+			return m, scrapeCmd(m.engine, m.torrentKeys[m.selectedIdx])
 		}
 		return m, nil
 
@@ -464,6 +693,58 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case "e":
+		// Edit the labels of the selected torrent
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			t := m.torrents[m.torrentKeys[m.selectedIdx]]
+			if t != nil {
+				m.inputMode = true
+				m.inputPrompt = labelsPrompt
+				m.textInput.SetValue(strings.Join(t.Labels, ", "))
+				m.textInput.Placeholder = "linux, iso"
+				m.textInput.Focus()
+				m.statusMsg = ""
+				return m, textinput.Blink
+			}
+		}
+		return m, nil
+
+	case "O":
+		// Cycle the column the list is sorted by
+		m.sortColumn = nextSortColumn(m.sortColumn)
+		m.updateTorrentStats()
+		m.statusMsg = fmt.Sprintf("Sorted by %s (%s)", m.sortColumn, sortDirection(m.sortDesc))
+		m.statusStyle = m.styles.Success
+		return m, nil
+
+	case "R":
+		// Reverse the sort direction
+		m.sortDesc = !m.sortDesc
+		m.updateTorrentStats()
+		m.statusMsg = fmt.Sprintf("Sorted by %s (%s)", m.sortColumn, sortDirection(m.sortDesc))
+		m.statusStyle = m.styles.Success
+		return m, nil
+
+	case "L":
+		// Cycle the label filter through the labels in use
+		m.labelFilter = nextLabel(allLabels(m.torrents), m.labelFilter)
+		m.updateTorrentStats()
+		if m.labelFilter == "" {
+			m.statusMsg = "Showing all torrents"
+		} else {
+			m.statusMsg = fmt.Sprintf("Showing torrents labelled %q", m.labelFilter)
+		}
+		m.statusStyle = m.styles.Success
+		return m, nil
+
+	case "t":
+		// Cycle the main list layout
+		m.displayMode = nextDisplayMode(m.displayMode)
+		m.updateTorrentStats()
+		m.statusMsg = fmt.Sprintf("Layout: %s", m.displayMode)
+		m.statusStyle = m.styles.Success
+		return m, nil
+
 	case "s":
 		// Start torrent
 		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
@@ -498,27 +779,193 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case "P":
+		// Pause all running torrents
+		if err := m.engine.PauseAll(); err != nil {
+			m.statusMsg = fmt.Sprintf("Error: %v", err)
+			m.statusStyle = m.styles.Error
+		} else {
+			m.statusMsg = "Paused all torrents, [S] resumes them"
+			m.statusStyle = m.styles.Success
+		}
+		m.updateTorrentStats()
+		return m, nil
+
+	case "S":
+		// Resume the torrents paused with [P]
+		if err := m.engine.ResumeAll(); err != nil {
+			m.statusMsg = fmt.Sprintf("Error: %v", err)
+			m.statusStyle = m.styles.Error
+		} else {
+			m.statusMsg = "Resumed paused torrents"
+			m.statusStyle = m.styles.Success
+		}
+		m.updateTorrentStats()
+		return m, nil
+
+	case "o":
+		// Toggle seed-only mode
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			t := m.torrents[key]
+			if t != nil {
+				if err := m.engine.SetSeedOnly(key, !t.SeedOnly); err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
+					m.statusStyle = m.styles.Error
+				} else if t.SeedOnly {
+					m.statusMsg = fmt.Sprintf("Seed only: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
+				} else {
+					m.statusMsg = fmt.Sprintf("Downloading: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
+				}
+			}
+		}
+		return m, nil
+
+	case "f":
+		// Cycle the completion policy
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			t := m.torrents[key]
+			if t != nil {
+				next := nextPolicy(t.OnComplete)
+				if err := m.engine.SetCompletionPolicy(key, next); err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
+					m.statusStyle = m.styles.Error
+				} else {
+					m.statusMsg = fmt.Sprintf("On complete: %s", describePolicy(next, m.engine.Config().OnComplete))
+					m.statusStyle = m.styles.Success
+				}
+			}
+		}
+		return m, nil
+
 	case "d":
-		// Delete torrent
+		// Delete torrent, after asking whether to keep its files
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			if m.torrents[key] != nil {
+				m.confirmDelete = key
+				m.statusMsg = ""
+			}
+		}
+		return m, nil
+
+	case "i":
+		// Toggle sequential (in-order) download
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			t := m.torrents[key]
+			if t != nil {
+				if err := m.engine.SetSequential(key, !t.Sequential); err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
+					m.statusStyle = m.styles.Error
+				} else if t.Sequential {
+					m.statusMsg = fmt.Sprintf("Sequential: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
+				} else {
+					m.statusMsg = fmt.Sprintf("Rarest first: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
+				}
+			}
+		}
+		return m, nil
+
+	case "l":
+		// Toggle per-torrent debug logging
 		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
 			key := m.torrentKeys[m.selectedIdx]
 			t := m.torrents[key]
 			if t != nil {
-				torrentName := t.Name
-				if err := m.engine.DeleteTorrent(key); err != nil {
-					m.statusMsg = fmt.Sprintf("Error deleting torrent: %v", err)
+				on := !m.engine.TorrentDebug(key)
+				if err := m.engine.SetTorrentDebug(key, on); err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
+					m.statusStyle = m.styles.Error
+				} else if on {
+					m.statusMsg = fmt.Sprintf("Debug log on: %s (%s)", truncate(t.Name, 40), debugLogName)
+					m.statusStyle = m.styles.Success
+				} else {
+					m.statusMsg = fmt.Sprintf("Debug log off: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
+				}
+			}
+		}
+		return m, nil
+
+	case "r":
+		// Announce to trackers now instead of waiting for the interval
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			if t := m.torrents[key]; t != nil {
+				if err := m.engine.ReannounceTorrent(key); err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
 					m.statusStyle = m.styles.Error
 				} else {
-					m.statusMsg = fmt.Sprintf("Deleted: %s", truncate(torrentName, 40))
+					m.statusMsg = fmt.Sprintf("Reannounced: %s", truncate(t.Name, 40))
 					m.statusStyle = m.styles.Success
+				}
+			}
+		}
+		return m, nil
+
+	case "v":
+		// Recheck data and show the per-file report
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			if t := m.torrents[key]; t != nil {
+				m.statusMsg = fmt.Sprintf("Verifying: %s", truncate(t.Name, 40))
+				m.statusStyle = m.styles.Success
+				return m, verifyCmd(m.engine, key)
+			}
+		}
+		return m, nil
 
-					// Force immediate update to refresh torrent list
-					m.updateTorrentStats()
+	case "y":
+		// Copy the magnet link of the selected torrent
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			key := m.torrentKeys[m.selectedIdx]
+			if t := m.torrents[key]; t != nil {
+				link, err := magnetLink(t)
+				if err != nil {
+					m.statusMsg = fmt.Sprintf("Error: %v", err)
+					m.statusStyle = m.styles.Error
+				} else if err := copyToClipboard(link); err != nil {
+					// leave it on screen to be selected by hand
+					m.statusMsg = fmt.Sprintf("Could not copy (%v): %s", err, link)
+					m.statusStyle = m.styles.Error
+				} else {
+					m.statusMsg = fmt.Sprintf("Copied magnet link: %s", truncate(t.Name, 40))
+					m.statusStyle = m.styles.Success
 				}
 			}
 		}
 		return m, nil
 
+	case "M":
+		// Move the selected torrent's data to another directory
+		if len(m.torrentKeys) > 0 && m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+			if t := m.torrents[m.torrentKeys[m.selectedIdx]]; t != nil {
+				m.inputMode = true
+				m.inputPrompt = "Move data to directory:"
+				m.textInput.SetValue("")
+				m.textInput.Placeholder = downloadDir(t, m.engine.Config())
+				m.textInput.Focus()
+				m.statusMsg = ""
+				return m, textinput.Blink
+			}
+		}
+		return m, nil
+
+	case "tab":
+		// Focus the file list of the details view
+		if m.currentView == viewTorrentDetails {
+			if t := m.detailsTorrent(); t != nil && len(t.Files) > 0 {
+				m.fileFocus = true
+			}
+		}
+		return m, nil
+
 	case "c":
 		m.currentView = viewSettings
 		return m, nil
@@ -531,6 +978,107 @@ func (m Model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// activeDownloads counts torrents that are still downloading (not seeding).
+func (m Model) activeDownloads() int {
+	n := 0
+	for _, t := range m.torrents {
+		if t != nil && t.Started && !t.SeedOnly && t.CompletedAt.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// renderConfirmQuit asks whether to quit while downloads are active
+func (m Model) renderConfirmQuit() string {
+	title := m.styles.Title.Render("Quit Intunja?")
+	body := m.styles.Error.Render(fmt.Sprintf(
+		"%d torrent(s) are still downloading and will be interrupted.",
+		m.activeDownloads(),
+	))
+	help := m.styles.Help.Render("[y] Quit anyway  [n/Esc] Cancel")
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		title,
+		body,
+		"",
+		help,
+	)
+}
+
+// quit quits, first asking for confirmation while downloads are active
+// unless noConfirm is set.
+func (m Model) quit() (tea.Model, tea.Cmd) {
+	if !m.noConfirm && m.activeDownloads() > 0 {
+		m.confirmQuit = true
+		return m, nil
+	}
+	return m, tea.Quit
+}
+
+// handleConfirmQuit processes input while the quit confirmation is shown
+func (m Model) handleConfirmQuit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "q", "ctrl+c":
+		return m, tea.Quit
+	case "n", "esc":
+		m.confirmQuit = false
+	}
+	return m, nil
+}
+
+func (m Model) renderConfirmDelete() string {
+	name := m.confirmDelete
+	if t := m.torrents[m.confirmDelete]; t != nil {
+		name = t.Name
+	}
+	title := m.styles.Title.Render("Delete torrent?")
+	body := m.styles.Error.Render(truncate(name, 60))
+	help := m.styles.Help.Render("[y]es / [f] delete+files / [n]o")
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		title,
+		body,
+		"",
+		help,
+	)
+}
+
+// handleConfirmDelete processes input while the delete confirmation is
+// shown
+func (m Model) handleConfirmDelete(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := m.confirmDelete
+	var deleteData bool
+	switch msg.String() {
+	case "y":
+	case "f":
+		deleteData = true
+	case "n", "esc":
+		m.confirmDelete = ""
+		return m, nil
+	default:
+		return m, nil
+	}
+	m.confirmDelete = ""
+	name := key
+	if t := m.torrents[key]; t != nil {
+		name = t.Name
+	}
+	if err := m.engine.DeleteTorrent(key, deleteData); err != nil {
+		m.statusMsg = fmt.Sprintf("Error deleting torrent: %v", err)
+		m.statusStyle = m.styles.Error
+	} else if deleteData {
+		m.statusMsg = fmt.Sprintf("Deleted with files: %s", truncate(name, 40))
+		m.statusStyle = m.styles.Success
+	} else {
+		m.statusMsg = fmt.Sprintf("Deleted: %s", truncate(name, 40))
+		m.statusStyle = m.styles.Success
+	}
+	// Force immediate update to refresh torrent list
+	m.updateTorrentStats()
+	return m, nil
+}
+
 // handleInputMode processes input in input mode
 func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.Type {
@@ -538,6 +1086,13 @@ func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		// Process input
 		value := strings.TrimSpace(m.textInput.Value())
 
+		if m.inputPrompt == labelsPrompt {
+			// empty input clears the labels
+			m.inputMode = false
+			m.textInput.Blur()
+			return m.setLabels(parseLabels(value)), nil
+		}
+
 		if value == "" {
 			m.statusMsg = "Input cannot be empty"
 			m.statusStyle = m.styles.Error
@@ -547,10 +1102,52 @@ func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.inputMode = false
 		m.textInput.Blur()
 
-		if strings.Contains(m.inputPrompt, "magnet") {
-			// Sanitize magnet link and surface warnings about dropped trackers
-			sanitized, dropped, err := engine.SanitizeMagnet(value)
-			if err != nil {
+		if m.inputPrompt == "Enter download directory:" {
+			m.addDir = value
+			m.inputMode = true
+			m.inputPrompt = fmt.Sprintf("Add to %s (magnet URI, .torrent file path or URL):", value)
+			m.textInput.SetValue("")
+			m.textInput.Placeholder = "magnet:?xt=urn:btih:... or /path/to/file.torrent"
+			m.textInput.Focus()
+			return m, textinput.Blink
+		}
+
+		if m.inputPrompt == "Move data to directory:" {
+			if m.selectedIdx < 0 || m.selectedIdx >= len(m.torrentKeys) {
+				return m, nil
+			}
+			key := m.torrentKeys[m.selectedIdx]
+			m.statusMsg = fmt.Sprintf("Moving to %s...", value)
+			m.statusStyle = m.styles.Success
+			return m, moveCmd(m.engine, key, value)
+		}
+
+		if m.addDir != "" && engine.IsTorrentURL(value) {
+			dir := m.addDir
+			m.addDir = ""
+			m.statusMsg = fmt.Sprintf("Fetching %s...", value)
+			m.statusStyle = m.styles.Success
+			return m, addURLCmd(m.engine, value, dir)
+		}
+
+		if m.addDir != "" {
+			if err := m.engine.AddTorrentTo(value, m.addDir); err != nil {
+				m.statusMsg = fmt.Sprintf("Error adding torrent: %v", err)
+				m.statusStyle = m.styles.Error
+				m.inputMode = true
+				m.textInput.Focus()
+				return m, textinput.Blink
+			}
+			m.statusMsg = fmt.Sprintf("Torrent added to %s", m.addDir)
+			m.statusStyle = m.styles.Success
+			m.addDir = ""
+			return m, nil
+		}
+
+		if strings.Contains(m.inputPrompt, "magnet") {
+			// Sanitize magnet link and surface warnings about dropped trackers
+			sanitized, dropped, err := engine.SanitizeMagnet(value)
+			if err != nil {
 				m.statusMsg = fmt.Sprintf("Invalid magnet: %v", err)
 				m.statusStyle = m.styles.Error
 				m.inputMode = true
@@ -580,6 +1177,12 @@ func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 
 		} else if strings.Contains(m.inputPrompt, "torrent") {
+			if engine.IsTorrentURL(value) {
+				m.statusMsg = fmt.Sprintf("Fetching %s...", value)
+				m.statusStyle = m.styles.Success
+				return m, addURLCmd(m.engine, value, "")
+			}
+
 			if _, err := os.Stat(value); os.IsNotExist(err) {
 				m.statusMsg = fmt.Sprintf("File not found: %s", value)
 				m.statusStyle = m.styles.Error
@@ -588,20 +1191,28 @@ func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				return m, textinput.Blink
 			}
 
-			m.statusMsg = "Torrent file support coming soon"
-			m.statusStyle = m.styles.Error
+			if err := m.engine.AddTorrentFile(value); err != nil {
+				m.statusMsg = fmt.Sprintf("Error adding torrent: %v", err)
+				m.statusStyle = m.styles.Error
+				m.inputMode = true
+				m.textInput.Focus()
+				return m, textinput.Blink
+			}
+			m.statusMsg = "Torrent added successfully!"
+			m.statusStyle = m.styles.Success
 		}
 
 		return m, nil
 
 	case tea.KeyEsc:
 		m.inputMode = false
+		m.addDir = ""
 		m.textInput.Blur()
 		m.statusMsg = ""
 		return m, nil
 
 	case tea.KeyCtrlC:
-		return m, tea.Quit
+		return m.quit()
 	}
 
 	var cmd tea.Cmd
@@ -609,36 +1220,79 @@ func (m Model) handleInputMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// setLabels replaces the labels of the selected torrent.
+func (m Model) setLabels(labels []string) Model {
+	if m.selectedIdx < 0 || m.selectedIdx >= len(m.torrentKeys) {
+		return m
+	}
+	key := m.torrentKeys[m.selectedIdx]
+	if err := m.engine.SetLabels(key, labels); err != nil {
+		m.statusMsg = fmt.Sprintf("Error: %v", err)
+		m.statusStyle = m.styles.Error
+		return m
+	}
+	if len(labels) == 0 {
+		m.statusMsg = "Labels cleared"
+	} else {
+		m.statusMsg = fmt.Sprintf("Labels: %s", strings.Join(labels, ", "))
+	}
+	m.statusStyle = m.styles.Success
+	m.updateTorrentStats()
+	return m
+}
+
+// checkPersistence warns once when persistence is unavailable
+func (m *Model) checkPersistence() {
+	if m.persistWarned {
+		return
+	}
+	err := m.persistErr
+	if err == nil && m.engine != nil {
+		err = m.engine.PersistErr()
+	}
+	if err == nil {
+		return
+	}
+	m.persistWarned = true
+	m.statusMsg = fmt.Sprintf("Persistence disabled, torrents won't survive a restart: %v", err)
+	m.statusStyle = m.styles.Error
+}
+
+// updateTorrentStats polls the engine: ListTorrents for the rows, in
+// canonical order, and GetTorrents for what the details view shows.
 func (m *Model) updateTorrentStats() {
+	summaries := m.engine.ListTorrents()
+	m.setTorrents(summaries, m.engine.GetTorrents())
+}
+
+// setTorrents shows the torrents of summaries, keeping the selection where
+// possible. Summaries without a torrent in ts, deleted since they were
+// taken, are left out.
+func (m *Model) setTorrents(summaries []engine.TorrentSummary, ts map[string]*engine.Torrent) {
 	// Preserve current selection
 	var currentSelectedInfo string
 	if m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
 		currentSelectedInfo = m.torrentKeys[m.selectedIdx]
+	} else {
+		// e.g. a selection restored from the previous session
+		currentSelectedInfo = m.selectedInfo
 	}
 
-	m.torrents = m.engine.GetTorrents()
+	m.torrents = ts
 
-	newKeys := make([]string, 0, len(m.torrents))
-	for key := range m.torrents {
-		newKeys = append(newKeys, key)
-	}
-	// Sort keys by torrent name (ascending)
-	sort.Slice(newKeys, func(i, j int) bool {
-		ai := newKeys[i]
-		aj := newKeys[j]
-		ta := m.torrents[ai]
-		tb := m.torrents[aj]
-		if ta == nil && tb == nil {
-			return ai < aj
-		}
-		if ta == nil {
-			return false
-		}
-		if tb == nil {
-			return true
-		}
-		return strings.ToLower(ta.Name) < strings.ToLower(tb.Name)
+	// Order keys by the sort column, canonically (by name) between equals
+	summaries = slices.DeleteFunc(summaries, func(s engine.TorrentSummary) bool {
+		t := m.torrents[s.InfoHash]
+		return t == nil || m.labelFilter != "" && !t.HasLabel(m.labelFilter)
 	})
+	sortSummaries(summaries, m.torrents, m.sortColumn, m.sortDesc)
+	newKeys := make([]string, len(summaries))
+	for i, ts := range summaries {
+		newKeys[i] = ts.InfoHash
+	}
+	if m.displayMode == displayGrouped {
+		groupKeys(newKeys, m.torrents)
+	}
 	m.torrentKeys = newKeys
 
 	if len(m.torrentKeys) == 0 {
@@ -669,6 +1323,135 @@ func (m *Model) updateTorrentStats() {
 
 type tickMsg time.Time
 
+// debugLogName is the file in the download directory debug events go to.
+const debugLogName = "debug.log"
+
+// scrapeMsg carries the swarm estimate for one torrent, from scraping all
+// of its trackers.
+type scrapeMsg struct {
+	infohash string
+	swarm    engine.SwarmEstimate
+	err      error
+}
+
+func scrapeCmd(e engine.EngineInterface, infohash string) tea.Cmd {
+	return func() tea.Msg {
+		se, err := e.SwarmSize(infohash)
+		return scrapeMsg{infohash: infohash, swarm: se, err: err}
+	}
+}
+
+// verifyMsg carries the result of a recheck started with [v].
+type verifyMsg struct {
+	result *engine.VerifyResult
+	err    error
+}
+
+func verifyCmd(e engine.EngineInterface, infohash string) tea.Cmd {
+	return func() tea.Msg {
+		r, err := e.VerifyReport(infohash)
+		return verifyMsg{result: r, err: err}
+	}
+}
+
+// addURLMsg carries the result of adding a .torrent URL.
+type addURLMsg struct {
+	dir string
+	err error
+}
+
+// addURLCmd fetches and adds a .torrent URL in the background, as the
+// download can take up to 30 seconds, storing its data in dir if set.
+func addURLCmd(e engine.EngineInterface, url, dir string) tea.Cmd {
+	return func() tea.Msg {
+		if dir != "" {
+			return addURLMsg{dir: dir, err: e.AddTorrentTo(url, dir)}
+		}
+		return addURLMsg{err: e.AddTorrentURL(url)}
+	}
+}
+
+// moveMsg carries the result of a move started with [M].
+type moveMsg struct {
+	dir string
+	err error
+}
+
+// moveCmd moves a torrent's data in the background, as it can take a while
+// across filesystems.
+func moveCmd(e engine.EngineInterface, infohash, dir string) tea.Cmd {
+	return func() tea.Msg {
+		return moveMsg{dir: dir, err: e.MoveTorrent(infohash, dir)}
+	}
+}
+
+func (m Model) describeScrape(infohash string) string {
+	s, ok := m.scrapes[infohash]
+	switch {
+	case !ok:
+		return "scraping..."
+	case s.err != nil || len(s.swarm.Scrapes) == 0:
+		return "unavailable"
+	}
+	// the counts of the tracker that sees the most of the swarm
+	best := s.swarm.Scrapes[0]
+	for _, r := range s.swarm.Scrapes[1:] {
+		if r.Complete+r.Incomplete > best.Complete+best.Incomplete {
+			best = r
+		}
+	}
+	return fmt.Sprintf("S: %d / L: %d / Downloaded: %d", best.Complete, best.Incomplete, best.Downloaded)
+}
+
+// describeSwarmSize shows the estimated size of the whole swarm and what it
+// is based on.
+func (m Model) describeSwarmSize(infohash string) string {
+	s, ok := m.scrapes[infohash]
+	switch {
+	case !ok:
+		return "estimating..."
+	case s.err != nil:
+		return "unavailable"
+	}
+	return fmt.Sprintf("~%d peers (%d trackers, %d known peers)", s.swarm.Size, len(s.swarm.Scrapes), s.swarm.KnownPeers)
+}
+
+// eventMsg reports a change from the engine's event subscription.
+type eventMsg engine.Event
+
+// eventCmd waits for the next engine event. It returns nil once there is no
+// subscription or it has ended.
+func eventCmd(events <-chan engine.Event) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ev, ok := <-events
+		if !ok {
+			return nil
+		}
+		return eventMsg(ev)
+	}
+}
+
+// streamMsg holds the torrents pushed by a daemon; nil when the stream
+// has ended.
+type streamMsg map[string]*engine.Torrent
+
+// streamCmd waits for the next update of stream.
+func streamCmd(stream <-chan map[string]*engine.Torrent) tea.Cmd {
+	if stream == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ts, ok := <-stream
+		if !ok {
+			return streamMsg(nil)
+		}
+		return streamMsg(ts)
+	}
+}
+
 func tickCmd() tea.Cmd {
 	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
 		return tickMsg(t)
@@ -688,52 +1471,382 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// policyCycle is the order completion policies are cycled through with [f].
+var policyCycle = []engine.CompletionPolicy{
+	{},
+	engine.SeedForever(),
+	engine.StopAfterDownload(),
+	engine.SeedUntilRatio(1),
+	engine.SeedUntilRatio(2),
+}
+
+func nextPolicy(p engine.CompletionPolicy) engine.CompletionPolicy {
+	for i, c := range policyCycle {
+		if c == p {
+			return policyCycle[(i+1)%len(policyCycle)]
+		}
+	}
+	return policyCycle[0]
+}
+
+func describePolicy(p, global engine.CompletionPolicy) string {
+	if !p.IsDefault() {
+		return p.Describe()
+	}
+	if global.IsDefault() {
+		global = engine.SeedForever()
+	}
+	return fmt.Sprintf("Default (%s)", global.Describe())
+}
+
+// formatLimit renders a rate limit in bytes per second, 0 being unlimited.
+func formatLimit(bytesPerSec int64) string {
+	if bytesPerSec <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(bytesPerSec) + "/s"
+}
+
+// formatLabels renders a torrent's labels.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	return strings.Join(labels, ", ")
+}
+
+// formatRatioLimit renders Config.SeedRatioLimit.
+func formatRatioLimit(r float64) string {
+	if r <= 0 {
+		return "none (seed forever)"
+	}
+	return formatRatio(r)
+}
+
+// formatMinFreeSpace renders Config.MinFreeSpace, 0 meaning downloads are
+// never paused for space.
+func formatMinFreeSpace(n int64) string {
+	if n <= 0 {
+		return "none (never pause)"
+	}
+	return formatBytes(n)
+}
+
+// formatTorrentCount renders the number of torrents, out of
+// Config.MaxTorrents when there is a limit.
+func formatTorrentCount(n, max int) string {
+	if max <= 0 {
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%d/%d", n, max)
+}
+
+// formatMaxTorrents renders Config.MaxTorrents, 0 meaning no limit.
+func formatMaxTorrents(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+// formatIdlePeerTimeout renders Config.IdlePeerTimeout, 0 meaning idle
+// peers are kept.
+func formatIdlePeerTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "none (keep idle peers)"
+	}
+	return d.String()
+}
+
+// formatListenPort renders the configured incoming port along with the
+// one actually bound, when they differ.
+func formatListenPort(configured int, e engine.EngineInterface) string {
+	port, err := e.ListenPort()
+	switch {
+	case err != nil && configured == 0:
+		return "random"
+	case err != nil || port == configured:
+		return strconv.Itoa(configured)
+	case configured == 0:
+		return fmt.Sprintf("random (%d)", port)
+	}
+	return fmt.Sprintf("%d (bound %d)", configured, port)
+}
+
+// formatPortForwarding renders Config.EnablePortForwarding with the
+// external address the port is forwarded to, when the engine is local.
+func formatPortForwarding(enabled bool, e engine.EngineInterface) string {
+	le, ok := e.(*engine.Engine)
+	if !enabled || !ok {
+		return fmt.Sprintf("%t", enabled)
+	}
+	addr, err := le.ExternalAddress()
+	if err != nil {
+		return fmt.Sprintf("true (%v)", err)
+	}
+	return fmt.Sprintf("true (external %s)", addr)
+}
+
+// formatBlocklist renders Config.BlocklistPath with the size of the list
+// and the peers it refused, when the engine is local.
+func formatBlocklist(path string, e engine.EngineInterface) string {
+	le, ok := e.(*engine.Engine)
+	if path == "" || !ok {
+		return orNone(path)
+	}
+	ranges, blocked := le.BlocklistStats()
+	return fmt.Sprintf("%s (%d ranges, %d peers refused)", path, ranges, blocked)
+}
+
+// formatProxy renders Config.ProxyURL without its password.
+func formatProxy(raw string) string {
+	if u, err := url.Parse(raw); err == nil && raw != "" {
+		return u.Redacted() + " (no DHT or uTP)"
+	}
+	return orNone(raw)
+}
+
+// orNone renders an optional setting, such as Config.WatchDirectory, empty
+// meaning it is off.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// describeStatus is the status shown in the details view.
+func describeStatus(t *engine.Torrent) string {
+	switch {
+	case t.Started:
+		return "Active"
+	case t.SeedingComplete:
+		return "Seeding complete (ratio limit reached)"
+	case t.PausedAll:
+		return "Paused (resumed by resume all)"
+	case t.PausedDiskFull:
+		return "Paused (disk full, resumes when space is freed)"
+	default:
+		return "Stopped"
+	}
+}
+
+// formatRatio renders a share ratio, with ∞ for uploads of nothing
+// downloaded.
+func formatRatio(r float64) string {
+	if r == engine.InfiniteRatio {
+		return "∞"
+	}
+	return fmt.Sprintf("%.2f", r)
+}
+
+// downloadDir is the directory t's data is stored in.
+func downloadDir(t *engine.Torrent, c engine.Config) string {
+	if t.DownloadDir != "" {
+		return t.DownloadDir
+	}
+	return fmt.Sprintf("%s (default)", c.DownloadDirectory)
+}
+
+// formatAgo renders t relative to now, e.g. "2h ago". Zero times render as "-".
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+// truncate shortens s to at most max characters, replacing the end with
+// "..." when it is cut. Combining marks and other zero-width characters stay
+// with the character before them and don't count towards max. If max leaves
+// no room for the ellipsis the first max characters are returned.
 func truncate(s string, max int) string {
-	if len(s) <= max {
+	const ellipsis = "..."
+	if max <= 0 {
+		return ""
+	}
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	n := 0
+	for _, r := range s {
+		if !zeroWidth(r) {
+			n++
+		}
+	}
+	if n <= max {
 		return s
 	}
-	return s[:max-3] + "..."
+	keep, tail := max-len(ellipsis), ellipsis
+	if keep <= 0 {
+		keep, tail = max, ""
+	}
+	n = 0
+	for i, r := range s {
+		if zeroWidth(r) {
+			continue
+		}
+		if n == keep {
+			return s[:i] + tail
+		}
+		n++
+	}
+	return s
 }
 
-func Run(configPath string, version string) error {
-	// Support daemon subcommands: daemon start|stop|status|run
-	/*
-		if len(os.Args) >= 2 && os.Args[1] == "daemon" {
-			if len(os.Args) >= 3 {
-				switch os.Args[2] {
-				case "start":
-					if err := daemonStart(); err != nil {
-						return fmt.Errorf("failed to start daemon: %w", err)
-					}
-					fmt.Println("daemon started")
-					return nil
-				case "stop":
-					if err := daemonStop(); err != nil {
-						return fmt.Errorf("failed to stop daemon: %w", err)
-					}
-					fmt.Println("daemon stopped")
-					return nil
-				case "status":
-					alive, pid := daemonStatus()
-					if pid == 0 {
-						fmt.Println("no daemon pid file")
-					} else if alive {
-						fmt.Printf("daemon running (pid=%d)\n", pid)
-					} else {
-						fmt.Printf("daemon not running (stale pid=%d)\n", pid)
-					}
-					return nil
-				case "run":
-					// Run server in foreground (daemon child)
-					s := &server.Server{Port: 8080, Open: false, ConfigPath: configPath}
-					return s.Run(version)
-				default:
-					return fmt.Errorf("unknown daemon subcommand: %s", os.Args[2])
-				}
+// zeroWidth reports whether r takes up no space on its own, like combining
+// accents and joiners.
+func zeroWidth(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf)
+}
+
+// startupTorrent is a torrent passed on the command line.
+type startupTorrent struct {
+	arg    string
+	magnet string
+	url    string
+}
+
+// parseArgs sorts command line torrent arguments into .torrent paths,
+// magnet URIs and http(s) URLs. Invalid magnets are reported in errs
+// without stopping the others. Files are only read when they are added, so
+// their errors come from addStartupTorrents.
+func parseArgs(args []string) (ts []startupTorrent, errs []error) {
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "magnet:"):
+			sanitized, _, err := engine.SanitizeMagnet(arg)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", arg, err))
+				continue
 			}
-			return fmt.Errorf("missing daemon subcommand: start|stop|status|run")
+			ts = append(ts, startupTorrent{arg: arg, magnet: sanitized})
+		case engine.IsTorrentURL(arg):
+			ts = append(ts, startupTorrent{arg: arg, url: arg})
+		default:
+			ts = append(ts, startupTorrent{arg: arg})
 		}
-	*/
+	}
+	return ts, errs
+}
+
+// addStartupTorrents adds torrents given on the command line and returns a
+// status message summarising the result.
+func addStartupTorrents(e engine.EngineInterface, args []string) (string, bool) {
+	ts, errs := parseArgs(args)
+	added := 0
+	for _, st := range ts {
+		var err error
+		switch {
+		case st.magnet != "":
+			err = e.NewMagnet(st.magnet)
+		case st.url != "":
+			err = e.AddTorrentURL(st.url)
+		default:
+			err = e.AddTorrentFile(st.arg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", st.arg, err))
+			continue
+		}
+		added++
+	}
+	if len(errs) == 0 {
+		return fmt.Sprintf("Added %d torrent(s)", added), true
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("Added %d torrent(s), %d failed: %s", added, len(errs), strings.Join(msgs, "; ")), false
+}
+
+// runDemo runs the TUI against an in-memory engine with a few fake torrents,
+// without touching the network or disk.
+func runDemo(noConfirm bool) error {
+	e := engine.NewMemoryEngine()
+	e.Realtime = true
+	e.Configure(engine.Config{AutoStart: true, EnableUpload: true, EnableSeeding: true, EnableDHT: true, EnablePEX: true})
+	demo := []struct {
+		name     string
+		size     int64
+		done     int64
+		down, up int64
+	}{
+		{"ubuntu-24.04-desktop-amd64.iso", 6 << 30, 0, 12 << 20, 512 << 10},
+		{"big-buck-bunny-1080p.mkv", 700 << 20, 200 << 20, 3 << 20, 128 << 10},
+		{"debian-12-netinst.iso", 650 << 20, 650 << 20, 0, 1 << 20},
+	}
+	for _, d := range demo {
+		ih, err := e.AddFake(d.name, d.size)
+		if err != nil {
+			return err
+		}
+		e.SetProgress(ih, d.done)
+		e.SetRate(ih, d.down, d.up)
+	}
+	model := NewModel(e)
+	model.noConfirm = noConfirm
+	events, unsubscribe := e.Subscribe()
+	defer unsubscribe()
+	model.events = events
+	if _, err := tea.NewProgram(model, tea.WithAltScreen()).Run(); err != nil {
+		return fmt.Errorf("error running TUI: %w", err)
+	}
+	return nil
+}
+
+// runDB runs a database maintenance subcommand against the persister at dbPath.
+func runDB(dbPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing db subcommand: stats|vacuum")
+	}
+	p, err := engine.NewPersister(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer p.Close()
+
+	switch args[0] {
+	case "stats":
+		stats, err := p.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("torrents: %d\nmeta: %d\nsize: %s\n", stats.Torrents, stats.Meta, formatBytes(stats.Size))
+		return nil
+	case "vacuum":
+		before, err := p.Stats()
+		if err != nil {
+			return err
+		}
+		if err := p.Vacuum(); err != nil {
+			return err
+		}
+		after, err := p.Stats()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("vacuumed %s: %s -> %s\n", dbPath, formatBytes(before.Size), formatBytes(after.Size))
+		return nil
+	default:
+		return fmt.Errorf("unknown db subcommand: %s", args[0])
+	}
+}
+
+func Run(configPath string, version string, args []string, noConfirm bool) error {
+	// background engine serving the API: intunja daemon start|stop|status|run
+	if len(args) > 0 && args[0] == "daemon" {
+		return runDaemon(configPath, args[1:])
+	}
 
 	// Provide a headless (non-interactive) mode for automated tests:
 	// `./intunja headless` will run a simple loop that fetches torrent state
@@ -805,49 +1918,72 @@ func Run(configPath string, version string) error {
 		}
 	*/
 
-	// If daemon running, use remote engine proxy to avoid binding ports locally
-	var e engine.EngineInterface
-	/*
-		if alive, _ := daemonStatus(); alive {
-			// remote server listens on http://localhost:8080 (daemon run uses 8080)
-			e = engine.NewRemoteEngine("http://localhost:8080")
-		} else {
-	*/
-	e = engine.New()
-	//}
+	// UI demo against fake torrents: intunja demo
+	if len(args) > 0 && args[0] == "demo" {
+		return runDemo(noConfirm)
+	}
 
-	config := engine.Config{
-		AutoStart:         true,
-		DisableEncryption: false,
-		DownloadDirectory: "./downloads",
-		EnableUpload:      true,
-		EnableSeeding:     true,
-		IncomingPort:      50007,
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
 	if err := os.MkdirAll(config.DownloadDirectory, 0755); err != nil {
 		return fmt.Errorf("failed to create download directory: %w", err)
 	}
 
-	// Only configure local engine; remote engine will forward configure calls
+	// If daemon running, use remote engine proxy to avoid binding ports locally
+	var e engine.EngineInterface
+	if url := runningDaemon(config); url != "" {
+		e = engine.NewRemoteEngine(url)
+	} else {
+		e = engine.New()
+	}
+
+	// per-torrent debug events ([l] in the TUI) go to a file, not the terminal
+	if le, ok := e.(*engine.Engine); ok {
+		f, err := os.OpenFile(filepath.Join(config.DownloadDirectory, debugLogName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err == nil {
+			defer f.Close()
+			le.SetDebugLogger(slog.New(slog.NewTextHandler(f, nil)))
+		}
+	}
+
+	// database maintenance: intunja db stats|vacuum
+	if len(args) > 0 && args[0] == "db" {
+		return runDB(filepath.Join(config.DownloadDirectory, "intunja.db"), args[1:])
+	}
+
+	// throughput self-test: intunja diag [magnet|file|url]
+	if len(args) > 0 && args[0] == "diag" {
+		return runDiag(os.Stdout, config, args[1:])
+	}
+
+	// torrent summaries as JSON: intunja list
+	if len(args) > 0 && args[0] == "list" {
+		return runList(os.Stdout, e, config)
+	}
+
+	if le, ok := e.(*engine.Engine); ok {
+		// remove the port mappings on exit, after persistence is detached
+		defer le.Close()
+	}
+	// Only attach a persister to a local engine; a remote one forwards
+	// configure calls to the daemon, which keeps its own
+	var persister *engine.Persister
+	var persistErr error
 	if _, ok := e.(*engine.RemoteEngine); !ok {
-		// attach persister (DB file in download dir)
-		dbPath := filepath.Join(config.DownloadDirectory, "intunja.db")
-		if p, err := engine.NewPersister(dbPath); err == nil {
-			e.AttachPersister(p)
-			if err := e.Configure(config); err != nil {
-				return fmt.Errorf("failed to configure engine: %w", err)
-			}
-			e.RehydrateFromPersister()
+		persister, persistErr, err = configureEngine(e, config)
+		if err != nil {
+			return err
+		}
+		if persister != nil {
 			defer func() {
 				e.DetachPersister()
-				p.Close()
+				persister.Close()
 			}()
 		} else {
-			fmt.Printf("warning: could not open persister: %v\n", err)
-			if err := e.Configure(config); err != nil {
-				return fmt.Errorf("failed to configure engine: %w", err)
-			}
+			fmt.Printf("warning: could not open persister: %v\n", persistErr)
 		}
 	} else {
 		// send configuration to remote daemon
@@ -857,90 +1993,42 @@ func Run(configPath string, version string) error {
 	}
 
 	model := NewModel(e)
-	p := tea.NewProgram(model, tea.WithAltScreen())
-
-	if _, err := p.Run(); err != nil {
-		return fmt.Errorf("error running TUI: %w", err)
-	}
-
-	return nil
-}
-
-/*
-func pidFilePath() string {
-	return filepath.Join(os.TempDir(), "intunja-daemon.pid")
-}
-
-func daemonStart() error {
-	pidfile := pidFilePath()
-	if b, err := ioutil.ReadFile(pidfile); err == nil && len(b) > 0 {
-		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
-			if p, err := os.FindProcess(pid); err == nil {
-				// try signal 0
-				if err := p.Signal(syscall.Signal(0)); err == nil {
-					return fmt.Errorf("daemon already running (pid=%d)", pid)
-				}
-			}
+	model.noConfirm = noConfirm
+	model.persistErr = persistErr
+	if persister != nil {
+		if state, err := loadUIState(persister); err == nil {
+			model.applyUIState(state)
 		}
 	}
-
-	cmd := exec.Command(os.Args[0], "daemon", "run")
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	cmd.Stdin = nil
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
-
-	if err := cmd.Start(); err != nil {
-		return err
+	if len(args) > 0 {
+		msg, ok := addStartupTorrents(e, args)
+		model.statusMsg = msg
+		model.statusStyle = model.styles.Success
+		if !ok {
+			model.statusStyle = model.styles.Error
+		}
 	}
-
-	pid := cmd.Process.Pid
-	if err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(pid)), 0644); err != nil {
-		return err
+	events, unsubscribe := e.Subscribe()
+	defer unsubscribe()
+	model.events = events
+	if re, ok := e.(*engine.RemoteEngine); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if stream, err := re.StreamTorrents(ctx); err == nil {
+			model.stream = stream
+		}
 	}
-	return nil
-}
+	p := tea.NewProgram(model, tea.WithAltScreen())
 
-func daemonStop() error {
-	pidfile := pidFilePath()
-	b, err := ioutil.ReadFile(pidfile)
-	if err != nil {
-		return fmt.Errorf("pid file not found")
-	}
-	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
-	if err != nil {
-		return err
-	}
-	p, err := os.FindProcess(pid)
+	final, err := p.Run()
 	if err != nil {
-		return err
+		return fmt.Errorf("error running TUI: %w", err)
 	}
-	// send SIGTERM
-	if err := p.Signal(syscall.SIGTERM); err != nil {
-		return err
+	if fm, ok := final.(Model); ok && persister != nil {
+		if err := saveUIState(persister, fm.uiState()); err != nil {
+			fmt.Printf("warning: could not save UI state: %v\n", err)
+		}
 	}
-	// remove pidfile
-	_ = os.Remove(pidfile)
-	return nil
-}
 
-func daemonStatus() (bool, int) {
-	pidfile := pidFilePath()
-	b, err := ioutil.ReadFile(pidfile)
-	if err != nil {
-		return false, 0
-	}
-	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
-	if err != nil {
-		return false, 0
-	}
-	p, err := os.FindProcess(pid)
-	if err != nil {
-		return false, pid
-	}
-	if err := p.Signal(syscall.Signal(0)); err != nil {
-		return false, pid
-	}
-	return true, pid
+	return nil
 }
-*/
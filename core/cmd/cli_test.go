@@ -0,0 +1,1056 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// writeTestTorrent creates a small .torrent file and returns its path.
+func writeTestTorrent(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(data, []byte("hello intunja"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 16384}
+	if err := info.BuildFromFilePath(data); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes}
+	path := filepath.Join(dir, "test.torrent")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseArgs(t *testing.T) {
+	valid := writeTestTorrent(t)
+	garbage := filepath.Join(t.TempDir(), "garbage.torrent")
+	if err := os.WriteFile(garbage, []byte("not a torrent"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{
+		valid,
+		"/does/not/exist.torrent",
+		"magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567",
+		garbage,
+		"magnet:?dn=missing-xt",
+		"https://example.com/file.torrent",
+	}
+	ts, errs := parseArgs(args)
+	if len(ts) != 5 {
+		t.Fatalf("expected 5 torrents, got %d", len(ts))
+	}
+	if ts[0].arg != valid || ts[2].magnet == "" || ts[4].url == "" {
+		t.Fatalf("unexpected parse result: %+v", ts)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+
+	// files are read once, when added
+	msg, ok := addStartupTorrents(engine.NewMemoryEngine(), args[:4])
+	if ok || !strings.HasPrefix(msg, "Added 2 torrent(s), 2 failed") ||
+		!strings.Contains(msg, "/does/not/exist.torrent") || !strings.Contains(msg, garbage) {
+		t.Fatalf("unexpected status %q", msg)
+	}
+}
+
+func keyMsg(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func isQuit(cmd tea.Cmd) bool {
+	if cmd == nil {
+		return false
+	}
+	_, ok := cmd().(tea.QuitMsg)
+	return ok
+}
+
+func TestQuitConfirmation(t *testing.T) {
+	m := NewModel(nil)
+	m.torrents = map[string]*engine.Torrent{
+		"a": {InfoHash: "a", Started: true},
+	}
+
+	next, cmd := m.Update(keyMsg("q"))
+	if isQuit(cmd) {
+		t.Fatalf("expected confirmation instead of quitting")
+	}
+	m = next.(Model)
+	if !m.confirmQuit {
+		t.Fatalf("expected confirmation state")
+	}
+
+	next, cmd = m.Update(keyMsg("n"))
+	m = next.(Model)
+	if isQuit(cmd) || m.confirmQuit {
+		t.Fatalf("expected cancel to return to the app")
+	}
+
+	next, _ = m.Update(keyMsg("q"))
+	m = next.(Model)
+	if _, cmd = m.Update(keyMsg("y")); !isQuit(cmd) {
+		t.Fatalf("expected quit anyway to quit")
+	}
+
+	// ctrl+c asks too, also while typing
+	m.confirmQuit = false
+	for _, inputMode := range []bool{false, true} {
+		m.inputMode = inputMode
+		next, cmd = m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+		if isQuit(cmd) || !next.(Model).confirmQuit {
+			t.Fatalf("input mode %v: expected ctrl+c to ask for confirmation", inputMode)
+		}
+		if _, cmd = next.(Model).Update(tea.KeyMsg{Type: tea.KeyCtrlC}); !isQuit(cmd) {
+			t.Fatalf("input mode %v: expected a second ctrl+c to quit", inputMode)
+		}
+	}
+}
+
+func TestQuitWithoutConfirmation(t *testing.T) {
+	seeding := NewModel(nil)
+	seeding.torrents = map[string]*engine.Torrent{
+		"a": {InfoHash: "a", Started: true, CompletedAt: time.Now()},
+	}
+	if _, cmd := seeding.Update(keyMsg("q")); !isQuit(cmd) {
+		t.Fatalf("expected immediate quit when only seeding")
+	}
+
+	flagged := NewModel(nil)
+	flagged.noConfirm = true
+	flagged.torrents = map[string]*engine.Torrent{
+		"a": {InfoHash: "a", Started: true},
+	}
+	if _, cmd := flagged.Update(keyMsg("q")); !isQuit(cmd) {
+		t.Fatalf("expected immediate quit with --no-confirm")
+	}
+	if _, cmd := flagged.Update(tea.KeyMsg{Type: tea.KeyCtrlC}); !isQuit(cmd) {
+		t.Fatalf("expected ctrl+c to quit immediately with --no-confirm")
+	}
+}
+
+type fakeTorrentEngine struct {
+	engine.EngineInterface
+	torrents map[string]*engine.Torrent
+}
+
+func (f fakeTorrentEngine) GetTorrents() map[string]*engine.Torrent {
+	return f.torrents
+}
+
+func (f fakeTorrentEngine) ListTorrents() []engine.TorrentSummary {
+	return engine.SummarizeTorrents(f.torrents)
+}
+
+// staleListEngine lists torrents from another snapshot than GetTorrents,
+// as when one is added or deleted between the two calls.
+type staleListEngine struct {
+	fakeTorrentEngine
+	listed map[string]*engine.Torrent
+}
+
+func (e staleListEngine) ListTorrents() []engine.TorrentSummary {
+	return engine.SummarizeTorrents(e.listed)
+}
+
+func TestListedTorrents(t *testing.T) {
+	alpha := &engine.Torrent{InfoHash: "a", Name: "alpha"}
+	m := NewModel(staleListEngine{
+		fakeTorrentEngine: fakeTorrentEngine{torrents: map[string]*engine.Torrent{
+			"a": alpha,
+			"b": {InfoHash: "b", Name: "bravo"},
+		}},
+		listed: map[string]*engine.Torrent{
+			"a":    alpha,
+			"gone": {InfoHash: "gone", Name: "deleted"},
+		},
+	})
+	m.updateTorrentStats()
+	// b waits for the next update to be listed, gone has nothing to show
+	if !slices.Equal(m.torrentKeys, []string{"a"}) {
+		t.Fatalf("expected only the torrent in both snapshots, got %v", m.torrentKeys)
+	}
+}
+
+func TestListSubcommand(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	e.Configure(engine.Config{AutoStart: true})
+	for _, ih := range []string{"0123456789abcdef0123456789abcdef01234567", "89abcdef0123456789abcdef0123456789abcdef"} {
+		if err := e.NewMagnet("magnet:?xt=urn:btih:" + ih + "&dn=" + ih[:4]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := runList(&out, e, engine.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	var got []engine.TorrentSummary
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON list, got %q: %v", out.String(), err)
+	}
+	if want := e.ListTorrents(); len(got) != 2 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	if err := runList(&out, engine.NewRemoteEngine("http://127.0.0.1:1"), engine.Config{}); err == nil {
+		t.Fatal("expected an unreachable daemon reported")
+	}
+}
+
+func TestUIStateRestore(t *testing.T) {
+	p, err := engine.NewPersister(filepath.Join(t.TempDir(), "ui.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	e := fakeTorrentEngine{torrents: map[string]*engine.Torrent{
+		"a": {InfoHash: "a", Name: "alpha"},
+		"b": {InfoHash: "b", Name: "bravo"},
+		"c": {InfoHash: "c", Name: "charlie"},
+	}}
+	m := NewModel(e)
+	m.sortDesc = true
+	m.displayMode = displayCompact
+	m.updateTorrentStats()
+	m.selectedIdx = 2 // alpha, last when sorted descending
+	if err := saveUIState(p, m.uiState()); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	state, err := loadUIState(p)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	fresh := NewModel(e)
+	fresh.applyUIState(state)
+	fresh.updateTorrentStats()
+	if !fresh.sortDesc || fresh.sortColumn != "name" {
+		t.Fatalf("sort not restored: column=%q desc=%v", fresh.sortColumn, fresh.sortDesc)
+	}
+	if fresh.displayMode != displayCompact {
+		t.Fatalf("display mode not restored: %q", fresh.displayMode)
+	}
+	if fresh.torrentKeys[fresh.selectedIdx] != "a" {
+		t.Fatalf("expected selection on a, got %s", fresh.torrentKeys[fresh.selectedIdx])
+	}
+}
+
+type failingPersistEngine struct {
+	fakeTorrentEngine
+}
+
+func (failingPersistEngine) PersistErr() error {
+	return errors.New("disk full")
+}
+
+func TestPersistenceWarningShownOnce(t *testing.T) {
+	m := NewModel(failingPersistEngine{})
+	next, _ := m.Update(tickMsg(time.Now()))
+	m = next.(Model)
+	if !strings.Contains(m.statusMsg, "disk full") {
+		t.Fatalf("expected persistence warning, got %q", m.statusMsg)
+	}
+	m.statusMsg = ""
+	next, _ = m.Update(tickMsg(time.Now()))
+	m = next.(Model)
+	if m.statusMsg != "" {
+		t.Fatalf("expected warning only once, got %q", m.statusMsg)
+	}
+}
+
+func TestStreamReplacesPolling(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	polled, err := e.AddFake("polled", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := make(chan map[string]*engine.Torrent)
+	m := NewModel(e)
+	m.stream = stream
+
+	pushed := &engine.Torrent{InfoHash: "pushed", Name: "pushed"}
+	next, cmd := m.Update(streamMsg{"pushed": pushed})
+	m = next.(Model)
+	if len(m.torrentKeys) != 1 || m.torrentKeys[0] != "pushed" || cmd == nil {
+		t.Fatalf("expected the pushed torrent and a wait for more, got %v", m.torrentKeys)
+	}
+	next, _ = m.Update(tickMsg(time.Now()))
+	m = next.(Model)
+	if len(m.torrentKeys) != 1 || m.torrentKeys[0] != "pushed" {
+		t.Fatalf("expected no polling while streaming, got %v", m.torrentKeys)
+	}
+
+	// the stream ending brings polling back
+	close(stream)
+	next, _ = m.Update(streamCmd(m.stream)())
+	m = next.(Model)
+	if m.stream != nil || len(m.torrentKeys) != 1 || m.torrentKeys[0] != polled {
+		t.Fatalf("expected polling after the stream ended, got %v", m.torrentKeys)
+	}
+}
+
+// magnetEngine records magnets added through the TUI.
+type magnetEngine struct {
+	fakeTorrentEngine
+	added []string
+}
+
+func (m *magnetEngine) NewMagnet(uri string) error {
+	m.added = append(m.added, uri)
+	return nil
+}
+
+func TestAddMagnetWebSocketTrackersNoWarning(t *testing.T) {
+	e := &magnetEngine{}
+	m := NewModel(e)
+	next, _ := m.Update(keyMsg("m"))
+	m = next.(Model)
+	m.textInput.SetValue("magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567" +
+		"&tr=wss%3A%2F%2Ftracker.example.com&tr=ws%3A%2F%2Ftracker.example.com%3A8000")
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+
+	if len(e.added) != 1 {
+		t.Fatalf("expected one magnet added, got %d", len(e.added))
+	}
+	if m.statusMsg != "Magnet link added successfully!" {
+		t.Fatalf("unexpected status %q", m.statusMsg)
+	}
+}
+
+// urlEngine blocks adding a URL until released, as a slow download would.
+type urlEngine struct {
+	fakeTorrentEngine
+	release chan struct{}
+	added   []string
+}
+
+func (u *urlEngine) AddTorrentURL(url string) error {
+	<-u.release
+	u.added = append(u.added, url)
+	return nil
+}
+
+func TestAddTorrentURLInBackground(t *testing.T) {
+	e := &urlEngine{release: make(chan struct{})}
+	m := NewModel(e)
+	next, _ := m.Update(keyMsg("a"))
+	m = next.(Model)
+	m.textInput.SetValue("https://example.com/file.torrent")
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if cmd == nil || !strings.HasPrefix(m.statusMsg, "Fetching") {
+		t.Fatalf("expected the fetch left to a command, status %q", m.statusMsg)
+	}
+	if len(e.added) != 0 {
+		t.Fatal("expected nothing added before the command runs")
+	}
+	close(e.release)
+	next, _ = m.Update(cmd())
+	m = next.(Model)
+	if len(e.added) != 1 || m.statusMsg != "Torrent added successfully!" {
+		t.Fatalf("expected the torrent added, got %v, status %q", e.added, m.statusMsg)
+	}
+
+	next, _ = m.Update(addURLMsg{err: errors.New("fetch torrent: timeout")})
+	m = next.(Model)
+	if !strings.Contains(m.statusMsg, "timeout") {
+		t.Fatalf("expected the fetch error shown, got %q", m.statusMsg)
+	}
+}
+
+func TestModelWithMemoryEngine(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	m := NewModel(e)
+	press := func(msg tea.Msg) {
+		t.Helper()
+		next, _ := m.Update(msg)
+		m = next.(Model)
+	}
+
+	press(keyMsg("a"))
+	m.textInput.SetValue(writeTestTorrent(t))
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.statusMsg != "Torrent added successfully!" {
+		t.Fatalf("unexpected status %q", m.statusMsg)
+	}
+	m.updateTorrentStats()
+	if len(m.torrentKeys) != 1 {
+		t.Fatalf("expected one torrent, got %d", len(m.torrentKeys))
+	}
+	key := m.torrentKeys[0]
+	tor := m.torrents[key]
+
+	press(keyMsg("s"))
+	if !tor.Started || !strings.HasPrefix(m.statusMsg, "Started") {
+		t.Fatalf("expected torrent started, status %q", m.statusMsg)
+	}
+	e.SetRate(key, tor.Size/2, 0)
+	e.Advance(time.Second)
+	m.updateTorrentStats()
+	half := tor.Downloaded
+	if half != tor.Size/2 {
+		t.Fatalf("expected %d bytes after one second, got %d", tor.Size/2, half)
+	}
+
+	press(keyMsg("p"))
+	if tor.Started || !strings.HasPrefix(m.statusMsg, "Paused") {
+		t.Fatalf("expected torrent paused, status %q", m.statusMsg)
+	}
+	e.Advance(time.Second)
+	if tor.Downloaded != half {
+		t.Fatalf("paused torrent progressed to %d bytes", tor.Downloaded)
+	}
+
+	press(keyMsg("d"))
+	press(keyMsg("y"))
+	if len(m.torrentKeys) != 0 || len(e.GetTorrents()) != 0 {
+		t.Fatalf("expected torrent deleted, keys %v", m.torrentKeys)
+	}
+}
+
+func TestAddToDirectory(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	m := NewModel(e)
+	press := func(msg tea.Msg) {
+		t.Helper()
+		next, _ := m.Update(msg)
+		m = next.(Model)
+	}
+
+	press(keyMsg("A"))
+	m.textInput.SetValue("/srv/movies")
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	if !m.inputMode || !strings.Contains(m.inputPrompt, "/srv/movies") {
+		t.Fatalf("expected source prompt for the directory, got %q", m.inputPrompt)
+	}
+	m.textInput.SetValue("magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=film")
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.inputMode || m.addDir != "" {
+		t.Fatalf("expected add to finish, status %q", m.statusMsg)
+	}
+	tor := e.GetTorrents()["0123456789abcdef0123456789abcdef01234567"]
+	if tor == nil || tor.DownloadDir != "/srv/movies" {
+		t.Fatalf("expected torrent in /srv/movies, got %+v", tor)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"short", 40, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a longer torrent name", 10, "a longe..."},
+		{"日本語のタイトルです", 8, "日本語のタ..."},
+		{"🎬🎞️🍿📽️🎥 movie night", 6, "🎬🎞️🍿..."},
+		{"cafe\u0301 au lait", 7, "cafe\u0301..."},
+		{"abcdef", 3, "abc"},
+		{"abcdef", 1, "a"},
+		{"ab", 1, "a"},
+		{"ab", 0, ""},
+		{"x", -1, ""},
+		{"bad\xffbyte name", 8, "bad�b..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.max)
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, not valid UTF-8", tt.s, tt.max, got)
+		}
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestCompactLayout(t *testing.T) {
+	ts := map[string]*engine.Torrent{}
+	for i := range 50 {
+		key := fmt.Sprintf("%02d", i)
+		ts[key] = &engine.Torrent{InfoHash: key, Name: "torrent " + key, Loaded: true, Started: i%3 != 0, Size: 100, BytesCompleted: int64(i % 2 * 100)}
+	}
+	m := NewModel(fakeTorrentEngine{EngineInterface: engine.NewMemoryEngine(), torrents: ts})
+	m.width, m.height = 100, 30
+	next, _ := m.Update(keyMsg("t"))
+	m = next.(Model)
+	if m.displayMode != displayCompact {
+		t.Fatalf("expected compact mode, got %q", m.displayMode)
+	}
+	m.updateTorrentStats()
+	if n := len(strings.Split(m.renderCompact(), "\n")); n != m.height-mainViewChrome {
+		t.Fatalf("expected %d compact lines, got %d", m.height-mainViewChrome, n)
+	}
+	if n := len(strings.Split(m.View(), "\n")); n > m.height {
+		t.Fatalf("compact view is %d lines, taller than the %d line terminal", n, m.height)
+	}
+	// the selection stays in view when scrolling past the first screen
+	m.selectedIdx = 40
+	if !strings.Contains(m.renderCompact(), "> torrent 40") {
+		t.Fatal("selected torrent scrolled out of view")
+	}
+
+	next, _ = m.Update(keyMsg("t"))
+	m = next.(Model)
+	if m.displayMode != displayGrouped {
+		t.Fatalf("expected grouped mode, got %q", m.displayMode)
+	}
+	last := -1
+	for _, key := range m.torrentKeys {
+		g := statusGroup(ts[key])
+		if g < last {
+			t.Fatalf("torrent %s listed out of its status group", key)
+		}
+		last = g
+	}
+	m.height = 200
+	out := m.renderGrouped()
+	for _, heading := range statusGroups {
+		if !strings.Contains(out, heading) {
+			t.Errorf("missing %s heading", heading)
+		}
+	}
+}
+
+func TestEventRefresh(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	events, unsubscribe := e.Subscribe()
+	m := NewModel(e)
+	m.events = events
+	ih, err := e.AddFake("demo.iso", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := eventCmd(m.events)()
+	if ev, ok := msg.(eventMsg); !ok || ev.Type != engine.EventAdded || ev.InfoHash != ih {
+		t.Fatalf("expected an added event, got %#v", msg)
+	}
+	next, cmd := m.Update(msg)
+	m = next.(Model)
+	if m.torrents[ih] == nil {
+		t.Fatal("list not refreshed on the event")
+	}
+	if cmd == nil {
+		t.Fatal("expected to keep waiting for events")
+	}
+	unsubscribe()
+	if msg := cmd(); msg != nil {
+		t.Fatalf("expected no message after unsubscribing, got %#v", msg)
+	}
+}
+
+func TestLabelFilter(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	linux, _ := e.AddFake("debian.iso", 100)
+	e.AddFake("movie.mkv", 100)
+	e.SetLabels(linux, []string{"linux"})
+	m := NewModel(e)
+	m.updateTorrentStats()
+
+	// label the selected torrent through the prompt
+	m.selectedIdx = slices.Index(m.torrentKeys, linux)
+	next, _ := m.Update(keyMsg("e"))
+	m = next.(Model)
+	if m.inputPrompt != labelsPrompt || m.textInput.Value() != "linux" {
+		t.Fatalf("expected the labels prompt with the current labels, got %q %q", m.inputPrompt, m.textInput.Value())
+	}
+	m.textInput.SetValue("linux, iso")
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if got := e.GetTorrents()[linux].Labels; !slices.Equal(got, []string{"iso", "linux"}) {
+		t.Fatalf("unexpected labels %q", got)
+	}
+
+	for _, want := range []struct {
+		filter string
+		n      int
+	}{{"iso", 1}, {"linux", 1}, {"", 2}} {
+		next, _ = m.Update(keyMsg("L"))
+		m = next.(Model)
+		if m.labelFilter != want.filter || len(m.torrentKeys) != want.n {
+			t.Fatalf("expected filter %q listing %d, got %q listing %d", want.filter, want.n, m.labelFilter, len(m.torrentKeys))
+		}
+	}
+
+	// an empty answer clears the labels
+	next, _ = m.Update(keyMsg("e"))
+	m = next.(Model)
+	m.textInput.SetValue("")
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if got := e.GetTorrents()[linux].Labels; len(got) != 0 {
+		t.Fatalf("expected labels cleared, got %q", got)
+	}
+}
+
+func TestPauseResumeAll(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	e.Configure(engine.Config{AutoStart: true})
+	running, _ := e.AddFake("running.iso", 100)
+	paused, _ := e.AddFake("paused.iso", 100)
+	e.StopTorrent(paused)
+	m := NewModel(e)
+	m.updateTorrentStats()
+
+	next, _ := m.Update(keyMsg("P"))
+	m = next.(Model)
+	if m.torrents[running].Started || !m.torrents[running].PausedAll {
+		t.Fatal("expected the running torrent paused")
+	}
+	next, _ = m.Update(keyMsg("S"))
+	m = next.(Model)
+	if !m.torrents[running].Started || m.torrents[paused].Started {
+		t.Fatalf("expected only the running torrent resumed: running=%v paused=%v",
+			m.torrents[running].Started, m.torrents[paused].Started)
+	}
+}
+
+func TestMoveTorrentKey(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	ih, _ := e.AddFake("demo.iso", 100)
+	m := NewModel(e)
+	m.updateTorrentStats()
+
+	next, _ := m.Update(keyMsg("M"))
+	m = next.(Model)
+	m.textInput.SetValue("/mnt/archive")
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = next.(Model)
+	if cmd == nil {
+		t.Fatal("expected the move to run in the background")
+	}
+	next, _ = m.Update(cmd())
+	m = next.(Model)
+	if got := m.torrents[ih].DownloadDir; got != "/mnt/archive" {
+		t.Fatalf("expected the torrent moved, got %q", got)
+	}
+	if !strings.Contains(m.statusMsg, "Moved to /mnt/archive") {
+		t.Fatalf("unexpected status %q", m.statusMsg)
+	}
+}
+
+func TestCopyMagnet(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	const link = "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=demo.iso&tr=udp%3A%2F%2Ftracker.example%3A1337"
+	if err := e.NewMagnet(link); err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel(e)
+	m.updateTorrentStats()
+	defer func(orig func() [][]string) { clipboardCommands = orig }(clipboardCommands)
+
+	out := filepath.Join(t.TempDir(), "clipboard")
+	clipboardCommands = func() [][]string {
+		return [][]string{{"intunja-missing-tool"}, {"sh", "-c", "cat > " + out}}
+	}
+	next, _ := m.Update(keyMsg("y"))
+	m = next.(Model)
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("expected the magnet copied: %v (status %q)", err, m.statusMsg)
+	}
+	mag, err := metainfo.ParseMagnetUri(string(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mag.InfoHash.HexString() != "0123456789abcdef0123456789abcdef01234567" || mag.DisplayName != "demo.iso" ||
+		!slices.Equal(mag.Trackers, []string{"udp://tracker.example:1337"}) {
+		t.Fatalf("unexpected magnet %q", got)
+	}
+	if !strings.Contains(m.statusMsg, "Copied magnet link") {
+		t.Fatalf("unexpected status %q", m.statusMsg)
+	}
+
+	// without a clipboard tool the link is shown instead
+	clipboardCommands = func() [][]string { return [][]string{{"intunja-missing-tool"}} }
+	next, _ = m.Update(keyMsg("y"))
+	m = next.(Model)
+	if !strings.Contains(m.statusMsg, string(got)) {
+		t.Fatalf("expected the magnet in the status line, got %q", m.statusMsg)
+	}
+}
+
+// deleteRecorder records the deleteData flag of each deletion.
+type deleteRecorder struct {
+	engine.EngineInterface
+	deleted map[string]bool
+}
+
+func (d *deleteRecorder) DeleteTorrent(infohash string, deleteData bool) error {
+	d.deleted[infohash] = deleteData
+	return d.EngineInterface.DeleteTorrent(infohash, deleteData)
+}
+
+func TestDeleteConfirmation(t *testing.T) {
+	mem := engine.NewMemoryEngine()
+	keep, _ := mem.AddFake("keep.iso", 100)
+	files, _ := mem.AddFake("files.iso", 100)
+	e := &deleteRecorder{EngineInterface: mem, deleted: map[string]bool{}}
+	m := NewModel(e)
+	m.updateTorrentStats()
+	selectTorrent := func(ih string) {
+		m.selectedIdx = slices.Index(m.torrentKeys, ih)
+	}
+
+	selectTorrent(keep)
+	next, _ := m.Update(keyMsg("d"))
+	m = next.(Model)
+	if m.confirmDelete != keep || !strings.Contains(m.View(), "Delete torrent?") {
+		t.Fatalf("expected a confirmation, got %q", m.View())
+	}
+	next, _ = m.Update(keyMsg("n"))
+	m = next.(Model)
+	if m.confirmDelete != "" || len(e.deleted) != 0 {
+		t.Fatal("expected no to cancel")
+	}
+
+	next, _ = m.Update(keyMsg("d"))
+	m = next.(Model)
+	next, _ = m.Update(keyMsg("y"))
+	m = next.(Model)
+	if deleteData, ok := e.deleted[keep]; !ok || deleteData {
+		t.Fatalf("expected %s deleted keeping its files, got %v", keep, e.deleted)
+	}
+
+	selectTorrent(files)
+	next, _ = m.Update(keyMsg("d"))
+	m = next.(Model)
+	next, _ = m.Update(keyMsg("f"))
+	m = next.(Model)
+	if !e.deleted[files] {
+		t.Fatalf("expected %s deleted with its files, got %v", files, e.deleted)
+	}
+	if len(m.torrents) != 0 || !strings.Contains(m.statusMsg, "Deleted with files") {
+		t.Fatalf("unexpected state: %d torrents, status %q", len(m.torrents), m.statusMsg)
+	}
+}
+
+func TestSortColumns(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	big, _ := e.AddFake("big.iso", 300)
+	small, _ := e.AddFake("small.iso", 100)
+	alpha, _ := e.AddFake("alpha.iso", 200)
+	beta, _ := e.AddFake("beta.iso", 200)
+	m := NewModel(e)
+	m.updateTorrentStats()
+	if want := []string{alpha, beta, big, small}; !slices.Equal(m.torrentKeys, want) {
+		t.Fatalf("expected name order %v, got %v", want, m.torrentKeys)
+	}
+	m.selectedIdx = slices.Index(m.torrentKeys, big)
+
+	// name, progress, size
+	for range 2 {
+		next, _ := m.Update(keyMsg("O"))
+		m = next.(Model)
+	}
+	if m.sortColumn != "size" {
+		t.Fatalf("expected sort by size, got %q", m.sortColumn)
+	}
+	// equal sizes keep their name order
+	if want := []string{small, alpha, beta, big}; !slices.Equal(m.torrentKeys, want) {
+		t.Fatalf("expected size order %v, got %v", want, m.torrentKeys)
+	}
+	if m.torrentKeys[m.selectedIdx] != big {
+		t.Fatal("expected the selection to follow the torrent")
+	}
+
+	next, _ := m.Update(keyMsg("R"))
+	m = next.(Model)
+	if want := []string{big, alpha, beta, small}; !slices.Equal(m.torrentKeys, want) {
+		t.Fatalf("expected descending size order %v, got %v", want, m.torrentKeys)
+	}
+	if m.torrentKeys[m.selectedIdx] != big {
+		t.Fatal("expected the selection to follow the torrent")
+	}
+	if !strings.Contains(m.View(), "Sort: size desc") {
+		t.Fatalf("expected the sort in the subtitle, got %q", m.View())
+	}
+	// ticks don't reorder equal rows
+	m.updateTorrentStats()
+	if want := []string{big, alpha, beta, small}; !slices.Equal(m.torrentKeys, want) {
+		t.Fatalf("expected a stable order, got %v", m.torrentKeys)
+	}
+
+	// wraps around after the last column
+	for range len(sortColumns) - 2 {
+		next, _ = m.Update(keyMsg("O"))
+		m = next.(Model)
+	}
+	if m.sortColumn != "name" {
+		t.Fatalf("expected the cycle back at name, got %q", m.sortColumn)
+	}
+}
+
+func TestFilePane(t *testing.T) {
+	e := engine.NewMemoryEngine()
+	info := metainfo.Info{Name: "many", PieceLength: 16384, Pieces: make([]byte, 20)}
+	for i := range 30 {
+		info.Files = append(info.Files, metainfo.FileInfo{Path: []string{fmt.Sprintf("f%02d.bin", i)}, Length: 100})
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewTorrent(spec); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := e.AddFake("other.iso", 100)
+	m := NewModel(e)
+	m.updateTorrentStats()
+	press := func(keys ...string) {
+		t.Helper()
+		for _, k := range keys {
+			next, _ := m.Update(keyMsg(k))
+			m = next.(Model)
+		}
+	}
+	press("enter")
+	tor := m.detailsTorrent()
+	if tor == nil || tor.Name != "many" {
+		t.Fatalf("expected the details of many, got %+v", tor)
+	}
+	if view := m.View(); !strings.Contains(view, "Files (1-10 of 30)") || strings.Contains(view, "f10.bin") {
+		t.Fatalf("expected the first 10 files listed, got %q", view)
+	}
+
+	press("tab")
+	for range 15 {
+		press("down")
+	}
+	if m.fileIdx != 15 || m.torrentKeys[m.selectedIdx] == other {
+		t.Fatalf("expected the file selection moved, not the torrent, got file %d", m.fileIdx)
+	}
+	view := m.View()
+	if !strings.Contains(view, "> ") || !strings.Contains(view, "f15.bin") || strings.Contains(view, "f05.bin") {
+		t.Fatalf("expected the list scrolled to the selection, got %q", view)
+	}
+
+	press(" ")
+	if tor.Files[15].Priority != engine.FilePrioritySkip || !strings.Contains(m.View(), "[skip]") {
+		t.Fatalf("expected f15.bin skipped, status %q", m.statusMsg)
+	}
+	press(" ")
+	if tor.Files[15].Priority != engine.FilePriorityNormal {
+		t.Fatalf("expected f15.bin downloaded again, status %q", m.statusMsg)
+	}
+
+	press("G")
+	if m.fileIdx != 29 || !strings.Contains(m.View(), "Files (21-30 of 30)") {
+		t.Fatalf("expected the last files listed, got %q", m.View())
+	}
+	// once the pane is left, up/down move the torrent selection again
+	press("tab", "down")
+	if m.fileFocus || m.torrentKeys[m.selectedIdx] != other {
+		t.Fatalf("expected the torrent selection moved, focus %v", m.fileFocus)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	// a missing file is written with the defaults
+	path := filepath.Join(dir, "config.json")
+	c, err := loadConfig(path)
+	if err != nil || c != defaultConfig() {
+		t.Fatalf("expected the defaults, got %+v, %v", c, err)
+	}
+	if c, err := loadConfig(path); err != nil || c != defaultConfig() {
+		t.Fatalf("expected the written defaults read back, got %+v, %v", c, err)
+	}
+
+	// missing fields keep their defaults
+	if err := os.WriteFile(path, []byte(`{"IncomingPort": 6881, "MaxUploadRate": 1000}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IncomingPort != 6881 || c.MaxUploadRate != 1000 || c.DownloadDirectory != "./downloads" || !c.AutoStart {
+		t.Fatalf("unexpected config %+v", c)
+	}
+
+	// durations are written as strings, and numbers of nanoseconds still read
+	c = defaultConfig()
+	c.RateHistoryAge = 90 * time.Second
+	c.ClientConfigOverrides.HandshakesTimeout = 4 * time.Second
+	if err := writeConfig(path, c); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"IdlePeerTimeout": "5m0s"`, `"RateHistoryAge": "1m30s"`, `"HandshakesTimeout": "4s"`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %s in the written config, got\n%s", want, data)
+		}
+	}
+	if got, err := loadConfig(path); err != nil || got != c {
+		t.Fatalf("expected %+v read back, got %+v, %v", c, got, err)
+	}
+	if err := os.WriteFile(path, []byte(`{"IdlePeerTimeout": 60000000000, "ClientConfigOverrides": {"MinDialTimeout": "2s"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadConfig(path)
+	if err != nil || c.IdlePeerTimeout != time.Minute || c.ClientConfigOverrides.MinDialTimeout != 2*time.Second {
+		t.Fatalf("unexpected durations %+v, %v", c, err)
+	}
+
+	for content, want := range map[string]string{
+		"{\n  \"AutoStart\": true,\n  \"IncomingPort\": 6881,,\n}": "line 3, column 24",
+		`{"IncomingPort": "6881"}`:                                 "IncomingPort",
+		`{"IncomingPort": 70000}`:                                  "out of range",
+		`{"DownloadDirectory": ""}`:                                "DownloadDirectory must not be empty",
+		`{"IncommingPort": 6881}`:                                  "unknown field",
+		"":                                                         "file is empty",
+		`{"OnComplete": {"Mode": "x"}}`:                            "unknown OnComplete mode",
+		`{"IdlePeerTimeout": "5 minutes"}`:                         "invalid duration",
+		`{"IdlePeerTimeout": true}`:                                "invalid duration",
+		`{"IdlePeerTimeout": "-1m"}`:                               "IdlePeerTimeout must not be negative",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", content, want, err)
+		}
+	}
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Fatal("expected the test process alive")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if processAlive(cmd.Process.Pid) {
+		t.Fatalf("expected pid %d of an exited process reported dead", cmd.Process.Pid)
+	}
+}
+
+func TestDaemonStartRace(t *testing.T) {
+	if os.Getenv("INTUNJA_FAKE_DAEMON") != "" {
+		// a daemon started by the test below
+		time.Sleep(time.Minute)
+		return
+	}
+	fakeDaemon := func() *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDaemonStartRace$")
+		cmd.Env = append(os.Environ(), "INTUNJA_FAKE_DAEMON=1")
+		return cmd
+	}
+	pidfile := filepath.Join(t.TempDir(), "intunja-daemon.pid")
+
+	cmds := []*exec.Cmd{fakeDaemon(), fakeDaemon()}
+	errs := make([]error, len(cmds))
+	done := make(chan int)
+	for i, cmd := range cmds {
+		go func() {
+			errs[i] = startDaemonProcess(pidfile, cmd)
+			done <- i
+		}()
+	}
+	<-done
+	<-done
+	var started *exec.Cmd
+	for i, err := range errs {
+		if err == nil {
+			if started != nil {
+				t.Fatal("expected only one of two concurrent starts to succeed")
+			}
+			started = cmds[i]
+		} else if !strings.Contains(err.Error(), "already running") {
+			t.Fatalf("expected the other start refused as already running, got %v", err)
+		}
+	}
+	if started == nil {
+		t.Fatalf("expected one start to succeed, got %v", errs)
+	}
+	if pid := readPid(pidfile); pid != started.Process.Pid {
+		t.Fatalf("expected pid %d recorded, got %d", started.Process.Pid, pid)
+	}
+
+	// a crashed daemon releases the lock
+	started.Process.Kill()
+	started.Wait()
+	next := fakeDaemon()
+	if err := startDaemonProcess(pidfile, next); err != nil {
+		t.Fatalf("expected a start after a crash to succeed, got %v", err)
+	}
+	go next.Wait()
+	if err := stopDaemonProcess(pidfile); err != nil {
+		t.Fatal(err)
+	}
+	if pid := readPid(pidfile); pid != 0 {
+		t.Fatalf("expected the pid file emptied on stop, got pid %d", pid)
+	}
+	if err := stopDaemonProcess(pidfile); err == nil {
+		t.Fatal("expected stopping a stopped daemon to fail")
+	}
+}
+
+func TestRunningDaemon(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	config := engine.Config{}
+	if url := runningDaemon(config); url != "" {
+		t.Fatalf("expected no daemon, got %s", url)
+	}
+	pidfile, err := pidFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(pidfile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pidfile, []byte(fmt.Sprint(os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if url := runningDaemon(config); url != "http://"+daemonAddr {
+		t.Fatalf("expected the daemon's TCP address, got %q", url)
+	}
+	config.APISocket = "/run/intunja.sock"
+	if url := runningDaemon(config); url != "unix:///run/intunja.sock" {
+		t.Fatalf("expected the daemon's socket, got %q", url)
+	}
+}
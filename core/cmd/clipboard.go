@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/anacrolix/torrent/metainfo"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// errNoClipboard is returned by copyToClipboard when none of the clipboard
+// tools for this OS are installed.
+var errNoClipboard = errors.New("no clipboard tool found")
+
+// clipboardCommands lists the commands that copy their stdin to the
+// clipboard, in order of preference. Tests replace it.
+var clipboardCommands = func() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	default:
+		return [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+	}
+}
+
+// copyToClipboard copies text to the system clipboard with the first
+// available clipboard tool.
+func copyToClipboard(text string) error {
+	for _, args := range clipboardCommands() {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errNoClipboard
+}
+
+// magnetLink rebuilds a magnet URI from the torrent's info-hash, name and
+// trackers.
+func magnetLink(t *engine.Torrent) (string, error) {
+	var ih metainfo.Hash
+	if err := ih.FromHexString(t.InfoHash); err != nil {
+		return "", err
+	}
+	m := metainfo.Magnet{InfoHash: ih, DisplayName: t.Name, Trackers: t.Trackers}
+	return m.String(), nil
+}
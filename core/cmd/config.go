@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// defaultConfig is the configuration used for settings missing from the
+// config file.
+func defaultConfig() engine.Config {
+	return engine.Config{
+		AutoStart:            true,
+		DisableEncryption:    false,
+		DownloadDirectory:    "./downloads",
+		EnableUpload:         true,
+		EnableSeeding:        true,
+		IncomingPort:         50007,
+		EnablePortForwarding: true,
+		EnableDHT:            true,
+		EnablePEX:            true,
+		MinFreeSpace:         1 << 30,
+		IdlePeerTimeout:      5 * time.Minute,
+	}
+}
+
+// loadConfig reads the JSON config file at path over the defaults. A
+// missing file is created with the defaults, for users to edit.
+func loadConfig(path string) (engine.Config, error) {
+	config := defaultConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, writeConfig(path, config)
+	}
+	if err != nil {
+		return config, err
+	}
+	file := toConfigFile(config)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		if errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("%s: file is empty", path)
+		}
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			// Offset counts the offending byte
+			line, col := position(data, syntax.Offset-1)
+			return config, fmt.Errorf("%s: invalid JSON at line %d, column %d: %w", path, line, col, err)
+		}
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	config = file.config()
+	if err := validateConfig(config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// writeConfig saves config to path as indented JSON.
+func writeConfig(path string, config engine.Config) error {
+	data, err := json.MarshalIndent(toConfigFile(config), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write default config: %w", err)
+	}
+	return nil
+}
+
+// configFile is engine.Config as it is written to the config file, with
+// its durations as strings like "5m" rather than nanoseconds. Its fields
+// shadow those of the embedded engine.Config of the same name.
+type configFile struct {
+	engine.Config
+	RateHistoryAge        duration
+	IdlePeerTimeout       duration
+	ClientConfigOverrides overridesFile
+}
+
+// overridesFile is engine.ClientOverrides as configFile writes it.
+type overridesFile struct {
+	engine.ClientOverrides
+	NominalDialTimeout duration
+	MinDialTimeout     duration
+	HandshakesTimeout  duration
+}
+
+func toConfigFile(c engine.Config) configFile {
+	o := c.ClientConfigOverrides
+	return configFile{
+		Config:          c,
+		RateHistoryAge:  duration(c.RateHistoryAge),
+		IdlePeerTimeout: duration(c.IdlePeerTimeout),
+		ClientConfigOverrides: overridesFile{
+			ClientOverrides:    o,
+			NominalDialTimeout: duration(o.NominalDialTimeout),
+			MinDialTimeout:     duration(o.MinDialTimeout),
+			HandshakesTimeout:  duration(o.HandshakesTimeout),
+		},
+	}
+}
+
+// config returns the engine.Config f holds.
+func (f configFile) config() engine.Config {
+	c := f.Config
+	c.RateHistoryAge = time.Duration(f.RateHistoryAge)
+	c.IdlePeerTimeout = time.Duration(f.IdlePeerTimeout)
+	c.ClientConfigOverrides = f.ClientConfigOverrides.ClientOverrides
+	c.ClientConfigOverrides.NominalDialTimeout = time.Duration(f.ClientConfigOverrides.NominalDialTimeout)
+	c.ClientConfigOverrides.MinDialTimeout = time.Duration(f.ClientConfigOverrides.MinDialTimeout)
+	c.ClientConfigOverrides.HandshakesTimeout = time.Duration(f.ClientConfigOverrides.HandshakesTimeout)
+	return c
+}
+
+// duration is a time.Duration in JSON as a string like "1m30s". Numbers
+// are still read as nanoseconds, as config files used to have them.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*d = duration(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if v, err := time.ParseDuration(s); err == nil {
+			*d = duration(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid duration %s, expected a string like \"5m\"", data)
+}
+
+// validateConfig catches settings the engine would reject or misuse, with
+// the name of the setting as it appears in the file.
+func validateConfig(c engine.Config) error {
+	switch {
+	case c.DownloadDirectory == "":
+		return errors.New("DownloadDirectory must not be empty")
+	case c.IncomingPort < 0 || c.IncomingPort > 65535:
+		return fmt.Errorf("IncomingPort %d is out of range 0-65535", c.IncomingPort)
+	case c.MaxDownloadRate < 0 || c.MaxUploadRate < 0:
+		return errors.New("MaxDownloadRate and MaxUploadRate must not be negative")
+	case c.SeedRatioLimit < 0:
+		return errors.New("SeedRatioLimit must not be negative")
+	case c.RateHistoryLength < 0 || c.RateHistoryAge < 0:
+		return errors.New("RateHistoryLength and RateHistoryAge must not be negative")
+	case c.PeerRequestQueue < 0:
+		return errors.New("PeerRequestQueue must not be negative")
+	case c.MinFreeSpace < 0:
+		return errors.New("MinFreeSpace must not be negative")
+	case c.IdlePeerTimeout < 0:
+		return errors.New("IdlePeerTimeout must not be negative")
+	case c.MaxTorrents < 0:
+		return errors.New("MaxTorrents must not be negative")
+	}
+	if err := c.ClientConfigOverrides.Validate(); err != nil {
+		return err
+	}
+	switch c.OnComplete.Mode {
+	case "", "seed", "stop":
+	case "ratio":
+		if c.OnComplete.Ratio <= 0 {
+			return fmt.Errorf("OnComplete ratio %v must be positive", c.OnComplete.Ratio)
+		}
+	default:
+		return fmt.Errorf("unknown OnComplete mode %q (seed, stop or ratio)", c.OnComplete.Mode)
+	}
+	return nil
+}
+
+// position returns the line and column, both from 1, of the byte at offset
+// in data.
+func position(data []byte, offset int64) (line, col int) {
+	before := data[:min(int(offset), len(data))]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+const (
+	// daemonAddr is where the daemon's API listens unless Config.APISocket
+	// is set.
+	daemonAddr = "localhost:8080"
+	// daemonShutdownTimeout bounds the wait for API requests in flight
+	// when the daemon is stopped.
+	daemonShutdownTimeout = 5 * time.Second
+)
+
+// runDaemon runs intunja daemon start|stop|status|run.
+func runDaemon(configPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing daemon subcommand: start|stop|status|run")
+	}
+	pidfile, err := pidFilePath()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "start":
+		if err := daemonStart(configPath, pidfile); err != nil {
+			return fmt.Errorf("failed to start daemon: %w", err)
+		}
+		fmt.Println("daemon started")
+	case "stop":
+		if err := stopDaemonProcess(pidfile); err != nil {
+			return fmt.Errorf("failed to stop daemon: %w", err)
+		}
+		fmt.Println("daemon stopped")
+	case "status":
+		alive, pid := daemonStatus(pidfile)
+		if pid == 0 {
+			fmt.Println("no daemon pid file")
+		} else if alive {
+			fmt.Printf("daemon running (pid=%d)\n", pid)
+		} else {
+			fmt.Printf("daemon not running (stale pid=%d)\n", pid)
+		}
+	case "run":
+		return serveDaemon(configPath)
+	default:
+		return fmt.Errorf("unknown daemon subcommand: %s", args[0])
+	}
+	return nil
+}
+
+// pidFilePath is where the daemon's pid is kept, next to its API token.
+func pidFilePath() (string, error) {
+	path, err := engine.TokenPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate daemon pid file: %w", err)
+	}
+	return filepath.Join(filepath.Dir(path), "daemon.pid"), nil
+}
+
+// daemonStart starts intunja daemon run in the background with the same
+// config file.
+func daemonStart(configPath, pidfile string) error {
+	if err := os.MkdirAll(filepath.Dir(pidfile), 0700); err != nil {
+		return err
+	}
+	cmd := exec.Command(os.Args[0], "-config", configPath, "daemon", "run")
+	cmd.SysProcAttr = detachedProcAttr()
+	return startDaemonProcess(pidfile, cmd)
+}
+
+// daemonStatus reports whether the daemon recorded in pidfile is running,
+// and its pid, 0 without one.
+func daemonStatus(pidfile string) (bool, int) {
+	pid := readPid(pidfile)
+	return pid != 0 && processAlive(pid), pid
+}
+
+// daemonURL is the RemoteEngine base URL of the daemon's API.
+func daemonURL(config engine.Config) string {
+	if config.APISocket != "" {
+		return "unix://" + config.APISocket
+	}
+	return "http://" + daemonAddr
+}
+
+// runningDaemon returns the base URL of a running daemon's API, or "" if
+// none is running.
+func runningDaemon(config engine.Config) string {
+	pidfile, err := pidFilePath()
+	if err != nil {
+		return ""
+	}
+	if alive, _ := daemonStatus(pidfile); !alive {
+		return ""
+	}
+	return daemonURL(config)
+}
+
+// serveDaemon runs the engine in the foreground, serving engine.APIHandler
+// on Config.APISocket or daemonAddr until interrupted or terminated.
+func serveDaemon(configPath string) error {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := os.MkdirAll(config.DownloadDirectory, 0755); err != nil {
+		return fmt.Errorf("failed to create download directory: %w", err)
+	}
+	e := engine.New()
+	defer e.Close()
+	p, persistErr, err := configureEngine(e, config)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		defer func() {
+			e.DetachPersister()
+			p.Close()
+		}()
+	} else {
+		log.Printf("warning: could not open persister: %v", persistErr)
+	}
+	token, err := engine.ServerToken(config)
+	if err != nil {
+		return err
+	}
+	var l net.Listener
+	if config.APISocket != "" {
+		l, err = engine.ListenSocket(config.APISocket)
+	} else {
+		l, err = net.Listen("tcp", daemonAddr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen for the API: %w", err)
+	}
+	srv := &http.Server{Handler: engine.APIHandler(e, token)}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	select {
+	case err := <-served:
+		return err
+	case <-sigc:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
+
+// configureEngine attaches the database in the download directory to e,
+// then configures e and restores the torrents recorded there. If the
+// database can't be opened, e is only configured and persistErr says why.
+func configureEngine(e engine.EngineInterface, config engine.Config) (p *engine.Persister, persistErr, err error) {
+	p, persistErr = engine.NewPersister(filepath.Join(config.DownloadDirectory, "intunja.db"))
+	if persistErr != nil {
+		p = nil
+	} else {
+		e.AttachPersister(p)
+	}
+	if err := e.Configure(config); err != nil {
+		if p != nil {
+			e.DetachPersister()
+			p.Close()
+		}
+		return nil, persistErr, fmt.Errorf("failed to configure engine: %w", err)
+	}
+	if p != nil {
+		e.RehydrateFromPersister()
+	}
+	return p, persistErr, nil
+}
@@ -0,0 +1,61 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachedProcAttr starts the daemon in a session of its own, so it
+// outlives the terminal that started it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether a process with the given pid exists. A
+// process we may not signal still exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// lockFile opens path, creating it, and takes an exclusive flock on it,
+// failing with errLocked if another process holds one. The kernel drops the
+// lock when the last descriptor of the file closes, including on a crash.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// inheritLock passes the locked f on to cmd's process, which then holds
+// the lock for as long as it runs.
+func inheritLock(cmd *exec.Cmd, f *os.File) error {
+	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	return nil
+}
+
+// terminateProcess asks the process to shut down with SIGTERM.
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,88 @@
+//go:build windows
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	// process creation flag, missing from syscall
+	detachedProcess = 0x00000008
+	// exit code of a process that hasn't exited
+	stillActive = 259
+	// error opening a file another handle's share mode excludes
+	errorSharingViolation syscall.Errno = 32
+)
+
+// detachedProcAttr starts the daemon without a console and in a process
+// group of its own, so closing the terminal or pressing Ctrl+C in it
+// doesn't reach the daemon.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP,
+		HideWindow:    true,
+	}
+}
+
+// processAlive reports whether a process with the given pid is running.
+// os.FindProcess always succeeds on Windows and signal 0 isn't supported,
+// so the process is opened and its exit code checked instead.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// lockFile opens path, creating it, for writing while sharing it for
+// reading only, so no other process can lock it until the handle and those
+// inherited from it close, including on a crash. It fails with errLocked
+// while another process holds the file.
+func lockFile(path string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+// inheritLock passes the locked f on to cmd's process, which then holds
+// the lock for as long as it runs.
+func inheritLock(cmd *exec.Cmd, f *os.File) error {
+	h := syscall.Handle(f.Fd())
+	if err := syscall.SetHandleInformation(h, syscall.HANDLE_FLAG_INHERIT, syscall.HANDLE_FLAG_INHERIT); err != nil {
+		return err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.AdditionalInheritedHandles = append(cmd.SysProcAttr.AdditionalInheritedHandles, h)
+	return nil
+}
+
+// terminateProcess ends the process. Windows has no SIGTERM, and a detached
+// process has no console to send Ctrl+Break to, so it is killed outright.
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
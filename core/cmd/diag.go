@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+const (
+	// diagDiskSize is how much data intunja diag writes to time the disk.
+	diagDiskSize = 64 << 20
+	// diagPeerWait is how long intunja diag lets a torrent find peers
+	// before dialing them.
+	diagPeerWait = 20 * time.Second
+	// diagMaxPeers bounds the peers intunja diag dials.
+	diagMaxPeers = 50
+)
+
+// runDiag prints a report telling disk, network and client slowness apart:
+// the download directory's throughput and, given a magnet, .torrent file or
+// URL, how its trackers and peers respond. The torrent is downloaded to a
+// temporary directory for the duration, so a running TUI's data is left
+// alone, but the incoming port must be free.
+func runDiag(w io.Writer, config engine.Config, args []string) error {
+	fmt.Fprintf(w, "Disk (%s):\n", config.DownloadDirectory)
+	d, err := engine.MeasureDisk(config.DownloadDirectory, diagDiskSize)
+	if err != nil {
+		fmt.Fprintf(w, "  failed: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "  write %s/s, read %s/s (%s)\n",
+			formatBytes(int64(d.WriteRate())), formatBytes(int64(d.ReadRate())), formatBytes(d.Bytes))
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(w, "Pass a magnet, .torrent file or URL to check trackers and peers too.")
+		return nil
+	}
+
+	tmp, err := os.MkdirTemp("", "intunja-diag-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	e := engine.New()
+	config.AutoStart = true
+	if err := e.Configure(config); err != nil {
+		return fmt.Errorf("failed to start engine: %w", err)
+	}
+	if err := e.AddTorrentTo(args[0], tmp); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	// the only torrent of the fresh engine
+	var ih string
+	for k := range e.GetTorrents() {
+		ih = k
+	}
+
+	fmt.Fprintln(w, "Trackers:")
+	checks, err := e.CheckTrackers(ih)
+	if err != nil {
+		return err
+	}
+	if len(checks) == 0 {
+		fmt.Fprintln(w, "  none (HTTP or UDP)")
+	}
+	for _, c := range checks {
+		if c.Err != nil {
+			fmt.Fprintf(w, "  %s: unreachable: %v\n", c.Tracker, c.Err)
+		} else {
+			fmt.Fprintf(w, "  %s: ok in %s, %d peers\n", c.Tracker, c.Latency.Round(time.Millisecond), c.Peers)
+		}
+	}
+
+	fmt.Fprintf(w, "Peers (after %s):\n", diagPeerWait)
+	time.Sleep(diagPeerWait)
+	pc, err := e.CheckPeers(ih, diagMaxPeers)
+	if err != nil {
+		return err
+	}
+	if pc.Dialed == 0 {
+		fmt.Fprintln(w, "  none found")
+	} else {
+		fmt.Fprintf(w, "  %d of %d accepted a connection (%.0f%%)\n", pc.Connected, pc.Dialed, 100*pc.Rate())
+	}
+	if t := e.GetTorrents()[ih]; t != nil {
+		fmt.Fprintf(w, "  client: %d seeds and %d leechers connected, downloading at %s/s\n",
+			t.Seeds, t.Leechers, formatBytes(int64(t.DownloadRate)))
+	}
+	return e.DeleteTorrent(ih, false)
+}
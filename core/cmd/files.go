@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// fileRows is how many files the details view shows at once; longer lists
+// scroll with the selection.
+const fileRows = 10
+
+// detailsTorrent returns the torrent shown in the details view, or nil.
+func (m Model) detailsTorrent() *engine.Torrent {
+	if m.selectedIdx < 0 || m.selectedIdx >= len(m.torrentKeys) {
+		return nil
+	}
+	return m.torrents[m.torrentKeys[m.selectedIdx]]
+}
+
+// handleFileKey processes the keys of the focused file pane. Keys it
+// doesn't use fall through to handleKeyPress.
+func (m Model) handleFileKey(msg tea.KeyMsg) (Model, bool) {
+	t := m.detailsTorrent()
+	if t == nil || len(t.Files) == 0 {
+		m.fileFocus = false
+		return m, false
+	}
+	switch msg.String() {
+	case "tab", "esc":
+		m.fileFocus = false
+	case "up", "k":
+		m.fileIdx = max(m.fileIdx-1, 0)
+	case "down", "j":
+		m.fileIdx = min(m.fileIdx+1, len(t.Files)-1)
+	case "home", "g":
+		m.fileIdx = 0
+	case "end", "G":
+		m.fileIdx = len(t.Files) - 1
+	case " ":
+		m.toggleFile(t)
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// toggleFile skips the selected file, or downloads it again if skipped.
+func (m *Model) toggleFile(t *engine.Torrent) {
+	m.fileIdx = min(m.fileIdx, len(t.Files)-1)
+	f := t.Files[m.fileIdx]
+	if f == nil {
+		return
+	}
+	var err error
+	if f.Priority == engine.FilePrioritySkip {
+		err = m.engine.StartFile(t.InfoHash, f.Path)
+	} else {
+		err = m.engine.StopFile(t.InfoHash, f.Path)
+	}
+	switch {
+	case err != nil:
+		m.statusMsg = fmt.Sprintf("Error: %v", err)
+		m.statusStyle = m.styles.Error
+	case f.Priority == engine.FilePrioritySkip:
+		m.statusMsg = fmt.Sprintf("Skipped: %s", truncate(f.Path, 40))
+		m.statusStyle = m.styles.Success
+	default:
+		m.statusMsg = fmt.Sprintf("Downloading: %s", truncate(f.Path, 40))
+		m.statusStyle = m.styles.Success
+	}
+}
+
+// renderFiles lists the files of t, a window of fileRows around the
+// selection, with each file's progress, size and priority.
+func (m Model) renderFiles(t *engine.Torrent) string {
+	n := len(t.Files)
+	sel := min(m.fileIdx, n-1)
+	// keep the selection in the middle of the window where possible
+	start := max(0, min(sel-fileRows/2, n-fileRows))
+	end := min(start+fileRows, n)
+
+	var b strings.Builder
+	if n > fileRows {
+		fmt.Fprintf(&b, "Files (%d-%d of %d):\n", start+1, end, n)
+	} else {
+		b.WriteString("Files:\n")
+	}
+	for i := start; i < end; i++ {
+		f := t.Files[i]
+		if f == nil {
+			continue
+		}
+		cursor := "  "
+		if m.fileFocus && i == sel {
+			cursor = "> "
+		}
+		line := fmt.Sprintf("%s%-6s [%3.0f%%] %s (%s)",
+			cursor,
+			describePriority(f.Priority),
+			f.Percent,
+			truncate(f.Path, 50),
+			formatBytes(f.Size))
+		if f.Priority == engine.FilePrioritySkip {
+			line = m.styles.Subtitle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// describePriority is the marker shown before a file in the details view.
+func describePriority(p engine.FilePriority) string {
+	switch p {
+	case engine.FilePrioritySkip:
+		return "[skip]"
+	case engine.FilePriorityHigh:
+		return "[high]"
+	default:
+		return ""
+	}
+}
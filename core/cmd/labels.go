@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// labelsPrompt asks for the labels of the selected torrent, see [e].
+const labelsPrompt = "Enter labels (comma separated, empty to clear):"
+
+// allLabels returns the labels used by any of ts, sorted.
+func allLabels(ts map[string]*engine.Torrent) []string {
+	var labels []string
+	for _, t := range ts {
+		if t == nil {
+			continue
+		}
+		for _, l := range t.Labels {
+			if !slices.Contains(labels, l) {
+				labels = append(labels, l)
+			}
+		}
+	}
+	slices.Sort(labels)
+	return labels
+}
+
+// nextLabel returns the label filter after current when cycling through
+// labels, "" (no filter) coming before the first and after the last.
+func nextLabel(labels []string, current string) string {
+	i := slices.Index(labels, current)
+	if i+1 < len(labels) {
+		return labels[i+1]
+	}
+	return ""
+}
+
+// parseLabels splits comma separated labels.
+func parseLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// Display modes of the main view, cycled with [t].
+const (
+	displayTable   = "table"   // one row per torrent with all columns
+	displayCompact = "compact" // one short line per torrent
+	displayGrouped = "grouped" // compact lines under a heading per status
+)
+
+var displayModes = []string{displayTable, displayCompact, displayGrouped}
+
+func nextDisplayMode(mode string) string {
+	for i, d := range displayModes {
+		if d == mode {
+			return displayModes[(i+1)%len(displayModes)]
+		}
+	}
+	return displayModes[0]
+}
+
+// mainViewChrome is the number of lines the main view uses besides the
+// torrent list: title, subtitle, spacing, a two line status message and help.
+const mainViewChrome = 10
+
+// listHeight is how many lines the torrent list may use.
+func (m Model) listHeight() int {
+	if m.height <= 0 {
+		return 15
+	}
+	return max(m.height-mainViewChrome, 1)
+}
+
+// Status groups of the grouped display mode, in display order.
+var statusGroups = []string{"Active", "Seeding", "Paused"}
+
+// statusGroup returns the index in statusGroups t is listed under.
+func statusGroup(t *engine.Torrent) int {
+	switch engine.Summarize(t).State {
+	case "seeding", "seed-only":
+		return 1
+	case "stopped", "seeding complete":
+		return 2
+	default:
+		return 0
+	}
+}
+
+// groupKeys stably orders keys by the status group of their torrent.
+func groupKeys(keys []string, ts map[string]*engine.Torrent) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return statusGroup(ts[keys[i]]) < statusGroup(ts[keys[j]])
+	})
+}
+
+// compactLine renders a torrent as a single line: name, a mini progress bar
+// and the download rate.
+func (m Model) compactLine(t *engine.Torrent, selected bool) string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+	cursor := "  "
+	if selected {
+		cursor = "> "
+	}
+	rate := formatBytes(int64(t.DownloadRate)) + "/s"
+	// cursor, bar, percent and rate with the spaces between them
+	nameWidth := max(width-len(cursor)-miniBarWidth-7-12-3, 10)
+	return fmt.Sprintf("%s%-*s %s %5.1f%% %12s",
+		cursor, nameWidth, truncate(t.Name, nameWidth), miniBar(t.Percent), t.Percent, rate)
+}
+
+// miniBarWidth is the width of the progress bars in compact lines.
+const miniBarWidth = 10
+
+func miniBar(percent float32) string {
+	filled := min(max(int(percent/100*miniBarWidth), 0), miniBarWidth)
+	return strings.Repeat("█", filled) + strings.Repeat("░", miniBarWidth-filled)
+}
+
+// renderCompact lists one line per torrent.
+func (m Model) renderCompact() string {
+	var lines []string
+	selectedLine := 0
+	for i, key := range m.torrentKeys {
+		t := m.torrents[key]
+		if t == nil {
+			continue
+		}
+		if i == m.selectedIdx {
+			selectedLine = len(lines)
+		}
+		lines = append(lines, m.compactLine(t, i == m.selectedIdx))
+	}
+	return m.scrollList(lines, selectedLine)
+}
+
+// renderGrouped lists torrents as compact lines under a heading per status
+// group. The keys are kept in group order by updateTorrentStats.
+func (m Model) renderGrouped() string {
+	var lines []string
+	selectedLine := 0
+	group := -1
+	for i, key := range m.torrentKeys {
+		t := m.torrents[key]
+		if t == nil {
+			continue
+		}
+		if g := statusGroup(t); g != group {
+			group = g
+			lines = append(lines, m.styles.Subtitle.Render(statusGroups[g]))
+		}
+		if i == m.selectedIdx {
+			selectedLine = len(lines)
+		}
+		lines = append(lines, m.compactLine(t, i == m.selectedIdx))
+	}
+	return m.scrollList(lines, selectedLine)
+}
+
+// scrollList returns the listHeight lines of lines that keep the selected
+// line in view.
+func (m Model) scrollList(lines []string, selected int) string {
+	h := m.listHeight()
+	start := 0
+	if selected >= h {
+		start = selected - h + 1
+	}
+	end := min(start+h, len(lines))
+	return strings.Join(lines[start:end], "\n")
+}
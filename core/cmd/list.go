@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// runList prints the torrents as a JSON array of engine.TorrentSummary, in
+// canonical order, for scripts. It asks the daemon if one is running, or
+// else restores a local engine from its database, in which case torrents
+// still fetching their metadata are listed as loading.
+func runList(w io.Writer, e engine.EngineInterface, config engine.Config) error {
+	var summaries []engine.TorrentSummary
+	switch e := e.(type) {
+	case *engine.RemoteEngine:
+		var err error
+		if summaries, err = e.ListTorrentsCtx(context.Background()); err != nil {
+			return fmt.Errorf("failed to list torrents: %w", err)
+		}
+	case *engine.Engine:
+		defer e.Close()
+		p, persistErr, err := configureEngine(e, config)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return fmt.Errorf("could not open persister: %w", persistErr)
+		}
+		defer func() {
+			e.DetachPersister()
+			p.Close()
+		}()
+		summaries = e.ListTorrents()
+	default:
+		summaries = e.ListTorrents()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summaries)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// daemonStopTimeout is how long stopping the daemon waits for it to exit.
+const daemonStopTimeout = 10 * time.Second
+
+// errLocked is returned by lockFile for a file another process has locked.
+var errLocked = errors.New("file is locked")
+
+// startDaemonProcess starts cmd as the daemon, recording its pid in
+// pidfile. The pid file stays locked for as long as the daemon runs, which
+// lets only one start succeed even when several race: the others fail
+// with "daemon already running". A crashed daemon's lock is released with
+// it, so the stale pid left behind doesn't block the next start.
+func startDaemonProcess(pidfile string, cmd *exec.Cmd) error {
+	f, err := lockFile(pidfile)
+	if errors.Is(err, errLocked) {
+		return fmt.Errorf("daemon already running (pid=%d)", readPid(pidfile))
+	}
+	if err != nil {
+		return err
+	}
+	// the daemon holds its own handle of the lock from here on
+	defer f.Close()
+	if err := inheritLock(cmd, f); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(strconv.Itoa(cmd.Process.Pid)), 0)
+	return err
+}
+
+// stopDaemonProcess terminates the daemon recorded in pidfile and waits for
+// it to release the lock, then empties the pid file. A pid file nobody has
+// locked is stale, so its pid, possibly reused by now, is left alone.
+func stopDaemonProcess(pidfile string) error {
+	pid := readPid(pidfile)
+	if pid == 0 {
+		return fmt.Errorf("pid file not found")
+	}
+	if f, err := lockFile(pidfile); err == nil {
+		f.Truncate(0)
+		f.Close()
+		return fmt.Errorf("daemon not running (stale pid=%d)", pid)
+	}
+	if err := terminateProcess(pid); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(daemonStopTimeout)
+	for {
+		f, err := lockFile(pidfile)
+		if err == nil {
+			f.Truncate(0)
+			return f.Close()
+		}
+		if !errors.Is(err, errLocked) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon (pid=%d) did not exit within %s", pid, daemonStopTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// readPid returns the pid in pidfile, or 0 if there is none.
+func readPid(pidfile string) int {
+	b, err := os.ReadFile(pidfile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// sortColumns are the columns the list can be sorted by, cycled with [O].
+var sortColumns = []string{"name", "progress", "size", "rate", "status", "ratio"}
+
+// nextSortColumn returns the sort column after current.
+func nextSortColumn(current string) string {
+	i := slices.Index(sortColumns, current)
+	return sortColumns[(i+1)%len(sortColumns)]
+}
+
+// sortDirection names the direction for the subtitle and status line.
+func sortDirection(desc bool) string {
+	if desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// sortSummaries orders summaries, which come in canonical order (by name),
+// by column. The sort is stable and ties keep their canonical order in both
+// directions, so rows with equal values don't swap places between ticks.
+func sortSummaries(summaries []engine.TorrentSummary, ts map[string]*engine.Torrent, column string, desc bool) {
+	var compare func(a, b engine.TorrentSummary) int
+	switch column {
+	case "progress":
+		compare = func(a, b engine.TorrentSummary) int { return cmp.Compare(a.Percent, b.Percent) }
+	case "size":
+		compare = func(a, b engine.TorrentSummary) int { return cmp.Compare(a.Size, b.Size) }
+	case "rate":
+		compare = func(a, b engine.TorrentSummary) int { return cmp.Compare(a.DownloadRate, b.DownloadRate) }
+	case "status":
+		// in the order of the grouped layout: active, seeding, paused
+		compare = func(a, b engine.TorrentSummary) int {
+			return cmp.Compare(statusGroup(ts[a.InfoHash]), statusGroup(ts[b.InfoHash]))
+		}
+	case "ratio":
+		compare = func(a, b engine.TorrentSummary) int { return cmp.Compare(sortRatio(a.Ratio), sortRatio(b.Ratio)) }
+	default:
+		if desc {
+			slices.Reverse(summaries)
+		}
+		return
+	}
+	slices.SortStableFunc(summaries, func(a, b engine.TorrentSummary) int {
+		if desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+}
+
+// sortRatio places engine.InfiniteRatio above every finite ratio.
+func sortRatio(r float64) float64 {
+	if r == engine.InfiniteRatio {
+		return math.Inf(1)
+	}
+	return r
+}
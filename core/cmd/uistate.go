@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/mindsgn-studio/intunja/core/engine"
+)
+
+// uiStateKey is the persister meta key holding TUI preferences. It is kept
+// apart from torrent data so it can be reset without losing torrents.
+const uiStateKey = "ui_state"
+
+// uiState is the subset of TUI state restored across launches.
+type uiState struct {
+	SortColumn   string `json:"sort_column"`
+	SortDesc     bool   `json:"sort_desc"`
+	SelectedInfo string `json:"selected_info"`
+	DisplayMode  string `json:"display_mode"`
+}
+
+func (m Model) uiState() uiState {
+	selected := m.selectedInfo
+	if m.selectedIdx >= 0 && m.selectedIdx < len(m.torrentKeys) {
+		selected = m.torrentKeys[m.selectedIdx]
+	}
+	return uiState{
+		SortColumn:   m.sortColumn,
+		SortDesc:     m.sortDesc,
+		SelectedInfo: selected,
+		DisplayMode:  m.displayMode,
+	}
+}
+
+func (m *Model) applyUIState(s uiState) {
+	if slices.Contains(sortColumns, s.SortColumn) {
+		m.sortColumn = s.SortColumn
+	}
+	m.sortDesc = s.SortDesc
+	for _, d := range displayModes {
+		if s.DisplayMode == d {
+			m.displayMode = d
+		}
+	}
+	m.selectedInfo = s.SelectedInfo
+}
+
+func loadUIState(p *engine.Persister) (uiState, error) {
+	var s uiState
+	raw, err := p.GetMeta(uiStateKey)
+	if err != nil || raw == "" {
+		return s, err
+	}
+	err = json.Unmarshal([]byte(raw), &s)
+	return s, err
+}
+
+func saveUIState(p *engine.Persister, s uiState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return p.SetMeta(uiStateKey, string(b))
+}
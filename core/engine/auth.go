@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenPath is where the daemon keeps its API token, for RemoteEngine
+// clients of the same user to read: under $XDG_RUNTIME_DIR if set,
+// otherwise under the user's config directory. Unlike the temp directory,
+// neither is writable by other users.
+func TokenPath() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "intunja", "daemon.token"), nil
+}
+
+// ServerToken returns the token the daemon's API requires: Config.APIToken
+// if set, otherwise the one at TokenPath, generated on first use. It is
+// written to TokenPath either way, readable by the current user only.
+func ServerToken(c Config) (string, error) {
+	path, err := TokenPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate API token: %w", err)
+	}
+	if c.APIToken != "" {
+		return c.APIToken, writeToken(path, c.APIToken)
+	}
+	token, err := readTokenFile(path)
+	if err == nil && token != "" {
+		return token, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(raw)
+	return token, writeToken(path, token)
+}
+
+// writeToken replaces the token file at path with a new one only the
+// current user may read. It is created rather than opened, so a file or
+// link planted in its place is never written through.
+func writeToken(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to write API token: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to replace API token: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|oNoFollow, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write API token: %w", err)
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write API token: %w", err)
+	}
+	return f.Close()
+}
+
+// readTokenFile returns the token in the file at path, which must be a
+// regular file of the current user's that no one else may access.
+func readTokenFile(path string) (string, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("API token %s is not a regular file", path)
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|oNoFollow, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	defer f.Close()
+	// checked again on the open file, in case it was swapped meanwhile
+	if fi, err = f.Stat(); err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	if err := checkTokenFile(fi); err != nil {
+		return "", fmt.Errorf("refusing API token %s: %w", path, err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readToken returns the token at TokenPath, or "" if there is none or it
+// can't be trusted.
+func readToken() string {
+	path, err := TokenPath()
+	if err != nil {
+		return ""
+	}
+	token, err := readTokenFile(path)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// RequireToken wraps h to answer 401 Unauthorized unless the request has an
+// "Authorization: Bearer <token>" header. An empty token admits no one.
+func RequireToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="intunja"`)
+			http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// APIHandler serves the /api/* routes RemoteEngine uses, all requiring
+// token.
+func APIHandler(e EngineInterface, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/configure", ConfigureHandler(e))
+	mux.Handle("/api/magnet", MagnetHandler(e))
+	mux.Handle("/api/url", URLHandler(e))
+	mux.Handle("GET /api/torrents", TorrentsHandler(e))
+	mux.Handle("GET /api/torrents/{hash}/info", InfoHandler(e))
+	mux.Handle("POST /api/torrents/{hash}/verify", VerifyHandler(e))
+	mux.Handle("POST /api/torrents/{hash}/labels", LabelsHandler(e))
+	mux.Handle("POST /api/torrents/{hash}/limits", LimitsHandler(e))
+	mux.Handle("POST /api/torrents/{hash}/move", MoveHandler(e))
+	mux.Handle("POST /api/pause-all", PauseAllHandler(e))
+	mux.Handle("POST /api/resume-all", ResumeAllHandler(e))
+	mux.Handle("/api/torrent", TorrentHandler(e))
+	mux.Handle("/api/file", FileHandler(e))
+	mux.Handle("/api/torrentfile", TorrentFileHandler(e))
+	mux.Handle("/api/stream", StreamHandler(e))
+	return RequireToken(token, mux)
+}
+
+// bearerTransport adds the daemon's API token to RemoteEngine requests.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
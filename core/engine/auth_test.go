@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAPIToken(t *testing.T) {
+	// TokenPath is in the runtime directory
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	token, err := ServerToken(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 {
+		t.Fatalf("expected a generated token, got %q", token)
+	}
+	if again, err := ServerToken(Config{}); err != nil || again != token {
+		t.Fatalf("expected the token reused, got %q, %v", again, err)
+	}
+	path, err := TokenPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Fatalf("token file readable by others: %v", fi.Mode())
+	}
+
+	m := NewMemoryEngine()
+	ih, err := m.AddFake("fake", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(APIHandler(m, token))
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+	if err := r.StartTorrent(ih); err != nil {
+		t.Fatalf("request with the token refused: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := r.StreamTorrents(ctx); err != nil {
+		t.Fatalf("stream with the token refused: %v", err)
+	}
+
+	for _, auth := range []string{"", "Bearer ", "Bearer wrong", token} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/torrent", strings.NewReader("stop:"+ih))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %s", auth, resp.Status)
+		}
+	}
+
+	// a fixed token from the config replaces the generated one
+	fixed, err := ServerToken(Config{APIToken: "automation"})
+	if err != nil || fixed != "automation" {
+		t.Fatalf("expected the configured token, got %q, %v", fixed, err)
+	}
+	if r := NewRemoteEngine(srv.URL); r.StopTorrent(ih) == nil {
+		t.Fatal("expected the old server to refuse the new token")
+	}
+}
+
+func TestAPITokenUntrusted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symbolic links are not checked on Windows")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	path, err := TokenPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token others could have read is not reused
+	if err := os.WriteFile(path, []byte("leaked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ServerToken(Config{}); err == nil || !strings.Contains(err.Error(), "other users") {
+		t.Fatalf("expected a readable token refused, got %v", err)
+	}
+	if readToken() != "" {
+		t.Fatal("expected clients to ignore a readable token")
+	}
+
+	// nor is one behind a link, which is replaced rather than written
+	// through
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("planted\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ServerToken(Config{}); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Fatalf("expected a linked token refused, got %v", err)
+	}
+	if _, err := ServerToken(Config{APIToken: "automation"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "planted\n" {
+		t.Fatalf("expected the link target left alone, got %q", b)
+	}
+	if fi, err := os.Lstat(path); err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private regular token file, got %v, %v", fi, err)
+	}
+	if readToken() != "automation" {
+		t.Fatal("expected the configured token written")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// oNoFollow makes opening the token file fail if it is a symbolic link.
+const oNoFollow = syscall.O_NOFOLLOW
+
+// checkTokenFile reports why the token file fi can't be trusted: it
+// belongs to another user, or others may access it.
+func checkTokenFile(fi fs.FileInfo) error {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("owned by uid %d", st.Uid)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("accessible by other users (mode %v)", fi.Mode().Perm())
+	}
+	return nil
+}
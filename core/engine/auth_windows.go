@@ -0,0 +1,14 @@
+//go:build windows
+
+package engine
+
+import "io/fs"
+
+// oNoFollow is unneeded on Windows, where creating links takes privileges.
+const oNoFollow = 0
+
+// checkTokenFile accepts any token file: on Windows the user's config
+// directory is protected by its ACL rather than file modes.
+func checkTokenFile(fi fs.FileInfo) error {
+	return nil
+}
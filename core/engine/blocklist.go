@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// blocklist is the iplist.Ranger the clients check peers against, which
+// swaps in a new list without restarting them and counts the peers it
+// refuses.
+type blocklist struct {
+	ranges  atomic.Pointer[iplist.IPList]
+	path    string // the list was loaded from, guarded by Engine.mut
+	blocked atomic.Int64
+}
+
+func (b *blocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	r, ok := b.ranges.Load().Lookup(ip)
+	if ok {
+		b.blocked.Add(1)
+	}
+	return r, ok
+}
+
+func (b *blocklist) NumRanges() int {
+	return b.ranges.Load().NumRanges()
+}
+
+// set makes list, loaded from path, the one peers are checked against.
+// Engine.mut must be held.
+func (b *blocklist) set(path string, list *iplist.IPList) {
+	b.ranges.Store(list)
+	b.path = path
+}
+
+// loadBlocklist reads a PeerGuardian text (P2P) blocklist, gzipped if its
+// name ends in .gz, with lines such as
+//
+//	Some organization:1.2.3.0-1.2.3.255
+//
+// The ranges are sorted and overlapping ones merged for iplist's binary
+// search, all as 16-byte IPs so IPv4 and IPv6 ranges sort together.
+func loadBlocklist(path string) (*iplist.IPList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var ranges []iplist.Range
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		rg, ok, err := iplist.ParseBlocklistP2PLine(sc.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !ok {
+			continue
+		}
+		rg.First, rg.Last = rg.First.To16(), rg.Last.To16()
+		if bytes.Compare(rg.First, rg.Last) > 0 {
+			return nil, fmt.Errorf("line %d: range ends before it starts", n)
+		}
+		ranges = append(ranges, rg)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(ranges, func(a, b iplist.Range) int {
+		return bytes.Compare(a.First, b.First)
+	})
+	merged := ranges[:0]
+	for _, rg := range ranges {
+		if last := len(merged) - 1; last >= 0 && bytes.Compare(rg.First, merged[last].Last) <= 0 {
+			if bytes.Compare(rg.Last, merged[last].Last) > 0 {
+				merged[last].Last = rg.Last
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	return iplist.New(merged), nil
+}
+
+// openBlocklist is loadBlocklist, except that an empty path gives an empty
+// list.
+func openBlocklist(path string) (*iplist.IPList, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return loadBlocklist(path)
+}
+
+// ReloadBlocklist reads Config.BlocklistPath again, for when the list has
+// been updated. Running torrents keep their peers' connections, and new
+// ones are checked against the new list.
+func (e *Engine) ReloadBlocklist() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	list, err := openBlocklist(e.config.BlocklistPath)
+	if err != nil {
+		return fmt.Errorf("Invalid blocklist: %w", err)
+	}
+	e.blocklist.set(e.config.BlocklistPath, list)
+	return nil
+}
+
+// IsBlocked reports whether ip is in a range of the blocklist.
+func (e *Engine) IsBlocked(ip net.IP) bool {
+	_, ok := e.blocklist.ranges.Load().Lookup(ip)
+	return ok
+}
+
+// BlocklistStats returns how many ranges the blocklist has, and how many
+// times a peer was refused for being in one of them.
+func (e *Engine) BlocklistStats() (ranges int, blocked int64) {
+	return e.blocklist.NumRanges(), e.blocklist.blocked.Load()
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const testBlocklist = `# comment
+
+Second:10.0.0.0-10.0.0.255
+First:1.2.3.0-1.2.3.255
+Overlapping:10.0.0.128-10.0.1.10
+Inside:10.0.0.5-10.0.0.6
+`
+
+func TestLoadBlocklist(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.p2p")
+	if err := os.WriteFile(path, []byte(testBlocklist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "list.p2p.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(testBlocklist))
+	gz.Close()
+	f.Close()
+
+	for _, p := range []string{path, gzPath} {
+		list, err := loadBlocklist(p)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if n := list.NumRanges(); n != 2 {
+			t.Errorf("%s: expected overlapping ranges merged into 2, got %d", p, n)
+		}
+		for ip, want := range map[string]bool{
+			"1.2.3.4":    true,
+			"1.2.4.0":    false,
+			"10.0.0.0":   true,
+			"10.0.0.200": true,
+			"10.0.1.10":  true,
+			"10.0.1.11":  false,
+			"9.255.0.1":  false,
+			"::1":        false,
+		} {
+			if _, got := list.Lookup(net.ParseIP(ip)); got != want {
+				t.Errorf("%s: %s blocked = %v, want %v", p, ip, got, want)
+			}
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.p2p")
+	os.WriteFile(bad, []byte("ok:1.1.1.1-1.1.1.2\nno range here\n"), 0644)
+	if _, err := loadBlocklist(bad); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected the bad line reported, got %v", err)
+	}
+	os.WriteFile(bad, []byte("backwards:1.1.1.9-1.1.1.2\n"), 0644)
+	if _, err := loadBlocklist(bad); err == nil {
+		t.Fatal("expected a backwards range refused")
+	}
+}
+
+func TestBlocklistRefusesPeers(t *testing.T) {
+	mi, dir := newTestMetaInfo(t, "blocked", map[string][]byte{"a.bin": make([]byte, 32768)}, 16384)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	listPath := filepath.Join(t.TempDir(), "list.p2p")
+	if err := os.WriteFile(listPath, []byte("Loopback:127.0.0.0-127.255.255.255\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := New()
+	dataDir := t.TempDir()
+	config := newTestClientConfig(dataDir)
+	config.IPBlocklist = &e.blocklist
+	e.client = newTestClientFrom(t, config)
+	e.config = Config{DownloadDirectory: dataDir}
+	c := e.config
+	c.BlocklistPath = listPath
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if !e.IsBlocked(net.ParseIP("127.0.0.1")) || e.IsBlocked(net.ParseIP("192.168.1.1")) {
+		t.Fatal("expected loopback and only loopback blocked")
+	}
+
+	tor := addTestTorrent(t, e, mi)
+	tor.t.DownloadAll()
+	seederPeer := []torrent.PeerInfo{{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: seeder.LocalPort()}}}
+	tor.t.AddPeers(seederPeer)
+	if !waitFor(t, 5*time.Second, func() bool { _, n := e.BlocklistStats(); return n > 0 }) {
+		t.Fatal("expected the blocked seeder counted")
+	}
+	if ranges, _ := e.BlocklistStats(); ranges != 1 {
+		t.Fatalf("expected 1 range, got %d", ranges)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if tor.t.BytesCompleted() != 0 {
+		t.Fatal("expected nothing downloaded from a blocked peer")
+	}
+
+	// the list is reloaded without restarting the client
+	client := e.client
+	if err := os.WriteFile(listPath, []byte("Other:10.0.0.0-10.0.0.255\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ReloadBlocklist(); err != nil {
+		t.Fatal(err)
+	}
+	if e.client != client || e.IsBlocked(net.ParseIP("127.0.0.1")) {
+		t.Fatal("expected the new list in the same client")
+	}
+	tor.t.AddPeers(seederPeer)
+	if !waitFor(t, 10*time.Second, func() bool { return tor.t.BytesMissing() == 0 }) {
+		t.Fatalf("download stalled after unblocking, %d bytes missing", tor.t.BytesMissing())
+	}
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// CompletionPolicy controls what happens to a torrent once it finishes
+// downloading. The zero value defers to the engine-wide policy in Config.
+type CompletionPolicy struct {
+	Mode  string  // "seed", "stop" or "ratio"
+	Ratio float64 // target upload ratio when Mode is "ratio"
+}
+
+// SeedForever keeps seeding completed torrents until stopped by the user.
+func SeedForever() CompletionPolicy {
+	return CompletionPolicy{Mode: "seed"}
+}
+
+// StopAfterDownload stops torrents as soon as they complete.
+func StopAfterDownload() CompletionPolicy {
+	return CompletionPolicy{Mode: "stop"}
+}
+
+// SeedUntilRatio seeds completed torrents until their upload ratio reaches r.
+func SeedUntilRatio(r float64) CompletionPolicy {
+	return CompletionPolicy{Mode: "ratio", Ratio: r}
+}
+
+// IsDefault reports whether the policy defers to the engine-wide policy.
+func (p CompletionPolicy) IsDefault() bool {
+	return p.Mode == ""
+}
+
+func (p CompletionPolicy) String() string {
+	switch p.Mode {
+	case "":
+		return ""
+	case "ratio":
+		return "ratio:" + strconv.FormatFloat(p.Ratio, 'f', -1, 64)
+	default:
+		return p.Mode
+	}
+}
+
+// Describe returns a human readable form of the policy.
+func (p CompletionPolicy) Describe() string {
+	switch p.Mode {
+	case "":
+		return "Default"
+	case "stop":
+		return "Stop after download"
+	case "ratio":
+		return fmt.Sprintf("Seed until ratio %.2f", p.Ratio)
+	default:
+		return "Seed forever"
+	}
+}
+
+// ParseCompletionPolicy parses the String form of a policy.
+func ParseCompletionPolicy(s string) (CompletionPolicy, error) {
+	switch {
+	case s == "":
+		return CompletionPolicy{}, nil
+	case s == "seed":
+		return SeedForever(), nil
+	case s == "stop":
+		return StopAfterDownload(), nil
+	case strings.HasPrefix(s, "ratio:"):
+		r, err := strconv.ParseFloat(strings.TrimPrefix(s, "ratio:"), 64)
+		if err != nil || r < 0 {
+			return CompletionPolicy{}, fmt.Errorf("invalid ratio in completion policy %q", s)
+		}
+		return SeedUntilRatio(r), nil
+	}
+	return CompletionPolicy{}, fmt.Errorf("unknown completion policy %q", s)
+}
+
+// shouldStop reports whether a completed torrent with the given share ratio
+// should be stopped. justCompleted is true on the update where the torrent
+// first reached 100%.
+func (p CompletionPolicy) shouldStop(ratio float64, justCompleted bool) bool {
+	switch p.Mode {
+	case "stop":
+		return justCompleted
+	case "ratio":
+		return ratio == InfiniteRatio || ratio >= p.Ratio
+	default:
+		return false
+	}
+}
+
+// seedRatioReached reports whether t has seeded past the engine-wide ratio
+// limit. Torrents with a completion policy of their own are left to it.
+func seedRatioReached(t *Torrent, limit float64) bool {
+	return limit > 0 && t.OnComplete.IsDefault() && !t.CompletedAt.IsZero() &&
+		(t.Ratio == InfiniteRatio || t.Ratio > limit)
+}
+
+// OnComplete registers fn to be called once when the torrent finishes
+// downloading, or straight away if it already has. fn runs on its own
+// goroutine. The returned function unregisters fn; calling it after fn has
+// run does nothing.
+func (e *Engine) OnComplete(infohash string, fn func()) (func(), error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	e.mut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	cancel := make(chan struct{})
+	var once sync.Once
+	go e.watchComplete(t.InfoHash, t.t, cancel, fn)
+	return func() { once.Do(func() { close(cancel) }) }, nil
+}
+
+// watchComplete waits for tt to complete and calls fn, following the torrent
+// if it is re-added to another client (see movePrivate).
+func (e *Engine) watchComplete(infohash string, tt *torrent.Torrent, cancel chan struct{}, fn func()) {
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-tt.Complete().On():
+			select {
+			case <-cancel:
+			default:
+				fn()
+			}
+			return
+		case <-tt.Closed():
+		}
+		if tt = e.replacement(infohash, tt, cancel); tt == nil {
+			return
+		}
+	}
+}
+
+// replacement waits for the engine to swap a dropped torrent for a new one
+// and returns it, or nil if the torrent was deleted or cancel was closed.
+func (e *Engine) replacement(infohash string, old *torrent.Torrent, cancel chan struct{}) *torrent.Torrent {
+	for {
+		e.mut.Lock()
+		t := e.ts[infohash]
+		var cur *torrent.Torrent
+		var replaced chan struct{}
+		if t != nil {
+			cur, replaced = t.t, t.replaced
+		}
+		e.mut.Unlock()
+		if t == nil {
+			return nil
+		}
+		if cur != old {
+			return cur
+		}
+		select {
+		case <-cancel:
+			return nil
+		case <-replaced:
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompletionPolicyRoundTrip(t *testing.T) {
+	for _, p := range []CompletionPolicy{{}, SeedForever(), StopAfterDownload(), SeedUntilRatio(1.5)} {
+		got, err := ParseCompletionPolicy(p.String())
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", p.String(), err)
+		}
+		if got != p {
+			t.Fatalf("expected %+v, got %+v", p, got)
+		}
+	}
+	if _, err := ParseCompletionPolicy("ratio:abc"); err == nil {
+		t.Fatalf("expected error for invalid ratio")
+	}
+}
+
+func TestCompletionPolicyShouldStop(t *testing.T) {
+	cases := []struct {
+		policy        CompletionPolicy
+		ratio         float64
+		justCompleted bool
+		stop          bool
+	}{
+		{SeedForever(), 10, true, false},
+		{StopAfterDownload(), 0, true, true},
+		{StopAfterDownload(), 0, false, false},
+		{SeedUntilRatio(2), 1.9, false, false},
+		{SeedUntilRatio(2), 2, false, true},
+		{SeedUntilRatio(2), InfiniteRatio, false, true},
+	}
+	for _, c := range cases {
+		if got := c.policy.shouldStop(c.ratio, c.justCompleted); got != c.stop {
+			t.Errorf("%s ratio=%v justCompleted=%v: expected %v, got %v", c.policy, c.ratio, c.justCompleted, c.stop, got)
+		}
+	}
+}
+
+func TestOnComplete(t *testing.T) {
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "hook", map[string][]byte{"data.bin": data}, 16384)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	et := addTestTorrent(t, e, mi)
+	var fired, cancelled atomic.Int32
+	if _, err := e.OnComplete(et.InfoHash, func() { fired.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	unregister, err := e.OnComplete(et.InfoHash, func() { cancelled.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	unregister()
+
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	et.t.AddClientPeer(seeder)
+	if !waitFor(t, 10*time.Second, func() bool { return fired.Load() > 0 }) {
+		t.Fatal("completion callback did not fire")
+	}
+
+	// registering on an already complete torrent fires straight away
+	var late atomic.Int32
+	if _, err := e.OnComplete(et.InfoHash, func() { late.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return late.Load() > 0 }) {
+		t.Fatal("callback registered after completion did not fire")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := fired.Load(); n != 1 {
+		t.Fatalf("callback fired %d times, want 1", n)
+	}
+	if n := late.Load(); n != 1 {
+		t.Fatalf("late callback fired %d times, want 1", n)
+	}
+	if n := cancelled.Load(); n != 0 {
+		t.Fatalf("unregistered callback fired %d times", n)
+	}
+	if _, err := e.OnComplete("0000000000000000000000000000000000000000", func() {}); err == nil {
+		t.Fatal("expected error for unknown torrent")
+	}
+}
+
+func TestOnCompleteFollowsRestart(t *testing.T) {
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "restart", map[string][]byte{"data.bin": data}, 16384)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	et := addTestTorrent(t, e, mi)
+	var fired atomic.Int32
+	if _, err := e.OnComplete(et.InfoHash, func() { fired.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	// stopping drops the torrent the callback was registered on
+	if err := e.StopTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	tt := et.t
+	e.mut.Unlock()
+	tt.AddClientPeer(seeder)
+	if !waitFor(t, 10*time.Second, func() bool { return fired.Load() > 0 }) {
+		t.Fatal("completion callback did not follow the restarted torrent")
+	}
+}
+
+func TestSeedRatioLimit(t *testing.T) {
+	e := newTestEngine(t)
+	e.config.SeedRatioLimit = 2
+	et := completeTestTorrent(t, e, CompletionPolicy{})
+	if !et.Started {
+		t.Fatal("stopped before seeding")
+	}
+
+	// fake earlier uploads: at the limit keeps seeding, past it stops
+	e.mut.Lock()
+	et.uploadedBase = 2 * et.Downloaded
+	e.mut.Unlock()
+	e.GetTorrents()
+	if !et.Started || et.SeedingComplete {
+		t.Fatalf("stopped at ratio %v", et.Ratio)
+	}
+	e.mut.Lock()
+	et.uploadedBase = 3 * et.Downloaded
+	e.mut.Unlock()
+	e.GetTorrents()
+	if et.Started || !et.SeedingComplete {
+		t.Fatalf("still seeding at ratio %v", et.Ratio)
+	}
+	if s := Summarize(et).State; s != "seeding complete" {
+		t.Fatalf("expected seeding complete state, got %q", s)
+	}
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if et.SeedingComplete {
+		t.Fatal("seeding complete kept after restarting")
+	}
+
+	c := e.config
+	c.SeedRatioLimit = -1
+	if err := e.Configure(c); err == nil {
+		t.Fatal("expected error for a negative ratio limit")
+	}
+}
+
+func TestOnCompleteCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command is a POSIX shell script")
+	}
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "hook", map[string][]byte{"data.bin": data}, 16384)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	out := filepath.Join(t.TempDir(), "ran")
+	e.config.OnCompleteCommand = `echo "$INTUNJA_NAME $INTUNJA_SIZE $INTUNJA_PATH" >> ` + out
+	et := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	et.t.AddClientPeer(seeder)
+	if !waitFor(t, 10*time.Second, func() bool {
+		e.GetTorrents()
+		_, err := os.Stat(out)
+		return err == nil
+	}) {
+		t.Fatal("completion command did not run")
+	}
+
+	// later updates don't run it again
+	for range 5 {
+		e.GetTorrents()
+	}
+	time.Sleep(200 * time.Millisecond)
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hook 32768 " + filepath.Join(e.config.DownloadDirectory, "hook") + "\n"
+	if string(b) != want {
+		t.Fatalf("expected the command run once with %q, got %q", want, b)
+	}
+
+	start := time.Now()
+	runHook("sleep 10", nil, 100*time.Millisecond)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected a runaway command killed, took %s", d)
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// SetTorrentDebug turns verbose event logging on or off for one torrent.
+// Peer connects and disconnects, chokes, piece requests and completions and
+// tracker events are written to the debug logger tagged with the info-hash.
+func (e *Engine) SetTorrentDebug(infohash string, on bool) error {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	e.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	e.debugMut.Lock()
+	defer e.debugMut.Unlock()
+	if on == (e.debug[t.InfoHash] != nil) {
+		return nil
+	}
+	if !on {
+		stop := e.debug[t.InfoHash]
+		delete(e.debug, t.InfoHash)
+		close(stop)
+		return nil
+	}
+	if e.debug == nil {
+		e.debug = map[string]chan struct{}{}
+	}
+	stop := make(chan struct{})
+	e.debug[t.InfoHash] = stop
+	go e.logPieceChanges(t.t, stop)
+	return nil
+}
+
+// clearDebug stops debug logging for a removed torrent.
+func (e *Engine) clearDebug(infohash string) {
+	e.debugMut.Lock()
+	defer e.debugMut.Unlock()
+	if stop := e.debug[infohash]; stop != nil {
+		close(stop)
+		delete(e.debug, infohash)
+	}
+}
+
+// TorrentDebug reports whether debug logging is on for the torrent.
+func (e *Engine) TorrentDebug(infohash string) bool {
+	e.debugMut.Lock()
+	defer e.debugMut.Unlock()
+	return e.debug[infohash] != nil
+}
+
+// SetDebugLogger sets the logger debug events are written to. A nil logger
+// restores slog.Default().
+func (e *Engine) SetDebugLogger(l *slog.Logger) {
+	e.debugMut.Lock()
+	defer e.debugMut.Unlock()
+	e.debugLog = l
+}
+
+// debugf logs a debug event if debugging is on for the info-hash.
+func (e *Engine) debugf(ih torrent.InfoHash, event string, args ...any) {
+	infohash := ih.HexString()
+	e.debugMut.Lock()
+	on := e.debug[infohash] != nil
+	l := e.debugLog
+	e.debugMut.Unlock()
+	if !on {
+		return
+	}
+	if l == nil {
+		l = slog.Default()
+	}
+	l.Info("torrent "+event, append([]any{"infohash", infohash, "event", event}, args...)...)
+}
+
+func (e *Engine) logPieceChanges(tt *torrent.Torrent, stop chan struct{}) {
+	sub := tt.SubscribePieceStateChanges()
+	defer sub.Close()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tt.Closed():
+			return
+		case c, ok := <-sub.Values:
+			if !ok {
+				return
+			}
+			if c.Complete {
+				e.debugf(tt.InfoHash(), "piece_completed", "piece", c.Index)
+			}
+		}
+	}
+}
+
+// addDebugCallbacks hooks client events that SetTorrentDebug reports on.
+func (e *Engine) addDebugCallbacks(config *torrent.ClientConfig) {
+	cb := &config.Callbacks
+	cb.PeerConnAdded = append(cb.PeerConnAdded, func(pc *torrent.PeerConn) {
+		if t := pc.Torrent(); t != nil {
+			e.debugf(t.InfoHash(), "peer_connected", "peer", pc.RemoteAddr.String())
+		}
+	})
+	closed := cb.PeerConnClosed
+	cb.PeerConnClosed = func(pc *torrent.PeerConn) {
+		if closed != nil {
+			closed(pc)
+		}
+		if t := pc.Torrent(); t != nil {
+			e.debugf(t.InfoHash(), "peer_disconnected", "peer", pc.RemoteAddr.String())
+		}
+	}
+	read := cb.ReadMessage
+	cb.ReadMessage = func(pc *torrent.PeerConn, m *pp.Message) {
+		if read != nil {
+			read(pc, m)
+		}
+		t := pc.Torrent()
+		if t == nil {
+			return
+		}
+		switch m.Type {
+		case pp.Choke:
+			e.debugf(t.InfoHash(), "choked", "peer", pc.RemoteAddr.String())
+		case pp.Unchoke:
+			e.debugf(t.InfoHash(), "unchoked", "peer", pc.RemoteAddr.String())
+		}
+	}
+	cb.SentRequest = append(cb.SentRequest, func(ev torrent.PeerRequestEvent) {
+		if t := ev.Peer.Torrent(); t != nil {
+			e.debugf(t.InfoHash(), "piece_requested", "piece", ev.Index, "begin", ev.Begin, "length", ev.Length)
+		}
+	})
+	cb.StatusUpdated = append(cb.StatusUpdated, func(ev torrent.StatusUpdatedEvent) {
+		var ih torrent.InfoHash
+		switch len(ev.InfoHash) {
+		case 20:
+			copy(ih[:], ev.InfoHash)
+		case 40:
+			if err := ih.FromHexString(ev.InfoHash); err != nil {
+				return
+			}
+		default:
+			return
+		}
+		args := []any{"url", ev.Url}
+		if ev.Error != nil {
+			args = append(args, "error", ev.Error)
+		}
+		e.debugf(ih, string(ev.Event), args...)
+	})
+}
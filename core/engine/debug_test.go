@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestTorrentDebug(t *testing.T) {
+	const pieceLength = 16384
+	a, dirA := newTestMetaInfo(t, "a", map[string][]byte{"a.bin": bytes.Repeat([]byte("a"), 2*pieceLength)}, pieceLength)
+	b, dirB := newTestMetaInfo(t, "b", map[string][]byte{"b.bin": bytes.Repeat([]byte("b"), 2*pieceLength)}, pieceLength)
+	seedA := newTestClient(t, dirA)
+	seedB := newTestClient(t, dirB)
+	sa, err := seedA.AddTorrent(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := seedB.AddTorrent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New()
+	dir := t.TempDir()
+	config := newTestClientConfig(dir)
+	e.addDebugCallbacks(config)
+	e.client = newTestClientFrom(t, config)
+	e.config = Config{DownloadDirectory: dir}
+	var logs syncBuffer
+	e.SetDebugLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	ta := addTestTorrent(t, e, a)
+	tb := addTestTorrent(t, e, b)
+	if err := e.SetTorrentDebug(ta.InfoHash, true); err != nil {
+		t.Fatal(err)
+	}
+	if !e.TorrentDebug(ta.InfoHash) || e.TorrentDebug(tb.InfoHash) {
+		t.Fatal("debug flag not set on the targeted torrent only")
+	}
+	for _, tor := range []*Torrent{ta, tb} {
+		if err := e.StartTorrent(tor.InfoHash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ta.t.AddClientPeer(seedA)
+	tb.t.AddClientPeer(seedB)
+	if !waitFor(t, 10*time.Second, func() bool {
+		return ta.t.BytesMissing() == 0 && tb.t.BytesMissing() == 0
+	}) {
+		t.Fatal("downloads did not complete")
+	}
+	// Piece state changes reach the debug log through a subscription, so
+	// they can trail the download itself.
+	waitFor(t, 5*time.Second, func() bool {
+		return strings.Contains(logs.String(), "event=piece_completed")
+	})
+
+	out := logs.String()
+	for _, event := range []string{"peer_connected", "piece_requested", "piece_completed"} {
+		if !strings.Contains(out, "event="+event) {
+			t.Errorf("missing %s event in debug log:\n%s", event, out)
+		}
+	}
+	if !strings.Contains(out, "infohash="+ta.InfoHash) {
+		t.Errorf("debug log not tagged with %s", ta.InfoHash)
+	}
+	if strings.Contains(out, tb.InfoHash) {
+		t.Errorf("debug log contains events for untargeted torrent %s", tb.InfoHash)
+	}
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	// dhtRefreshInterval is how often each running torrent looks for peers
+	// on the DHT, on top of the announces anacrolix makes on its own.
+	dhtRefreshInterval = 5 * time.Minute
+	// dhtLowPeers is the peer count under which a torrent looks again
+	// sooner, after dhtMinRefreshInterval.
+	dhtLowPeers = 10
+	// dhtMinRefreshInterval is the least time between two lookups of the
+	// same torrent, however few peers it has.
+	dhtMinRefreshInterval = time.Minute
+	// dhtLookupTimeout bounds a lookup, which otherwise keeps traversing
+	// the DHT.
+	dhtLookupTimeout = 2 * time.Minute
+)
+
+// refreshDHT starts a DHT peer lookup for each running public torrent that
+// is due one: every dhtRefreshInterval, or every dhtMinRefreshInterval
+// while it has fewer than dhtLowPeers peers. Called with e.mut held.
+func (e *Engine) refreshDHT(now time.Time) {
+	if !e.config.EnableDHT {
+		return
+	}
+	dhtServers := e.dhtServers
+	if dhtServers == nil {
+		dhtServers = (*torrent.Client).DhtServers
+	}
+	for _, t := range e.ts {
+		if !t.Started || t.Private || t.t == nil || t.dhtLookup {
+			continue
+		}
+		since := now.Sub(t.lastDHTLookup)
+		low := t.Seeds+t.Leechers < dhtLowPeers
+		if since < dhtRefreshInterval && (!low || since < dhtMinRefreshInterval) {
+			continue
+		}
+		// the private client has no DHT servers
+		servers := dhtServers(e.clientOf(t.t))
+		if len(servers) == 0 {
+			continue
+		}
+		t.lastDHTLookup = now
+		t.dhtLookup = true
+		go e.lookupDHT(t, t.t, servers)
+	}
+}
+
+// lookupDHT searches each DHT server for peers of tt, which anacrolix adds
+// as they are found.
+func (e *Engine) lookupDHT(t *Torrent, tt *torrent.Torrent, servers []torrent.DhtServer) {
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, stop, err := tt.AnnounceToDht(s)
+			if err != nil {
+				log.Printf("dht: lookup of %s failed: %v", t.InfoHash, err)
+				return
+			}
+			defer stop()
+			select {
+			case <-done:
+			case <-tt.Closed():
+			case <-time.After(dhtLookupTimeout):
+			}
+		}()
+	}
+	wg.Wait()
+	e.mut.Lock()
+	t.dhtLookup = false
+	e.mut.Unlock()
+}
+
+// isDHTPeer reports whether a peer was found through the DHT.
+func isDHTPeer(pc *torrent.PeerConn) bool {
+	return pc.Discovery == torrent.PeerSourceDhtGetPeers || pc.Discovery == torrent.PeerSourceDhtAnnouncePeer
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/torrent"
+)
+
+// fakeDHT answers every lookup with the same peer.
+type fakeDHT struct {
+	lookups atomic.Int32
+	peer    dht.Peer
+}
+
+func (d *fakeDHT) Stats() interface{}             { return nil }
+func (d *fakeDHT) ID() [20]byte                   { return [20]byte{} }
+func (d *fakeDHT) Addr() net.Addr                 { return &net.UDPAddr{} }
+func (d *fakeDHT) AddNode(ni krpc.NodeInfo) error { return nil }
+func (d *fakeDHT) Ping(addr *net.UDPAddr)         {}
+func (d *fakeDHT) WriteStatus(io.Writer)          {}
+
+func (d *fakeDHT) Announce(hash [20]byte, port int, impliedPort bool) (torrent.DhtAnnounce, error) {
+	d.lookups.Add(1)
+	peers := make(chan dht.PeersValues, 1)
+	peers <- dht.PeersValues{Peers: []dht.Peer{d.peer}}
+	close(peers)
+	return fakeAnnounce(peers), nil
+}
+
+type fakeAnnounce chan dht.PeersValues
+
+func (a fakeAnnounce) Close()                        {}
+func (a fakeAnnounce) Peers() <-chan dht.PeersValues { return a }
+
+func TestRefreshDHT(t *testing.T) {
+	e := newTestEngine(t)
+	e.config.EnableDHT = true
+	d := &fakeDHT{peer: dht.Peer{IP: net.IPv4(192, 0, 2, 1), Port: 6881}}
+	e.dhtServers = func(*torrent.Client) []torrent.DhtServer { return []torrent.DhtServer{d} }
+	mi, _ := newTestMetaInfo(t, "dht", map[string][]byte{"a.bin": make([]byte, 16384)}, 16384)
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(now time.Time) {
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		e.refreshDHT(now)
+	}
+	idle := func() bool {
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		return !tor.dhtLookup
+	}
+
+	// with no peers, a lookup starts straight away
+	start := time.Now()
+	refresh(start)
+	if !waitFor(t, 5*time.Second, idle) || d.lookups.Load() != 1 {
+		t.Fatalf("expected one lookup, got %d", d.lookups.Load())
+	}
+	if tor.t.Stats().TotalPeers == 0 {
+		t.Fatal("expected the peer found added to the torrent")
+	}
+
+	// rate limited, then retried while peers stay few
+	refresh(start.Add(dhtMinRefreshInterval / 2))
+	if n := d.lookups.Load(); n != 1 {
+		t.Fatalf("expected no lookup within %s, got %d", dhtMinRefreshInterval, n)
+	}
+	refresh(start.Add(dhtMinRefreshInterval))
+	if !waitFor(t, 5*time.Second, idle) || d.lookups.Load() != 2 {
+		t.Fatalf("expected a second lookup for a torrent short of peers, got %d", d.lookups.Load())
+	}
+
+	// enough peers wait out the full interval
+	e.mut.Lock()
+	tor.Seeds = dhtLowPeers
+	e.mut.Unlock()
+	refresh(start.Add(3 * dhtMinRefreshInterval))
+	if n := d.lookups.Load(); n != 2 {
+		t.Fatalf("expected no lookup for a torrent with enough peers, got %d", n)
+	}
+	refresh(start.Add(dhtMinRefreshInterval + dhtRefreshInterval))
+	if !waitFor(t, 5*time.Second, idle) || d.lookups.Load() != 3 {
+		t.Fatalf("expected the periodic lookup, got %d", d.lookups.Load())
+	}
+
+	// private torrents stay off the DHT
+	e.mut.Lock()
+	tor.Private = true
+	e.refreshDHT(start.Add(time.Hour))
+	e.mut.Unlock()
+	if n := d.lookups.Load(); n != 3 {
+		t.Fatalf("expected no lookup for a private torrent, got %d", n)
+	}
+}
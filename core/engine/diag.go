@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// diagPieceLength is the piece size MeasureDisk writes in, a typical one
+// for torrents of a few GB.
+const diagPieceLength = 1 << 20
+
+// DiskThroughput is what MeasureDisk timed.
+type DiskThroughput struct {
+	Bytes int64
+	Write time.Duration // including the sync to disk
+	Read  time.Duration
+}
+
+// WriteRate is the write throughput in bytes per second.
+func (d DiskThroughput) WriteRate() float64 { return perSecond(d.Bytes, d.Write) }
+
+// ReadRate is the read throughput in bytes per second.
+func (d DiskThroughput) ReadRate() float64 { return perSecond(d.Bytes, d.Read) }
+
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
+
+// MeasureDisk writes size bytes into dir piece by piece through the file
+// storage torrents use, reads them back and removes them. Reads are likely
+// served from the page cache, so they show the storage's overhead more than
+// the disk's.
+func MeasureDisk(dir string, size int64) (DiskThroughput, error) {
+	if size <= 0 {
+		return DiskThroughput{}, errors.New("nothing to measure")
+	}
+	store, err := openStorage(dir)
+	if err != nil {
+		return DiskThroughput{}, err
+	}
+	defer store.Close()
+	numPieces := (size + diagPieceLength - 1) / diagPieceLength
+	info := metainfo.Info{
+		Name:        fmt.Sprintf(".intunja-diag-%d", time.Now().UnixNano()),
+		Length:      size,
+		PieceLength: diagPieceLength,
+		Pieces:      make([]byte, sha1.Size*numPieces),
+	}
+	// incomplete data is kept in a .part file
+	name := filepath.Join(dir, info.Name)
+	defer os.Remove(name + ".part")
+	defer os.Remove(name)
+	ih := metainfo.HashBytes([]byte(info.Name))
+	t, err := store.OpenTorrent(context.Background(), &info, ih)
+	if err != nil {
+		return DiskThroughput{}, err
+	}
+	defer t.Close()
+
+	buf := make([]byte, diagPieceLength)
+	rand.Read(buf) // incompressible, should the filesystem compress
+	d := DiskThroughput{Bytes: size}
+	start := time.Now()
+	for i := range info.NumPieces() {
+		p := info.Piece(i)
+		piece := t.Piece(p)
+		if _, err := piece.WriteAt(buf[:p.Length()], 0); err != nil {
+			return d, fmt.Errorf("write: %w", err)
+		}
+		if f, ok := piece.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return d, fmt.Errorf("sync: %w", err)
+			}
+		}
+	}
+	d.Write = time.Since(start)
+
+	start = time.Now()
+	for i := range info.NumPieces() {
+		p := info.Piece(i)
+		if _, err := t.Piece(p).ReadAt(buf[:p.Length()], 0); err != nil {
+			return d, fmt.Errorf("read: %w", err)
+		}
+	}
+	d.Read = time.Since(start)
+	return d, nil
+}
+
+// TrackerCheck is the outcome of announcing to one tracker.
+type TrackerCheck struct {
+	Tracker string
+	Latency time.Duration
+	Peers   int
+	Err     error
+}
+
+// CheckTrackers announces the torrent to each of its HTTP and UDP trackers
+// and reports how each answered. The peers they return are added to the
+// torrent.
+func (e *Engine) CheckTrackers(infohash string) ([]TrackerCheck, error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		e.mut.Unlock()
+		return nil, err
+	}
+	tt := t.t
+	req := announceRequest(e.clientOf(tt), tt, tracker.None)
+	proxyURL := e.proxy()
+	e.mut.Unlock()
+
+	trackers := announceTrackers(tt)
+	checks := make([]TrackerCheck, len(trackers))
+	ctx, cancel := context.WithTimeout(context.Background(), tracker.DefaultTrackerAnnounceTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, tr := range trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := announce(ctx, tr, req, proxyURL)
+			checks[i] = TrackerCheck{Tracker: tr, Latency: time.Since(start), Err: err}
+			if err != nil {
+				return
+			}
+			checks[i].Peers = len(resp.Peers)
+			tt.AddPeers(trackerPeers(resp))
+		}()
+	}
+	wg.Wait()
+	return checks, nil
+}
+
+// PeerCheck counts the outcome of dialing a torrent's known peers.
+type PeerCheck struct {
+	Dialed    int
+	Connected int
+}
+
+// Rate is the fraction of dialed peers that accepted the connection.
+func (p PeerCheck) Rate() float64 {
+	if p.Dialed == 0 {
+		return 0
+	}
+	return float64(p.Connected) / float64(p.Dialed)
+}
+
+const (
+	// peerDialTimeout is how long CheckPeers waits for each peer.
+	peerDialTimeout = 5 * time.Second
+	// peerDialsAtOnce bounds the dials CheckPeers has in flight.
+	peerDialsAtOnce = 10
+)
+
+// CheckPeers opens a TCP connection to up to limit of the torrent's known
+// peers, without a BitTorrent handshake, and counts those that accept.
+// A low rate points at the network, such as a firewall or ISP throttling,
+// rather than at the client.
+func (e *Engine) CheckPeers(infohash string, limit int) (PeerCheck, error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	dial := proxyDial(e.proxy())
+	e.mut.Unlock()
+	if err != nil {
+		return PeerCheck{}, err
+	}
+	seen := map[string]bool{}
+	var addrs []string
+	for _, p := range t.t.KnownSwarm() {
+		if p.Addr == nil || seen[p.Addr.String()] || len(addrs) == limit {
+			continue
+		}
+		seen[p.Addr.String()] = true
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil && e.IsBlocked(net.ParseIP(host)) {
+			continue
+		}
+		addrs = append(addrs, p.Addr.String())
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		pc  = PeerCheck{Dialed: len(addrs)}
+		sem = make(chan struct{}, peerDialsAtOnce)
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(context.Background(), peerDialTimeout)
+			defer cancel()
+			conn, err := dial(ctx, "tcp", addr)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			mu.Lock()
+			pc.Connected++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return pc, nil
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMeasureDisk(t *testing.T) {
+	dir := t.TempDir()
+	const size = 4<<20 + 1000 // a partial last piece
+	d, err := MeasureDisk(dir, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Bytes != size || d.WriteRate() <= 0 || d.ReadRate() <= 0 {
+		t.Fatalf("implausible throughput %+v", d)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".intunja-diag-") {
+			t.Fatalf("test data %s left behind", e.Name())
+		}
+	}
+}
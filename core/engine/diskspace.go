@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"log"
+	"time"
+)
+
+// diskCheckInterval is how often the maintenance loop checks the free space
+// of the download directories.
+const diskCheckInterval = 10 * time.Second
+
+// checkDiskSpace pauses the downloading torrents whose directory has less
+// than Config.MinFreeSpace bytes free, so they don't fail one by one on
+// writes, and resumes them once there's 10% more than that free again.
+// Seeds only read, and keep running. Called with e.mut held.
+func (e *Engine) checkDiskSpace(now time.Time) {
+	if now.Sub(e.diskChecked) < diskCheckInterval {
+		return
+	}
+	e.diskChecked = now
+	limit := e.config.MinFreeSpace
+	freeSpace := e.freeSpace
+	if freeSpace == nil {
+		freeSpace = diskFree
+	}
+	// one check per directory
+	free := map[string]int64{}
+	freeIn := func(t *Torrent) (int64, bool) {
+		dir := t.DownloadDir
+		if dir == "" {
+			dir = e.config.DownloadDirectory
+		}
+		n, ok := free[dir]
+		if !ok {
+			var err error
+			if n, err = freeSpace(dir); err != nil {
+				log.Printf("disk space: %s: %v", dir, err)
+				n = -1
+			}
+			free[dir] = n
+		}
+		return n, n >= 0
+	}
+	var paused, resumed []string
+	for _, t := range e.ts {
+		switch {
+		case t.Started && t.PausedDiskFull:
+			// started again by hand
+			t.PausedDiskFull = false
+		case t.PausedDiskFull:
+			if n, ok := freeIn(t); limit > 0 && (!ok || n < limit+limit/10) {
+				continue
+			}
+			if err := e.startTorrent(t); err != nil {
+				log.Printf("disk space: failed to resume %s: %v", t.InfoHash, err)
+				continue
+			}
+			t.PausedDiskFull = false
+			resumed = append(resumed, t.InfoHash)
+		case limit > 0 && t.Started && !t.SeedOnly && t.CompletedAt.IsZero():
+			if n, ok := freeIn(t); !ok || n >= limit {
+				continue
+			}
+			e.stopTorrent(t)
+			t.PausedDiskFull = true
+			paused = append(paused, t.InfoHash)
+			e.events.publish(EventDiskFull, t.InfoHash)
+		}
+	}
+	if len(paused) > 0 {
+		log.Printf("disk space: less than %d bytes free, paused %d downloads", limit, len(paused))
+	}
+	if e.persister != nil && len(resumed) > 0 {
+		e.enqueuePersist(persistOp{Op: "desired", InfoHashes: resumed, DesiredState: "started"})
+	}
+}
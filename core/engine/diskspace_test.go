@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiskSpaceSafeguard(t *testing.T) {
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	seedMI, dir := newTestMetaInfo(t, "seed", map[string][]byte{"a.bin": data}, 16384)
+	e := newTestEngineIn(t, dir)
+	e.config.MinFreeSpace = 1000
+	free := int64(5000)
+	e.freeSpace = func(got string) (int64, error) {
+		if got != dir {
+			return 0, fmt.Errorf("unexpected directory %s", got)
+		}
+		return free, nil
+	}
+	seed := addTestTorrent(t, e, seedMI)
+	if err := seed.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	downloadMI, _ := newTestMetaInfo(t, "download", map[string][]byte{"a.bin": data}, 16384)
+	download := addTestTorrent(t, e, downloadMI)
+	for _, tor := range []*Torrent{seed, download} {
+		if err := e.StartTorrent(tor.InfoHash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		e.GetTorrents()
+		return !seed.CompletedAt.IsZero()
+	})
+	events, unsubscribe := e.Subscribe()
+	defer unsubscribe()
+	check := func(after time.Duration) {
+		t.Helper()
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		e.checkDiskSpace(time.Now().Add(after))
+	}
+
+	check(time.Minute)
+	if !download.Started || download.PausedDiskFull {
+		t.Fatal("expected the download running with space to spare")
+	}
+
+	free = 500
+	check(2 * time.Minute)
+	if download.Started || !download.PausedDiskFull {
+		t.Fatal("expected the download paused when the disk filled up")
+	}
+	if !seed.Started {
+		t.Fatal("expected the seed kept running")
+	}
+	for {
+		ev := nextEvent(t, events)
+		if ev.Type == EventDiskFull {
+			if ev.InfoHash != download.InfoHash {
+				t.Fatalf("expected a warning for the download, got %+v", ev)
+			}
+			break
+		}
+	}
+
+	// just over the threshold isn't enough to resume
+	free = 1050
+	check(3 * time.Minute)
+	if download.Started {
+		t.Fatal("expected the download kept paused until there's some room")
+	}
+	// checks are spaced out
+	free = 2000
+	check(3*time.Minute + time.Second)
+	if download.Started {
+		t.Fatal("expected no check within the interval")
+	}
+	check(4 * time.Minute)
+	if !download.Started || download.PausedDiskFull {
+		t.Fatal("expected the download resumed once space was freed")
+	}
+	if download.desiredState() != "started" {
+		t.Fatalf("expected the resumed download persisted as started, got %s", download.desiredState())
+	}
+}
+
+func TestDiskSpaceResumesPrivate(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "private", map[string][]byte{"a.bin": make([]byte, 32768)}, 16384)
+	makePrivate(t, mi)
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	e.config.MinFreeSpace = 1000
+	free := int64(500)
+	e.freeSpace = func(string) (int64, error) { return free, nil }
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	check := func(after time.Duration) {
+		t.Helper()
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		e.checkDiskSpace(time.Now().Add(after))
+	}
+	check(time.Minute)
+	if tor.Started || !tor.PausedDiskFull {
+		t.Fatal("expected the download paused when the disk filled up")
+	}
+
+	// resuming re-adds the dropped torrent to the private client
+	free = 2000
+	if !finishes(t, 5*time.Second, func() { check(2 * time.Minute) }) {
+		t.Fatal("resuming a private torrent deadlocked")
+	}
+	if !tor.Started || tor.PausedDiskFull {
+		t.Fatal("expected the download resumed once there's room")
+	}
+	if _, ok := e.private.Torrent(mi.HashInfoBytes()); !ok {
+		t.Fatal("expected the torrent back on the private client")
+	}
+}
@@ -1,30 +1,108 @@
 package engine
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"mime"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/iplist"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/anacrolix/torrent/tracker"
+	"golang.org/x/time/rate"
 )
 
+// ErrDuplicateTorrent is returned when adding a torrent whose info-hash is
+// already tracked by the engine.
+var ErrDuplicateTorrent = errors.New("torrent already added")
+
+// ErrEmptyTorrent is returned when adding a torrent whose info has no pieces.
+var ErrEmptyTorrent = errors.New("torrent has no pieces")
+
+// ErrDuplicateFilePath is returned when adding a torrent that lists two
+// files at the same path, which would be written over each other.
+var ErrDuplicateFilePath = errors.New("torrent lists a file path twice")
+
+// ErrTorrentLimitReached is returned when adding a torrent while the engine
+// already tracks Config.MaxTorrents of them.
+var ErrTorrentLimitReached = errors.New("torrent limit reached")
+
+// checkTorrentLimit returns ErrTorrentLimitReached if c allows no more
+// than the n torrents already tracked.
+func checkTorrentLimit(c Config, n int) error {
+	if c.MaxTorrents > 0 && n >= c.MaxTorrents {
+		return fmt.Errorf("%w (%d of %d)", ErrTorrentLimitReached, n, c.MaxTorrents)
+	}
+	return nil
+}
+
+// torrentCacheDir is the directory in the download directory keeping the
+// .torrent files of torrents added by URL.
+const torrentCacheDir = ".torrents"
+
 type Engine struct {
 	mut       sync.Mutex
 	cacheDir  string
 	client    *torrent.Client
+	store     storage.ClientImplCloser // default storage of client
+	private   *torrent.Client          // DHT and PEX disabled, created on first private torrent
+	privStore storage.ClientImplCloser
+	blocklist blocklist
 	config    Config
 	ts        map[string]*Torrent
 	persister *Persister
 	persistQ  chan persistOp
 	persistWg *sync.WaitGroup
+	// persistErr is the error that disabled persistence for this session
+	persistMut sync.Mutex
+	persistErr error
+	// global rate limits, shared by both clients
+	downLimit, upLimit *rate.Limiter
+	// per-torrent debug logging, see SetTorrentDebug
+	debugMut sync.Mutex
+	debug    map[string]chan struct{}
+	debugLog *slog.Logger
+	// subscribers to torrent events, see Subscribe
+	events events
+	// total rates of all torrents, see RateHistory
+	rates rateHistory
+	// free space checks, see checkDiskSpace; freeSpace is diskFree
+	// unless replaced by tests
+	diskChecked time.Time
+	freeSpace   func(dir string) (int64, error)
+	// the running Config.WatchDirectory watcher, if any
+	watcher *dirWatcher
+	// dhtServers is (*torrent.Client).DhtServers unless replaced by tests,
+	// see refreshDHT
+	dhtServers func(*torrent.Client) []torrent.DhtServer
+	// last idle peer check and the peers that said they are interested in
+	// downloading from us, see disconnectIdlePeers
+	idleChecked  time.Time
+	peerInterest sync.Map
+	// forwarding of the listen port, see forwardPort; gateways is
+	// discoverGateways unless replaced by tests
+	portForward *portForward
+	gateways    func() []gateway
+	// closed to stop the maintenance loop, see startMaintenance
+	maintenance chan struct{}
 }
 
 func New() *Engine {
@@ -38,6 +116,13 @@ type persistOp struct {
 	Magnet       string
 	TorrentPath  string
 	DesiredState string
+	CompletedAt  time.Time
+	OnComplete   string
+	Downloaded   int64
+	Uploaded     int64
+	DownloadDir  string
+	Labels       []string
+	InfoHashes   []string
 }
 
 // AttachPersister attaches a Persister and starts a background worker
@@ -50,35 +135,81 @@ func (e *Engine) AttachPersister(p *Persister) {
 	}
 	e.persister = p
 	if e.persistQ == nil {
-		e.persistQ = make(chan persistOp, 128)
-		e.persistWg = &sync.WaitGroup{}
-		e.persistWg.Add(1)
+		q := make(chan persistOp, 128)
+		wg := &sync.WaitGroup{}
+		e.persistQ, e.persistWg = q, wg
+		wg.Add(1)
+		// The worker uses q and wg rather than e.persistQ and e.persistWg,
+		// which DetachPersister clears without waiting for it.
 		go func() {
-			defer e.persistWg.Done()
-			for op := range e.persistQ {
-				switch op.Op {
-				case "upsert":
-					if e.persister != nil {
-						_ = e.persister.UpsertTorrent(op.InfoHash, op.Name, op.Magnet, op.TorrentPath, op.DesiredState)
-					}
-				case "delete":
-					if e.persister != nil {
-						_ = e.persister.DeleteTorrent(op.InfoHash)
-					}
+			defer wg.Done()
+			for op := range q {
+				if e.PersistErr() != nil {
+					// persistence disabled, drain the queue
+					continue
+				}
+				if err := e.applyPersistOp(op); err != nil {
+					e.disablePersist(err)
 				}
 			}
 		}()
 	}
 }
 
-// DetachPersister gracefully shuts down the persistence worker and clears the persister.
+func (e *Engine) applyPersistOp(op persistOp) error {
+	p := e.persister
+	if p == nil {
+		return nil
+	}
+	switch op.Op {
+	case "upsert":
+		return p.UpsertTorrent(op.InfoHash, op.Name, op.Magnet, op.TorrentPath, op.DesiredState)
+	case "completed":
+		return p.SetCompletedAt(op.InfoHash, op.CompletedAt)
+	case "policy":
+		return p.SetCompletionPolicy(op.InfoHash, op.OnComplete)
+	case "stats":
+		return p.SetTransferStats(op.InfoHash, op.Downloaded, op.Uploaded)
+	case "dir":
+		return p.SetDownloadDir(op.InfoHash, op.DownloadDir)
+	case "labels":
+		return p.SetLabels(op.InfoHash, op.Labels)
+	case "desired":
+		return p.SetDesiredStates(op.InfoHashes, op.DesiredState)
+	case "delete":
+		return p.DeleteTorrent(op.InfoHash)
+	}
+	return nil
+}
+
+// disablePersist records a persister failure (e.g. disk full) and stops
+// further persist attempts for the rest of the session.
+func (e *Engine) disablePersist(err error) {
+	e.persistMut.Lock()
+	defer e.persistMut.Unlock()
+	if e.persistErr != nil {
+		return
+	}
+	e.persistErr = err
+	log.Printf("persist: disabling persistence, torrents will not survive a restart: %v", err)
+}
+
+// PersistErr returns the error that disabled persistence, or nil while
+// persistence is working.
+func (e *Engine) PersistErr() error {
+	e.persistMut.Lock()
+	defer e.persistMut.Unlock()
+	return e.persistErr
+}
+
+// DetachPersister gracefully shuts down the persistence worker, flushing any
+// queued operations, and clears the persister.
 func (e *Engine) DetachPersister() {
 	e.mut.Lock()
 	ch := e.persistQ
 	wg := e.persistWg
 	e.persistQ = nil
 	e.persistWg = nil
-	e.persister = nil
 	e.mut.Unlock()
 	if ch != nil {
 		close(ch)
@@ -86,6 +217,9 @@ func (e *Engine) DetachPersister() {
 	if wg != nil {
 		wg.Wait()
 	}
+	e.mut.Lock()
+	e.persister = nil
+	e.mut.Unlock()
 }
 
 // RehydrateFromPersister loads persisted torrents and re-adds them to the engine.
@@ -114,7 +248,7 @@ func (e *Engine) RehydrateFromPersister() {
 				continue
 			}
 			// directly add magnet and control desired start
-			tt, err := e.client.AddMagnet(san)
+			tt, err := e.restoreMagnet(san, r["download_dir"])
 			if err != nil {
 				log.Printf("rehydrate: failed to add magnet %s: %v", infohash, err)
 				continue
@@ -123,25 +257,154 @@ func (e *Engine) RehydrateFromPersister() {
 				log.Printf("rehydrate: failed to register magnet %s: %v", infohash, err)
 				continue
 			}
+			e.restorePersisted(tt.InfoHash().HexString(), r)
 			// proceed to next persisted row
 			continue
 		}
-		// attempt to restore from a stored .torrent file path
+		// restore from the .torrent file or URL it was added from
 		if torrentPath != "" {
-			// Adding from a .torrent file is not implemented in rehydration yet.
-			// Implementing this requires constructing a torrent spec from the
-			// .torrent meta-info and calling client.AddTorrentSpec, which
-			// depends on the anacrolix API. We'll skip for now and log.
-			log.Printf("rehydrate: skipping torrent file restore for %s (path=%s)", infohash, torrentPath)
-			continue
+			tt, mi, dropped, err := e.restoreTorrentFile(infohash, torrentPath, r["download_dir"])
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Printf("rehydrate: torrent file for %s is gone, forgetting it (path=%s)", infohash, torrentPath)
+				if err := p.DeleteTorrent(infohash); err != nil {
+					log.Printf("rehydrate: failed to delete %s: %v", infohash, err)
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("rehydrate: failed to restore %s from %s: %v", infohash, torrentPath, err)
+				continue
+			}
+			if err := e.newTorrent(tt, desired == "started"); err != nil {
+				log.Printf("rehydrate: failed to register torrent %s: %v", infohash, err)
+				continue
+			}
+			e.warnDroppedTrackers(tt, dropped)
+			e.keepHeader(tt.InfoHash(), mi)
+			e.restorePersisted(tt.InfoHash().HexString(), r)
 		}
-		// TODO: support torrent_path restore
-		_ = infohash
+	}
+}
+
+// restoreMagnet adds a persisted magnet to the client, storing its data in
+// dir if one was chosen when it was added.
+func (e *Engine) restoreMagnet(magnetURI, dir string) (*torrent.Torrent, error) {
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnetURI)
+	if err != nil {
+		return nil, err
+	}
+	return e.addSpec(e.client, spec, dir)
+}
+
+// restoreTorrentFile loads a persisted .torrent from a local path or URL
+// and adds it to the right client, without starting it, dropping invalid
+// trackers as adding it did. The loaded metainfo and the dropped trackers
+// are returned too.
+func (e *Engine) restoreTorrentFile(infohash, torrentPath, dir string) (*torrent.Torrent, *metainfo.MetaInfo, []string, error) {
+	var mi *metainfo.MetaInfo
+	var err error
+	if IsTorrentURL(torrentPath) {
+		mi, err = e.loadTorrentURL(infohash, torrentPath)
+	} else {
+		mi, err = metainfo.LoadFromFile(torrentPath)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	trackers, dropped := sanitizeTrackers(spec.Trackers)
+	spec.Trackers = trackers
+	client := e.client
+	if isPrivate(&info) {
+		if client, err = e.privateClient(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	tt, err := e.addSpec(client, spec, dir)
+	return tt, mi, dropped, err
+}
+
+// loadTorrentURL returns the .torrent of infohash added from rawURL: the
+// copy cached when it was added, or else the file fetched and cached anew.
+func (e *Engine) loadTorrentURL(infohash, rawURL string) (*metainfo.MetaInfo, error) {
+	e.mut.Lock()
+	cached := e.cachedTorrentPath(infohash)
+	proxyURL := e.proxy()
+	e.mut.Unlock()
+	if cached != "" {
+		if mi, err := metainfo.LoadFromFile(cached); err == nil {
+			return mi, nil
+		}
+	}
+	mi, err := fetchTorrent(rawURL, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	e.cacheTorrent(mi)
+	return mi, nil
+}
+
+// IsTorrentURL reports whether s is an http(s) URL to fetch a .torrent
+// from, rather than a local file.
+func IsTorrentURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// restorePersisted copies persisted add/completion times and the completion
+// policy onto a rehydrated torrent.
+func (e *Engine) restorePersisted(infohash string, row map[string]string) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, ok := e.ts[infohash]
+	if !ok {
+		return
+	}
+	if at, err := time.Parse(time.RFC3339Nano, row["added_at"]); err == nil {
+		t.AddedAt = at
+	}
+	if at, err := time.Parse(time.RFC3339Nano, row["completed_at"]); err == nil {
+		t.CompletedAt = at
+	}
+	// transfers are only counted per session by the client, so carry the
+	// saved totals forward
+	if n, err := strconv.ParseInt(row["uploaded"], 10, 64); err == nil && n > 0 {
+		t.uploadedBase = n
+		t.Uploaded += n
+	}
+	if n, err := strconv.ParseInt(row["downloaded"], 10, 64); err == nil && n > 0 {
+		t.downloadedBase = n
+		t.Downloaded += n
+	}
+	t.Ratio = ratio(t.Uploaded, t.Downloaded)
+	t.PausedAll = !t.Started && row["desired_state"] == "paused"
+	if s := row["labels"]; s != "" {
+		var labels []string
+		if err := json.Unmarshal([]byte(s), &labels); err == nil {
+			t.Labels = normalizeLabels(labels)
+		} else {
+			log.Printf("rehydrate: %s: labels: %v", infohash, err)
+		}
+	}
+	if p, err := ParseCompletionPolicy(row["on_complete"]); err == nil {
+		t.OnComplete = p
+	} else {
+		log.Printf("rehydrate: %s: %v", infohash, err)
 	}
 }
 
 func (e *Engine) enqueuePersist(op persistOp) {
-	if e.persistQ == nil {
+	if e.persistQ == nil || e.PersistErr() != nil {
 		return
 	}
 	select {
@@ -152,43 +415,329 @@ func (e *Engine) enqueuePersist(op persistOp) {
 }
 
 func (e *Engine) Config() Config {
+	e.mut.Lock()
+	defer e.mut.Unlock()
 	return e.config
 }
 
+// ListenPort returns the port peers can connect to, the one picked at
+// random when Config.IncomingPort is 0.
+func (e *Engine) ListenPort() (int, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.client == nil {
+		return 0, errors.New("engine not configured")
+	}
+	port := e.client.LocalPort()
+	if port == 0 {
+		return 0, errors.New("not listening for peers")
+	}
+	return port, nil
+}
+
 func (e *Engine) Configure(c Config) error {
+	if c.IncomingPort < 0 || c.IncomingPort > 65535 {
+		return fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
+	}
+	if c.MaxDownloadRate < 0 || c.MaxUploadRate < 0 {
+		return fmt.Errorf("Invalid rate limits %d/%d", c.MaxDownloadRate, c.MaxUploadRate)
+	}
+	if c.SeedRatioLimit < 0 {
+		return fmt.Errorf("Invalid seed ratio limit %v", c.SeedRatioLimit)
+	}
+	if c.RateHistoryLength < 0 || c.RateHistoryAge < 0 {
+		return fmt.Errorf("Invalid rate history %d/%v", c.RateHistoryLength, c.RateHistoryAge)
+	}
+	if c.PeerRequestQueue < 0 {
+		return fmt.Errorf("Invalid peer request queue %d", c.PeerRequestQueue)
+	}
+	if c.MinFreeSpace < 0 {
+		return fmt.Errorf("Invalid minimum free space %d", c.MinFreeSpace)
+	}
+	if c.IdlePeerTimeout < 0 {
+		return fmt.Errorf("Invalid idle peer timeout %v", c.IdlePeerTimeout)
+	}
+	if c.MaxTorrents < 0 {
+		return fmt.Errorf("Invalid maximum torrents %d", c.MaxTorrents)
+	}
+	if err := c.ClientConfigOverrides.Validate(); err != nil {
+		return err
+	}
+	proxyURL, err := parseProxy(c.ProxyURL)
+	if err != nil {
+		return fmt.Errorf("Invalid proxy URL: %w", err)
+	}
+	if proxyURL != nil {
+		if err := checkProxy(proxyURL); err != nil {
+			return fmt.Errorf("Proxy %s unreachable: %w", proxyURL.Host, err)
+		}
+	}
+	// anacrolix would fall back to the working directory
+	if c.DownloadDirectory == "" {
+		return errors.New("Download directory not set")
+	}
+	if err := os.MkdirAll(c.DownloadDirectory, 0755); err != nil {
+		return fmt.Errorf("Invalid download directory: %w", err)
+	}
+	if c.WatchDirectory != "" {
+		if err := os.MkdirAll(c.WatchDirectory, 0755); err != nil {
+			return fmt.Errorf("Invalid watch directory: %w", err)
+		}
+	}
+	e.mut.Lock()
+	newList := c.BlocklistPath != e.blocklist.path
+	e.mut.Unlock()
+	var list *iplist.IPList
+	if newList {
+		if list, err = openBlocklist(c.BlocklistPath); err != nil {
+			return fmt.Errorf("Invalid blocklist: %w", err)
+		}
+	}
+	if e.client != nil && !needsRestart(e.config, c) {
+		// keep the running client and its torrents
+		e.mut.Lock()
+		if newList {
+			e.blocklist.set(c.BlocklistPath, list)
+		}
+		e.rateLimiters(c)
+		e.config = c
+		e.cacheDir = filepath.Join(c.DownloadDirectory, torrentCacheDir)
+		e.watchDirectory(c.WatchDirectory)
+		e.forwardPort(c.EnablePortForwarding, e.client.LocalPort())
+		e.startMaintenance()
+		e.mut.Unlock()
+		return nil
+	}
 	//recieve config
 	if e.client != nil {
 		e.client.Close()
+		if e.store != nil {
+			e.store.Close()
+		}
 		time.Sleep(1 * time.Second)
 	}
-	if c.IncomingPort <= 0 {
-		return fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
+
+	config := clientConfig(c)
+	config.DownloadRateLimiter, config.UploadRateLimiter = e.rateLimiters(c)
+	e.addDebugCallbacks(config)
+	e.addIdleCallbacks(config)
+	pc, err := dirPieceCompletion(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("Invalid download directory: %w", err)
 	}
+	store := newFileStorage(config.DataDir, pc)
+	config.DefaultStorage = store
+	config.IPBlocklist = &e.blocklist
+	client, err := torrent.NewClient(config)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	addProxyDialer(client, proxyURL)
+	e.mut.Lock()
+	if e.private != nil {
+		e.private.Close()
+		if e.privStore != nil {
+			e.privStore.Close()
+		}
+		e.private, e.privStore = nil, nil
+	}
+	if newList {
+		e.blocklist.set(c.BlocklistPath, list)
+	}
+	e.config = c
+	e.cacheDir = filepath.Join(c.DownloadDirectory, torrentCacheDir)
+	e.client, e.store = client, store
+	e.restartTorrents()
+	e.watchDirectory(c.WatchDirectory)
+	e.forwardPort(c.EnablePortForwarding, client.LocalPort())
+	e.startMaintenance()
+	e.mut.Unlock()
+	//reset
+	e.GetTorrents()
+	return nil
+}
 
+func clientConfig(c Config) *torrent.ClientConfig {
 	config := torrent.NewDefaultClientConfig()
 	config.DataDir = c.DownloadDirectory
 	config.NoUpload = !c.EnableUpload
 	config.Seed = c.EnableSeeding
 	config.ListenPort = c.IncomingPort
-	client, err := torrent.NewClient(config)
+	config.NoDHT = !c.EnableDHT
+	config.DisablePEX = !c.EnablePEX
+	// the engine forwards the port itself, see forwardPort
+	config.NoDefaultPortForwarding = true
+	config.MaxAllocPeerRequestDataPerConn = peerRequestQueue(c) * blockSize
+	c.ClientConfigOverrides.apply(config)
+	// validated by Configure
+	proxyURL, _ := parseProxy(c.ProxyURL)
+	applyProxy(config, proxyURL)
+	return config
+}
+
+// defaultPeerRequestQueue is the PeerRequestQueue used when it's 0.
+const defaultPeerRequestQueue = 256
+
+// blockSize is the length of the blocks peers request, 16KiB by convention.
+const blockSize = 1 << 14
+
+// peerRequestQueue returns how many blocks are buffered for each peer.
+// anacrolix keeps at most 1024 requests per peer whatever the setting,
+// rejecting the rest when the peer supports the Fast Extension; the queue
+// bounds the data read for them, which is what the memory goes to.
+func peerRequestQueue(c Config) int {
+	if c.PeerRequestQueue > 0 {
+		return c.PeerRequestQueue
+	}
+	return defaultPeerRequestQueue
+}
+
+// privateClientConfig is clientConfig with DHT and PEX switched off. It
+// listens on a random port since the public client owns IncomingPort.
+func privateClientConfig(c Config) *torrent.ClientConfig {
+	config := clientConfig(c)
+	config.NoDHT = true
+	config.DisablePEX = true
+	config.ListenPort = 0
+	return config
+}
+
+// privateClient returns the client for private (BEP 27) torrents, creating
+// it on first use. anacrolix only toggles DHT and PEX per client, so private
+// torrents are kept on a client of their own to never leak to either.
+func (e *Engine) privateClient() (*torrent.Client, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.privateClientLocked()
+}
+
+// privateClientLocked is privateClient for callers already holding e.mut.
+func (e *Engine) privateClientLocked() (*torrent.Client, error) {
+	if e.private == nil {
+		config := privateClientConfig(e.config)
+		config.DownloadRateLimiter, config.UploadRateLimiter = e.rateLimiters(e.config)
+		e.addDebugCallbacks(config)
+		e.addIdleCallbacks(config)
+		// the main client holds the piece completion db in DataDir open
+		pc, err := dirPieceCompletion(filepath.Join(config.DataDir, ".private"))
+		if err != nil {
+			return nil, fmt.Errorf("private client: %w", err)
+		}
+		store := newFileStorage(config.DataDir, pc)
+		config.DefaultStorage = store
+		config.IPBlocklist = &e.blocklist
+		client, err := torrent.NewClient(config)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("private client: %w", err)
+		}
+		addProxyDialer(client, e.proxy())
+		e.private, e.privStore = client, store
+	}
+	return e.private, nil
+}
+
+// restartTorrents adds the started torrents of the clients Configure
+// closed to the new ones, private torrents to a new private client, and
+// starts them again. Stopped ones are added back when started. Called with
+// e.mut held.
+func (e *Engine) restartTorrents() {
+	for _, t := range e.ts {
+		if t.t == nil || !t.Started || !isClosed(t.t.Closed()) {
+			continue
+		}
+		if err := e.startTorrent(t); err != nil {
+			log.Printf("reconfigure: %v", err)
+		}
+	}
+}
+
+// clients returns the engine's open clients.
+func (e *Engine) clients() []*torrent.Client {
+	cs := []*torrent.Client{}
+	for _, c := range []*torrent.Client{e.client, e.private} {
+		if c != nil {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// onPrivateClient reports whether tt belongs to the private client.
+func (e *Engine) onPrivateClient(tt *torrent.Torrent) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.private == nil {
+		return false
+	}
+	pt, ok := e.private.Torrent(tt.InfoHash())
+	return ok && pt == tt
+}
+
+func isPrivate(info *metainfo.Info) bool {
+	return info != nil && info.Private != nil && *info.Private
+}
+
+// movePrivate re-adds a magnet torrent whose metadata turned out to be
+// private to the private client, so it stops using DHT and PEX.
+func (e *Engine) movePrivate(tt *torrent.Torrent) error {
+	pc, err := e.privateClient()
 	if err != nil {
 		return err
 	}
+	mi := tt.Metainfo()
+	spec := &torrent.TorrentSpec{
+		AddTorrentOpts: torrent.AddTorrentOpts{InfoHash: tt.InfoHash(), InfoBytes: mi.InfoBytes},
+		Trackers:       mi.UpvertedAnnounceList(),
+	}
 	e.mut.Lock()
-	e.config = c
-	e.client = client
+	if t, ok := e.ts[tt.InfoHash().HexString()]; ok && t.store != nil {
+		spec.Storage = t.store
+	}
+	e.mut.Unlock()
+	tt.Drop()
+	nt, _, err := pc.AddTorrentSpec(spec)
+	if err != nil {
+		return err
+	}
+	e.mut.Lock()
+	e.upsertTorrent(nt)
 	e.mut.Unlock()
-	//reset
-	e.GetTorrents()
 	return nil
 }
 
 func (e *Engine) NewMagnet(magnetURI string) error {
+	return e.addMagnet(magnetURI, "")
+}
+
+// addMagnet adds a magnet, storing its data in dir if it is not empty.
+func (e *Engine) addMagnet(magnetURI, dir string) error {
 	// defensive: validate magnet and sanitize trackers
 	safe, err := sanitizeMagnet(magnetURI)
 	if err != nil {
 		return err
 	}
+	// check for duplicates before the client starts any network activity
+	m, err := ParseMagnet(safe)
+	if err != nil {
+		return err
+	}
+	// a hybrid torrent may already be here under either of its hashes
+	e.mut.Lock()
+	_, exists := e.ts[magnetKey(m).HexString()]
+	if m.V2InfoHash.Ok && !exists {
+		_, exists = e.ts[m.V2InfoHash.Value.ToShort().HexString()]
+	}
+	limitErr := checkTorrentLimit(e.config, len(e.ts))
+	autoStart := e.config.AutoStart
+	e.mut.Unlock()
+	if exists {
+		return ErrDuplicateTorrent
+	}
+	if limitErr != nil {
+		return limitErr
+	}
 
 	// recover from possible panics inside the client library
 	defer func() error {
@@ -198,11 +747,15 @@ func (e *Engine) NewMagnet(magnetURI string) error {
 		return nil
 	}()
 
-	tt, err := e.client.AddMagnet(safe)
+	spec, err := torrent.TorrentSpecFromMagnetUri(safe)
+	if err != nil {
+		return fmt.Errorf("invalid magnet URI: %w", err)
+	}
+	tt, err := e.addSpec(e.client, spec, dir)
 	if err != nil {
 		return err
 	}
-	if err := e.newTorrent(tt, e.config.AutoStart); err != nil {
+	if err := e.newTorrent(tt, autoStart); err != nil {
 		return err
 	}
 	// persist metadata (magnet) if available
@@ -210,15 +763,62 @@ func (e *Engine) NewMagnet(magnetURI string) error {
 		ih := tt.InfoHash().HexString()
 		name := tt.Name()
 		desired := "stopped"
-		if e.config.AutoStart {
+		if autoStart {
 			desired = "started"
 		}
 		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: ih, Name: name, Magnet: magnetURI, DesiredState: desired})
+		if dir != "" {
+			e.enqueuePersist(persistOp{Op: "dir", InfoHash: ih, DownloadDir: dir})
+		}
 	}
 	return nil
 }
 
 func (e *Engine) NewTorrent(spec *torrent.TorrentSpec) error {
+	return e.addTorrentSpec(spec, "", "")
+}
+
+// addTorrentSpec adds spec to the client and records torrentPath (a local
+// path or URL the .torrent was loaded from) with the persister. The data is
+// stored in dir, or in the download directory if dir is empty.
+func (e *Engine) addTorrentSpec(spec *torrent.TorrentSpec, torrentPath, dir string) error {
+	private := false
+	if len(spec.InfoBytes) > 0 {
+		var info metainfo.Info
+		if err := bencode.Unmarshal(spec.InfoBytes, &info); err != nil {
+			return fmt.Errorf("invalid torrent info: %w", err)
+		}
+		if info.NumPieces() == 0 {
+			return ErrEmptyTorrent
+		}
+		if err := checkFilePaths(&info); err != nil {
+			return err
+		}
+		private = isPrivate(&info)
+	}
+	// announcing to a malformed URL would only fail later, out of sight
+	trackers, dropped := sanitizeTrackers(spec.Trackers)
+	spec.Trackers = trackers
+	e.mut.Lock()
+	existing, exists := e.ts[spec.InfoHash.HexString()]
+	var tt *torrent.Torrent
+	if exists {
+		tt = existing.t
+	}
+	limitErr := checkTorrentLimit(e.config, len(e.ts))
+	autoStart := e.config.AutoStart
+	e.mut.Unlock()
+	if exists {
+		// a magnet still waiting for metadata is completed from the spec
+		if len(spec.InfoBytes) == 0 || tt == nil || tt.Info() != nil {
+			return ErrDuplicateTorrent
+		}
+		return e.mergeInfo(tt, spec, torrentPath)
+	}
+	if limitErr != nil {
+		return limitErr
+	}
+
 	// recover from panics in underlying library
 	defer func() error {
 		if r := recover(); r != nil {
@@ -227,77 +827,303 @@ func (e *Engine) NewTorrent(spec *torrent.TorrentSpec) error {
 		return nil
 	}()
 
-	tt, _, err := e.client.AddTorrentSpec(spec)
+	client := e.client
+	if private {
+		pc, err := e.privateClient()
+		if err != nil {
+			return err
+		}
+		client = pc
+	}
+	tt, err := e.addSpec(client, spec, dir)
 	if err != nil {
 		return err
 	}
-	if err := e.newTorrent(tt, e.config.AutoStart); err != nil {
+	if err := e.newTorrent(tt, autoStart); err != nil {
 		return err
 	}
+	e.warnDroppedTrackers(tt, dropped)
 	if e.persister != nil {
 		ih := tt.InfoHash().HexString()
 		name := tt.Name()
 		desired := "stopped"
-		if e.config.AutoStart {
+		if autoStart {
 			desired = "started"
 		}
-		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: ih, Name: name, TorrentPath: "", DesiredState: desired})
+		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: ih, Name: name, TorrentPath: torrentPath, DesiredState: desired})
+		if dir != "" {
+			e.enqueuePersist(persistOp{Op: "dir", InfoHash: ih, DownloadDir: dir})
+		}
 	}
 	return nil
 }
 
-// sanitizeMagnet removes invalid trackers and validates the magnet URI.
-// It returns a possibly modified magnet URI or an error if the input is invalid.
-func sanitizeMagnet(m string) (string, error) {
-	if strings.TrimSpace(m) == "" {
-		return "", errors.New("empty magnet URI")
+// addSpec adds spec to client with its data in dir, or in the download
+// directory if dir is empty.
+func (e *Engine) addSpec(client *torrent.Client, spec *torrent.TorrentSpec, dir string) (*torrent.Torrent, error) {
+	if dir == "" {
+		tt, _, err := client.AddTorrentSpec(spec)
+		return tt, err
 	}
-	if !strings.HasPrefix(m, "magnet:") {
-		return "", errors.New("invalid magnet URI: missing 'magnet:' scheme")
+	store, err := openStorage(dir)
+	if err != nil {
+		return nil, err
 	}
-	u, err := url.Parse(m)
+	spec.Storage = store
+	tt, _, err := client.AddTorrentSpec(spec)
 	if err != nil {
-		return "", fmt.Errorf("invalid magnet URI: %w", err)
+		store.Close()
+		return nil, err
 	}
-	// Ensure xt contains urn:btih
-	q := u.Query()
-	xts := q["xt"]
-	if len(xts) == 0 {
-		return "", errors.New("magnet URI missing xt parameter")
+	e.setDownloadDir(tt, dir, store)
+	return tt, nil
+}
+
+// setDownloadDir records that tt stores its data in dir rather than the
+// download directory. store is closed when the torrent is deleted.
+func (e *Engine) setDownloadDir(tt *torrent.Torrent, dir string, store storage.ClientImplCloser) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t := e.upsertTorrent(tt)
+	t.DownloadDir, t.store = dir, store
+}
+
+// openStorage returns file storage for torrents kept in dir, after making
+// sure dir can be written to.
+func openStorage(dir string) (storage.ClientImplCloser, error) {
+	if err := checkWritable(dir); err != nil {
+		return nil, err
 	}
-	// Sanitize trackers: remove trackers with empty or unknown schemes
-	goodTr := []string{}
-	for _, tr := range q["tr"] {
-		tu, err := url.Parse(tr)
-		if err != nil || tu.Scheme == "" {
-			// skip invalid tracker
-			continue
+	pc, err := dirPieceCompletion(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newFileStorage(dir, pc), nil
+}
+
+// checkWritable creates dir if needed and checks that files can be created
+// in it.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("download directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".intunja-*")
+	if err != nil {
+		return fmt.Errorf("download directory %s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// mergeInfo gives a magnet torrent that has no metadata yet the info and
+// trackers from a .torrent for the same info-hash. The existing entry, and
+// with it its add time, settings and any data on disk, is kept.
+func (e *Engine) mergeInfo(tt *torrent.Torrent, spec *torrent.TorrentSpec, torrentPath string) error {
+	if err := tt.MergeSpec(spec); err != nil {
+		return fmt.Errorf("merge torrent info: %w", err)
+	}
+	if e.persister != nil && torrentPath != "" {
+		e.mut.Lock()
+		t := e.upsertTorrent(tt)
+		desired := "stopped"
+		if t.Started {
+			desired = "started"
 		}
-		// keep only http(s) and udp schemes commonly used by trackers
-		switch strings.ToLower(tu.Scheme) {
-		case "http", "https", "udp":
-			goodTr = append(goodTr, tr)
-		default:
-			// skip unknown scheme
+		e.mut.Unlock()
+		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: t.InfoHash, Name: t.Name, TorrentPath: torrentPath, DesiredState: desired})
+	}
+	return nil
+}
+
+// maxTorrentFileSize bounds the size of .torrent files fetched over HTTP.
+const maxTorrentFileSize = 10 << 20
+
+var torrentHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// AddTorrentURL fetches a .torrent file over HTTP(S) and adds it. The source
+// URL is persisted so the torrent can be restored later.
+func (e *Engine) AddTorrentURL(rawURL string) error {
+	return e.addTorrentURL(rawURL, "")
+}
+
+func (e *Engine) addTorrentURL(rawURL, dir string) error {
+	e.mut.Lock()
+	proxyURL := e.proxy()
+	e.mut.Unlock()
+	mi, err := fetchTorrent(rawURL, proxyURL)
+	if err != nil {
+		return err
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return fmt.Errorf("invalid torrent file: %w", err)
+	}
+	if err := e.addTorrentSpec(spec, rawURL, dir); err != nil {
+		return err
+	}
+	e.keepHeader(spec.InfoHash, mi)
+	e.cacheTorrent(mi)
+	return nil
+}
+
+// cachedTorrentPath is where the .torrent of infohash is cached, "" before
+// the engine is configured. Called with e.mut held.
+func (e *Engine) cachedTorrentPath(infohash string) string {
+	if e.cacheDir == "" {
+		return ""
+	}
+	return filepath.Join(e.cacheDir, infohash+".torrent")
+}
+
+// cacheTorrent keeps a copy of a .torrent fetched over HTTP, for restoring
+// the torrent from on startup instead of fetching it again. DeleteTorrent
+// removes it.
+func (e *Engine) cacheTorrent(mi *metainfo.MetaInfo) {
+	e.mut.Lock()
+	path := e.cachedTorrentPath(mi.HashInfoBytes().HexString())
+	e.mut.Unlock()
+	if path == "" {
+		return
+	}
+	if err := writeTorrentFile(path, mi); err != nil {
+		log.Printf("cache torrent: %v", err)
+	}
+}
+
+// writeTorrentFile writes mi to path through a temporary file, so a crash
+// never leaves a truncated one behind.
+func writeTorrentFile(path string, mi *metainfo.MetaInfo) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".intunja-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := mi.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// fetchTorrent downloads and parses a .torrent file over HTTP(S), through
+// proxyURL unless it is nil.
+func fetchTorrent(rawURL string, proxyURL *url.URL) (*metainfo.MetaInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid torrent URL: unsupported scheme %q", u.Scheme)
+	}
+	// redirects are followed by the http client
+	client := torrentHTTPClient
+	if proxyURL != nil {
+		client = &http.Client{Timeout: client.Timeout, Transport: proxyTransport(proxyURL)}
+	}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("fetch torrent: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch torrent: unexpected status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || strings.HasPrefix(mt, "text/") {
+			return nil, fmt.Errorf("fetch torrent: unexpected content type %q", ct)
 		}
 	}
-	// Rebuild query with sanitized trackers
-	newQ := url.Values{}
-	for _, xt := range xts {
-		newQ.Add("xt", xt)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch torrent: %w", err)
+	}
+	if len(data) > maxTorrentFileSize {
+		return nil, fmt.Errorf("fetch torrent: file exceeds %d bytes", maxTorrentFileSize)
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %w", err)
+	}
+	return mi, nil
+}
+
+// AddTorrentFile loads a .torrent file from disk and adds it. The absolute
+// path of the file is persisted so the torrent can be restored later.
+func (e *Engine) AddTorrentFile(path string) error {
+	return e.addTorrentFile(path, "")
+}
+
+func (e *Engine) addTorrentFile(path, dir string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("load torrent: %w", err)
+	}
+	mi, err := metainfo.LoadFromFile(abs)
+	if err != nil {
+		return fmt.Errorf("load torrent: %w", err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return fmt.Errorf("invalid torrent file: %w", err)
+	}
+	if err := e.addTorrentSpec(spec, abs, dir); err != nil {
+		return err
+	}
+	e.keepHeader(spec.InfoHash, mi)
+	return nil
+}
+
+// AddTorrentTo adds a magnet URI, .torrent URL or .torrent file path and
+// stores the torrent's data in dir instead of the download directory. dir is
+// created if needed and must be writable.
+func (e *Engine) AddTorrentTo(source, dir string) error {
+	if strings.TrimSpace(dir) == "" {
+		return errors.New("empty download directory")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("download directory: %w", err)
 	}
-	if dn := q.Get("dn"); dn != "" {
-		newQ.Set("dn", dn)
+	if err := checkWritable(abs); err != nil {
+		return err
 	}
-	for _, tr := range goodTr {
-		newQ.Add("tr", tr)
+	switch {
+	case strings.HasPrefix(source, "magnet:"):
+		return e.addMagnet(source, abs)
+	case IsTorrentURL(source):
+		return e.addTorrentURL(source, abs)
+	default:
+		return e.addTorrentFile(source, abs)
 	}
-	u.RawQuery = newQ.Encode()
-	return u.String(), nil
+}
+
+// trackerSchemes are the tracker URL schemes kept in magnets. ws and wss
+// are WebTorrent trackers.
+var trackerSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"udp":   true,
+	"ws":    true,
+	"wss":   true,
+}
+
+// sanitizeMagnet removes invalid trackers and validates the magnet URI.
+// It returns a possibly modified magnet URI or an error if the input is invalid.
+func sanitizeMagnet(m string) (string, error) {
+	safe, _, err := SanitizeMagnet(m)
+	return safe, err
 }
 
 // SanitizeMagnet is an exported wrapper that returns the sanitized magnet URI
-// along with a list of dropped trackers (for user-facing warnings).
+// along with a list of dropped trackers (for user-facing warnings). Only the
+// BitTorrent info-hashes are kept of the xt values; see ParseMagnet.
 func SanitizeMagnet(m string) (string, []string, error) {
 	if strings.TrimSpace(m) == "" {
 		return "", nil, errors.New("empty magnet URI")
@@ -305,49 +1131,148 @@ func SanitizeMagnet(m string) (string, []string, error) {
 	if !strings.HasPrefix(m, "magnet:") {
 		return "", nil, errors.New("invalid magnet URI: missing 'magnet:' scheme")
 	}
-	u, err := url.Parse(m)
+	mag, err := ParseMagnet(m)
 	if err != nil {
-		return "", nil, fmt.Errorf("invalid magnet URI: %w", err)
-	}
-	q := u.Query()
-	if len(q["xt"]) == 0 {
-		return "", nil, errors.New("magnet URI missing xt parameter")
+		return "", nil, err
 	}
 	goodTr := []string{}
 	dropped := []string{}
-	for _, tr := range q["tr"] {
-		tu, err := url.Parse(tr)
-		if err != nil || tu.Scheme == "" {
-			dropped = append(dropped, tr)
-			continue
-		}
-		switch strings.ToLower(tu.Scheme) {
-		case "http", "https", "udp":
+	for _, tr := range mag.Trackers {
+		if validTracker(tr) {
 			goodTr = append(goodTr, tr)
-		default:
+		} else {
 			dropped = append(dropped, tr)
 		}
 	}
-	newQ := url.Values{}
+	safe := metainfo.MagnetV2{
+		InfoHash:    mag.InfoHash,
+		V2InfoHash:  mag.V2InfoHash,
+		DisplayName: mag.DisplayName,
+		Trackers:    goodTr,
+	}
+	return safe.String(), dropped, nil
+}
+
+// ParseMagnet parses a magnet URI, picking out its BitTorrent info-hashes:
+// the v1 btih and, for v2 and hybrid torrents, the btmh. Unlike anacrolix's
+// parser it ignores the xt values of other networks (urn:sha1, urn:ed2k,
+// ...) and accepts a hash repeated in another encoding, wherever they
+// appear.
+func ParseMagnet(uri string) (metainfo.MagnetV2, error) {
+	var m metainfo.MagnetV2
+	u, err := url.Parse(uri)
+	if err != nil {
+		return m, fmt.Errorf("invalid magnet URI: %w", err)
+	}
+	if u.Scheme != "magnet" {
+		return m, errors.New("invalid magnet URI: missing 'magnet:' scheme")
+	}
+	q := u.Query()
+	if len(q["xt"]) == 0 {
+		return m, errors.New("magnet URI missing xt parameter")
+	}
 	for _, xt := range q["xt"] {
-		newQ.Add("xt", xt)
+		// parsed alone, so that the parser sees at most one hash
+		one, err := metainfo.ParseMagnetV2Uri("magnet:?xt=" + url.QueryEscape(xt))
+		if err != nil {
+			return m, fmt.Errorf("invalid magnet URI: %w", err)
+		}
+		switch {
+		case one.InfoHash.Ok:
+			if m.InfoHash.Ok && m.InfoHash.Value != one.InfoHash.Value {
+				return m, errors.New("magnet URI has more than one btih info-hash")
+			}
+			m.InfoHash = one.InfoHash
+		case one.V2InfoHash.Ok:
+			if m.V2InfoHash.Ok && m.V2InfoHash.Value != one.V2InfoHash.Value {
+				return m, errors.New("magnet URI has more than one btmh info-hash")
+			}
+			m.V2InfoHash = one.V2InfoHash
+		}
+	}
+	if !m.InfoHash.Ok && !m.V2InfoHash.Ok {
+		return m, errors.New("magnet URI has no BitTorrent info-hash (urn:btih or urn:btmh)")
 	}
-	if dn := q.Get("dn"); dn != "" {
-		newQ.Set("dn", dn)
+	m.DisplayName = q.Get("dn")
+	m.Trackers = q["tr"]
+	q.Del("xt")
+	q.Del("dn")
+	q.Del("tr")
+	m.Params = q
+	return m, nil
+}
+
+// magnetKey is the info-hash the client knows a magnet's torrent by: the
+// v1 hash, or for v2-only torrents the v2 hash truncated to 20 bytes.
+func magnetKey(m metainfo.MagnetV2) metainfo.Hash {
+	if m.InfoHash.Ok {
+		return m.InfoHash.Value
+	}
+	return *m.V2InfoHash.Value.ToShort()
+}
+
+// validTracker reports whether tr is a URL with one of the trackerSchemes.
+func validTracker(tr string) bool {
+	tu, err := url.Parse(tr)
+	return err == nil && trackerSchemes[strings.ToLower(tu.Scheme)]
+}
+
+// warnDroppedTrackers records the trackers sanitizeTrackers dropped from
+// tt as warnings.
+func (e *Engine) warnDroppedTrackers(tt *torrent.Torrent, dropped []string) {
+	if len(dropped) == 0 {
+		return
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t := e.upsertTorrent(tt)
+	for _, tr := range dropped {
+		t.Warnings = append(t.Warnings, fmt.Sprintf("dropped invalid tracker %q", tr))
 	}
-	for _, tr := range goodTr {
-		newQ.Add("tr", tr)
+}
+
+// sanitizeTrackers removes invalid tracker URLs, and the tiers left empty,
+// from an announce list. It returns the remaining tiers and the dropped URLs.
+func sanitizeTrackers(tiers [][]string) ([][]string, []string) {
+	var good [][]string
+	var dropped []string
+	for _, tier := range tiers {
+		var urls []string
+		for _, tr := range tier {
+			if validTracker(tr) {
+				urls = append(urls, tr)
+			} else {
+				dropped = append(dropped, tr)
+			}
+		}
+		if len(urls) > 0 {
+			good = append(good, urls)
+		}
 	}
-	u.RawQuery = newQ.Encode()
-	return u.String(), dropped, nil
+	return good, dropped
 }
 
 func (e *Engine) newTorrent(tt *torrent.Torrent, desiredStart bool) error {
+	e.mut.Lock()
 	t := e.upsertTorrent(tt)
+	e.mut.Unlock()
 	go func() {
-		<-t.t.GotInfo()
-		if desiredStart || e.config.AutoStart {
-			e.StartTorrent(t.InfoHash)
+		<-tt.GotInfo()
+		if isPrivate(tt.Info()) && !e.onPrivateClient(tt) {
+			if err := e.movePrivate(tt); err != nil {
+				log.Printf("private torrent %s: %v", t.InfoHash, err)
+			}
+		}
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		if !desiredStart && !e.config.AutoStart {
+			return
+		}
+		// it may have been removed or started meanwhile
+		if t, err := e.getOpenTorrent(t.InfoHash); err == nil && !t.Started {
+			if err := e.startDesired(t); err != nil {
+				log.Printf("start %s: %v", t.InfoHash, err)
+			}
 		}
 	}()
 	return nil
@@ -360,9 +1285,8 @@ func (e *Engine) GetTorrents() map[string]*Torrent {
 	if e.client == nil {
 		return nil
 	}
-	for _, tt := range e.client.Torrents() {
-		e.upsertTorrent(tt)
-	}
+	// the periodic checks are left to the maintenance loop
+	e.updateTorrents()
 	return e.ts
 }
 
@@ -370,18 +1294,45 @@ func (e *Engine) upsertTorrent(tt *torrent.Torrent) *Torrent {
 	ih := tt.InfoHash().HexString()
 	torrent, ok := e.ts[ih]
 	if !ok {
-		torrent = &Torrent{InfoHash: ih}
+		torrent = &Torrent{InfoHash: ih, AddedAt: time.Now(), replaced: make(chan struct{})}
 		e.ts[ih] = torrent
+		e.events.publish(EventAdded, ih)
+	}
+	if torrent.t != nil && torrent.t != tt {
+		close(torrent.replaced)
+		torrent.replaced = make(chan struct{})
 	}
 	//update torrent fields using underlying torrent
+	wasComplete := !torrent.CompletedAt.IsZero()
 	torrent.Update(tt)
+	justCompleted := !wasComplete && !torrent.CompletedAt.IsZero()
+	if justCompleted {
+		e.enqueuePersist(persistOp{Op: "completed", InfoHash: torrent.InfoHash, CompletedAt: torrent.CompletedAt})
+		e.events.publish(EventCompleted, ih)
+		// added with its data already complete, nothing was downloaded
+		if torrent.CompletedAt != torrent.AddedAt {
+			e.runCompleteCommand(torrent)
+		}
+	}
+	if torrent.Started && !torrent.CompletedAt.IsZero() {
+		if e.completionPolicy(torrent).shouldStop(torrent.Ratio, justCompleted) {
+			if err := e.stopDesired(torrent); err != nil {
+				log.Printf("on complete: failed to stop %s: %v", torrent.InfoHash, err)
+			}
+		} else if seedRatioReached(torrent, e.config.SeedRatioLimit) {
+			if err := e.stopDesired(torrent); err != nil {
+				log.Printf("seed ratio: failed to stop %s: %v", torrent.InfoHash, err)
+			} else {
+				torrent.SeedingComplete = true
+			}
+		}
+	}
 	// Persist new/updated torrent metadata asynchronously
 	if e.persister != nil {
-		desired := "stopped"
-		if torrent.Started {
-			desired = "started"
+		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: torrent.InfoHash, Name: torrent.Name, DesiredState: torrent.desiredState()})
+		if torrent.Loaded {
+			e.enqueuePersist(persistOp{Op: "stats", InfoHash: torrent.InfoHash, Downloaded: torrent.Downloaded, Uploaded: torrent.Uploaded})
 		}
-		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: torrent.InfoHash, Name: torrent.Name, DesiredState: desired})
 	}
 	return torrent
 }
@@ -407,21 +1358,23 @@ func (e *Engine) getOpenTorrent(infohash string) (*Torrent, error) {
 }
 
 func (e *Engine) StartTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
 	t, err := e.getOpenTorrent(infohash)
 	if err != nil {
 		return err
 	}
+	return e.startDesired(t)
+}
+
+// startDesired starts t and persists started as its desired state. Called
+// with e.mut held.
+func (e *Engine) startDesired(t *Torrent) error {
 	if t.Started {
 		return fmt.Errorf("Already started")
 	}
-	t.Started = true
-	for _, f := range t.Files {
-		if f != nil {
-			f.Started = true
-		}
-	}
-	if t.t.Info() != nil {
-		t.t.DownloadAll()
+	if err := e.startTorrent(t); err != nil {
+		return err
 	}
 	// persist desired state
 	if e.persister != nil {
@@ -431,13 +1384,54 @@ func (e *Engine) StartTorrent(infohash string) error {
 }
 
 func (e *Engine) StopTorrent(infohash string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
 	t, err := e.getTorrent(infohash)
 	if err != nil {
 		return err
 	}
+	return e.stopDesired(t)
+}
+
+// stopDesired stops t and persists stopped as its desired state. Called
+// with e.mut held.
+func (e *Engine) stopDesired(t *Torrent) error {
 	if !t.Started {
 		return fmt.Errorf("Already stopped")
 	}
+	e.stopTorrent(t)
+	// persist desired state
+	if e.persister != nil {
+		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: t.InfoHash, Name: t.Name, DesiredState: "stopped"})
+	}
+	return nil
+}
+
+// startTorrent starts t without persisting its desired state, adding it
+// back to its client if it was stopped, and tells its trackers. Called
+// with e.mut held.
+func (e *Engine) startTorrent(t *Torrent) error {
+	if isClosed(t.t.Closed()) {
+		if err := e.readd(t); err != nil {
+			return fmt.Errorf("restart %s: %w", t.InfoHash, err)
+		}
+	}
+	t.Started = true
+	t.SeedingComplete = false
+	t.PausedAll = false
+	t.startFiles()
+	if !t.SeedOnly {
+		t.download()
+	}
+	e.notifyTrackers(t, tracker.Started)
+	e.events.publish(EventStateChanged, t.InfoHash)
+	return nil
+}
+
+// stopTorrent stops t without persisting its desired state, telling its
+// trackers it left the swarm. Called with e.mut held.
+func (e *Engine) stopTorrent(t *Torrent) {
+	e.notifyTrackers(t, tracker.Stopped)
 	//there is no stop - kill underlying torrent
 	t.t.Drop()
 	t.Started = false
@@ -446,55 +1440,244 @@ func (e *Engine) StopTorrent(infohash string) error {
 			f.Started = false
 		}
 	}
-	// persist desired state
+	e.events.publish(EventStateChanged, t.InfoHash)
+}
+
+// readd adds a dropped torrent back to its client with the same storage,
+// and resumes enforcing its rate limits and sequential mode. Called with
+// e.mut held.
+func (e *Engine) readd(t *Torrent) error {
+	old := t.t
+	mi := old.Metainfo()
+	spec := &torrent.TorrentSpec{
+		AddTorrentOpts: torrent.AddTorrentOpts{InfoHash: old.InfoHash(), InfoBytes: mi.InfoBytes, Storage: t.store},
+		Trackers:       mi.UpvertedAnnounceList(),
+		DisplayName:    t.Name,
+	}
+	client := e.client
+	if isPrivate(old.Info()) {
+		var err error
+		if client, err = e.privateClientLocked(); err != nil {
+			return err
+		}
+	}
+	tt, _, err := client.AddTorrentSpec(spec)
+	if err != nil {
+		return err
+	}
+	e.upsertTorrent(tt)
+	// the goroutines of the dropped torrent have returned
+	if t.stopLimits != nil {
+		t.stopLimits = make(chan struct{})
+		go e.runLimits(t.InfoHash, tt, t.stopLimits)
+	}
+	if t.stopSequential != nil {
+		t.stopSequential = make(chan struct{})
+		t.sequentialDone = make(chan struct{})
+		go e.runSequential(t.InfoHash, tt, t.stopSequential, t.sequentialDone)
+	}
+	return nil
+}
+
+// completionPolicy returns the policy applied when t completes, falling back
+// to the engine-wide policy.
+func (e *Engine) completionPolicy(t *Torrent) CompletionPolicy {
+	if !t.OnComplete.IsDefault() {
+		return t.OnComplete
+	}
+	return e.config.OnComplete
+}
+
+// SetCompletionPolicy sets what happens when the torrent finishes
+// downloading. The zero CompletionPolicy reverts to the engine-wide policy.
+func (e *Engine) SetCompletionPolicy(infohash string, p CompletionPolicy) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	t.OnComplete = p
 	if e.persister != nil {
-		e.enqueuePersist(persistOp{Op: "upsert", InfoHash: t.InfoHash, Name: t.Name, DesiredState: "stopped"})
+		e.enqueuePersist(persistOp{Op: "policy", InfoHash: t.InfoHash, OnComplete: p.String()})
+	}
+	return nil
+}
+
+// SetSeedOnly toggles seed-only mode. A seed-only torrent keeps serving the
+// pieces it already has but never requests missing ones; unlike StopTorrent
+// it stays in the swarm.
+func (e *Engine) SetSeedOnly(infohash string, on bool) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	if t.SeedOnly == on {
+		return nil
+	}
+	if on {
+		t.t.DisallowDataDownload()
+		if t.t.Info() != nil {
+			t.t.CancelPieces(0, t.t.NumPieces())
+		}
+	} else {
+		t.t.AllowDataDownload()
+		if t.Started {
+			t.download()
+		}
 	}
+	t.SeedOnly = on
 	return nil
 }
 
-func (e *Engine) DeleteTorrent(infohash string) error {
+// DeleteTorrent removes a torrent from the engine and, with deleteData,
+// its downloaded files from the data directory. The persisted torrent is
+// forgotten even if the files can't be removed.
+func (e *Engine) DeleteTorrent(infohash string, deleteData bool) error {
+	e.mut.Lock()
 	t, err := e.getTorrent(infohash)
 	if err != nil {
+		e.mut.Unlock()
 		return err
 	}
-	os.Remove(filepath.Join(e.cacheDir, infohash+".torrent"))
+	var data []string
+	if deleteData && t.t.Info() != nil {
+		dir := t.DownloadDir
+		if dir == "" {
+			dir = e.config.DownloadDirectory
+		}
+		names, err := dataNames(t.t.Info(), t.t.InfoHash())
+		if err != nil {
+			e.mut.Unlock()
+			return err
+		}
+		for _, name := range names {
+			data = append(data, filepath.Join(dir, name))
+		}
+	}
+	if path := e.cachedTorrentPath(infohash); path != "" {
+		os.Remove(path)
+	}
 	delete(e.ts, t.InfoHash)
+	close(t.replaced)
+	e.clearDebug(t.InfoHash)
 	ih, _ := str2ih(infohash)
-	if tt, ok := e.client.Torrent(ih); ok {
-		tt.Drop()
+	for _, c := range e.clients() {
+		if tt, ok := c.Torrent(ih); ok {
+			tt.Drop()
+		}
+	}
+	if t.store != nil {
+		t.store.Close()
 	}
 	if e.persister != nil {
 		e.enqueuePersist(persistOp{Op: "delete", InfoHash: t.InfoHash})
 	}
+	e.events.publish(EventRemoved, t.InfoHash)
+	e.mut.Unlock()
+	// the storage is closed, nothing writes to the files anymore
+	for _, path := range data {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("delete data: %w", err)
+		}
+	}
 	return nil
 }
 
+// dataNames returns the names a torrent's data can have in its download
+// directory: its top-level file or directory, and the part file anacrolix
+// keeps for an incomplete single-file torrent.
+func dataNames(info *metainfo.Info, ih metainfo.Hash) ([]string, error) {
+	name := dataName(info, ih)
+	if name == "." || name == ".." || name != filepath.Base(name) {
+		return nil, fmt.Errorf("unsafe torrent name %q", name)
+	}
+	return []string{name, name + ".part"}, nil
+}
+
+// checkFilePaths makes sure no two files of info resolve to the same path
+// on disk, and that no file sits where another needs a directory.
+func checkFilePaths(info *metainfo.Info) error {
+	if len(info.Files) == 0 {
+		return nil
+	}
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, fi := range info.UpvertedFiles() {
+		p := path.Clean(strings.Join(fi.BestPath(), "/"))
+		if files[p] || dirs[p] {
+			return fmt.Errorf("%w: %s", ErrDuplicateFilePath, p)
+		}
+		files[p] = true
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if files[dir] {
+				return fmt.Errorf("%w: %s", ErrDuplicateFilePath, dir)
+			}
+			dirs[dir] = true
+		}
+	}
+	return nil
+}
+
+// StartFile downloads a file that was stopped or skipped, starting the
+// torrent if it isn't running.
 func (e *Engine) StartFile(infohash, filepath string) error {
-	t, err := e.getOpenTorrent(infohash)
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, f, err := e.getOpenFile(infohash, filepath)
 	if err != nil {
 		return err
 	}
-	var f *File
-	for _, file := range t.Files {
-		if file.Path == filepath {
-			f = file
-			break
-		}
+	if t.Started && f.Priority != FilePrioritySkip {
+		return fmt.Errorf("Already started")
 	}
-	if f == nil {
-		return fmt.Errorf("Missing file %s", filepath)
+	if f.Priority == FilePrioritySkip {
+		f.Priority = FilePriorityNormal
 	}
-	if f.Started {
-		return fmt.Errorf("Already started")
+	if !t.Started {
+		return e.startDesired(t)
 	}
-	t.Started = true
 	f.Started = true
+	if !t.SeedOnly {
+		t.download()
+	}
 	return nil
 }
 
+// StopFile stops downloading a file by skipping it. Pieces it shares with
+// files still being downloaded are fetched anyway.
 func (e *Engine) StopFile(infohash, filepath string) error {
-	return fmt.Errorf("Unsupported")
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, f, err := e.getOpenFile(infohash, filepath)
+	if err != nil {
+		return err
+	}
+	if f.Priority == FilePrioritySkip {
+		return fmt.Errorf("Already stopped")
+	}
+	f.Priority = FilePrioritySkip
+	f.Started = false
+	if t.Started && !t.SeedOnly {
+		t.download()
+	}
+	return nil
+}
+
+// getOpenFile returns the torrent and its file at path.
+func (e *Engine) getOpenFile(infohash, path string) (*Torrent, *File, error) {
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range t.Files {
+		if f != nil && f.Path == path {
+			return t, f, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("Missing file %s", path)
 }
 
 func str2ih(str string) (metainfo.Hash, error) {
@@ -0,0 +1,1394 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// newTestClientConfig returns the config for an offline client (no DHT,
+// trackers or port forwarding) storing data in dataDir.
+func newTestClientConfig(dataDir string) *torrent.ClientConfig {
+	config := torrent.NewDefaultClientConfig()
+	config.DataDir = dataDir
+	config.ListenPort = 0
+	config.Seed = true
+	config.NoDHT = true
+	config.DisableTrackers = true
+	config.DisablePEX = true
+	config.NoDefaultPortForwarding = true
+	return config
+}
+
+// newTestClient returns an offline client storing data in dataDir.
+func newTestClient(t *testing.T, dataDir string) *torrent.Client {
+	t.Helper()
+	return newTestClientFrom(t, newTestClientConfig(dataDir))
+}
+
+func newTestClientFrom(t *testing.T, config *torrent.ClientConfig) *torrent.Client {
+	t.Helper()
+	client, err := torrent.NewClient(config)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestEngine returns an engine backed by an offline client writing into
+// a temporary directory.
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	return newTestEngineIn(t, t.TempDir())
+}
+
+// newTestEngineIn is like newTestEngine but stores data in dataDir.
+func newTestEngineIn(t *testing.T, dataDir string) *Engine {
+	t.Helper()
+	e := New()
+	e.client = newTestClient(t, dataDir)
+	e.config = Config{DownloadDirectory: dataDir}
+	e.cacheDir = filepath.Join(dataDir, torrentCacheDir)
+	return e
+}
+
+const testMagnet = "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=test"
+
+func TestNewMagnetDuplicate(t *testing.T) {
+	e := newTestEngine(t)
+	if err := e.NewMagnet(testMagnet); err != nil {
+		t.Fatalf("first add failed: %v", err)
+	}
+	// a duplicate must be rejected before the client is touched
+	client := e.client
+	e.client = nil
+	defer func() { e.client = client }()
+	if err := e.NewMagnet(testMagnet); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	if n := len(client.Torrents()); n != 1 {
+		t.Fatalf("expected 1 torrent in client, got %d", n)
+	}
+}
+
+func TestTorrentLimit(t *testing.T) {
+	e := newTestEngine(t)
+	e.config.MaxTorrents = 2
+	if err := e.NewMagnet(testMagnet); err != nil {
+		t.Fatalf("first add failed: %v", err)
+	}
+	mi, _ := newTestMetaInfo(t, "second", map[string][]byte{"a.txt": []byte("hello world")}, 16384)
+	addTestTorrent(t, e, mi)
+
+	over, _ := newTestMetaInfo(t, "third", map[string][]byte{"b.txt": []byte("one too many")}, 16384)
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(over)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewTorrent(spec); !errors.Is(err, ErrTorrentLimitReached) || !strings.Contains(err.Error(), "2 of 2") {
+		t.Fatalf("expected ErrTorrentLimitReached, got %v", err)
+	}
+	if err := e.NewMagnet("magnet:?xt=urn:btih:" + strings.Repeat("ab", 20)); !errors.Is(err, ErrTorrentLimitReached) {
+		t.Fatalf("expected ErrTorrentLimitReached for a magnet, got %v", err)
+	}
+	// a duplicate is still reported as one
+	if err := e.NewMagnet(testMagnet); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	if n := len(e.GetTorrents()); n != 2 {
+		t.Fatalf("expected 2 torrents, got %d", n)
+	}
+
+	e.config.MaxTorrents = 0
+	if err := e.NewTorrent(spec); err != nil {
+		t.Fatalf("expected no limit at 0, got %v", err)
+	}
+}
+
+func TestNewMagnetTrackerless(t *testing.T) {
+	e := newTestEngine(t)
+	if err := e.NewMagnet(testMagnet); err != nil {
+		t.Fatalf("trackerless magnet rejected: %v", err)
+	}
+	tor, ok := e.GetTorrents()["0123456789abcdef0123456789abcdef01234567"]
+	if !ok {
+		t.Fatal("trackerless magnet was not added")
+	}
+	mi := tor.t.Metainfo()
+	if n := len(mi.UpvertedAnnounceList()); n != 0 {
+		t.Fatalf("expected no trackers, got %d tiers", n)
+	}
+}
+
+// newTestMetaInfo writes files into a temporary directory and builds a
+// metainfo describing them with the given piece length.
+func newTestMetaInfo(t *testing.T, name string, files map[string][]byte, pieceLength int64) (*metainfo.MetaInfo, string) {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, name)
+	for p, data := range files {
+		fp := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := metainfo.Info{PieceLength: pieceLength}
+	if err := info.BuildFromFilePath(root); err != nil {
+		t.Fatalf("failed to build info: %v", err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &metainfo.MetaInfo{InfoBytes: infoBytes}, dir
+}
+
+func TestAddTorrentURL(t *testing.T) {
+	e := newTestEngine(t)
+	p, err := NewPersister(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	e.AttachPersister(p)
+	mi, _ := newTestMetaInfo(t, "url", map[string][]byte{"a.txt": []byte("hello world")}, 16384)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.torrent":
+			w.Header().Set("Content-Type", "application/x-bittorrent")
+			mi.Write(w)
+		case "/redirect":
+			http.Redirect(w, r, "/file.torrent", http.StatusFound)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if err := e.AddTorrentURL(srv.URL + "/missing.torrent"); err == nil {
+		t.Fatalf("expected error for non-200 response")
+	}
+	if err := e.AddTorrentURL(srv.URL + "/page"); err == nil {
+		t.Fatalf("expected error for html response")
+	}
+	if err := e.AddTorrentURL(srv.URL + "/redirect"); err != nil {
+		t.Fatalf("add by url failed: %v", err)
+	}
+	ih := mi.HashInfoBytes().HexString()
+	if _, ok := e.GetTorrents()[ih]; !ok {
+		t.Fatalf("torrent %s not added", ih)
+	}
+	if err := e.AddTorrentURL(srv.URL + "/file.torrent"); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["torrent_path"] != srv.URL+"/redirect" {
+		t.Fatalf("source url not persisted: %v", rows)
+	}
+}
+
+func TestRehydrateTorrentURLFromCache(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	mi, _ := newTestMetaInfo(t, "cached", map[string][]byte{"a.txt": []byte("hello world")}, 16384)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		mi.Write(w)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	e := newTestEngineIn(t, dir)
+	e.AttachPersister(p)
+	if err := e.AddTorrentURL(srv.URL + "/cached.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	e.DetachPersister()
+	ih := mi.HashInfoBytes().HexString()
+	if _, err := os.Stat(filepath.Join(dir, torrentCacheDir, ih+".torrent")); err != nil {
+		t.Fatalf("expected the fetched torrent cached: %v", err)
+	}
+
+	srv.Close()
+	restored := newTestEngineIn(t, dir)
+	restored.AttachPersister(p)
+	restored.RehydrateFromPersister()
+	if _, ok := restored.GetTorrents()[ih]; !ok {
+		t.Fatalf("torrent %s not restored from the cache", ih)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected the torrent fetched once, got %d fetches", n)
+	}
+
+	if err := restored.DeleteTorrent(ih, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, torrentCacheDir, ih+".torrent")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the cached torrent removed with the torrent, got %v", err)
+	}
+}
+
+func TestAddTorrentFile(t *testing.T) {
+	e := newTestEngine(t)
+	p, err := NewPersister(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	e.AttachPersister(p)
+	mi, dir := newTestMetaInfo(t, "file", map[string][]byte{"a.txt": []byte("hello world")}, 16384)
+	path := writeTestTorrentFile(t, mi, filepath.Join(dir, "file.torrent"))
+
+	if err := e.AddTorrentFile(filepath.Join(dir, "missing.torrent")); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+	if err := e.AddTorrentFile(path); err != nil {
+		t.Fatalf("add torrent file failed: %v", err)
+	}
+	ih := mi.HashInfoBytes().HexString()
+	if _, ok := e.GetTorrents()[ih]; !ok {
+		t.Fatalf("torrent %s not added", ih)
+	}
+	if err := e.AddTorrentFile(path); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["torrent_path"] != path {
+		t.Fatalf("torrent path not persisted: %v", rows)
+	}
+}
+
+func TestAddTorrentTo(t *testing.T) {
+	e := newTestEngine(t)
+	p, err := NewPersister(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	e.AttachPersister(p)
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "custom", map[string][]byte{"data.bin": data}, 16384)
+	path := writeTestTorrentFile(t, mi, filepath.Join(dir, "custom.torrent"))
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddTorrentTo(path, notDir); err == nil {
+		t.Fatalf("expected error for a download directory that is a file")
+	}
+
+	target := filepath.Join(t.TempDir(), "movies")
+	if err := e.AddTorrentTo(path, target); err != nil {
+		t.Fatalf("add torrent failed: %v", err)
+	}
+	tor := e.GetTorrents()[mi.HashInfoBytes().HexString()]
+	if tor == nil || tor.DownloadDir != target {
+		t.Fatalf("expected download dir %s, got %+v", target, tor)
+	}
+	<-tor.t.GotInfo()
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	tor.t.AddClientPeer(seeder)
+	select {
+	case <-tor.t.Complete().On():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("torrent did not complete")
+	}
+
+	got, err := os.ReadFile(filepath.Join(target, "custom", "data.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("data not written to the chosen directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(e.config.DownloadDirectory, "custom")); !os.IsNotExist(err) {
+		t.Fatalf("data written to the download directory: %v", err)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["download_dir"] != target {
+		t.Fatalf("download dir not persisted: %v", rows)
+	}
+}
+
+// writeTestTorrentFile writes mi to path and returns path.
+func writeTestTorrentFile(t *testing.T, mi *metainfo.MetaInfo, path string) string {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRehydrateTorrentFile(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	mi, dir := newTestMetaInfo(t, "restore", map[string][]byte{"a.txt": []byte("hello world")}, 16384)
+	path := writeTestTorrentFile(t, mi, filepath.Join(dir, "restore.torrent"))
+	ih := mi.HashInfoBytes().HexString()
+	if err := p.UpsertTorrent(ih, "restore", "", path, "started"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetTransferStats(ih, 11, 5000); err != nil {
+		t.Fatal(err)
+	}
+	target := t.TempDir()
+	if err := p.SetDownloadDir(ih, target); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLabels(ih, []string{"linux", "iso"}); err != nil {
+		t.Fatal(err)
+	}
+	const gone = "0123456789abcdef0123456789abcdef01234567"
+	if err := p.UpsertTorrent(gone, "gone", "", filepath.Join(dir, "gone.torrent"), "started"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	e.AttachPersister(p)
+	e.RehydrateFromPersister()
+
+	tor, ok := e.GetTorrents()[ih]
+	if !ok {
+		t.Fatalf("torrent %s not restored", ih)
+	}
+	if !waitFor(t, 5*time.Second, func() bool {
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		return tor.Started
+	}) {
+		t.Fatalf("restored torrent not started")
+	}
+	e.GetTorrents()
+	if tor.Uploaded != 5000 {
+		t.Fatalf("expected the saved upload total to be restored, got %d", tor.Uploaded)
+	}
+	if tor.Downloaded != 11 {
+		t.Fatalf("expected the saved download total to be kept, got %d", tor.Downloaded)
+	}
+	if tor.DownloadDir != target {
+		t.Fatalf("expected download dir %s to be restored, got %q", target, tor.DownloadDir)
+	}
+	if !reflect.DeepEqual(tor.Labels, []string{"iso", "linux"}) {
+		t.Fatalf("expected labels to be restored, got %v", tor.Labels)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["infohash"] != ih || rows[0]["torrent_path"] != path || rows[0]["uploaded"] != "5000" {
+		t.Fatalf("expected only the restored row to remain, got %v", rows)
+	}
+}
+
+// newPartialDataDir returns a data directory holding name/file where only
+// piece keep of data is present. Earlier pieces are zeroed and the file is
+// truncated after the kept piece.
+func newPartialDataDir(t *testing.T, name, file string, data []byte, pieceLength, keep int) string {
+	t.Helper()
+	dir := t.TempDir()
+	end := min((keep+1)*pieceLength, len(data))
+	buf := make([]byte, end)
+	copy(buf[keep*pieceLength:], data[keep*pieceLength:end])
+	if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name, file), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// waitFor polls cond until it returns true or the timeout elapses.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
+
+// finishes reports whether f returns within timeout, so a deadlock fails
+// the test rather than hanging it.
+func finishes(t *testing.T, timeout time.Duration, f func()) bool {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// addTestTorrent adds mi to the engine and waits for its info.
+func addTestTorrent(t *testing.T, e *Engine, mi *metainfo.MetaInfo) *Torrent {
+	t.Helper()
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewTorrent(spec); err != nil {
+		t.Fatalf("add torrent failed: %v", err)
+	}
+	tor := e.GetTorrents()[spec.InfoHash.HexString()]
+	<-tor.t.GotInfo()
+	return tor
+}
+
+func TestSeedOnly(t *testing.T) {
+	const pieceLength = 16384
+	data := bytes.Repeat([]byte("intunja!"), pieceLength/4)
+	mi, _ := newTestMetaInfo(t, "seed", map[string][]byte{"data.bin": data}, pieceLength)
+
+	// the seed-only side holds the first piece, the peer holds the second
+	e := newTestEngineIn(t, newPartialDataDir(t, "seed", "data.bin", data, pieceLength, 0))
+	et := addTestTorrent(t, e, mi)
+	if err := et.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetSeedOnly(et.InfoHash, true); err != nil {
+		t.Fatalf("set seed only failed: %v", err)
+	}
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+
+	peer := newTestClient(t, newPartialDataDir(t, "seed", "data.bin", data, pieceLength, 1))
+	pt, err := peer.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	pt.DownloadAll()
+	pt.AddClientPeer(e.client)
+
+	// serving still works: the peer completes using our first piece
+	if !waitFor(t, 10*time.Second, func() bool { return pt.BytesCompleted() == pt.Length() }) {
+		t.Fatalf("peer did not complete from seed-only torrent: %d/%d", pt.BytesCompleted(), pt.Length())
+	}
+	time.Sleep(500 * time.Millisecond)
+	// but we never requested the second piece from the peer
+	stats := et.t.Stats()
+	if n := stats.ChunksRead.Int64(); n != 0 {
+		t.Fatalf("expected no chunks read in seed-only mode, got %d", n)
+	}
+	if got := et.t.BytesCompleted(); got != pieceLength {
+		t.Fatalf("expected %d bytes completed, got %d", pieceLength, got)
+	}
+}
+
+func TestCompletedAt(t *testing.T) {
+	const pieceLength = 16384
+	data := bytes.Repeat([]byte("intunja!"), pieceLength/4)
+	mi, dir := newTestMetaInfo(t, "done", map[string][]byte{"data.bin": data}, pieceLength)
+
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngineIn(t, newPartialDataDir(t, "done", "data.bin", data, pieceLength, 0))
+	et := addTestTorrent(t, e, mi)
+	if err := et.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if et.AddedAt.IsZero() {
+		t.Fatalf("expected AddedAt to be set on add")
+	}
+	e.GetTorrents()
+	if et.Percent != 50 || !et.CompletedAt.IsZero() {
+		t.Fatalf("expected 50%% and no completion, got %v%% completed at %v", et.Percent, et.CompletedAt)
+	}
+
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	et.t.AddClientPeer(seeder)
+	ok := waitFor(t, 10*time.Second, func() bool {
+		e.GetTorrents()
+		if et.Percent < 100 && !et.CompletedAt.IsZero() {
+			t.Fatalf("CompletedAt set at %v%%", et.Percent)
+		}
+		return et.Percent == 100
+	})
+	if !ok {
+		t.Fatalf("torrent did not complete: %v%%", et.Percent)
+	}
+	if !et.CompletedAt.After(et.AddedAt) {
+		t.Fatalf("expected CompletedAt after AddedAt, got %v <= %v", et.CompletedAt, et.AddedAt)
+	}
+}
+
+func TestCompletedAtAlreadyComplete(t *testing.T) {
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "full", map[string][]byte{"data.bin": data}, 16384)
+	e := newTestEngineIn(t, dir)
+	et := addTestTorrent(t, e, mi)
+	if err := et.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	e.GetTorrents()
+	if et.Percent != 100 {
+		t.Fatalf("expected 100%%, got %v", et.Percent)
+	}
+	if !et.CompletedAt.Equal(et.AddedAt) {
+		t.Fatalf("expected CompletedAt == AddedAt, got %v and %v", et.CompletedAt, et.AddedAt)
+	}
+}
+
+func TestRatioIgnoresDataOnDisk(t *testing.T) {
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "ondisk", map[string][]byte{"data.bin": data}, 16384)
+	e := newTestEngineIn(t, dir)
+	et := addTestTorrent(t, e, mi)
+	if err := et.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	// seeding data that was never downloaded
+	e.mut.Lock()
+	et.uploadedBase = 1000
+	e.mut.Unlock()
+	e.GetTorrents()
+	if et.BytesCompleted != et.Size || et.Downloaded != 0 {
+		t.Fatalf("expected complete data with nothing downloaded, got %d of %d, %d downloaded", et.BytesCompleted, et.Size, et.Downloaded)
+	}
+	if et.Ratio != InfiniteRatio {
+		t.Fatalf("expected InfiniteRatio, got %v", et.Ratio)
+	}
+}
+
+func TestCompletedAtSurvivesRestart(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "restart", map[string][]byte{"data.bin": data}, 16384)
+	path := writeTestTorrentFile(t, mi, filepath.Join(t.TempDir(), "restart.torrent"))
+	ih := mi.HashInfoBytes().HexString()
+
+	// an earlier session verified the data, so the client knows it is
+	// complete as soon as the torrent is added back
+	prev := newTestClient(t, dir)
+	pt, err := prev.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pt.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	prev.Close()
+	if err := p.UpsertTorrent(ih, "restart", "", path, "stopped"); err != nil {
+		t.Fatal(err)
+	}
+	done := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := p.SetCompletedAt(ih, done); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngineIn(t, dir)
+	e.AttachPersister(p)
+	e.RehydrateFromPersister()
+	tor, ok := e.GetTorrents()[ih]
+	if !ok {
+		t.Fatalf("torrent %s not restored", ih)
+	}
+	if !tor.CompletedAt.Equal(done) {
+		t.Fatalf("expected CompletedAt %v to be restored, got %v", done, tor.CompletedAt)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	got, err := time.Parse(time.RFC3339Nano, rows[0]["completed_at"])
+	if err != nil || !got.Equal(done) {
+		t.Fatalf("expected the stored completed_at to stay %v, got %q (%v)", done, rows[0]["completed_at"], err)
+	}
+}
+
+// completeTestTorrent downloads a small torrent from a local seeder into e
+// using policy, and returns the engine's view of it once complete.
+func completeTestTorrent(t *testing.T, e *Engine, policy CompletionPolicy) *Torrent {
+	t.Helper()
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "policy", map[string][]byte{"data.bin": data}, 16384)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	et := addTestTorrent(t, e, mi)
+	if err := e.SetCompletionPolicy(et.InfoHash, policy); err != nil {
+		t.Fatalf("set completion policy failed: %v", err)
+	}
+	if err := e.StartTorrent(et.InfoHash); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	et.t.AddClientPeer(seeder)
+	if !waitFor(t, 10*time.Second, func() bool {
+		e.GetTorrents()
+		return !et.CompletedAt.IsZero()
+	}) {
+		t.Fatalf("torrent did not complete")
+	}
+	return et
+}
+
+func TestCompletionPolicies(t *testing.T) {
+	cases := []struct {
+		name    string
+		global  CompletionPolicy
+		policy  CompletionPolicy
+		started bool
+	}{
+		{"seed forever", CompletionPolicy{}, SeedForever(), true},
+		{"stop after download", CompletionPolicy{}, StopAfterDownload(), false},
+		{"ratio not reached", CompletionPolicy{}, SeedUntilRatio(1), true},
+		{"global stop", StopAfterDownload(), CompletionPolicy{}, false},
+		{"override global", StopAfterDownload(), SeedForever(), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.config.OnComplete = c.global
+			et := completeTestTorrent(t, e, c.policy)
+			if et.Started != c.started {
+				t.Fatalf("expected started=%v after completion, got %v", c.started, et.Started)
+			}
+		})
+	}
+}
+
+func TestFailingPersister(t *testing.T) {
+	e := newTestEngine(t)
+	p, err := NewPersister(filepath.Join(t.TempDir(), "broken.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	// every write fails once the database is closed
+	p.Close()
+	e.AttachPersister(p)
+	defer e.DetachPersister()
+
+	if err := e.NewMagnet(testMagnet); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return e.PersistErr() != nil }) {
+		t.Fatalf("expected persistence to be disabled")
+	}
+
+	// the engine keeps working without persistence
+	mi, _ := newTestMetaInfo(t, "nopersist", map[string][]byte{"a.txt": []byte("data")}, 16384)
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if n := len(e.GetTorrents()); n != 2 {
+		t.Fatalf("expected 2 torrents, got %d", n)
+	}
+	if err := e.DeleteTorrent(tor.InfoHash, false); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+}
+
+func TestDeleteTorrentData(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	keepMI, dir := newTestMetaInfo(t, "keep", map[string][]byte{"a.bin": data}, 16384)
+	e := newTestEngineIn(t, dir)
+	e.AttachPersister(p)
+	keep := addTestTorrent(t, e, keepMI)
+	removeMI, removeDir := newTestMetaInfo(t, "remove", map[string][]byte{"a.bin": data, "sub/b.bin": data}, 16384)
+	if err := os.Rename(filepath.Join(removeDir, "remove"), filepath.Join(dir, "remove")); err != nil {
+		t.Fatal(err)
+	}
+	remove := addTestTorrent(t, e, removeMI)
+
+	if err := e.DeleteTorrent(keep.InfoHash, false); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep", "a.bin")); err != nil {
+		t.Fatalf("expected the files kept: %v", err)
+	}
+	if err := e.DeleteTorrent(remove.InfoHash, true); err != nil {
+		t.Fatalf("delete with data failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "remove")); !os.IsNotExist(err) {
+		t.Fatalf("expected the files deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep", "a.bin")); err != nil {
+		t.Fatalf("expected other torrents' files kept: %v", err)
+	}
+
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Fatalf("expected both persisted rows removed, got %v", rows)
+	}
+}
+
+func TestDataNames(t *testing.T) {
+	var ih metainfo.Hash
+	for _, name := range []string{".", "..", "../escape", "a/b"} {
+		if _, err := dataNames(&metainfo.Info{Name: name}, ih); err == nil {
+			t.Errorf("expected %q refused", name)
+		}
+	}
+	names, err := dataNames(&metainfo.Info{Name: "movie.mkv"}, ih)
+	if err != nil || !reflect.DeepEqual(names, []string{"movie.mkv", "movie.mkv.part"}) {
+		t.Fatalf("unexpected names %v, %v", names, err)
+	}
+	for _, name := range []string{"", " \t"} {
+		names, err := dataNames(&metainfo.Info{Name: name}, ih)
+		if err != nil || names[0] != ih.HexString() {
+			t.Errorf("expected %q named after the info-hash, got %v, %v", name, names, err)
+		}
+	}
+}
+
+func TestNamelessTorrent(t *testing.T) {
+	e := newTestEngine(t)
+	data := bytes.Repeat([]byte("nameless"), 4096)
+	mi, _ := newTestMetaInfo(t, "single", map[string][]byte{"": data}, 16384)
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Name = ""
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+	ih := mi.HashInfoBytes().HexString()
+
+	// the data is found under the info-hash rather than at an empty name
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ih), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.addTorrentSpec(spec, "", dir); err != nil {
+		t.Fatalf("failed to add nameless torrent: %v", err)
+	}
+	tor := e.GetTorrents()[ih]
+	if tor.Name != ih || !slices.Contains(tor.Warnings, noNameWarning) {
+		t.Fatalf("expected the info-hash as name with a warning, got %q %v", tor.Name, tor.Warnings)
+	}
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return tor.t.BytesMissing() == 0 }) {
+		t.Fatalf("data under %s not found, %d bytes missing", ih, tor.t.BytesMissing())
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, de := range entries {
+		if strings.TrimSpace(de.Name()) == "" {
+			t.Fatalf("file created with blank name %q", de.Name())
+		}
+	}
+}
+
+func TestConfigureDownloadDirectory(t *testing.T) {
+	e := newTestEngine(t)
+	dir := e.config.DownloadDirectory
+	c := Config{IncomingPort: 50007}
+	if err := e.Configure(c); err == nil || !strings.Contains(err.Error(), "Download directory not set") {
+		t.Fatalf("expected an empty download directory refused, got %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.DownloadDirectory = filepath.Join(file, "downloads")
+	if err := e.Configure(c); err == nil || !strings.Contains(err.Error(), "Invalid download directory") {
+		t.Fatalf("expected an uncreatable download directory refused, got %v", err)
+	}
+	if e.config.DownloadDirectory != dir {
+		t.Fatalf("expected the configuration kept, got %+v", e.config)
+	}
+}
+
+func TestNewTorrentZeroPieces(t *testing.T) {
+	e := newTestEngine(t)
+	info := metainfo.Info{Name: "empty", PieceLength: 16384}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewTorrent(spec); !errors.Is(err, ErrEmptyTorrent) {
+		t.Fatalf("expected ErrEmptyTorrent, got %v", err)
+	}
+	if n := len(e.GetTorrents()); n != 0 {
+		t.Fatalf("expected no torrents, got %d", n)
+	}
+}
+
+// makePrivate sets the BEP 27 private flag in mi's info dictionary.
+func makePrivate(t *testing.T, mi *metainfo.MetaInfo) {
+	t.Helper()
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := true
+	info.Private = &private
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrivateTorrent(t *testing.T) {
+	config := privateClientConfig(Config{IncomingPort: 50007})
+	if !config.NoDHT || !config.DisablePEX {
+		t.Fatalf("private client must disable DHT and PEX: NoDHT=%v DisablePEX=%v", config.NoDHT, config.DisablePEX)
+	}
+
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+
+	priv, _ := newTestMetaInfo(t, "private", map[string][]byte{"a.bin": make([]byte, 16384)}, 16384)
+	makePrivate(t, priv)
+	pt := addTestTorrent(t, e, priv)
+	if !pt.Private {
+		t.Fatal("expected torrent to be marked private")
+	}
+	ih := priv.HashInfoBytes()
+	if _, ok := e.client.Torrent(ih); ok {
+		t.Fatal("private torrent added to the DHT/PEX client")
+	}
+	if _, ok := e.private.Torrent(ih); !ok {
+		t.Fatal("private torrent missing from the private client")
+	}
+
+	pub, _ := newTestMetaInfo(t, "public", map[string][]byte{"b.bin": make([]byte, 16384)}, 16384)
+	if tor := addTestTorrent(t, e, pub); tor.Private {
+		t.Fatal("public torrent marked private")
+	}
+	if _, ok := e.client.Torrent(pub.HashInfoBytes()); !ok {
+		t.Fatal("public torrent missing from the main client")
+	}
+}
+
+func TestRestartPrivateTorrent(t *testing.T) {
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	mi, _ := newTestMetaInfo(t, "private", map[string][]byte{"a.bin": make([]byte, 16384)}, 16384)
+	makePrivate(t, mi)
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StopTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if !finishes(t, 5*time.Second, func() { err = e.StartTorrent(tor.InfoHash) }) {
+		t.Fatal("restarting a private torrent deadlocked")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tor.Started {
+		t.Fatal("expected the torrent started")
+	}
+	if _, ok := e.private.Torrent(mi.HashInfoBytes()); !ok {
+		t.Fatal("expected the torrent back on the private client")
+	}
+}
+
+func TestConfigureRestartsTorrents(t *testing.T) {
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	priv, _ := newTestMetaInfo(t, "private", map[string][]byte{"a.bin": make([]byte, 16384)}, 16384)
+	makePrivate(t, priv)
+	pt := addTestTorrent(t, e, priv)
+	pub, _ := newTestMetaInfo(t, "public", map[string][]byte{"b.bin": make([]byte, 16384)}, 16384)
+	tor := addTestTorrent(t, e, pub)
+	stopped, _ := newTestMetaInfo(t, "stopped", map[string][]byte{"c.bin": make([]byte, 16384)}, 16384)
+	st := addTestTorrent(t, e, stopped)
+	for _, ih := range []string{pt.InfoHash, tor.InfoHash} {
+		if err := e.StartTorrent(ih); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldPrivate := e.private
+	c := e.config
+	c.EnablePEX = true
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if e.private == oldPrivate {
+		t.Fatal("expected a new private client")
+	}
+	if _, ok := e.private.Torrent(priv.HashInfoBytes()); !ok || !pt.Started || isClosed(pt.t.Closed()) {
+		t.Fatal("expected the private torrent running on the new private client")
+	}
+	if _, ok := e.client.Torrent(pub.HashInfoBytes()); !ok || !tor.Started || isClosed(tor.t.Closed()) {
+		t.Fatal("expected the public torrent running on the new client")
+	}
+	if st.Started {
+		t.Fatal("expected the stopped torrent left stopped")
+	}
+	if err := e.StartTorrent(st.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.client.Torrent(stopped.HashInfoBytes()); !ok {
+		t.Fatal("expected the stopped torrent added to the new client when started")
+	}
+}
+
+func TestPrivateClientDirError(t *testing.T) {
+	e := newTestEngine(t)
+	// the piece completion db of the private client can't be created
+	if err := os.WriteFile(filepath.Join(e.config.DownloadDirectory, ".private"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.privateClient(); err == nil || !strings.Contains(err.Error(), "private client") {
+		t.Fatalf("expected the private client refused, got %v", err)
+	}
+}
+
+func TestConfigureDHT(t *testing.T) {
+	config := clientConfig(Config{EnableDHT: true, EnablePEX: true})
+	if config.NoDHT || config.DisablePEX {
+		t.Fatalf("expected DHT and PEX on: NoDHT=%v DisablePEX=%v", config.NoDHT, config.DisablePEX)
+	}
+	config = clientConfig(Config{EnablePEX: true})
+	if !config.NoDHT || config.DisablePEX {
+		t.Fatalf("expected only DHT off: NoDHT=%v DisablePEX=%v", config.NoDHT, config.DisablePEX)
+	}
+	// BEP 27 wins over the settings
+	config = privateClientConfig(Config{EnableDHT: true, EnablePEX: true})
+	if !config.NoDHT || !config.DisablePEX {
+		t.Fatalf("private client must disable DHT and PEX: NoDHT=%v DisablePEX=%v", config.NoDHT, config.DisablePEX)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	e := newTestEngine(t)
+	e.config.IncomingPort = port
+	client := e.client
+	c := e.config
+	c.EnablePEX = true
+	if err := e.Configure(c); err != nil {
+		t.Fatalf("configure failed: %v", err)
+	}
+	defer e.client.Close()
+	if e.client == client || !e.config.EnablePEX {
+		t.Fatal("expected a new client for PEX")
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	e := New()
+	if _, err := e.ListenPort(); err == nil {
+		t.Fatal("expected no port before Configure")
+	}
+	if err := e.Configure(Config{DownloadDirectory: t.TempDir(), IncomingPort: 0}); err != nil {
+		t.Fatalf("configure failed: %v", err)
+	}
+	defer e.client.Close()
+	port, err := e.ListenPort()
+	if err != nil || port <= 0 || port > 65535 {
+		t.Fatalf("expected a random port bound, got %d, %v", port, err)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err == nil {
+		l.Close()
+		t.Fatalf("port %d is not in use", port)
+	}
+	if err := e.Configure(Config{DownloadDirectory: t.TempDir(), IncomingPort: -1}); err == nil {
+		t.Fatal("expected a negative port refused")
+	}
+}
+
+func TestPrivateMagnetMoves(t *testing.T) {
+	mi, dir := newTestMetaInfo(t, "private", map[string][]byte{"a.bin": make([]byte, 32768)}, 16384)
+	makePrivate(t, mi)
+	seeder := newTestClient(t, dir)
+	st, err := seeder.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	ih := mi.HashInfoBytes()
+	magnet := mi.Magnet(&ih, nil).String()
+	if err := e.NewMagnet(magnet); err != nil {
+		t.Fatal(err)
+	}
+	tt, _ := e.client.Torrent(ih)
+	tt.AddClientPeer(seeder)
+
+	// once the info arrives the torrent moves off the DHT/PEX client
+	if !waitFor(t, 10*time.Second, func() bool {
+		_, ok := e.private.Torrent(ih)
+		return ok
+	}) {
+		t.Fatal("private magnet was not moved to the private client")
+	}
+	if _, ok := e.client.Torrent(ih); ok {
+		t.Fatal("private magnet still on the DHT/PEX client")
+	}
+	if tor := e.GetTorrents()[ih.HexString()]; tor == nil || !tor.Private {
+		t.Fatalf("expected a private torrent, got %+v", tor)
+	}
+}
+
+func TestTorrentCompletesWaitingMagnet(t *testing.T) {
+	const pieceLength = 16384
+	data := bytes.Repeat([]byte("intunja!"), pieceLength/4)
+	mi, _ := newTestMetaInfo(t, "merge", map[string][]byte{"data.bin": data}, pieceLength)
+	ih := mi.HashInfoBytes()
+
+	// the first piece is already on disk from the magnet's earlier session
+	e := newTestEngineIn(t, newPartialDataDir(t, "merge", "data.bin", data, pieceLength, 0))
+	if err := e.NewMagnet(mi.Magnet(&ih, nil).String()); err != nil {
+		t.Fatal(err)
+	}
+	before := e.GetTorrents()[ih.HexString()]
+	if before == nil || before.Loaded {
+		t.Fatalf("expected a magnet waiting for metadata, got %+v", before)
+	}
+
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.NewTorrent(spec); err != nil {
+		t.Fatalf("adding the matching torrent failed: %v", err)
+	}
+	<-before.t.GotInfo()
+	if err := before.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := e.GetTorrents()
+	if len(ts) != 1 || ts[ih.HexString()] != before {
+		t.Fatalf("expected the magnet entry to be kept, got %d torrents", len(ts))
+	}
+	if got := before.t.BytesCompleted(); got != pieceLength {
+		t.Fatalf("expected %d bytes kept, got %d", pieceLength, got)
+	}
+
+	// once metadata is known a second copy is a duplicate
+	if err := e.NewTorrent(spec); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	if got := before.t.BytesCompleted(); got != pieceLength {
+		t.Fatalf("progress changed after duplicate add: %d", got)
+	}
+}
+
+func TestNewMagnetWebSocketTracker(t *testing.T) {
+	dialed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			select {
+			case dialed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // we never complete TLS
+	srv.StartTLS()
+	defer srv.Close()
+	tracker := "wss://" + srv.Listener.Addr().String() + "/announce"
+
+	magnet := testMagnet + "&tr=" + url.QueryEscape(tracker)
+	safe, dropped, err := SanitizeMagnet(magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Fatalf("wss tracker dropped: %v", dropped)
+	}
+	if !strings.Contains(safe, url.QueryEscape(tracker)) {
+		t.Fatalf("wss tracker missing from sanitized magnet %q", safe)
+	}
+
+	dir := t.TempDir()
+	config := newTestClientConfig(dir)
+	config.DisableTrackers = false
+	e := New()
+	e.client = newTestClientFrom(t, config)
+	e.config = Config{DownloadDirectory: dir}
+	if err := e.NewMagnet(magnet); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-dialed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("WebSocket tracker was never dialed")
+	}
+}
+
+func TestSanitizeMagnetWebSocketTrackers(t *testing.T) {
+	magnet := testMagnet +
+		"&tr=" + url.QueryEscape("wss://tracker.example.com") +
+		"&tr=" + url.QueryEscape("ws://tracker.example.com:8000") +
+		"&tr=" + url.QueryEscape("gopher://tracker.example.com")
+	safe, dropped, err := SanitizeMagnet(magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0] != "gopher://tracker.example.com" {
+		t.Fatalf("expected only the gopher tracker dropped, got %v", dropped)
+	}
+	m, err := metainfo.ParseMagnetUri(safe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Trackers) != 2 {
+		t.Fatalf("expected ws and wss trackers kept, got %v", m.Trackers)
+	}
+}
+
+func TestMagnetMultipleXT(t *testing.T) {
+	const (
+		v1 = "0123456789abcdef0123456789abcdef01234567"
+		v2 = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+	)
+	// btmh first, the btih repeated in base32, and hashes of other networks
+	magnet := "magnet:?xt=urn:btmh:1220" + v2 +
+		"&xt=urn:sha1:YNCKHTQCWBTRNJIV4WNAE52SJUQCZO5C" +
+		"&xt=urn:btih:" + v1 +
+		"&xt=urn:ed2k:354B15E68FB8F36D7CD88FF94116CDC1" +
+		"&xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH" +
+		"&dn=hybrid"
+	m, err := ParseMagnet(magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.InfoHash.Ok || m.InfoHash.Value.HexString() != v1 {
+		t.Fatalf("v1 info-hash %v, want %s", m.InfoHash, v1)
+	}
+	if !m.V2InfoHash.Ok || m.V2InfoHash.Value.HexString() != v2 {
+		t.Fatalf("v2 info-hash %v, want %s", m.V2InfoHash, v2)
+	}
+
+	safe, _, err := SanitizeMagnet(magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(safe, "sha1") || strings.Contains(safe, "ed2k") {
+		t.Fatalf("non-BitTorrent xt kept in %q", safe)
+	}
+	// anacrolix rejects a magnet with a hash given twice
+	if _, err := metainfo.ParseMagnetV2Uri(safe); err != nil {
+		t.Fatalf("sanitized magnet %q: %v", safe, err)
+	}
+
+	e := newTestEngine(t)
+	if err := e.NewMagnet(magnet); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.GetTorrents()[v1]; !ok {
+		t.Fatal("hybrid torrent not known by its v1 info-hash")
+	}
+	if err := e.NewMagnet("magnet:?xt=urn:btih:" + v1); !errors.Is(err, ErrDuplicateTorrent) {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+
+	for _, bad := range []string{
+		"magnet:?xt=urn:sha1:YNCKHTQCWBTRNJIV4WNAE52SJUQCZO5C",
+		"magnet:?xt=urn:btih:" + v1 + "&xt=urn:btih:1123456789abcdef0123456789abcdef01234567",
+	} {
+		if _, _, err := SanitizeMagnet(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestAnnounceListDropsInvalidTrackers(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "tiers", map[string][]byte{"a.txt": []byte("data")}, 16384)
+	mi.AnnounceList = [][]string{
+		{"http://tracker.example.com/announce", "http//missing-colon/announce"},
+		{},
+		{"gopher://tracker.example.com"},
+		{"udp://tracker.example.com:1337"},
+	}
+	tor := addTestTorrent(t, e, mi)
+
+	want := metainfo.AnnounceList{{"http://tracker.example.com/announce"}, {"udp://tracker.example.com:1337"}}
+	added := tor.t.Metainfo()
+	if got := added.UpvertedAnnounceList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected announce list %v, got %v", want, got)
+	}
+	if len(tor.Warnings) != 2 ||
+		!strings.Contains(tor.Warnings[0], "http//missing-colon/announce") ||
+		!strings.Contains(tor.Warnings[1], "gopher://tracker.example.com") {
+		t.Fatalf("expected warnings for both dropped trackers, got %v", tor.Warnings)
+	}
+}
+
+func TestRehydrateDropsInvalidTrackers(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	mi, dir := newTestMetaInfo(t, "tiers", map[string][]byte{"a.txt": []byte("data")}, 16384)
+	mi.AnnounceList = [][]string{
+		{"http://tracker.example.com/announce", "http//missing-colon/announce"},
+		{"gopher://tracker.example.com"},
+	}
+	path := writeTestTorrentFile(t, mi, filepath.Join(dir, "tiers.torrent"))
+	ih := mi.HashInfoBytes().HexString()
+	if err := p.UpsertTorrent(ih, "tiers", "", path, "stopped"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t)
+	e.AttachPersister(p)
+	e.RehydrateFromPersister()
+	tor, ok := e.GetTorrents()[ih]
+	if !ok {
+		t.Fatalf("torrent %s not restored", ih)
+	}
+	want := metainfo.AnnounceList{{"http://tracker.example.com/announce"}}
+	restored := tor.t.Metainfo()
+	if got := restored.UpvertedAnnounceList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected announce list %v, got %v", want, got)
+	}
+	if len(tor.Warnings) != 2 ||
+		!strings.Contains(tor.Warnings[0], "http//missing-colon/announce") ||
+		!strings.Contains(tor.Warnings[1], "gopher://tracker.example.com") {
+		t.Fatalf("expected warnings for both dropped trackers, got %v", tor.Warnings)
+	}
+}
+
+func TestNewTorrentDuplicateFilePath(t *testing.T) {
+	e := newTestEngine(t)
+	m := NewMemoryEngine()
+	for _, files := range [][]metainfo.FileInfo{
+		{{Path: []string{"a.bin"}, Length: 16384}, {Path: []string{"a.bin"}, Length: 16384}},
+		{{Path: []string{"sub", "a.bin"}, Length: 16384}, {Path: []string{"sub", ".", "a.bin"}, Length: 16384}},
+		{{Path: []string{"sub"}, Length: 16384}, {Path: []string{"sub", "a.bin"}, Length: 16384}},
+	} {
+		info := metainfo.Info{Name: "dup", PieceLength: 16384, Files: files, Pieces: make([]byte, 2*20)}
+		infoBytes, err := bencode.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := torrent.TorrentSpecFromMetaInfoErr(&metainfo.MetaInfo{InfoBytes: infoBytes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.NewTorrent(spec); !errors.Is(err, ErrDuplicateFilePath) {
+			t.Fatalf("expected ErrDuplicateFilePath for %v, got %v", files, err)
+		}
+		if err := m.NewTorrent(spec); !errors.Is(err, ErrDuplicateFilePath) {
+			t.Fatalf("expected the memory engine to refuse %v, got %v", files, err)
+		}
+	}
+	if n := len(e.GetTorrents()); n != 0 {
+		t.Fatalf("expected no torrents, got %d", n)
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import "sync"
+
+// EventType is the kind of change an Event reports.
+type EventType string
+
+const (
+	EventAdded        EventType = "added"
+	EventRemoved      EventType = "removed"
+	EventCompleted    EventType = "completed"
+	EventStateChanged EventType = "state-changed" // started or stopped
+	EventDiskFull     EventType = "disk-full"     // paused for lack of disk space
+)
+
+// Event reports a change to a torrent, see Subscribe.
+type Event struct {
+	Type     EventType
+	InfoHash string
+}
+
+// eventBuffer is how many events a subscriber can fall behind by before
+// further events are dropped for it.
+const eventBuffer = 64
+
+// events fans published events out to subscribers. The zero value is ready
+// to use.
+type events struct {
+	mut  sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// subscribe returns a channel receiving published events and a function
+// that ends the subscription. Events are never waited on: a subscriber that
+// falls behind misses them and should resync with GetTorrents.
+func (b *events) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	b.mut.Lock()
+	if b.subs == nil {
+		b.subs = map[chan Event]struct{}{}
+	}
+	b.subs[ch] = struct{}{}
+	b.mut.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mut.Lock()
+			delete(b.subs, ch)
+			b.mut.Unlock()
+			// nothing sends on ch anymore, so pending events can be
+			// discarded and receivers released
+			for len(ch) > 0 {
+				<-ch
+			}
+			close(ch)
+		})
+	}
+}
+
+func (b *events) publish(typ EventType, infohash string) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- Event{Type: typ, InfoHash: infohash}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of torrent events and a function that ends
+// the subscription and closes the channel.
+func (e *Engine) Subscribe() (<-chan Event, func()) {
+	return e.events.subscribe()
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event on ch, failing if none arrives.
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	e := newTestEngine(t)
+	events, unsubscribe := e.Subscribe()
+	mi, _ := newTestMetaInfo(t, "events", map[string][]byte{"a.txt": []byte("data")}, 16384)
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StopTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DeleteTorrent(tor.InfoHash, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []EventType{EventAdded, EventStateChanged, EventStateChanged, EventRemoved} {
+		if ev := nextEvent(t, events); ev.Type != want || ev.InfoHash != tor.InfoHash {
+			t.Fatalf("expected %s for %s, got %+v", want, tor.InfoHash, ev)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel closed after unsubscribing")
+	}
+	// publishing without subscribers must not block or panic
+	e.events.publish(EventAdded, tor.InfoHash)
+}
+
+func TestSubscribeSlowSubscriber(t *testing.T) {
+	var b events
+	slow, unsubscribeSlow := b.subscribe()
+	fast, unsubscribeFast := b.subscribe()
+	defer unsubscribeFast()
+	for range eventBuffer + 10 {
+		b.publish(EventStateChanged, "x")
+		<-fast
+	}
+	if len(slow) != eventBuffer {
+		t.Fatalf("expected %d buffered events, got %d", eventBuffer, len(slow))
+	}
+	// unsubscribing discards what was never read
+	unsubscribeSlow()
+	if _, ok := <-slow; ok {
+		t.Fatal("expected pending events discarded")
+	}
+}
+
+func TestMemoryEngineEvents(t *testing.T) {
+	e := NewMemoryEngine()
+	e.Configure(Config{AutoStart: true})
+	events, unsubscribe := e.Subscribe()
+	defer unsubscribe()
+	ih, err := e.AddFake("demo.iso", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetProgress(ih, 1000)
+	e.DeleteTorrent(ih, false)
+	for _, want := range []EventType{EventAdded, EventCompleted, EventRemoved} {
+		if ev := nextEvent(t, events); ev.Type != want || ev.InfoHash != ih {
+			t.Fatalf("expected %s for %s, got %+v", want, ih, ev)
+		}
+	}
+}
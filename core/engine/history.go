@@ -0,0 +1,99 @@
+package engine
+
+import "time"
+
+// defaultRateHistory is how many rate samples are kept when
+// Config.RateHistoryLength is 0, five minutes at one poll a second.
+const defaultRateHistory = 300
+
+// rateHistoryPoints is the most samples RateHistory returns, enough for a
+// graph across a terminal.
+const rateHistoryPoints = 60
+
+// RateSample is the download and upload rate, in bytes per second, at a
+// point in time.
+type RateSample struct {
+	Time     time.Time
+	Download float32
+	Upload   float32
+}
+
+// rateHistory holds rate samples, oldest first.
+type rateHistory struct {
+	samples []RateSample
+}
+
+// add appends s and evicts samples beyond the configured length or older
+// than the configured age.
+func (h *rateHistory) add(s RateSample, c Config) {
+	h.samples = append(h.samples, s)
+	length := c.RateHistoryLength
+	if length <= 0 {
+		length = defaultRateHistory
+	}
+	drop := max(len(h.samples)-length, 0)
+	if c.RateHistoryAge > 0 {
+		oldest := s.Time.Add(-c.RateHistoryAge)
+		for drop < len(h.samples) && h.samples[drop].Time.Before(oldest) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		// copy rather than reslice so evicted samples don't pin memory
+		h.samples = append(h.samples[:0], h.samples[drop:]...)
+	}
+}
+
+// downsample returns at most points samples, each averaging a run of
+// consecutive samples and timed at the last of them.
+func (h *rateHistory) downsample(points int) []RateSample {
+	n := len(h.samples)
+	if n == 0 {
+		return nil
+	}
+	if n <= points {
+		return append([]RateSample(nil), h.samples...)
+	}
+	out := make([]RateSample, 0, points)
+	for i := range points {
+		// split as evenly as possible, the newest bucket ending at n
+		bucket := h.samples[i*n/points : (i+1)*n/points]
+		var down, up float32
+		for _, s := range bucket {
+			down += s.Download
+			up += s.Upload
+		}
+		size := float32(len(bucket))
+		out = append(out, RateSample{Time: bucket[len(bucket)-1].Time, Download: down / size, Upload: up / size})
+	}
+	return out
+}
+
+// recordRates adds a sample of each torrent's rates, and of their totals,
+// to the histories.
+func recordRates(ts map[string]*Torrent, total *rateHistory, c Config, now time.Time) {
+	var sum RateSample
+	for _, t := range ts {
+		t.rates.add(RateSample{Time: now, Download: t.DownloadRate, Upload: t.UploadRate}, c)
+		sum.Download += t.DownloadRate
+		sum.Upload += t.UploadRate
+	}
+	sum.Time = now
+	total.add(sum, c)
+}
+
+// RateHistory returns the recent rates of a torrent, or of all torrents
+// together if infohash is empty, oldest first and downsampled for graphs.
+// Samples are taken by the maintenance loop, every maintainInterval.
+func (e *Engine) RateHistory(infohash string) []RateSample {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if infohash == "" {
+		return e.rates.downsample(rateHistoryPoints)
+	}
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return nil
+	}
+	return t.rates.downsample(rateHistoryPoints)
+}
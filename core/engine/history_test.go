@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateHistory(t *testing.T) {
+	e := NewMemoryEngine()
+	e.Configure(Config{AutoStart: true, RateHistoryLength: 3})
+	a, _ := e.AddFake("a.iso", 1<<40)
+	b, _ := e.AddFake("b.iso", 1<<40)
+	e.SetRate(b, 100, 0)
+	for i := range 5 {
+		e.SetRate(a, int64(i+1)*1000, 10)
+		e.Advance(time.Second)
+	}
+
+	history := e.RateHistory(a)
+	if len(history) != 3 {
+		t.Fatalf("expected the history capped at 3 samples, got %d", len(history))
+	}
+	// the two oldest samples were evicted
+	for i, s := range history {
+		if want := float32(i+3) * 1000; s.Download != want || s.Upload != 10 {
+			t.Fatalf("sample %d: expected %v down, 10 up, got %+v", i, want, s)
+		}
+	}
+	if history[2].Time.Before(history[0].Time) {
+		t.Fatalf("expected samples oldest first, got %+v", history)
+	}
+	total := e.RateHistory("")
+	if len(total) != 3 || total[2].Download != 5100 || total[2].Upload != 10 {
+		t.Fatalf("expected totals of the last 3 samples, got %+v", total)
+	}
+
+	e.DeleteTorrent(a, false)
+	if history := e.RateHistory(a); history != nil {
+		t.Fatalf("expected no history for a deleted torrent, got %+v", history)
+	}
+}
+
+func TestRateHistoryAge(t *testing.T) {
+	var h rateHistory
+	c := Config{RateHistoryAge: 10 * time.Second}
+	start := time.Now()
+	for i := range 20 {
+		h.add(RateSample{Time: start.Add(time.Duration(i) * time.Second), Download: float32(i)}, c)
+	}
+	if len(h.samples) != 11 || h.samples[0].Download != 9 {
+		t.Fatalf("expected the samples of the last 10s, got %+v", h.samples)
+	}
+}
+
+func TestRateHistoryDownsample(t *testing.T) {
+	var h rateHistory
+	start := time.Now()
+	for i := range 10 {
+		h.add(RateSample{Time: start.Add(time.Duration(i) * time.Second), Download: float32(i), Upload: 1}, Config{})
+	}
+	got := h.downsample(4)
+	// buckets of 2, 3, 2 and 3 samples
+	want := []float32{0.5, 3, 5.5, 8}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d points, got %+v", len(want), got)
+	}
+	for i, s := range got {
+		if s.Download != want[i] || s.Upload != 1 {
+			t.Fatalf("point %d: expected %v down, 1 up, got %+v", i, want[i], s)
+		}
+	}
+	if !got[3].Time.Equal(h.samples[9].Time) {
+		t.Fatalf("expected the last point at the newest sample, got %v", got[3].Time)
+	}
+	if len(h.downsample(60)) != 10 {
+		t.Fatal("expected short histories returned whole")
+	}
+}
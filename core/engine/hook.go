@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// hookTimeout is how long Config.OnCompleteCommand may run before it is
+// killed.
+const hookTimeout = 5 * time.Minute
+
+// runCompleteCommand starts Config.OnCompleteCommand for t, which just
+// finished downloading, in the background. Called with e.mut held.
+func (e *Engine) runCompleteCommand(t *Torrent) {
+	command := e.config.OnCompleteCommand
+	if command == "" {
+		return
+	}
+	dir := t.DownloadDir
+	if dir == "" {
+		dir = e.config.DownloadDirectory
+	}
+	path, err := filepath.Abs(filepath.Join(dir, t.Name))
+	if err != nil {
+		path = filepath.Join(dir, t.Name)
+	}
+	env := append(os.Environ(),
+		"INTUNJA_INFOHASH="+t.InfoHash,
+		"INTUNJA_NAME="+t.Name,
+		"INTUNJA_PATH="+path,
+		fmt.Sprintf("INTUNJA_SIZE=%d", t.Size),
+	)
+	go runHook(command, env, hookTimeout)
+}
+
+// runHook runs command with the system shell, killing it after timeout,
+// and logs its output if it fails.
+func runHook(command string, env []string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = env
+	// don't wait on children of the shell holding its output open
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		log.Printf("on complete command killed after %s", timeout)
+		return
+	}
+	if err != nil {
+		log.Printf("on complete command failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+}
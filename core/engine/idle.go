@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+	"github.com/anacrolix/torrent/types"
+)
+
+// idleCheckInterval is how often the maintenance loop looks for idle peers,
+// or Config.IdlePeerTimeout if that is shorter.
+const idleCheckInterval = 10 * time.Second
+
+// peerActivity is what disconnectIdlePeers last saw of a peer: the data
+// exchanged with it, and since when the peer has been idle.
+type peerActivity struct {
+	data  int64
+	since time.Time
+}
+
+// addIdleCallbacks hooks the interest messages peers send, which
+// disconnectIdlePeers needs to tell the idle ones from those waiting on us.
+func (e *Engine) addIdleCallbacks(config *torrent.ClientConfig) {
+	cb := &config.Callbacks
+	closed := cb.PeerConnClosed
+	cb.PeerConnClosed = func(pc *torrent.PeerConn) {
+		if closed != nil {
+			closed(pc)
+		}
+		e.peerInterest.Delete(pc)
+	}
+	read := cb.ReadMessage
+	cb.ReadMessage = func(pc *torrent.PeerConn, m *pp.Message) {
+		if read != nil {
+			read(pc, m)
+		}
+		switch m.Type {
+		case pp.Interested:
+			e.peerInterest.Store(pc, true)
+		case pp.NotInterested:
+			e.peerInterest.Delete(pc)
+		}
+	}
+}
+
+// disconnectIdlePeers closes the connections of peers that have exchanged
+// no data for Config.IdlePeerTimeout while neither side wanted anything
+// from the other, freeing their slots for peers that may be useful.
+// Called with e.mut held.
+func (e *Engine) disconnectIdlePeers(now time.Time) {
+	timeout := e.config.IdlePeerTimeout
+	if timeout <= 0 || now.Sub(e.idleChecked) < min(idleCheckInterval, timeout) {
+		return
+	}
+	e.idleChecked = now
+	for _, t := range e.ts {
+		if t.t == nil || t.t.Info() == nil {
+			t.peerActivity = nil
+			continue
+		}
+		t.peerActivity = e.checkIdlePeers(t.t, t.peerActivity, now, timeout)
+	}
+}
+
+// checkIdlePeers closes the peers of tt idle for longer than timeout,
+// given what was seen of them before, and returns what is seen now.
+func (e *Engine) checkIdlePeers(tt *torrent.Torrent, seen map[*torrent.PeerConn]peerActivity, now time.Time, timeout time.Duration) map[*torrent.PeerConn]peerActivity {
+	runs := tt.PieceStateRuns()
+	current := map[*torrent.PeerConn]peerActivity{}
+	for _, pc := range tt.PeerConns() {
+		stats := pc.Stats()
+		a := peerActivity{
+			data:  stats.BytesReadData.Int64() + stats.BytesWrittenData.Int64(),
+			since: now,
+		}
+		if prev, ok := seen[pc]; ok && prev.data == a.data && !e.peerBusy(pc, runs) {
+			a.since = prev.since
+			if now.Sub(a.since) >= timeout {
+				pc.Close()
+				continue
+			}
+		}
+		current[pc] = a
+	}
+	return current
+}
+
+// peerBusy reports whether either side of pc has a reason to keep it: the
+// peer wants to download from us, or has pieces we want.
+func (e *Engine) peerBusy(pc *torrent.PeerConn, runs torrent.PieceStateRuns) bool {
+	if _, ok := e.peerInterest.Load(pc); ok && e.config.EnableUpload {
+		return true
+	}
+	has := pc.PeerPieces()
+	start := uint64(0)
+	for _, r := range runs {
+		end := start + uint64(r.Length)
+		if !r.Complete && r.Priority != types.PiecePriorityNone && has.IntersectsWithInterval(start, end) {
+			return true
+		}
+		start = end
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/time/rate"
+)
+
+func TestDisconnectIdlePeers(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	mi, dir := newTestMetaInfo(t, "idle", map[string][]byte{"a.bin": data}, 16384)
+	e := New()
+	config := newTestClientConfig(dir)
+	e.addIdleCallbacks(config)
+	e.client = newTestClientFrom(t, config)
+	e.config = Config{DownloadDirectory: dir, EnableUpload: true}
+	tor := addTestTorrent(t, e, mi)
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the active peer downloads slowly enough to still be at it when
+	// checked, the idle one has a piece but wants no more
+	activeConfig := newTestClientConfig(t.TempDir())
+	activeConfig.DownloadRateLimiter = rate.NewLimiter(16384, 16384)
+	active := newTestClientFrom(t, activeConfig)
+	idleDir := filepath.Join(t.TempDir(), "idle")
+	if err := os.MkdirAll(idleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	partial := make([]byte, len(data))
+	copy(partial, data[:16384])
+	if err := os.WriteFile(filepath.Join(idleDir, "a.bin"), partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idle := newTestClient(t, filepath.Dir(idleDir))
+	at, err := active.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at.DownloadAll()
+	it, err := idle.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := it.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	at.AddClientPeer(e.client)
+	tor.t.AddClientPeer(idle)
+	connected := func(c *torrent.Client) bool {
+		return slices.ContainsFunc(tor.t.PeerConns(), func(pc *torrent.PeerConn) bool {
+			return pc.PeerID == c.PeerID()
+		})
+	}
+	interested := func() bool {
+		n := 0
+		e.peerInterest.Range(func(any, any) bool { n++; return true })
+		return n > 0
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return connected(active) && connected(idle) && interested() }) {
+		t.Fatal("expected both peers connected and the active one interested")
+	}
+
+	e.mut.Lock()
+	e.config.IdlePeerTimeout = time.Minute
+	start := time.Now()
+	e.disconnectIdlePeers(start)
+	e.disconnectIdlePeers(start.Add(30 * time.Second))
+	early := connected(idle)
+	e.disconnectIdlePeers(start.Add(2 * time.Minute))
+	e.mut.Unlock()
+	if !early {
+		t.Fatal("expected the idle peer kept until the timeout")
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return !connected(idle) }) {
+		t.Fatal("expected the idle peer dropped")
+	}
+	if !connected(active) {
+		t.Fatal("expected the active peer kept")
+	}
+	if at.BytesMissing() == 0 {
+		t.Fatal("expected the active peer still downloading")
+	}
+}
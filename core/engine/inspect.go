@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TorrentInfo is the metadata of a torrent, see InspectTorrent.
+type TorrentInfo struct {
+	InfoHash string
+	Name     string
+	// MetadataPending is set for magnets whose info hasn't arrived yet.
+	// Only InfoHash, Name, Trackers and WebSeeds are known then.
+	MetadataPending bool
+	Trackers        [][]string // announce URLs by tier
+	WebSeeds        []string
+	Private         bool
+	PieceLength     int64
+	NumPieces       int
+	Size            int64
+	Files           []TorrentFileInfo
+	// from the .torrent file, empty for magnets
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+}
+
+// TorrentFileInfo is a file listed in a torrent's metadata.
+type TorrentFileInfo struct {
+	Path string
+	Size int64
+}
+
+// header holds the fields of a .torrent file outside its info, which the
+// client doesn't keep.
+type header struct {
+	comment      string
+	createdBy    string
+	creationDate time.Time
+}
+
+func newHeader(mi *metainfo.MetaInfo) header {
+	h := header{comment: mi.Comment, createdBy: mi.CreatedBy}
+	if mi.CreationDate > 0 {
+		h.creationDate = time.Unix(mi.CreationDate, 0)
+	}
+	return h
+}
+
+// keepHeader records the header of the .torrent file a torrent was added
+// from, for InspectTorrent.
+func (e *Engine) keepHeader(ih metainfo.Hash, mi *metainfo.MetaInfo) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if t := e.ts[ih.HexString()]; t != nil {
+		t.header = newHeader(mi)
+	}
+}
+
+// InspectTorrent returns the metadata of a torrent. It doesn't need the
+// torrent to be started or any of its data.
+func (e *Engine) InspectTorrent(infohash string) (*TorrentInfo, error) {
+	e.mut.Lock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		e.mut.Unlock()
+		return nil, err
+	}
+	tt := t.t
+	ti := &TorrentInfo{
+		InfoHash:     t.InfoHash,
+		Name:         t.Name,
+		Comment:      t.header.comment,
+		CreatedBy:    t.header.createdBy,
+		CreationDate: t.header.creationDate,
+	}
+	e.mut.Unlock()
+
+	mi := tt.Metainfo()
+	ti.Trackers = mi.UpvertedAnnounceList()
+	ti.WebSeeds = mi.UrlList
+	info := tt.Info()
+	if info == nil {
+		ti.MetadataPending = true
+		return ti, nil
+	}
+	ti.Name = info.BestName()
+	ti.Private = isPrivate(info)
+	ti.PieceLength = info.PieceLength
+	ti.NumPieces = info.NumPieces()
+	ti.Size = info.TotalLength()
+	for _, f := range tt.Files() {
+		ti.Files = append(ti.Files, TorrentFileInfo{Path: f.Path(), Size: f.Length()})
+	}
+	return ti, nil
+}
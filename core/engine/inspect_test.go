@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInspectTorrent(t *testing.T) {
+	e := newTestEngine(t)
+	mi, dir := newTestMetaInfo(t, "inspect", map[string][]byte{
+		"a.txt":       []byte("hello"),
+		"sub/b.bin":   make([]byte, 20000),
+		"sub/c/d.bin": make([]byte, 40000),
+	}, 16384)
+	mi.Announce = "udp://tracker.example:1337/announce"
+	mi.Comment = "test comment"
+	mi.CreatedBy = "intunja tests"
+	mi.CreationDate = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+	path := writeTestTorrentFile(t, mi, filepath.Join(dir, "inspect.torrent"))
+	if err := e.AddTorrentFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ti, err := e.InspectTorrent(mi.HashInfoBytes().HexString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []TorrentFileInfo{
+		{Path: "inspect/a.txt", Size: 5},
+		{Path: "inspect/sub/b.bin", Size: 20000},
+		{Path: "inspect/sub/c/d.bin", Size: 40000},
+	}
+	if !reflect.DeepEqual(ti.Files, wantFiles) {
+		t.Fatalf("expected files %+v, got %+v", wantFiles, ti.Files)
+	}
+	if ti.MetadataPending || ti.Name != "inspect" || ti.Size != 60005 || ti.PieceLength != 16384 || ti.NumPieces != 4 {
+		t.Fatalf("unexpected metadata %+v", ti)
+	}
+	if !reflect.DeepEqual(ti.Trackers, [][]string{{mi.Announce}}) {
+		t.Fatalf("unexpected trackers %v", ti.Trackers)
+	}
+	if ti.Comment != "test comment" || ti.CreatedBy != "intunja tests" || ti.CreationDate.Unix() != mi.CreationDate {
+		t.Fatalf("expected the .torrent header, got %+v", ti)
+	}
+
+	if _, err := e.InspectTorrent("0000000000000000000000000000000000000000"); err == nil {
+		t.Fatal("expected error for an unknown torrent")
+	}
+}
+
+func TestInspectTorrentMetadataPending(t *testing.T) {
+	e := newTestEngine(t)
+	if err := e.NewMagnet(testMagnet + "&tr=udp%3A%2F%2Ftracker.example%3A1337"); err != nil {
+		t.Fatal(err)
+	}
+	ti, err := e.InspectTorrent("0123456789abcdef0123456789abcdef01234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ti.MetadataPending || ti.Name != "test" || ti.Files != nil {
+		t.Fatalf("expected only what the magnet tells, got %+v", ti)
+	}
+	if !reflect.DeepEqual(ti.Trackers, [][]string{{"udp://tracker.example:1337"}}) {
+		t.Fatalf("unexpected trackers %v", ti.Trackers)
+	}
+}
+
+func TestRemoteInspectTorrent(t *testing.T) {
+	want := TorrentInfo{InfoHash: "abc", Name: "remote", Files: []TorrentFileInfo{{Path: "remote/a", Size: 1}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/torrents/abc/info" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer srv.Close()
+
+	ti, err := NewRemoteEngine(srv.URL).InspectTorrent("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*ti, want) {
+		t.Fatalf("expected %+v, got %+v", want, *ti)
+	}
+	if _, err := NewRemoteEngine(srv.URL).InspectTorrent("missing"); err == nil {
+		t.Fatal("expected error for a failed request")
+	}
+}
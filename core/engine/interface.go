@@ -5,15 +5,40 @@ import "github.com/anacrolix/torrent"
 type EngineInterface interface {
 	Config() Config
 	Configure(Config) error
+	ListenPort() (int, error)
 	NewMagnet(string) error
 	NewTorrent(*torrent.TorrentSpec) error
+	AddTorrentURL(string) error
+	AddTorrentFile(string) error
+	AddTorrentTo(string, string) error
 	GetTorrents() map[string]*Torrent
+	ListTorrents() []TorrentSummary
+	InspectTorrent(string) (*TorrentInfo, error)
+	RateHistory(string) []RateSample
+	Scrape(string) (ScrapeResult, error)
+	SwarmSize(string) (SwarmEstimate, error)
+	ReannounceTorrent(string) error
+	VerifyReport(string) (*VerifyResult, error)
+	SetTorrentDebug(string, bool) error
+	TorrentDebug(string) bool
 	StartTorrent(string) error
 	StopTorrent(string) error
-	DeleteTorrent(string) error
+	PauseAll() error
+	ResumeAll() error
+	SetSeedOnly(string, bool) error
+	SetSequential(string, bool) error
+	SetCompletionPolicy(string, CompletionPolicy) error
+	SetTorrentLimits(string, int64, int64) error
+	SetLabels(string, []string) error
+	DeleteTorrent(string, bool) error
+	MoveTorrent(string, string) error
 	StartFile(string, string) error
 	StopFile(string, string) error
+	SetFilePriority(string, string, FilePriority) error
+	SelectFilesByPattern(string, string) error
 	AttachPersister(*Persister)
 	DetachPersister()
+	PersistErr() error
 	RehydrateFromPersister()
+	Subscribe() (<-chan Event, func())
 }
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+)
+
+// normalizeLabels trims labels and drops empty and repeated ones, returning
+// them sorted.
+func normalizeLabels(labels []string) []string {
+	var out []string
+	for _, l := range labels {
+		if l = strings.TrimSpace(l); l != "" && !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// HasLabel reports whether the torrent is labelled label.
+func (t *Torrent) HasLabel(label string) bool {
+	return slices.Contains(t.Labels, label)
+}
+
+// SetLabels replaces the labels of a torrent, used to organize torrents
+// into categories. An empty list removes all labels.
+func (e *Engine) SetLabels(infohash string, labels []string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	t.Labels = normalizeLabels(labels)
+	if e.persister != nil {
+		e.enqueuePersist(persistOp{Op: "labels", InfoHash: t.InfoHash, Labels: t.Labels})
+	}
+	return nil
+}
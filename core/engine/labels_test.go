@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSetLabels(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	e := newTestEngine(t)
+	e.AttachPersister(p)
+	mi, _ := newTestMetaInfo(t, "labels", map[string][]byte{"a.txt": []byte("data")}, 16384)
+	tor := addTestTorrent(t, e, mi)
+
+	if err := e.SetLabels(tor.InfoHash, []string{" tv ", "linux", "", "tv"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"linux", "tv"}; !slices.Equal(tor.Labels, want) {
+		t.Fatalf("expected labels %q, got %q", want, tor.Labels)
+	}
+	if !tor.HasLabel("tv") || tor.HasLabel("movies") {
+		t.Fatalf("unexpected HasLabel results for %q", tor.Labels)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["labels"] != `["linux","tv"]` {
+		t.Fatalf("labels not persisted: %v", rows)
+	}
+
+	if err := e.SetLabels("0123456789abcdef0123456789abcdef01234567", []string{"tv"}); err == nil {
+		t.Fatal("expected error labelling a missing torrent")
+	}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import "time"
+
+// maintainInterval is how often the engine refreshes its torrents and runs
+// its periodic checks, whether or not anything polls GetTorrents.
+const maintainInterval = time.Second
+
+// startMaintenance starts the loop that keeps the torrents up to date, so a
+// daemon nobody is connected to still applies completion policies, the
+// on-complete command and the seed ratio limit, and checks disk space, the
+// DHT and idle peers. Running it twice is a no-op. Called with e.mut held.
+func (e *Engine) startMaintenance() {
+	if e.maintenance != nil {
+		return
+	}
+	stop := make(chan struct{})
+	e.maintenance = stop
+	go func() {
+		ticker := time.NewTicker(maintainInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				e.mut.Lock()
+				e.maintain(now)
+				e.mut.Unlock()
+			}
+		}
+	}()
+}
+
+// stopMaintenance ends the loop started by startMaintenance. Called with
+// e.mut held.
+func (e *Engine) stopMaintenance() {
+	if e.maintenance != nil {
+		close(e.maintenance)
+		e.maintenance = nil
+	}
+}
+
+// maintain refreshes every torrent, which applies what happens on
+// completion, samples the rates and runs the checks that are due. Called
+// with e.mut held.
+func (e *Engine) maintain(now time.Time) {
+	if e.client == nil {
+		return
+	}
+	e.updateTorrents()
+	recordRates(e.ts, &e.rates, e.config, now)
+	e.checkDiskSpace(now)
+	e.refreshDHT(now)
+	e.disconnectIdlePeers(now)
+}
+
+// updateTorrents refreshes the engine's view of the torrents of its
+// clients. Called with e.mut held.
+func (e *Engine) updateTorrents() {
+	for _, c := range e.clients() {
+		for _, tt := range c.Torrents() {
+			e.upsertTorrent(tt)
+		}
+	}
+}
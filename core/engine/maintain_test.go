@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWithoutPolling(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "headless", map[string][]byte{"a.bin": make([]byte, 32768)}, 16384)
+	e := newTestEngine(t)
+	e.config.MinFreeSpace = 1000
+	e.freeSpace = func(string) (int64, error) { return 500, nil }
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing calls GetTorrents, as in a daemon with no client connected
+	e.mut.Lock()
+	e.startMaintenance()
+	e.startMaintenance()
+	e.mut.Unlock()
+	if !waitFor(t, 5*time.Second, func() bool {
+		e.mut.Lock()
+		defer e.mut.Unlock()
+		return tor.PausedDiskFull
+	}) {
+		t.Fatal("expected the maintenance loop to pause the download on a full disk")
+	}
+
+	e.Close()
+	if e.maintenance != nil {
+		t.Fatal("expected Close to stop the maintenance loop")
+	}
+}
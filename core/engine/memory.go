@@ -0,0 +1,629 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// defaultMagnetSize is the size given to magnets added to a MemoryEngine
+// without an exact length (xl) parameter.
+const defaultMagnetSize = 256 << 20
+
+// MemoryEngine is an EngineInterface kept entirely in memory, for tests and
+// UI demos. Nothing touches the network or disk: torrents progress only at
+// the rates scripted with SetRate, when Advance is called or, if Realtime is
+// set, as wall-clock time passes between GetTorrents calls.
+type MemoryEngine struct {
+	// Realtime advances torrents by the time elapsed since the previous
+	// GetTorrents call.
+	Realtime bool
+
+	mut      sync.Mutex
+	config   Config
+	ts       map[string]*Torrent
+	down, up map[string]int64
+	debug    map[string]bool
+	polled   time.Time
+	events   events
+	rates    rateHistory
+}
+
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{
+		ts:    map[string]*Torrent{},
+		down:  map[string]int64{},
+		up:    map[string]int64{},
+		debug: map[string]bool{},
+	}
+}
+
+// AddFake adds a loaded single-file torrent of the given size and returns
+// its info-hash, which is derived from the name.
+func (m *MemoryEngine) AddFake(name string, size int64) (string, error) {
+	ih := metainfo.Hash(sha1.Sum([]byte(name)))
+	return ih.HexString(), m.add(ih, name, nil, []*File{{Path: name, Size: size}})
+}
+
+// SetRate sets the download and upload rates, in bytes per second, of a
+// started torrent.
+func (m *MemoryEngine) SetRate(infohash string, down, up int64) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	m.down[t.InfoHash], m.up[t.InfoHash] = down, up
+	return nil
+}
+
+// SetProgress sets how many bytes of the torrent have been downloaded.
+func (m *MemoryEngine) SetProgress(infohash string, downloaded int64) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	m.setDownloaded(t, min(max(downloaded, 0), t.Size))
+	return nil
+}
+
+// Advance moves all started torrents forward by d at their scripted rates.
+func (m *MemoryEngine) Advance(d time.Duration) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.advance(d)
+}
+
+func (m *MemoryEngine) advance(d time.Duration) {
+	secs := d.Seconds()
+	for ih, t := range m.ts {
+		t.DownloadRate, t.UploadRate = 0, 0
+		if !t.Started {
+			continue
+		}
+		if up := capRate(m.up[ih], t.MaxUploadRate, m.config.MaxUploadRate); up > 0 {
+			t.Uploaded += int64(float64(up) * secs)
+			t.UploadRate = float32(up)
+		}
+		if t.SeedOnly || t.Downloaded >= t.Size {
+			continue
+		}
+		down := capRate(m.down[ih], t.MaxDownloadRate, m.config.MaxDownloadRate)
+		m.setDownloaded(t, min(t.Downloaded+int64(float64(down)*secs), t.Size))
+		t.DownloadRate = float32(down)
+	}
+	for _, t := range m.ts {
+		t.Ratio = ratio(t.Uploaded, t.Downloaded)
+		if t.Started && seedRatioReached(t, m.config.SeedRatioLimit) {
+			t.Started = false
+			t.SeedingComplete = true
+			m.events.publish(EventStateChanged, t.InfoHash)
+		}
+	}
+	recordRates(m.ts, &m.rates, m.config, time.Now())
+}
+
+// capRate returns rate limited by the non-zero limits.
+func capRate(rate int64, limits ...int64) int64 {
+	for _, l := range limits {
+		if l > 0 {
+			rate = min(rate, l)
+		}
+	}
+	return rate
+}
+
+// setDownloaded updates the torrent and file progress, filling files in
+// order, and applies the completion policy once the torrent completes.
+func (m *MemoryEngine) setDownloaded(t *Torrent, n int64) {
+	t.Downloaded = n
+	t.BytesCompleted = n
+	t.Ratio = ratio(t.Uploaded, n)
+	t.Percent = percent(n, t.Size)
+	for _, f := range t.Files {
+		have := min(n, f.Size)
+		f.Percent = percent(have, f.Size)
+		n -= have
+	}
+	if t.Downloaded < t.Size {
+		t.CompletedAt = time.Time{}
+		return
+	}
+	if t.CompletedAt.IsZero() {
+		t.CompletedAt = time.Now()
+		m.events.publish(EventCompleted, t.InfoHash)
+		if t.Started && m.policy(t).shouldStop(t.Ratio, true) {
+			t.Started = false
+			m.events.publish(EventStateChanged, t.InfoHash)
+		}
+	}
+}
+
+func (m *MemoryEngine) policy(t *Torrent) CompletionPolicy {
+	if !t.OnComplete.IsDefault() {
+		return t.OnComplete
+	}
+	return m.config.OnComplete
+}
+
+func (m *MemoryEngine) get(infohash string) (*Torrent, error) {
+	ih, err := str2ih(infohash)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := m.ts[ih.HexString()]
+	if !ok {
+		return nil, fmt.Errorf("Missing torrent %x", ih)
+	}
+	return t, nil
+}
+
+func (m *MemoryEngine) add(ih metainfo.Hash, name string, trackers []string, files []*File) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if _, ok := m.ts[ih.HexString()]; ok {
+		return ErrDuplicateTorrent
+	}
+	if err := checkTorrentLimit(m.config, len(m.ts)); err != nil {
+		return err
+	}
+	t := &Torrent{
+		InfoHash: ih.HexString(),
+		Name:     name,
+		Trackers: trackers,
+		Loaded:   true,
+		Files:    files,
+		Started:  m.config.AutoStart,
+		AddedAt:  time.Now(),
+	}
+	for _, f := range files {
+		t.Size += f.Size
+		f.Started = t.Started
+	}
+	m.ts[t.InfoHash] = t
+	m.events.publish(EventAdded, t.InfoHash)
+	return nil
+}
+
+func (m *MemoryEngine) Config() Config {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.config
+}
+
+func (m *MemoryEngine) Configure(c Config) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.config = c
+	return nil
+}
+
+// ListenPort returns Config.IncomingPort, as the memory engine never
+// listens.
+func (m *MemoryEngine) ListenPort() (int, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.config.IncomingPort, nil
+}
+
+func (m *MemoryEngine) NewMagnet(magnetURI string) error {
+	if _, _, err := SanitizeMagnet(magnetURI); err != nil {
+		return err
+	}
+	// parsed unsanitized to keep xl
+	mag, err := ParseMagnet(magnetURI)
+	if err != nil {
+		return err
+	}
+	ih := magnetKey(mag)
+	name := mag.DisplayName
+	if name == "" {
+		name = ih.HexString()
+	}
+	size := int64(defaultMagnetSize)
+	if xl, err := strconv.ParseInt(mag.Params.Get("xl"), 10, 64); err == nil && xl > 0 {
+		size = xl
+	}
+	trackers := slices.DeleteFunc(mag.Trackers, func(tr string) bool { return !validTracker(tr) })
+	return m.add(ih, name, trackers, []*File{{Path: name, Size: size}})
+}
+
+func (m *MemoryEngine) NewTorrent(spec *torrent.TorrentSpec) error {
+	if len(spec.InfoBytes) == 0 {
+		name := spec.DisplayName
+		if name == "" {
+			name = spec.InfoHash.HexString()
+		}
+		return m.add(spec.InfoHash, name, flattenTrackers(spec.Trackers), []*File{{Path: name, Size: defaultMagnetSize}})
+	}
+	mi := metainfo.MetaInfo{InfoBytes: spec.InfoBytes}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return fmt.Errorf("invalid torrent info: %w", err)
+	}
+	if info.NumPieces() == 0 {
+		return ErrEmptyTorrent
+	}
+	if err := checkFilePaths(&info); err != nil {
+		return err
+	}
+	var files []*File
+	for _, fi := range info.UpvertedFiles() {
+		path := info.BestName()
+		if len(info.Files) > 0 {
+			path += "/" + fi.DisplayPath(&info)
+		}
+		files = append(files, &File{Path: path, Size: fi.Length})
+	}
+	return m.add(spec.InfoHash, info.BestName(), flattenTrackers(spec.Trackers), files)
+}
+
+func (m *MemoryEngine) AddTorrentURL(url string) error {
+	return fmt.Errorf("AddTorrentURL not supported by memory engine")
+}
+
+func (m *MemoryEngine) AddTorrentFile(path string) error {
+	mi, err := metainfo.LoadFromFile(path)
+	if err != nil {
+		return fmt.Errorf("load torrent: %w", err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return fmt.Errorf("invalid torrent file: %w", err)
+	}
+	return m.NewTorrent(spec)
+}
+
+// AddTorrentTo adds a magnet URI or .torrent file and records dir as its
+// download directory. Nothing is written there.
+func (m *MemoryEngine) AddTorrentTo(source, dir string) error {
+	if strings.TrimSpace(dir) == "" {
+		return errors.New("empty download directory")
+	}
+	var ih metainfo.Hash
+	switch {
+	case strings.HasPrefix(source, "magnet:"):
+		if err := m.NewMagnet(source); err != nil {
+			return err
+		}
+		mag, _ := ParseMagnet(source)
+		ih = magnetKey(mag)
+	case IsTorrentURL(source):
+		return m.AddTorrentURL(source)
+	default:
+		if err := m.AddTorrentFile(source); err != nil {
+			return err
+		}
+		mi, _ := metainfo.LoadFromFile(source)
+		ih = mi.HashInfoBytes()
+	}
+	return m.update(ih.HexString(), func(t *Torrent) error {
+		t.DownloadDir = dir
+		return nil
+	})
+}
+
+func (m *MemoryEngine) GetTorrents() map[string]*Torrent {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	now := time.Now()
+	if m.Realtime && !m.polled.IsZero() {
+		m.advance(now.Sub(m.polled))
+	}
+	m.polled = now
+	return m.ts
+}
+
+func (m *MemoryEngine) ListTorrents() []TorrentSummary {
+	ts := m.GetTorrents()
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return SummarizeTorrents(ts)
+}
+
+// InspectTorrent describes the torrent from what the MemoryEngine keeps:
+// its files, size and trackers.
+func (m *MemoryEngine) InspectTorrent(infohash string) (*TorrentInfo, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return nil, err
+	}
+	ti := &TorrentInfo{InfoHash: t.InfoHash, Name: t.Name, Size: t.Size}
+	if len(t.Trackers) > 0 {
+		ti.Trackers = [][]string{slices.Clone(t.Trackers)}
+	}
+	for _, f := range t.Files {
+		ti.Files = append(ti.Files, TorrentFileInfo{Path: f.Path, Size: f.Size})
+	}
+	return ti, nil
+}
+
+// RateHistory returns the rates recorded each time torrents advanced.
+func (m *MemoryEngine) RateHistory(infohash string) []RateSample {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if infohash == "" {
+		return m.rates.downsample(rateHistoryPoints)
+	}
+	t, err := m.get(infohash)
+	if err != nil {
+		return nil
+	}
+	return t.rates.downsample(rateHistoryPoints)
+}
+
+func (m *MemoryEngine) Scrape(infohash string) (ScrapeResult, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	return ScrapeResult{Tracker: "memory", Complete: t.Seeds, Incomplete: t.Leechers}, nil
+}
+
+func (m *MemoryEngine) SwarmSize(infohash string) (SwarmEstimate, error) {
+	r, err := m.Scrape(infohash)
+	if err != nil {
+		return SwarmEstimate{}, err
+	}
+	known := r.Complete + r.Incomplete
+	return SwarmEstimate{Size: estimateSwarm([]ScrapeResult{r}, known), Scrapes: []ScrapeResult{r}, KnownPeers: known}, nil
+}
+
+func (m *MemoryEngine) ReannounceTorrent(infohash string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	_, err := m.get(infohash)
+	return err
+}
+
+func (m *MemoryEngine) VerifyReport(infohash string) (*VerifyResult, error) {
+	return nil, fmt.Errorf("VerifyReport not supported by memory engine")
+}
+
+func (m *MemoryEngine) SetTorrentDebug(infohash string, on bool) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	m.debug[t.InfoHash] = on
+	return nil
+}
+
+func (m *MemoryEngine) TorrentDebug(infohash string) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.debug[infohash]
+}
+
+func (m *MemoryEngine) StartTorrent(infohash string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	if t.Started {
+		return fmt.Errorf("Already started")
+	}
+	t.Started = true
+	t.SeedingComplete = false
+	t.PausedAll = false
+	t.startFiles()
+	m.events.publish(EventStateChanged, t.InfoHash)
+	return nil
+}
+
+func (m *MemoryEngine) StopTorrent(infohash string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	if !t.Started {
+		return fmt.Errorf("Already stopped")
+	}
+	t.Started = false
+	t.DownloadRate, t.UploadRate = 0, 0
+	for _, f := range t.Files {
+		f.Started = false
+	}
+	m.events.publish(EventStateChanged, t.InfoHash)
+	return nil
+}
+
+func (m *MemoryEngine) PauseAll() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, t := range m.ts {
+		if t.Started {
+			t.Started, t.PausedAll = false, true
+			t.DownloadRate, t.UploadRate = 0, 0
+			for _, f := range t.Files {
+				f.Started = false
+			}
+			m.events.publish(EventStateChanged, t.InfoHash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryEngine) ResumeAll() error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, t := range m.ts {
+		if t.PausedAll && !t.Started {
+			t.Started, t.PausedAll = true, false
+			t.startFiles()
+			m.events.publish(EventStateChanged, t.InfoHash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryEngine) SetSeedOnly(infohash string, on bool) error {
+	return m.update(infohash, func(t *Torrent) error {
+		t.SeedOnly = on
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SetSequential(infohash string, on bool) error {
+	return m.update(infohash, func(t *Torrent) error {
+		t.Sequential = on
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SetCompletionPolicy(infohash string, p CompletionPolicy) error {
+	return m.update(infohash, func(t *Torrent) error {
+		t.OnComplete = p
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SetTorrentLimits(infohash string, down, up int64) error {
+	if down < 0 || up < 0 {
+		return fmt.Errorf("Invalid rate limits %d/%d", down, up)
+	}
+	return m.update(infohash, func(t *Torrent) error {
+		t.MaxDownloadRate, t.MaxUploadRate = down, up
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SetLabels(infohash string, labels []string) error {
+	return m.update(infohash, func(t *Torrent) error {
+		t.Labels = normalizeLabels(labels)
+		return nil
+	})
+}
+
+// MoveTorrent records the new location; there is no data to move.
+func (m *MemoryEngine) MoveTorrent(infohash, newDir string) error {
+	if strings.TrimSpace(newDir) == "" {
+		return errors.New("empty download directory")
+	}
+	return m.update(infohash, func(t *Torrent) error {
+		dir := newDir
+		if dir == m.config.DownloadDirectory {
+			dir = ""
+		}
+		if dir == t.DownloadDir {
+			return fmt.Errorf("torrent is already in %s", newDir)
+		}
+		t.DownloadDir = dir
+		return nil
+	})
+}
+
+// DeleteTorrent forgets the torrent. There is no data to delete.
+func (m *MemoryEngine) DeleteTorrent(infohash string, deleteData bool) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	delete(m.ts, t.InfoHash)
+	delete(m.down, t.InfoHash)
+	delete(m.up, t.InfoHash)
+	delete(m.debug, t.InfoHash)
+	m.events.publish(EventRemoved, t.InfoHash)
+	return nil
+}
+
+func (m *MemoryEngine) StartFile(infohash, filepath string) error {
+	return m.updateFile(infohash, filepath, func(t *Torrent, f *File) error {
+		if t.Started && f.Priority != FilePrioritySkip {
+			return fmt.Errorf("Already started")
+		}
+		if f.Priority == FilePrioritySkip {
+			f.Priority = FilePriorityNormal
+		}
+		if !t.Started {
+			m.events.publish(EventStateChanged, t.InfoHash)
+		}
+		t.Started, t.PausedAll = true, false
+		t.startFiles()
+		return nil
+	})
+}
+
+func (m *MemoryEngine) StopFile(infohash, filepath string) error {
+	return m.updateFile(infohash, filepath, func(t *Torrent, f *File) error {
+		if f.Priority == FilePrioritySkip {
+			return fmt.Errorf("Already stopped")
+		}
+		f.Priority = FilePrioritySkip
+		f.Started = false
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SetFilePriority(infohash, filepath string, prio FilePriority) error {
+	if prio < FilePriorityNormal || prio > FilePriorityHigh {
+		return fmt.Errorf("Invalid file priority %d", prio)
+	}
+	return m.updateFile(infohash, filepath, func(t *Torrent, f *File) error {
+		f.Priority = prio
+		return nil
+	})
+}
+
+func (m *MemoryEngine) SelectFilesByPattern(infohash, glob string) error {
+	return m.update(infohash, func(t *Torrent) error {
+		return selectFiles(t.Files, t.Name, glob)
+	})
+}
+
+func (m *MemoryEngine) update(infohash string, fn func(*Torrent) error) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	t, err := m.get(infohash)
+	if err != nil {
+		return err
+	}
+	return fn(t)
+}
+
+func (m *MemoryEngine) updateFile(infohash, path string, fn func(*Torrent, *File) error) error {
+	return m.update(infohash, func(t *Torrent) error {
+		for _, f := range t.Files {
+			if f.Path == path {
+				return fn(t, f)
+			}
+		}
+		return fmt.Errorf("Missing file %s", path)
+	})
+}
+
+// AttachPersister is a no-op for MemoryEngine (nothing survives a restart)
+func (m *MemoryEngine) AttachPersister(p *Persister) {}
+
+func (m *MemoryEngine) DetachPersister() {}
+
+func (m *MemoryEngine) PersistErr() error { return nil }
+
+func (m *MemoryEngine) RehydrateFromPersister() {}
+
+func (m *MemoryEngine) Subscribe() (<-chan Event, func()) {
+	return m.events.subscribe()
+}
@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryEngineProgress(t *testing.T) {
+	e := NewMemoryEngine()
+	e.Configure(Config{AutoStart: true, OnComplete: StopAfterDownload()})
+	ih, err := e.AddFake("demo.iso", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddFake("demo.iso", 1000); err != ErrDuplicateTorrent {
+		t.Fatalf("expected ErrDuplicateTorrent, got %v", err)
+	}
+	if err := e.SetRate(ih, 300, 100); err != nil {
+		t.Fatal(err)
+	}
+	tor := e.GetTorrents()[ih]
+
+	e.Advance(2 * time.Second)
+	if tor.Downloaded != 600 || tor.Uploaded != 200 || tor.Percent != 60 {
+		t.Fatalf("unexpected progress: %d down, %d up, %v%%", tor.Downloaded, tor.Uploaded, tor.Percent)
+	}
+	if s := e.ListTorrents()[0]; s.State != "downloading" || s.ETA != time.Second+time.Second/3 {
+		t.Fatalf("unexpected summary: %+v", s)
+	}
+
+	e.Advance(2 * time.Second)
+	if tor.Downloaded != 1000 || tor.CompletedAt.IsZero() {
+		t.Fatalf("expected completion, got %d bytes", tor.Downloaded)
+	}
+	if tor.Started {
+		t.Fatalf("expected the stop policy to stop the completed torrent")
+	}
+
+	if err := e.SetProgress(ih, 250); err != nil {
+		t.Fatal(err)
+	}
+	if tor.Percent != 25 || !tor.CompletedAt.IsZero() {
+		t.Fatalf("unexpected state after SetProgress: %v%%, completed at %v", tor.Percent, tor.CompletedAt)
+	}
+}
+
+func TestMemoryEngineMagnet(t *testing.T) {
+	e := NewMemoryEngine()
+	if err := e.NewMagnet(testMagnet + "&xl=4096"); err != nil {
+		t.Fatal(err)
+	}
+	tor := e.GetTorrents()["0123456789abcdef0123456789abcdef01234567"]
+	if tor == nil || tor.Name != "test" || tor.Size != 4096 || tor.Started {
+		t.Fatalf("unexpected magnet torrent: %+v", tor)
+	}
+}
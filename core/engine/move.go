@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// MoveTorrent moves a torrent's data to newDir without downloading it
+// again: the torrent is paused, its files are moved, it is re-added with
+// storage in newDir and resumed if it was running. If anything fails the
+// files are moved back and the torrent keeps its old location.
+func (e *Engine) MoveTorrent(infohash, newDir string) error {
+	if strings.TrimSpace(newDir) == "" {
+		return errors.New("empty download directory")
+	}
+	dst, err := filepath.Abs(newDir)
+	if err != nil {
+		return fmt.Errorf("download directory: %w", err)
+	}
+
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		e.mut.Unlock()
+		return err
+	}
+	tt := t.t
+	info := tt.Info()
+	if info == nil {
+		e.mut.Unlock()
+		return errors.New("torrent metadata not loaded yet")
+	}
+	src := t.DownloadDir
+	if src == "" {
+		src = e.config.DownloadDirectory
+	}
+	if src, err = filepath.Abs(src); err != nil {
+		e.mut.Unlock()
+		return fmt.Errorf("download directory: %w", err)
+	}
+	if dst == src {
+		e.mut.Unlock()
+		return fmt.Errorf("torrent is already in %s", dst)
+	}
+	root := filepath.Join(src, dataName(info, tt.InfoHash()))
+	if isWithin(root, dst) {
+		e.mut.Unlock()
+		return fmt.Errorf("cannot move %s into itself", root)
+	}
+	defaultDir, _ := filepath.Abs(e.config.DownloadDirectory)
+	wasStarted := t.Started
+	wasDropped := isClosed(tt.Closed())
+	oldStore := t.store
+	mi := tt.Metainfo()
+	e.mut.Unlock()
+
+	if err := checkWritable(dst); err != nil {
+		return err
+	}
+	names, err := dataNames(info, tt.InfoHash())
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := os.Lstat(filepath.Join(dst, name)); err == nil {
+			return fmt.Errorf("%s already exists", filepath.Join(dst, name))
+		}
+	}
+
+	// pause, keeping the desired state persisted as it was
+	e.mut.Lock()
+	if wasStarted {
+		e.stopTorrent(t)
+	}
+	tt.Drop()
+	e.mut.Unlock()
+
+	spec := &torrent.TorrentSpec{
+		AddTorrentOpts: torrent.AddTorrentOpts{InfoHash: tt.InfoHash(), InfoBytes: mi.InfoBytes},
+		Trackers:       mi.UpvertedAnnounceList(),
+	}
+	client := e.client
+	if isPrivate(info) {
+		if client, err = e.privateClient(); err != nil {
+			return e.restoreMove(t, spec, oldStore, wasStarted, wasDropped, err)
+		}
+	}
+	moved, err := moveEntries(src, dst, names)
+	if err != nil {
+		return e.restoreMove(t, spec, oldStore, wasStarted, wasDropped, err)
+	}
+	dir := dst
+	if dst == defaultDir {
+		dir = ""
+	}
+	nt, err := e.addSpec(client, spec, dir)
+	if err != nil {
+		if _, rerr := moveEntries(dst, src, moved); rerr != nil {
+			return fmt.Errorf("move torrent: %w; moving the files back failed, they are in %s: %v", err, dst, rerr)
+		}
+		return e.restoreMove(t, spec, oldStore, wasStarted, wasDropped, err)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.upsertTorrent(nt)
+	if dir == "" {
+		t.DownloadDir, t.store = "", nil
+	}
+	if oldStore != nil {
+		oldStore.Close()
+	}
+	e.resumeMoved(t, wasStarted, wasDropped)
+	if e.persister != nil {
+		e.enqueuePersist(persistOp{Op: "dir", InfoHash: t.InfoHash, DownloadDir: dir})
+	}
+	return nil
+}
+
+// restoreMove re-adds a torrent whose move failed with its old storage and
+// returns the move error.
+func (e *Engine) restoreMove(t *Torrent, spec *torrent.TorrentSpec, store storage.ClientImplCloser, wasStarted, wasDropped bool, moveErr error) error {
+	client := e.client
+	if isPrivate(t.t.Info()) {
+		if pc, err := e.privateClient(); err == nil {
+			client = pc
+		}
+	}
+	// addSpec may have set the new location's storage
+	spec.Storage = store
+	nt, _, err := client.AddTorrentSpec(spec)
+	if err != nil {
+		return fmt.Errorf("move torrent: %w; re-adding it failed: %v", moveErr, err)
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.upsertTorrent(nt)
+	e.resumeMoved(t, wasStarted, wasDropped)
+	return fmt.Errorf("move torrent: %w", moveErr)
+}
+
+// resumeMoved puts a re-added torrent back in the state it was in before
+// the move.
+func (e *Engine) resumeMoved(t *Torrent, wasStarted, wasDropped bool) {
+	switch {
+	case wasStarted:
+		if err := e.startTorrent(t); err != nil {
+			log.Printf("move: %v", err)
+		}
+	case wasDropped:
+		t.t.Drop()
+	}
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveEntries moves the named files or directories from src to dst,
+// skipping those that don't exist, and returns the names moved. On failure
+// the entries already moved are moved back.
+func moveEntries(src, dst string, names []string) ([]string, error) {
+	var moved []string
+	for _, name := range names {
+		from, to := filepath.Join(src, name), filepath.Join(dst, name)
+		if _, err := os.Lstat(from); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := moveEntry(from, to); err != nil {
+			for _, m := range moved {
+				moveEntry(filepath.Join(dst, m), filepath.Join(src, m))
+			}
+			return nil, err
+		}
+		moved = append(moved, name)
+	}
+	return moved, nil
+}
+
+// moveEntry renames from to to, copying when a rename isn't possible, e.g.
+// across filesystems. A failed copy is removed again, leaving from intact.
+func moveEntry(from, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	if err := copyTree(from, to); err != nil {
+		os.RemoveAll(to)
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+// copyTree copies the file or directory from to to.
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMoveTorrent(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	data := bytes.Repeat([]byte("intunja!"), 4096)
+	mi, dir := newTestMetaInfo(t, "move", map[string][]byte{"a.bin": data, "sub/b.bin": data}, 16384)
+	e := newTestEngineIn(t, dir)
+	e.AttachPersister(p)
+	tor := addTestTorrent(t, e, mi)
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.MoveTorrent(tor.InfoHash, dir); err == nil {
+		t.Fatal("expected error moving into the same directory")
+	}
+	if err := e.MoveTorrent(tor.InfoHash, filepath.Join(dir, "move", "sub")); err == nil {
+		t.Fatal("expected error moving the data into itself")
+	}
+
+	dst := t.TempDir()
+	if err := e.MoveTorrent(tor.InfoHash, dst); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "move", "sub", "b.bin")); err != nil {
+		t.Fatalf("data not moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "move")); !os.IsNotExist(err) {
+		t.Fatalf("data left in the old directory: %v", err)
+	}
+	if tor.DownloadDir != dst || !tor.Started {
+		t.Fatalf("unexpected state after move: dir=%q started=%v", tor.DownloadDir, tor.Started)
+	}
+	if !waitFor(t, 5*time.Second, func() bool {
+		e.GetTorrents()
+		return tor.Percent == 100
+	}) {
+		t.Fatalf("moved data not complete, at %v%%", tor.Percent)
+	}
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["download_dir"] != dst {
+		t.Fatalf("new location not persisted: %v", rows)
+	}
+
+	// back into the download directory
+	if err := e.MoveTorrent(tor.InfoHash, dir); err != nil {
+		t.Fatalf("move back failed: %v", err)
+	}
+	if tor.DownloadDir != "" {
+		t.Fatalf("expected the download directory, got %q", tor.DownloadDir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "move", "a.bin")); err != nil {
+		t.Fatalf("data not moved back: %v", err)
+	}
+}
+
+func TestMoveEntriesRollsBack(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dst, "b"), []byte("in the way"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := moveEntries(src, dst, []string{"a", "b", "missing"}); err == nil {
+		t.Fatal("expected error moving onto an existing file")
+	}
+	for _, name := range []string{"a", "b"} {
+		if data, err := os.ReadFile(filepath.Join(src, name)); err != nil || string(data) != name {
+			t.Fatalf("%s not restored: %q %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a")); !os.IsNotExist(err) {
+		t.Fatalf("moved file not moved back: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "b")); string(data) != "in the way" {
+		t.Fatalf("existing file changed: %q", data)
+	}
+
+	moved, err := moveEntries(src, dst, []string{"a", "missing"})
+	if err != nil || len(moved) != 1 || moved[0] != "a" {
+		t.Fatalf("unexpected result %v, %v", moved, err)
+	}
+}
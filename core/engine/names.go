@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// noNameWarning is the warning of a torrent whose info has no usable name.
+const noNameWarning = "torrent has no name, its data is named after its info-hash"
+
+// blankName reports whether info has no name to store its data under,
+// being empty or only whitespace.
+func blankName(info *metainfo.Info) bool {
+	return strings.TrimSpace(info.BestName()) == ""
+}
+
+// dataName returns the name of a torrent's top-level file or directory in
+// its download directory: its own name, or its info-hash if it has none.
+func dataName(info *metainfo.Info, ih metainfo.Hash) string {
+	if blankName(info) {
+		return ih.HexString()
+	}
+	return info.BestName()
+}
+
+// fallbackNames maps the info of each nameless torrent opened by a
+// newFileStorage to its dataName. Storage hands the info-hash to the
+// TorrentDirMaker only, which runs before the FilePathMaker needing it.
+var fallbackNames sync.Map
+
+// newFileStorage returns anacrolix's file storage in dir, except that a
+// torrent without a name is stored under its info-hash: anacrolix would
+// write a single-file one to a file with an empty name, and spill the
+// files of a multi-file one into dir itself.
+func newFileStorage(dir string, pc storage.PieceCompletion) storage.ClientImplCloser {
+	return storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir:   dir,
+		PieceCompletion: pc,
+		TorrentDirMaker: func(baseDir string, info *metainfo.Info, ih metainfo.Hash) string {
+			if blankName(info) {
+				fallbackNames.Store(info, dataName(info, ih))
+			}
+			return baseDir
+		},
+		FilePathMaker: func(o storage.FilePathMakerOpts) string {
+			name := o.Info.BestName()
+			if v, ok := fallbackNames.Load(o.Info); ok {
+				name = v.(string)
+			}
+			var parts []string
+			if name != metainfo.NoName {
+				parts = append(parts, name)
+			}
+			return filepath.Join(append(parts, o.File.BestPath()...)...)
+		},
+	})
+}
+
+// dirPieceCompletion returns the piece completion db kept in dir, creating
+// dir, or one in memory if the db can't be opened, as anacrolix's default
+// file storage.
+func dirPieceCompletion(dir string) (storage.PieceCompletion, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pc, err := storage.NewDefaultPieceCompletionForDir(dir)
+	if err != nil {
+		log.Printf("piece completion for %s kept in memory: %v", dir, err)
+		return storage.NewMapPieceCompletion(), nil
+	}
+	return pc, nil
+}
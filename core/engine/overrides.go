@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// ClientOverrides tunes settings of the anacrolix client that intunja has
+// no option of its own for. Zero values keep anacrolix's defaults.
+type ClientOverrides struct {
+	EstablishedConnsPerTorrent int // peers connected per torrent
+	HalfOpenConnsPerTorrent    int // connection attempts in flight per torrent
+	TotalHalfOpenConns         int // connection attempts in flight overall
+	TorrentPeersHighWater      int // peer addresses kept in reserve per torrent
+	TorrentPeersLowWater       int // reserve under which more peers are sought
+	NominalDialTimeout         time.Duration
+	MinDialTimeout             time.Duration
+	HandshakesTimeout          time.Duration
+	// HeaderObfuscation is "prefer" (anacrolix's default), "require" to
+	// only talk to peers that obfuscate, or "off" to never obfuscate.
+	HeaderObfuscation string
+	DisableUTP        bool
+	DisableTCP        bool
+	DisableIPv6       bool
+}
+
+// Validate rejects overrides that anacrolix would misbehave with.
+func (o ClientOverrides) Validate() error {
+	for name, n := range map[string]int64{
+		"EstablishedConnsPerTorrent": int64(o.EstablishedConnsPerTorrent),
+		"HalfOpenConnsPerTorrent":    int64(o.HalfOpenConnsPerTorrent),
+		"TotalHalfOpenConns":         int64(o.TotalHalfOpenConns),
+		"TorrentPeersHighWater":      int64(o.TorrentPeersHighWater),
+		"TorrentPeersLowWater":       int64(o.TorrentPeersLowWater),
+		"NominalDialTimeout":         int64(o.NominalDialTimeout),
+		"MinDialTimeout":             int64(o.MinDialTimeout),
+		"HandshakesTimeout":          int64(o.HandshakesTimeout),
+	} {
+		if n < 0 {
+			return fmt.Errorf("Invalid client override %s: %d is negative", name, n)
+		}
+	}
+	switch {
+	case o.TorrentPeersLowWater > 0 && o.TorrentPeersHighWater > 0 && o.TorrentPeersLowWater > o.TorrentPeersHighWater:
+		return errors.New("Invalid client overrides: TorrentPeersLowWater is above TorrentPeersHighWater")
+	case o.HalfOpenConnsPerTorrent > 0 && o.TotalHalfOpenConns > 0 && o.HalfOpenConnsPerTorrent > o.TotalHalfOpenConns:
+		return errors.New("Invalid client overrides: HalfOpenConnsPerTorrent is above TotalHalfOpenConns")
+	case o.MinDialTimeout > 0 && o.NominalDialTimeout > 0 && o.MinDialTimeout > o.NominalDialTimeout:
+		return errors.New("Invalid client overrides: MinDialTimeout is above NominalDialTimeout")
+	case o.DisableUTP && o.DisableTCP:
+		return errors.New("Invalid client overrides: DisableUTP and DisableTCP leave no way to reach peers")
+	}
+	switch o.HeaderObfuscation {
+	case "", "prefer", "require", "off":
+	default:
+		return fmt.Errorf("Invalid client override HeaderObfuscation %q (prefer, require or off)", o.HeaderObfuscation)
+	}
+	return nil
+}
+
+// apply sets the overridden settings on config.
+func (o ClientOverrides) apply(config *torrent.ClientConfig) {
+	setIfSet(&config.EstablishedConnsPerTorrent, o.EstablishedConnsPerTorrent)
+	setIfSet(&config.HalfOpenConnsPerTorrent, o.HalfOpenConnsPerTorrent)
+	setIfSet(&config.TotalHalfOpenConns, o.TotalHalfOpenConns)
+	setIfSet(&config.TorrentPeersHighWater, o.TorrentPeersHighWater)
+	setIfSet(&config.TorrentPeersLowWater, o.TorrentPeersLowWater)
+	setIfSet(&config.NominalDialTimeout, o.NominalDialTimeout)
+	setIfSet(&config.MinDialTimeout, o.MinDialTimeout)
+	setIfSet(&config.HandshakesTimeout, o.HandshakesTimeout)
+	switch o.HeaderObfuscation {
+	case "prefer":
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true}
+	case "require":
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+	case "off":
+		config.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: false, RequirePreferred: true}
+	}
+	config.DisableUTP = config.DisableUTP || o.DisableUTP
+	config.DisableTCP = config.DisableTCP || o.DisableTCP
+	config.DisableIPv6 = config.DisableIPv6 || o.DisableIPv6
+}
+
+func setIfSet[T comparable](dst *T, v T) {
+	var zero T
+	if v != zero {
+		*dst = v
+	}
+}
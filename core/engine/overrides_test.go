@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+func TestClientOverrides(t *testing.T) {
+	defaults := torrent.NewDefaultClientConfig()
+	c := Config{ClientConfigOverrides: ClientOverrides{
+		EstablishedConnsPerTorrent: 80,
+		HandshakesTimeout:          10 * time.Second,
+		HeaderObfuscation:          "require",
+		DisableUTP:                 true,
+	}}
+	config := clientConfig(c)
+	if config.EstablishedConnsPerTorrent != 80 || config.HandshakesTimeout != 10*time.Second {
+		t.Fatalf("overrides not applied: %d conns, %v handshake timeout", config.EstablishedConnsPerTorrent, config.HandshakesTimeout)
+	}
+	if !config.HeaderObfuscationPolicy.Preferred || !config.HeaderObfuscationPolicy.RequirePreferred || !config.DisableUTP {
+		t.Fatalf("overrides not applied: %+v, DisableUTP %v", config.HeaderObfuscationPolicy, config.DisableUTP)
+	}
+	if config.HalfOpenConnsPerTorrent != defaults.HalfOpenConnsPerTorrent || config.TorrentPeersHighWater != defaults.TorrentPeersHighWater {
+		t.Fatal("expected settings left out to keep anacrolix's defaults")
+	}
+	if !privateClientConfig(c).DisableUTP {
+		t.Fatal("expected the private client overridden too")
+	}
+	if !needsRestart(Config{}, c) {
+		t.Fatal("expected overrides to need a new client")
+	}
+
+	for _, o := range []ClientOverrides{
+		{EstablishedConnsPerTorrent: -1},
+		{MinDialTimeout: -time.Second},
+		{TorrentPeersLowWater: 100, TorrentPeersHighWater: 10},
+		{HalfOpenConnsPerTorrent: 50, TotalHalfOpenConns: 20},
+		{DisableUTP: true, DisableTCP: true},
+		{HeaderObfuscation: "sometimes"},
+	} {
+		if err := o.Validate(); err == nil {
+			t.Errorf("expected %+v refused", o)
+		}
+	}
+
+	e := newTestEngine(t)
+	bad := e.config
+	bad.IncomingPort = 50007
+	bad.ClientConfigOverrides.HeaderObfuscation = "sometimes"
+	if err := e.Configure(bad); err == nil || !strings.Contains(err.Error(), "HeaderObfuscation") {
+		t.Fatalf("expected Configure to refuse the override, got %v", err)
+	}
+}
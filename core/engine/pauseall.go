@@ -0,0 +1,60 @@
+package engine
+
+import "log"
+
+// desiredState is the state t is restored in after a restart: "started",
+// "paused" (stopped by PauseAll) or "stopped". Torrents paused for disk
+// space are started, and paused again if space is still short.
+func (t *Torrent) desiredState() string {
+	switch {
+	case t.Started, t.PausedDiskFull:
+		return "started"
+	case t.PausedAll:
+		return "paused"
+	default:
+		return "stopped"
+	}
+}
+
+// PauseAll stops every running torrent, marking them so ResumeAll restarts
+// them but not the torrents that were already stopped. Their new state is
+// persisted in one transaction, so a crash can't leave only some of them
+// recorded as paused.
+func (e *Engine) PauseAll() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	var paused []string
+	for _, t := range e.ts {
+		if !t.Started {
+			continue
+		}
+		e.stopTorrent(t)
+		t.PausedAll = true
+		paused = append(paused, t.InfoHash)
+	}
+	if e.persister != nil && len(paused) > 0 {
+		e.enqueuePersist(persistOp{Op: "desired", InfoHashes: paused, DesiredState: "paused"})
+	}
+	return nil
+}
+
+// ResumeAll restarts the torrents stopped by PauseAll.
+func (e *Engine) ResumeAll() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	var resumed []string
+	for _, t := range e.ts {
+		if !t.PausedAll || t.Started {
+			continue
+		}
+		if err := e.startTorrent(t); err != nil {
+			log.Printf("resume all: %v", err)
+			continue
+		}
+		resumed = append(resumed, t.InfoHash)
+	}
+	if e.persister != nil && len(resumed) > 0 {
+		e.enqueuePersist(persistOp{Op: "desired", InfoHashes: resumed, DesiredState: "started"})
+	}
+	return nil
+}
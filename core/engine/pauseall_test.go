@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPauseAll(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	e.AttachPersister(p)
+	var ts []*Torrent
+	for i := range 4 {
+		mi, _ := newTestMetaInfo(t, fmt.Sprintf("pause%d", i), map[string][]byte{"a.txt": []byte("data")}, 16384)
+		// the last one is re-added to the private client on resuming
+		if i == 3 {
+			makePrivate(t, mi)
+		}
+		tor := addTestTorrent(t, e, mi)
+		if err := e.StartTorrent(tor.InfoHash); err != nil {
+			t.Fatal(err)
+		}
+		ts = append(ts, tor)
+	}
+	// paused by hand before pausing everything
+	if err := e.StopTorrent(ts[0].InfoHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.PauseAll(); err != nil {
+		t.Fatal(err)
+	}
+	for i, tor := range ts {
+		if tor.Started || tor.PausedAll != (i > 0) {
+			t.Fatalf("torrent %d: started=%v pausedAll=%v after pausing all", i, tor.Started, tor.PausedAll)
+		}
+	}
+	states := persistedStates(t, e, p)
+	if states[ts[0].InfoHash] != "stopped" || states[ts[1].InfoHash] != "paused" || states[ts[3].InfoHash] != "paused" {
+		t.Fatalf("unexpected persisted states after pausing all: %v", states)
+	}
+
+	e.AttachPersister(p)
+	if !finishes(t, 5*time.Second, func() { err = e.ResumeAll() }) {
+		t.Fatal("resuming all deadlocked")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts[0].Started {
+		t.Fatal("resuming all restarted a torrent paused by hand")
+	}
+	for _, tor := range ts[1:] {
+		if !tor.Started || tor.PausedAll {
+			t.Fatalf("%s not resumed", tor.Name)
+		}
+	}
+	states = persistedStates(t, e, p)
+	if states[ts[0].InfoHash] != "stopped" || states[ts[1].InfoHash] != "started" || states[ts[3].InfoHash] != "started" {
+		t.Fatalf("unexpected persisted states after resuming all: %v", states)
+	}
+}
+
+// persistedStates flushes the persist queue by detaching p and returns the
+// desired states stored in it.
+func persistedStates(t *testing.T, e *Engine, p *Persister) map[string]string {
+	t.Helper()
+	e.DetachPersister()
+	rows, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[string]string{}
+	for _, r := range rows {
+		states[r["infohash"]] = r["desired_state"]
+	}
+	return states
+}
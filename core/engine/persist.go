@@ -2,7 +2,13 @@ package engine
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -15,19 +21,77 @@ type Persister struct {
 
 // NewPersister opens (or creates) the SQLite database at path.
 // Use ":memory:" for an in-memory DB for tests.
+//
+// A database file that fails its integrity check is moved aside and replaced
+// with an empty one, so a corrupt file loses torrent state but does not stop
+// the application from starting.
 func NewPersister(dsn string) (*Persister, error) {
+	p, err := openPersister(dsn)
+	if err == nil || dsn == ":memory:" || !isCorrupt(err) {
+		return p, err
+	}
+	backup := fmt.Sprintf("%s.corrupt-%s", dsn, time.Now().UTC().Format("20060102T150405"))
+	if rerr := os.Rename(dsn, backup); rerr != nil {
+		return nil, fmt.Errorf("%w (backup failed: %v)", err, rerr)
+	}
+	log.Printf("persist: database %s is corrupt (%v), moved to %s and starting fresh", dsn, err, backup)
+	return openPersister(dsn)
+}
+
+func openPersister(dsn string) (*Persister, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
 	p := &Persister{db: db}
-	if err := p.initSchema(); err != nil {
+	if err := p.checkIntegrity(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := p.migrate(); err != nil {
 		db.Close()
 		return nil, err
 	}
 	return p, nil
 }
 
+// errCorrupt wraps integrity check failures.
+var errCorrupt = errors.New("database integrity check failed")
+
+func (p *Persister) checkIntegrity() error {
+	var result string
+	if err := p.db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		first, _, _ := strings.Cut(result, "\n")
+		return fmt.Errorf("%w: %s", errCorrupt, first)
+	}
+	return nil
+}
+
+// isCorrupt reports whether err indicates a damaged database file rather
+// than, say, a locked or unreadable one.
+func isCorrupt(err error) bool {
+	if errors.Is(err, errCorrupt) {
+		return true
+	}
+	var serr interface{ Code() int }
+	if errors.As(err, &serr) {
+		switch serr.Code() & 0xff {
+		case sqliteCorrupt, sqliteNotADB:
+			return true
+		}
+	}
+	return false
+}
+
+// SQLite primary result codes for damaged files.
+const (
+	sqliteCorrupt = 11
+	sqliteNotADB  = 26
+)
+
 func (p *Persister) Close() error {
 	if p.db == nil {
 		return nil
@@ -35,13 +99,18 @@ func (p *Persister) Close() error {
 	return p.db.Close()
 }
 
-func (p *Persister) initSchema() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS meta (
-  key TEXT PRIMARY KEY,
-  value TEXT
-);
-CREATE TABLE IF NOT EXISTS torrents (
+// schemaVersionKey is the meta key holding the number of migrations applied
+// to the database.
+const schemaVersionKey = "schema_version"
+
+// migrations upgrade the schema one version at a time: migrations[i] takes a
+// database from version i to i+1. Released steps must not be edited; schema
+// changes are made by appending a new step.
+var migrations = []func(tx *sql.Tx) error{
+	// 1: adopt the schema as it was before versioning. Unversioned
+	// databases may predate any of the later columns.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS torrents (
   infohash TEXT PRIMARY KEY,
   name TEXT,
   magnet TEXT,
@@ -49,20 +118,120 @@ CREATE TABLE IF NOT EXISTS torrents (
   desired_state TEXT,
   added_at DATETIME,
   updated_at DATETIME
-);
-`
-	_, err := p.db.Exec(schema)
+)`)
+		if err != nil {
+			return err
+		}
+		for _, c := range [][2]string{
+			{"completed_at", "DATETIME"},
+			{"on_complete", "TEXT"},
+			{"downloaded", "INTEGER NOT NULL DEFAULT 0"},
+			{"uploaded", "INTEGER NOT NULL DEFAULT 0"},
+		} {
+			if err := addColumnIfMissing(tx, "torrents", c[0], c[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: per-torrent download directories
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "torrents", "download_dir", "TEXT")
+	},
+	// 3: labels, a JSON array of strings
+	func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "torrents", "labels", "TEXT")
+	},
+}
+
+// migrate brings the schema up to the latest version. Each step runs in its
+// own transaction together with the version bump, so a failed step leaves the
+// database at the previous version.
+func (p *Persister) migrate() error {
+	// the version lives in meta, so that table exists in every version
+	if _, err := p.db.Exec(`CREATE TABLE IF NOT EXISTS meta (
+  key TEXT PRIMARY KEY,
+  value TEXT
+)`); err != nil {
+		return err
+	}
+	version, err := p.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := p.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate schema to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO meta(key,value) VALUES(?,?)
+ON CONFLICT(key) DO UPDATE SET value=excluded.value`, schemaVersionKey, strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate schema to version %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate schema to version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version of the database, 0 for databases
+// created before versioning.
+func (p *Persister) SchemaVersion() (int, error) {
+	v, err := p.GetMeta(schemaVersionKey)
+	if err != nil || v == "" {
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", v)
+	}
+	return n, nil
+}
+
+// addColumnIfMissing adds a column to databases created before it existed.
+func addColumnIfMissing(tx *sql.Tx, table, column, decl string) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || found {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
 	return err
 }
 
+// UpsertTorrent inserts or updates a torrent row. Empty magnet and torrentPath
+// values leave any previously stored source untouched.
 func (p *Persister) UpsertTorrent(infohash, name, magnet, torrentPath, desiredState string) error {
 	now := time.Now().UTC()
 	_, err := p.db.Exec(`INSERT INTO torrents(infohash,name,magnet,torrent_path,desired_state,added_at,updated_at)
 VALUES(?,?,?,?,?,?,?)
 ON CONFLICT(infohash) DO UPDATE SET
   name=excluded.name,
-  magnet=excluded.magnet,
-  torrent_path=excluded.torrent_path,
+  magnet=COALESCE(NULLIF(excluded.magnet,''),torrents.magnet),
+  torrent_path=COALESCE(NULLIF(excluded.torrent_path,''),torrents.torrent_path),
   desired_state=excluded.desired_state,
   updated_at=excluded.updated_at`, infohash, name, magnet, torrentPath, desiredState, now, now)
 	if err != nil {
@@ -71,16 +240,95 @@ ON CONFLICT(infohash) DO UPDATE SET
 	return nil
 }
 
+// SetDesiredStates sets the desired state of several torrents at once, in a
+// single transaction.
+func (p *Persister) SetDesiredStates(infohashes []string, desiredState string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("set desired states: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, ih := range infohashes {
+		if _, err := tx.Exec(`UPDATE torrents SET desired_state = ?, updated_at = ? WHERE infohash = ?`, desiredState, now, ih); err != nil {
+			return fmt.Errorf("set desired states: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("set desired states: %w", err)
+	}
+	return nil
+}
+
+// SetCompletedAt records when a torrent finished downloading. A time that
+// is already recorded is kept: a restored torrent looks freshly completed
+// until its persisted fields are applied.
+func (p *Persister) SetCompletedAt(infohash string, completedAt time.Time) error {
+	_, err := p.db.Exec(`UPDATE torrents SET completed_at = ? WHERE infohash = ? AND completed_at IS NULL`, completedAt.UTC(), infohash)
+	if err != nil {
+		return fmt.Errorf("set completed at: %w", err)
+	}
+	return nil
+}
+
+// SetCompletionPolicy stores the serialized per-torrent completion policy.
+func (p *Persister) SetCompletionPolicy(infohash, policy string) error {
+	_, err := p.db.Exec(`UPDATE torrents SET on_complete = ? WHERE infohash = ?`, policy, infohash)
+	if err != nil {
+		return fmt.Errorf("set completion policy: %w", err)
+	}
+	return nil
+}
+
+// SetTransferStats records the bytes downloaded and uploaded for a torrent.
+// The stored totals never decrease, so a late write from before a restart
+// can't reset them.
+func (p *Persister) SetTransferStats(infohash string, downloaded, uploaded int64) error {
+	_, err := p.db.Exec(`UPDATE torrents SET downloaded = MAX(downloaded, ?), uploaded = MAX(uploaded, ?) WHERE infohash = ?`, downloaded, uploaded, infohash)
+	if err != nil {
+		return fmt.Errorf("set transfer stats: %w", err)
+	}
+	return nil
+}
+
+// SetDownloadDir records the directory a torrent's data is stored in when it
+// is not the global download directory.
+func (p *Persister) SetDownloadDir(infohash, dir string) error {
+	_, err := p.db.Exec(`UPDATE torrents SET download_dir = ? WHERE infohash = ?`, dir, infohash)
+	if err != nil {
+		return fmt.Errorf("set download dir: %w", err)
+	}
+	return nil
+}
+
+// SetLabels stores a torrent's labels, JSON-encoded.
+func (p *Persister) SetLabels(infohash string, labels []string) error {
+	if labels == nil {
+		labels = []string{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("set labels: %w", err)
+	}
+	_, err = p.db.Exec(`UPDATE torrents SET labels = ? WHERE infohash = ?`, string(data), infohash)
+	if err != nil {
+		return fmt.Errorf("set labels: %w", err)
+	}
+	return nil
+}
+
 func (p *Persister) GetAllTorrents() ([]map[string]string, error) {
-	rows, err := p.db.Query(`SELECT infohash,name,magnet,torrent_path,desired_state FROM torrents`)
+	rows, err := p.db.Query(`SELECT infohash,name,magnet,torrent_path,desired_state,added_at,completed_at,on_complete,downloaded,uploaded,download_dir,labels FROM torrents`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []map[string]string
 	for rows.Next() {
-		var infohash, name, magnet, torrentPath, desiredState sql.NullString
-		if err := rows.Scan(&infohash, &name, &magnet, &torrentPath, &desiredState); err != nil {
+		var infohash, name, magnet, torrentPath, desiredState, onComplete, downloadDir, labels sql.NullString
+		var addedAt, completedAt sql.NullTime
+		var downloaded, uploaded int64
+		if err := rows.Scan(&infohash, &name, &magnet, &torrentPath, &desiredState, &addedAt, &completedAt, &onComplete, &downloaded, &uploaded, &downloadDir, &labels); err != nil {
 			return nil, err
 		}
 		m := map[string]string{}
@@ -99,6 +347,23 @@ func (p *Persister) GetAllTorrents() ([]map[string]string, error) {
 		if desiredState.Valid {
 			m["desired_state"] = desiredState.String
 		}
+		if addedAt.Valid {
+			m["added_at"] = addedAt.Time.Format(time.RFC3339Nano)
+		}
+		if completedAt.Valid {
+			m["completed_at"] = completedAt.Time.Format(time.RFC3339Nano)
+		}
+		if onComplete.Valid {
+			m["on_complete"] = onComplete.String
+		}
+		if downloadDir.Valid {
+			m["download_dir"] = downloadDir.String
+		}
+		if labels.Valid {
+			m["labels"] = labels.String
+		}
+		m["downloaded"] = strconv.FormatInt(downloaded, 10)
+		m["uploaded"] = strconv.FormatInt(uploaded, 10)
 		out = append(out, m)
 	}
 	return out, nil
@@ -111,3 +376,71 @@ func (p *Persister) DeleteTorrent(infohash string) error {
 	}
 	return nil
 }
+
+// GetMeta returns the value stored under key in the meta table, or "" if
+// the key is not set.
+func (p *Persister) GetMeta(key string) (string, error) {
+	var value sql.NullString
+	err := p.db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get meta: %w", err)
+	}
+	return value.String, nil
+}
+
+// SetMeta stores value under key in the meta table.
+func (p *Persister) SetMeta(key, value string) error {
+	_, err := p.db.Exec(`INSERT INTO meta(key,value) VALUES(?,?)
+ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, value)
+	if err != nil {
+		return fmt.Errorf("set meta: %w", err)
+	}
+	return nil
+}
+
+// DeleteMeta removes key from the meta table.
+func (p *Persister) DeleteMeta(key string) error {
+	_, err := p.db.Exec(`DELETE FROM meta WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("delete meta: %w", err)
+	}
+	return nil
+}
+
+// PersisterStats describes the database for diagnostics.
+type PersisterStats struct {
+	Torrents int   // rows in the torrents table
+	Meta     int   // rows in the meta table
+	Size     int64 // database size in bytes
+}
+
+// Stats returns row counts and the database size.
+func (p *Persister) Stats() (PersisterStats, error) {
+	var s PersisterStats
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM torrents`).Scan(&s.Torrents); err != nil {
+		return s, fmt.Errorf("stats: %w", err)
+	}
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM meta`).Scan(&s.Meta); err != nil {
+		return s, fmt.Errorf("stats: %w", err)
+	}
+	var pageCount, pageSize int64
+	if err := p.db.QueryRow(`PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return s, fmt.Errorf("stats: %w", err)
+	}
+	if err := p.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return s, fmt.Errorf("stats: %w", err)
+	}
+	s.Size = pageCount * pageSize
+	return s, nil
+}
+
+// Vacuum rebuilds the database file, reclaiming space left by deleted rows.
+func (p *Persister) Vacuum() error {
+	if _, err := p.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
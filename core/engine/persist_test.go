@@ -1,7 +1,17 @@
 package engine
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPersisterUpsertAndGet(t *testing.T) {
@@ -26,3 +36,347 @@ func TestPersisterUpsertAndGet(t *testing.T) {
 		t.Fatalf("unexpected infohash: %s", list[0]["infohash"])
 	}
 }
+
+func TestPersisterUpsertKeepsSource(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.UpsertTorrent("ih1", "name1", "", "https://example.com/a.torrent", "started"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	if err := p.UpsertTorrent("ih1", "name1", "", "", "stopped"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(list) != 1 || list[0]["torrent_path"] != "https://example.com/a.torrent" {
+		t.Fatalf("source not preserved: %v", list)
+	}
+	if list[0]["desired_state"] != "stopped" {
+		t.Fatalf("desired state not updated: %v", list[0]["desired_state"])
+	}
+}
+
+func TestPersisterCompletedAt(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.UpsertTorrent("ih1", "name1", "magnet:?xt=urn:btih:abc", "", "started"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	done := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := p.SetCompletedAt("ih1", done); err != nil {
+		t.Fatalf("set completed at failed: %v", err)
+	}
+	// the first completion time sticks
+	if err := p.SetCompletedAt("ih1", done.Add(time.Hour)); err != nil {
+		t.Fatalf("set completed at failed: %v", err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if list[0]["added_at"] == "" {
+		t.Fatalf("expected added_at to be set")
+	}
+	got, err := time.Parse(time.RFC3339Nano, list[0]["completed_at"])
+	if err != nil || !got.Equal(done) {
+		t.Fatalf("unexpected completed_at %q (%v)", list[0]["completed_at"], err)
+	}
+}
+
+func TestPersisterUpgradesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE torrents (infohash TEXT PRIMARY KEY, name TEXT, magnet TEXT, torrent_path TEXT, desired_state TEXT, added_at DATETIME, updated_at DATETIME)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO torrents(infohash,name) VALUES('old','old')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	p, err := NewPersister(path)
+	if err != nil {
+		t.Fatalf("failed to open old database: %v", err)
+	}
+	if v, err := p.SchemaVersion(); err != nil || v != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d (%v)", len(migrations), v, err)
+	}
+	if err := p.UpsertTorrent("ih1", "name1", "", "", "started"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	if err := p.SetCompletedAt("ih1", time.Now()); err != nil {
+		t.Fatalf("set completed at failed: %v", err)
+	}
+	if err := p.SetTransferStats("ih1", 10, 20); err != nil {
+		t.Fatalf("set transfer stats failed: %v", err)
+	}
+	p.Close()
+
+	// upgrading again is a no-op
+	p, err = NewPersister(path)
+	if err != nil {
+		t.Fatalf("failed to reopen upgraded database: %v", err)
+	}
+	defer p.Close()
+	if v, err := p.SchemaVersion(); err != nil || v != len(migrations) {
+		t.Fatalf("expected schema version %d after reopen, got %d (%v)", len(migrations), v, err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 torrents after upgrade, got %d", len(list))
+	}
+	for _, row := range list {
+		want := map[string]string{"old": "0", "ih1": "20"}[row["infohash"]]
+		if row["uploaded"] != want {
+			t.Fatalf("%s: expected uploaded %s, got %q", row["infohash"], want, row["uploaded"])
+		}
+	}
+}
+
+func TestPersisterRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.db")
+	p, err := NewPersister(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetMeta(schemaVersionKey, strconv.Itoa(len(migrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	if _, err := NewPersister(path); err == nil {
+		t.Fatal("expected error opening a database from a newer version")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database from a newer version was moved aside: %v", err)
+	}
+}
+
+func TestPersisterTransferStats(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.UpsertTorrent("ih1", "name1", "", "", "started"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	if err := p.SetTransferStats("ih1", 1000, 500); err != nil {
+		t.Fatalf("set transfer stats failed: %v", err)
+	}
+	// a stale write, e.g. from before the totals were restored, is ignored
+	if err := p.SetTransferStats("ih1", 0, 0); err != nil {
+		t.Fatalf("set transfer stats failed: %v", err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if list[0]["downloaded"] != "1000" || list[0]["uploaded"] != "500" {
+		t.Fatalf("unexpected transfer stats: %v", list[0])
+	}
+}
+
+func TestPersisterSetDesiredStates(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	for _, ih := range []string{"ih1", "ih2", "ih3"} {
+		if err := p.UpsertTorrent(ih, ih, "", "", "started"); err != nil {
+			t.Fatalf("upsert failed: %v", err)
+		}
+	}
+	if err := p.SetDesiredStates([]string{"ih1", "ih3"}, "paused"); err != nil {
+		t.Fatalf("set desired states failed: %v", err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	for _, row := range list {
+		want := map[string]string{"ih1": "paused", "ih2": "started", "ih3": "paused"}[row["infohash"]]
+		if row["desired_state"] != want {
+			t.Fatalf("%s: expected %s, got %q", row["infohash"], want, row["desired_state"])
+		}
+	}
+}
+
+func TestPersisterLabels(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.UpsertTorrent("ih1", "name1", "", "", "started"); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	list, err := p.GetAllTorrents()
+	if err != nil {
+		t.Fatalf("get all torrents failed: %v", err)
+	}
+	if _, ok := list[0]["labels"]; ok {
+		t.Fatalf("expected no labels before any were set, got %q", list[0]["labels"])
+	}
+	for _, labels := range [][]string{{"linux", "tv \"shows\", mostly"}, {}} {
+		if err := p.SetLabels("ih1", labels); err != nil {
+			t.Fatalf("set labels failed: %v", err)
+		}
+		list, err := p.GetAllTorrents()
+		if err != nil {
+			t.Fatalf("get all torrents failed: %v", err)
+		}
+		var got []string
+		if err := json.Unmarshal([]byte(list[0]["labels"]), &got); err != nil {
+			t.Fatalf("labels not stored as JSON: %q", list[0]["labels"])
+		}
+		if !slices.Equal(got, labels) {
+			t.Fatalf("expected labels %q, got %q", labels, got)
+		}
+	}
+}
+
+func TestPersisterMeta(t *testing.T) {
+	p, err := NewPersister(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	if v, err := p.GetMeta("missing"); err != nil || v != "" {
+		t.Fatalf("expected empty value for missing key, got %q (%v)", v, err)
+	}
+	if err := p.SetMeta("k", "v1"); err != nil {
+		t.Fatalf("set meta failed: %v", err)
+	}
+	if err := p.SetMeta("k", "v2"); err != nil {
+		t.Fatalf("set meta failed: %v", err)
+	}
+	if v, _ := p.GetMeta("k"); v != "v2" {
+		t.Fatalf("expected v2, got %q", v)
+	}
+	if err := p.DeleteMeta("k"); err != nil {
+		t.Fatalf("delete meta failed: %v", err)
+	}
+	if v, _ := p.GetMeta("k"); v != "" {
+		t.Fatalf("expected key to be deleted, got %q", v)
+	}
+}
+
+func TestPersisterVacuumAndStats(t *testing.T) {
+	p, err := NewPersister(filepath.Join(t.TempDir(), "vacuum.db"))
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 50; i++ {
+		ih := fmt.Sprintf("ih%d", i)
+		if err := p.UpsertTorrent(ih, strings.Repeat("n", 1000), "", "", "started"); err != nil {
+			t.Fatalf("upsert failed: %v", err)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		if err := p.DeleteTorrent(fmt.Sprintf("ih%d", i)); err != nil {
+			t.Fatalf("delete failed: %v", err)
+		}
+	}
+	before, err := p.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if err := p.Vacuum(); err != nil {
+		t.Fatalf("vacuum failed: %v", err)
+	}
+	after, err := p.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if after.Torrents != 10 {
+		t.Fatalf("expected 10 torrents, got %d", after.Torrents)
+	}
+	if after.Size <= 0 || after.Size > before.Size {
+		t.Fatalf("expected vacuum not to grow the database: %d -> %d", before.Size, after.Size)
+	}
+}
+
+func TestPersisterRecoversFromCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("garbage!"), 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPersister(path)
+	if err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	defer p.Close()
+	if err := p.UpsertTorrent("ih1", "name1", "", "", "started"); err != nil {
+		t.Fatalf("upsert after recovery failed: %v", err)
+	}
+	stats, err := p.Stats()
+	if err != nil || stats.Torrents != 1 {
+		t.Fatalf("expected a working database with 1 torrent, got %+v (%v)", stats, err)
+	}
+	backups, _ := filepath.Glob(path + ".corrupt-*")
+	if len(backups) != 1 {
+		t.Fatalf("expected corrupt file to be backed up, found %v", backups)
+	}
+}
+
+func TestPersisterRecoversFromDamagedPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "damaged.db")
+	p, err := NewPersister(path)
+	if err != nil {
+		t.Fatalf("failed to open persister: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := p.UpsertTorrent(fmt.Sprintf("ih%d", i), strings.Repeat("n", 200), "", "", "started"); err != nil {
+			t.Fatalf("upsert failed: %v", err)
+		}
+	}
+	p.Close()
+
+	// scribble over everything after the first page, keeping the header valid
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 4096; i < len(b); i++ {
+		b[i] = 0xff
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = NewPersister(path)
+	if err != nil {
+		t.Fatalf("expected recovery, got %v", err)
+	}
+	defer p.Close()
+	list, err := p.GetAllTorrents()
+	if err != nil || len(list) != 0 {
+		t.Fatalf("expected an empty working database, got %d rows (%v)", len(list), err)
+	}
+}
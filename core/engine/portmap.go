@@ -0,0 +1,356 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	alog "github.com/anacrolix/log"
+	"github.com/anacrolix/upnp"
+)
+
+const (
+	// portLease is the lifetime asked for a port mapping, which is
+	// renewed halfway through. Mapping again is also how a gateway that
+	// went away or rebooted is retried.
+	portLease = time.Hour
+	// upnpDiscoverTimeout bounds the wait for UPnP gateways to answer.
+	upnpDiscoverTimeout = 2 * time.Second
+	// portUnmapTimeout bounds the wait for mappings to be removed when
+	// forwarding stops.
+	portUnmapTimeout = 5 * time.Second
+	// natpmpPort is the port NAT-PMP gateways listen on (RFC 6886).
+	natpmpPort = 5351
+)
+
+// portDescription names the mappings in the gateway's table.
+const portDescription = "intunja"
+
+// gateway is a router able to forward a port to us, over UPnP IGD or
+// NAT-PMP.
+type gateway interface {
+	externalIP() (net.IP, error)
+	// mapPort forwards an external port to port, returning the external
+	// one, which the gateway may pick differently.
+	mapPort(proto upnp.Protocol, port int, lease time.Duration) (int, error)
+	unmapPort(proto upnp.Protocol, port, external int) error
+	String() string
+}
+
+// discoverGateways returns the UPnP gateways that answer on the local
+// network, followed by the default route's gateway for NAT-PMP, which may
+// or may not speak it.
+func discoverGateways() []gateway {
+	var gws []gateway
+	for _, d := range upnp.Discover(0, upnpDiscoverTimeout, alog.Default) {
+		gws = append(gws, upnpGateway{d})
+	}
+	if ip, err := defaultGateway(); err == nil {
+		gws = append(gws, natpmpGateway{net.JoinHostPort(ip.String(), fmt.Sprint(natpmpPort))})
+	}
+	return gws
+}
+
+// defaultGateway returns the gateway of the default IPv4 route, read from
+// /proc/net/route, so only on Linux.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Iface Destination Gateway ..., in little-endian hex
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, errors.New("no default route")
+}
+
+// upnpGateway is a gateway speaking UPnP IGD.
+type upnpGateway struct {
+	d upnp.Device
+}
+
+func (g upnpGateway) externalIP() (net.IP, error) {
+	return g.d.GetExternalIPAddress()
+}
+
+func (g upnpGateway) mapPort(proto upnp.Protocol, port int, lease time.Duration) (int, error) {
+	return g.d.AddPortMapping(proto, port, port, portDescription, lease)
+}
+
+func (g upnpGateway) unmapPort(proto upnp.Protocol, port, external int) error {
+	return g.d.DeletePortMapping(proto, external)
+}
+
+func (g upnpGateway) String() string {
+	return "UPnP gateway " + g.d.ID()
+}
+
+// natpmpGateway is a gateway speaking NAT-PMP at addr.
+type natpmpGateway struct {
+	addr string
+}
+
+// call sends req, retrying with a doubling timeout as RFC 6886 asks,
+// though giving up after a few seconds rather than a minute, and returns
+// the response, which has at least size bytes.
+func (g natpmpGateway) call(req []byte, size int) ([]byte, error) {
+	conn, err := net.Dial("udp", g.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buf := make([]byte, 16)
+	for timeout := 250 * time.Millisecond; timeout <= 2*time.Second; timeout *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < size || buf[0] != 0 || buf[1] != req[1]|128 {
+			return nil, errors.New("malformed NAT-PMP response")
+		}
+		if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP result code %d", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, errors.New("no NAT-PMP response")
+}
+
+func (g natpmpGateway) externalIP() (net.IP, error) {
+	resp, err := g.call([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+func (g natpmpGateway) mapPort(proto upnp.Protocol, port int, lease time.Duration) (int, error) {
+	return g.request(proto, port, port, lease)
+}
+
+func (g natpmpGateway) unmapPort(proto upnp.Protocol, port, external int) error {
+	// a lifetime and suggested port of 0 delete the mapping
+	_, err := g.request(proto, port, 0, 0)
+	return err
+}
+
+func (g natpmpGateway) request(proto upnp.Protocol, port, external int, lease time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 2
+	if proto == upnp.UDP {
+		req[1] = 1
+	}
+	binary.BigEndian.PutUint16(req[4:], uint16(port))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lease/time.Second))
+	resp, err := g.call(req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:])), nil
+}
+
+func (g natpmpGateway) String() string {
+	return "NAT-PMP gateway " + g.addr
+}
+
+// portForward keeps a port forwarded by a gateway, mapping it for TCP and
+// UDP and renewing the mappings until stopped.
+type portForward struct {
+	port int
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	external netip.AddrPort // once mapped
+}
+
+func newPortForward(port int, discover func() []gateway) *portForward {
+	f := &portForward{port: port, stop: make(chan struct{}), done: make(chan struct{})}
+	go f.run(discover)
+	return f
+}
+
+func (f *portForward) run(discover func() []gateway) {
+	defer close(f.done)
+	var gw gateway
+	mapped := map[upnp.Protocol]int{}
+	for {
+		if gw == nil {
+			gw = f.mapAny(discover(), mapped)
+		} else if err := f.mapOn(gw, mapped); err != nil {
+			log.Printf("port forwarding: %s failed to renew port %d: %v", gw, f.port, err)
+			gw = nil
+		}
+		select {
+		case <-f.stop:
+			if gw != nil {
+				for proto, external := range mapped {
+					if err := gw.unmapPort(proto, f.port, external); err != nil {
+						log.Printf("port forwarding: %s failed to remove %s port %d: %v", gw, proto, external, err)
+					}
+				}
+			}
+			return
+		case <-time.After(portLease / 2):
+		}
+	}
+}
+
+// mapAny maps the port on the first of gws that will, or returns nil
+// after logging why it isn't forwarded.
+func (f *portForward) mapAny(gws []gateway, mapped map[upnp.Protocol]int) gateway {
+	if len(gws) == 0 {
+		log.Printf("port forwarding: no UPnP or NAT-PMP gateway found, port %d not forwarded", f.port)
+	}
+	for _, gw := range gws {
+		clear(mapped)
+		err := f.mapOn(gw, mapped)
+		if err == nil {
+			log.Printf("port forwarding: %s forwards %s to port %d", gw, f.External(), f.port)
+			return gw
+		}
+		log.Printf("port forwarding: %s failed to forward port %d: %v", gw, f.port, err)
+	}
+	return nil
+}
+
+// mapOn maps the port for TCP and UDP on gw. Peers connect over TCP, so
+// only that failing is an error; uTP and the DHT just go without.
+func (f *portForward) mapOn(gw gateway, mapped map[upnp.Protocol]int) error {
+	addr, err := f.mapTCP(gw, mapped)
+	f.mu.Lock()
+	f.external = addr
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if external, err := gw.mapPort(upnp.UDP, f.port, portLease); err == nil {
+		mapped[upnp.UDP] = external
+	} else {
+		log.Printf("port forwarding: %s failed to forward UDP port %d: %v", gw, f.port, err)
+	}
+	return nil
+}
+
+// mapTCP maps the port for TCP on gw and returns the address it is
+// reached at, removing the mapping again if that can't be told.
+func (f *portForward) mapTCP(gw gateway, mapped map[upnp.Protocol]int) (netip.AddrPort, error) {
+	external, err := gw.mapPort(upnp.TCP, f.port, portLease)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ip, err := gw.externalIP()
+	addr, ok := netip.AddrFromSlice(ip.To4())
+	if err == nil && !ok {
+		err = fmt.Errorf("invalid external IP %v", ip)
+	}
+	if err != nil {
+		gw.unmapPort(upnp.TCP, f.port, external)
+		delete(mapped, upnp.TCP)
+		return netip.AddrPort{}, err
+	}
+	mapped[upnp.TCP] = external
+	return netip.AddrPortFrom(addr, uint16(external)), nil
+}
+
+// External returns the address peers reach the port at, the zero
+// AddrPort while it isn't forwarded.
+func (f *portForward) External() netip.AddrPort {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.external
+}
+
+// close stops renewing the mappings and removes them, waiting at most
+// portUnmapTimeout for the gateway.
+func (f *portForward) close() {
+	close(f.stop)
+	select {
+	case <-f.done:
+	case <-time.After(portUnmapTimeout):
+	}
+}
+
+// forwardPort keeps port forwarded while enabled, replacing the mappings
+// of a previous port. Called with e.mut held.
+func (e *Engine) forwardPort(enabled bool, port int) {
+	if f := e.portForward; f != nil {
+		if enabled && f.port == port {
+			return
+		}
+		f.close()
+		e.portForward = nil
+	}
+	if !enabled || port == 0 {
+		return
+	}
+	discover := e.gateways
+	if discover == nil {
+		discover = discoverGateways
+	}
+	e.portForward = newPortForward(port, discover)
+}
+
+// ExternalAddress returns the address the listen port is forwarded to by
+// the gateway, see Config.EnablePortForwarding.
+func (e *Engine) ExternalAddress() (netip.AddrPort, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.portForward == nil {
+		return netip.AddrPort{}, errors.New("port forwarding disabled")
+	}
+	addr := e.portForward.External()
+	if !addr.IsValid() {
+		return addr, errors.New("port not forwarded")
+	}
+	return addr, nil
+}
+
+// Close removes the port mappings and shuts the clients down, for when the
+// program exits.
+func (e *Engine) Close() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.stopMaintenance()
+	e.forwardPort(false, 0)
+	e.watchDirectory("")
+	for _, c := range e.clients() {
+		c.Close()
+	}
+	if e.store != nil {
+		e.store.Close()
+	}
+	if e.privStore != nil {
+		e.privStore.Close()
+	}
+	e.client, e.store, e.private, e.privStore = nil, nil, nil, nil
+}
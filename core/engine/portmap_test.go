@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natpmpStub is a NAT-PMP gateway with the external IP 203.0.113.7,
+// forwarding external port n+1000 to port n and recording the mapping
+// requests it gets.
+type natpmpStub struct {
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newNatpmpStub(t *testing.T) *natpmpStub {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natpmpStub{conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := s.respond(buf[:n]); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *natpmpStub) respond(req []byte) []byte {
+	if len(req) < 2 {
+		return nil
+	}
+	switch req[1] {
+	case 0:
+		return []byte{0, 128, 0, 0, 0, 0, 0, 1, 203, 0, 113, 7}
+	case 1, 2:
+		if len(req) < 12 {
+			return nil
+		}
+		proto := map[byte]string{1: "udp", 2: "tcp"}[req[1]]
+		port := binary.BigEndian.Uint16(req[4:])
+		lease := binary.BigEndian.Uint32(req[8:])
+		external := port + 1000
+		s.mu.Lock()
+		if lease == 0 {
+			external = 0
+			s.requests = append(s.requests, "unmap "+proto)
+		} else {
+			s.requests = append(s.requests, "map "+proto)
+		}
+		s.mu.Unlock()
+		resp := make([]byte, 16)
+		resp[1] = req[1] | 128
+		binary.BigEndian.PutUint16(resp[8:], port)
+		binary.BigEndian.PutUint16(resp[10:], external)
+		binary.BigEndian.PutUint32(resp[12:], lease)
+		return resp
+	}
+	return nil
+}
+
+func (s *natpmpStub) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func TestPortForwarding(t *testing.T) {
+	s := newNatpmpStub(t)
+	e := newTestEngine(t)
+	e.gateways = func() []gateway {
+		return []gateway{natpmpGateway{s.conn.LocalAddr().String()}}
+	}
+	if _, err := e.ExternalAddress(); err == nil {
+		t.Fatal("expected no external address while disabled")
+	}
+	c := e.config
+	c.EnablePortForwarding = true
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	port, err := e.ListenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { _, err := e.ExternalAddress(); return err == nil }) {
+		t.Fatal("expected the port forwarded")
+	}
+	addr, _ := e.ExternalAddress()
+	if addr.Addr().String() != "203.0.113.7" || int(addr.Port()) != port+1000 {
+		t.Fatalf("unexpected external address %v for port %d", addr, port)
+	}
+	if got := s.seen(); !slices.Equal(got, []string{"map tcp", "map udp"}) {
+		t.Fatalf("expected TCP and UDP mapped, got %v", got)
+	}
+
+	c.EnablePortForwarding = false
+	if err := e.Configure(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.seen(); len(got) != 4 || !slices.Contains(got, "unmap tcp") || !slices.Contains(got, "unmap udp") {
+		t.Fatalf("expected both mappings removed, got %v", got)
+	}
+	if _, err := e.ExternalAddress(); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("expected forwarding disabled, got %v", err)
+	}
+}
+
+func TestPortForwardingNoGateway(t *testing.T) {
+	e := newTestEngine(t)
+	e.gateways = func() []gateway { return nil }
+	c := e.config
+	c.EnablePortForwarding = true
+	if err := e.Configure(c); err != nil {
+		t.Fatalf("expected a missing gateway not to fail Configure: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := e.ExternalAddress(); err == nil || !strings.Contains(err.Error(), "not forwarded") {
+		t.Fatalf("expected the port not forwarded, got %v", err)
+	}
+	if _, err := e.ListenPort(); err != nil {
+		t.Fatalf("expected the engine still listening: %v", err)
+	}
+	e.Close()
+	if _, err := e.ListenPort(); err == nil {
+		t.Fatal("expected the engine closed")
+	}
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// FilePriority controls whether and how eagerly a file of a started torrent
+// is downloaded. The zero value downloads the file normally.
+type FilePriority int
+
+const (
+	FilePriorityNormal FilePriority = iota
+	FilePrioritySkip
+	FilePriorityHigh
+)
+
+func (p FilePriority) String() string {
+	switch p {
+	case FilePrioritySkip:
+		return "skip"
+	case FilePriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// piecePriority is the anacrolix priority a file gets while its torrent is
+// downloading.
+func (p FilePriority) piecePriority() types.PiecePriority {
+	switch p {
+	case FilePrioritySkip:
+		return types.PiecePriorityNone
+	case FilePriorityHigh:
+		return types.PiecePriorityHigh
+	default:
+		return types.PiecePriorityNormal
+	}
+}
+
+// SetFilePriority sets the priority of one file of the torrent. A piece is
+// fetched at the highest priority of the files it overlaps, so pieces shared
+// with a wanted file are still downloaded when a file is skipped.
+func (e *Engine) SetFilePriority(infohash, path string, prio FilePriority) error {
+	if prio < FilePriorityNormal || prio > FilePriorityHigh {
+		return fmt.Errorf("Invalid file priority %d", prio)
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, f, err := e.getOpenFile(infohash, path)
+	if err != nil {
+		return err
+	}
+	f.Priority = prio
+	if t.Started {
+		f.Started = prio != FilePrioritySkip
+		if !t.SeedOnly {
+			t.download()
+		}
+	}
+	return nil
+}
+
+// SelectFilesByPattern downloads only the files matching glob and skips the
+// rest. glob is a comma separated list of path.Match patterns; a pattern
+// prefixed with "!" excludes matching files. Patterns without a "/" match the
+// file name in any directory, others match the path inside the torrent, e.g.
+// "*.mkv,!*sample*" or "Season 01/*". High priority files that stay selected
+// keep their priority.
+func (e *Engine) SelectFilesByPattern(infohash, glob string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	if t.t.Info() == nil || len(t.Files) == 0 {
+		return fmt.Errorf("torrent metadata not yet available")
+	}
+	if err := selectFiles(t.Files, t.t.Name(), glob); err != nil {
+		return err
+	}
+	if t.Started {
+		t.startFiles()
+		if !t.SeedOnly {
+			t.download()
+		}
+	}
+	return nil
+}
+
+// selectFiles sets the priorities of files in a torrent called name
+// according to glob, see SelectFilesByPattern.
+func selectFiles(files []*File, name, glob string) error {
+	var include, exclude []string
+	for _, p := range strings.Split(glob, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(strings.TrimPrefix(p, "!"), ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q", p)
+		}
+		if strings.HasPrefix(p, "!") {
+			exclude = append(exclude, p[1:])
+		} else {
+			include = append(include, p)
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return errors.New("No file patterns given")
+	}
+	prefix := name + "/"
+	selected := make([]bool, len(files))
+	n := 0
+	for i, f := range files {
+		if f == nil {
+			continue
+		}
+		name := strings.TrimPrefix(f.Path, prefix)
+		selected[i] = (len(include) == 0 || matchAny(include, name)) && !matchAny(exclude, name)
+		if selected[i] {
+			n++
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("No files match %q", glob)
+	}
+	for i, f := range files {
+		switch {
+		case f == nil:
+		case !selected[i]:
+			f.Priority = FilePrioritySkip
+		case f.Priority == FilePrioritySkip:
+			f.Priority = FilePriorityNormal
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether name matches one of the patterns. A pattern with
+// no "/" is matched against the base name only.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		target := name
+		if !strings.Contains(p, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(p, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// startFiles marks the files of a started torrent that aren't skipped as
+// started.
+func (t *Torrent) startFiles() {
+	for _, f := range t.Files {
+		if f != nil {
+			f.Started = f.Priority != FilePrioritySkip
+		}
+	}
+}
+
+// download requests the torrent's data according to its file priorities.
+func (t *Torrent) download() {
+	if t.t.Info() == nil {
+		return
+	}
+	for i, f := range t.t.Files() {
+		prio := FilePriorityNormal
+		if i < len(t.Files) && t.Files[i] != nil {
+			prio = t.Files[i].Priority
+		}
+		f.SetPriority(prio.piecePriority())
+	}
+}
+
+// pieceFiles maps each piece index to the indexes of the files it overlaps.
+// File.BeginPieceIndex and EndPieceIndex give the inverse.
+func pieceFiles(tt *torrent.Torrent) [][]int {
+	out := make([][]int, tt.NumPieces())
+	for fi, f := range tt.Files() {
+		for i := f.BeginPieceIndex(); i < f.EndPieceIndex(); i++ {
+			out[i] = append(out[i], fi)
+		}
+	}
+	return out
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/types"
+)
+
+func TestPieceFiles(t *testing.T) {
+	// a and b share piece 1, c starts on a piece boundary
+	mi, _ := newTestMetaInfo(t, "map", map[string][]byte{
+		"a.bin": make([]byte, 24<<10),
+		"b.bin": make([]byte, 24<<10),
+		"c.bin": make([]byte, 16<<10),
+	}, 16<<10)
+	tor := addTestTorrent(t, newTestEngine(t), mi)
+
+	got := pieceFiles(tor.t)
+	want := [][]int{{0}, {0, 1}, {1}, {2}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d pieces, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("piece %d overlaps files %v, want %v", i, got[i], want[i])
+		}
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("piece %d overlaps files %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestSetFilePriority(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "prio", map[string][]byte{
+		"a.bin": make([]byte, 24<<10),
+		"b.bin": make([]byte, 24<<10),
+		"c.bin": make([]byte, 16<<10),
+	}, 16<<10)
+	e := newTestEngine(t)
+	tor := addTestTorrent(t, e, mi)
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	e.GetTorrents()
+	prio := func(i int) types.PiecePriority { return tor.t.Piece(i).State().Priority }
+
+	if err := e.SetFilePriority(tor.InfoHash, "prio/b.bin", FilePrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if p := prio(1); p != types.PiecePriorityNormal {
+		t.Errorf("piece shared with a wanted file has priority %v, want normal", p)
+	}
+	if p := prio(2); p != types.PiecePriorityNone {
+		t.Errorf("piece only in the skipped file has priority %v, want none", p)
+	}
+
+	// changes apply to a running torrent
+	if err := e.SetFilePriority(tor.InfoHash, "prio/c.bin", FilePriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetFilePriority(tor.InfoHash, "prio/a.bin", FilePrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []types.PiecePriority{
+		types.PiecePriorityNone,
+		types.PiecePriorityNone,
+		types.PiecePriorityNone,
+		types.PiecePriorityHigh,
+	} {
+		if p := prio(i); p != want {
+			t.Errorf("piece %d has priority %v, want %v", i, p, want)
+		}
+	}
+
+	if err := e.SetFilePriority(tor.InfoHash, "prio/missing.bin", FilePrioritySkip); err == nil {
+		t.Error("expected error for unknown file")
+	}
+	if err := e.SetFilePriority(tor.InfoHash, "prio/a.bin", FilePriority(7)); err == nil {
+		t.Error("expected error for invalid priority")
+	}
+}
+
+func TestSelectFilesByPattern(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "show", map[string][]byte{
+		"Season 01/e01.mkv":        make([]byte, 16<<10),
+		"Season 01/e01.srt":        make([]byte, 16<<10),
+		"Season 01/sample.mkv":     make([]byte, 16<<10),
+		"Season 02/e01.mkv":        make([]byte, 16<<10),
+		"extras/behind-scenes.mkv": make([]byte, 16<<10),
+		"info.nfo":                 make([]byte, 16<<10),
+	}, 16<<10)
+	e := newTestEngine(t)
+	tor := addTestTorrent(t, e, mi)
+	e.GetTorrents()
+
+	selected := func() []string {
+		var out []string
+		for _, f := range tor.Files {
+			if f.Priority != FilePrioritySkip {
+				out = append(out, f.Path)
+			}
+		}
+		return out
+	}
+	tests := []struct {
+		glob string
+		want []string
+	}{
+		{"*.mkv, !sample*", []string{
+			"show/Season 01/e01.mkv",
+			"show/Season 02/e01.mkv",
+			"show/extras/behind-scenes.mkv",
+		}},
+		{"Season 01/*", []string{
+			"show/Season 01/e01.mkv",
+			"show/Season 01/e01.srt",
+			"show/Season 01/sample.mkv",
+		}},
+		{"!extras/*,!*.nfo", []string{
+			"show/Season 01/e01.mkv",
+			"show/Season 01/e01.srt",
+			"show/Season 01/sample.mkv",
+			"show/Season 02/e01.mkv",
+		}},
+	}
+	for _, tt := range tests {
+		if err := e.SelectFilesByPattern(tor.InfoHash, tt.glob); err != nil {
+			t.Fatalf("%q: %v", tt.glob, err)
+		}
+		got := selected()
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q selected %q, want %q", tt.glob, got, tt.want)
+		}
+	}
+
+	if err := e.SelectFilesByPattern(tor.InfoHash, "*.iso"); err == nil {
+		t.Error("expected error when nothing matches")
+	}
+	if err := e.SelectFilesByPattern(tor.InfoHash, "[a-"); err == nil {
+		t.Error("expected error for bad pattern")
+	}
+}
+
+func TestStartStopFile(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "files", map[string][]byte{
+		"a.bin": make([]byte, 24<<10),
+		"b.bin": make([]byte, 24<<10),
+		"c.bin": make([]byte, 16<<10),
+	}, 16<<10)
+	e := newTestEngine(t)
+	tor := addTestTorrent(t, e, mi)
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	e.GetTorrents()
+	prio := func(i int) types.PiecePriority { return tor.t.Piece(i).State().Priority }
+
+	// stopping a file of a stopped torrent only takes effect once started
+	if err := e.StopFile(tor.InfoHash, "files/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StopFile(tor.InfoHash, "files/b.bin"); err == nil {
+		t.Error("expected error stopping a stopped file")
+	}
+	if err := e.StartFile(tor.InfoHash, "files/c.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !tor.Started {
+		t.Fatal("starting a file did not start the torrent")
+	}
+	b := tor.Files[1]
+	if b.Started || b.Priority != FilePrioritySkip {
+		t.Errorf("stopped file is started=%v priority=%v", b.Started, b.Priority)
+	}
+	if p := prio(2); p != types.PiecePriorityNone {
+		t.Errorf("piece only in the stopped file has priority %v, want none", p)
+	}
+
+	if err := e.StartFile(tor.InfoHash, "files/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Started || b.Priority != FilePriorityNormal {
+		t.Errorf("restarted file is started=%v priority=%v", b.Started, b.Priority)
+	}
+	if p := prio(2); p != types.PiecePriorityNormal {
+		t.Errorf("piece of the restarted file has priority %v, want normal", p)
+	}
+	if err := e.StartFile(tor.InfoHash, "files/b.bin"); err == nil {
+		t.Error("expected error starting a started file")
+	}
+
+	if err := e.StopFile(tor.InfoHash, "files/a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if p := prio(0); p != types.PiecePriorityNone {
+		t.Errorf("piece only in the stopped file has priority %v, want none", p)
+	}
+	if err := e.StopFile(tor.InfoHash, "files/missing.bin"); err == nil {
+		t.Error("expected error for unknown file")
+	}
+}
+
+func TestStartFilePrivate(t *testing.T) {
+	mi, _ := newTestMetaInfo(t, "files", map[string][]byte{"a.bin": make([]byte, 16<<10)}, 16<<10)
+	makePrivate(t, mi)
+	e := newTestEngine(t)
+	e.private = newTestClient(t, e.config.DownloadDirectory)
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StopTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	// the dropped torrent is re-added to the private client
+	var err error
+	if !finishes(t, 5*time.Second, func() { err = e.StartFile(tor.InfoHash, "files/a.bin") }) {
+		t.Fatal("starting a file of a stopped private torrent deadlocked")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tor.Started {
+		t.Fatal("starting a file did not start the torrent")
+	}
+}
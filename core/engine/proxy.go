@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/net/proxy"
+)
+
+// proxyDialTimeout bounds the check Configure makes that the proxy is up.
+const proxyDialTimeout = 5 * time.Second
+
+// errProxyUDP is returned for UDP traffic while Config.ProxyURL is set, as
+// SOCKS5 proxies are only used for TCP.
+var errProxyUDP = errors.New("UDP is disabled while traffic goes through a proxy")
+
+// parseProxy parses Config.ProxyURL, a socks5:// or socks5h:// URL with an
+// optional user and password. It returns nil if raw is empty.
+func parseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error repeats the URL, password included
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, errors.New("expected host:port")
+	}
+	return u, nil
+}
+
+// proxy returns the proxy of Config.ProxyURL, or nil to connect directly.
+// e.mut must be held.
+func (e *Engine) proxy() *url.URL {
+	// validated by Configure
+	u, _ := parseProxy(e.config.ProxyURL)
+	return u
+}
+
+// checkProxy makes sure the proxy at u accepts connections, so a proxy
+// that is down fails Configure rather than every connection afterwards.
+func checkProxy(u *url.URL) error {
+	conn, err := net.DialTimeout("tcp", u.Host, proxyDialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// proxyDial returns a function dialing through the proxy at u, or directly
+// if u is nil.
+func proxyDial(u *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	direct := &net.Dialer{}
+	if u == nil {
+		return direct.DialContext
+	}
+	d, err := proxy.FromURL(u, direct)
+	if err != nil {
+		// parseProxy only lets through schemes FromURL knows
+		panic(err)
+	}
+	return d.(proxy.ContextDialer).DialContext
+}
+
+// proxyTransport returns an HTTP transport going through the proxy at u,
+// or nil for the default one if u is nil.
+func proxyTransport(u *url.URL) http.RoundTripper {
+	if u == nil {
+		return nil
+	}
+	return &http.Transport{Proxy: http.ProxyURL(u), DisableKeepAlives: true}
+}
+
+// applyProxy routes the traffic of a client through the proxy at u: HTTP
+// trackers and web seeds, and peer connections once addProxyDialer is
+// called. SOCKS5 carries no UDP here, so DHT, uTP, WebRTC and UDP trackers
+// are switched off rather than reveal the real address.
+func applyProxy(config *torrent.ClientConfig, u *url.URL) {
+	if u == nil {
+		return
+	}
+	config.HTTPProxy = http.ProxyURL(u)
+	config.NoDHT = true
+	config.DisableUTP = true
+	config.DisableWebtorrent = true
+	config.DialForPeerConns = false
+	config.TrackerListenPacket = func(network, addr string) (net.PacketConn, error) {
+		// anacrolix panics if this fails, so UDP trackers get a socket
+		// that refuses to send instead
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return noSendConn{pc}, nil
+	}
+}
+
+// addProxyDialer makes client connect to peers through the proxy at u,
+// applyProxy having stopped it from dialing them directly.
+func addProxyDialer(client *torrent.Client, u *url.URL) {
+	if u != nil {
+		client.AddDialer(peerDialer{proxyDial(u)})
+	}
+}
+
+// peerDialer is a torrent.Dialer for TCP peers through a proxy.
+type peerDialer struct {
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (d peerDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return d.dial(ctx, "tcp", addr)
+}
+
+func (peerDialer) DialerNetwork() string {
+	return "tcp"
+}
+
+// noSendConn is a UDP socket that never sends.
+type noSendConn struct {
+	net.PacketConn
+}
+
+func (noSendConn) WriteTo([]byte, net.Addr) (int, error) {
+	return 0, errProxyUDP
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"golang.org/x/time/rate"
+)
+
+// limitInterval is how often per-torrent rate limits are enforced.
+const limitInterval = 250 * time.Millisecond
+
+// newLimit converts a rate in bytes per second, 0 meaning unlimited.
+func newLimit(bytesPerSec int64) rate.Limit {
+	if bytesPerSec <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bytesPerSec)
+}
+
+// rateLimiters returns the limiters shared by the engine's clients, set to
+// the global limits in c. They are created once and adjusted in place, so
+// running clients pick up new limits.
+func (e *Engine) rateLimiters(c Config) (down, up *rate.Limiter) {
+	if e.downLimit == nil {
+		// zero bursts are replaced with the client's defaults
+		e.downLimit = rate.NewLimiter(rate.Inf, 0)
+		e.upLimit = rate.NewLimiter(rate.Inf, 0)
+	}
+	e.downLimit.SetLimit(newLimit(c.MaxDownloadRate))
+	e.upLimit.SetLimit(newLimit(c.MaxUploadRate))
+	return e.downLimit, e.upLimit
+}
+
+// needsRestart reports whether changing the configuration from a to b needs
+// a new client. Other settings are applied to the running one.
+func needsRestart(a, b Config) bool {
+	return a.DownloadDirectory != b.DownloadDirectory ||
+		a.EnableUpload != b.EnableUpload ||
+		a.EnableSeeding != b.EnableSeeding ||
+		a.IncomingPort != b.IncomingPort ||
+		a.EnableDHT != b.EnableDHT ||
+		a.EnablePEX != b.EnablePEX ||
+		a.ProxyURL != b.ProxyURL ||
+		peerRequestQueue(a) != peerRequestQueue(b) ||
+		a.ClientConfigOverrides != b.ClientConfigOverrides
+}
+
+// SetTorrentLimits caps the download and upload rates of a torrent, in bytes
+// per second, 0 meaning unlimited. anacrolix only limits whole clients, so a
+// capped torrent has its data transfer paused whenever it gets ahead of its
+// allowance; the cap holds on average rather than at every instant.
+func (e *Engine) SetTorrentLimits(infohash string, down, up int64) error {
+	if down < 0 || up < 0 {
+		return fmt.Errorf("Invalid rate limits %d/%d", down, up)
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		return err
+	}
+	t.MaxDownloadRate, t.MaxUploadRate = down, up
+	limited := down > 0 || up > 0
+	if limited && t.stopLimits == nil {
+		t.stopLimits = make(chan struct{})
+		go e.runLimits(t.InfoHash, t.t, t.stopLimits)
+	}
+	if !limited && t.stopLimits != nil {
+		close(t.stopLimits)
+		t.stopLimits = nil
+	}
+	return nil
+}
+
+// runLimits enforces the rate limits of a torrent until stop is closed or
+// the torrent is dropped, then lets it transfer freely again.
+func (e *Engine) runLimits(infohash string, tt *torrent.Torrent, stop chan struct{}) {
+	var down, up allowance
+	stats := tt.Stats()
+	read, written := stats.BytesReadUsefulData.Int64(), stats.BytesWrittenData.Int64()
+	last := time.Now()
+	ticker := time.NewTicker(limitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			e.mut.Lock()
+			seedOnly := e.ts[infohash] != nil && e.ts[infohash].SeedOnly
+			e.mut.Unlock()
+			if !seedOnly {
+				tt.AllowDataDownload()
+			}
+			tt.AllowDataUpload()
+			return
+		case <-tt.Closed():
+			return
+		case now := <-ticker.C:
+			e.mut.Lock()
+			t := e.ts[infohash]
+			if t == nil {
+				e.mut.Unlock()
+				return
+			}
+			maxDown, maxUp, seedOnly := t.MaxDownloadRate, t.MaxUploadRate, t.SeedOnly
+			e.mut.Unlock()
+
+			stats := tt.Stats()
+			r, w := stats.BytesReadUsefulData.Int64(), stats.BytesWrittenData.Int64()
+			dt := now.Sub(last)
+			canDown := down.update(maxDown, r-read, dt)
+			canUp := up.update(maxUp, w-written, dt)
+			read, written, last = r, w, now
+
+			if canDown && !seedOnly {
+				tt.AllowDataDownload()
+			} else if !canDown {
+				tt.DisallowDataDownload()
+			}
+			if canUp {
+				tt.AllowDataUpload()
+			} else {
+				tt.DisallowDataUpload()
+			}
+		}
+	}
+}
+
+// allowance is a token bucket holding up to one second's worth of bytes.
+type allowance struct {
+	bytes float64
+}
+
+// update refills the bucket for dt at limit bytes per second, takes out the
+// used bytes and reports whether more may be transferred. A limit of 0 is
+// unlimited.
+func (a *allowance) update(limit, used int64, dt time.Duration) bool {
+	if limit <= 0 {
+		a.bytes = 0
+		return true
+	}
+	a.bytes = min(a.bytes+float64(limit)*dt.Seconds(), float64(limit)) - float64(used)
+	return a.bytes > 0
+}
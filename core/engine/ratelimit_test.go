@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAllowance(t *testing.T) {
+	var a allowance
+	// a full second of allowance builds up at most
+	if !a.update(1000, 0, 5*time.Second) {
+		t.Fatal("expected transfer allowed with an unused allowance")
+	}
+	if a.bytes != 1000 {
+		t.Fatalf("expected allowance capped at 1000 bytes, got %v", a.bytes)
+	}
+	if a.update(1000, 1500, 250*time.Millisecond) {
+		t.Fatal("expected transfer paused after going over the allowance")
+	}
+	// the debt is paid back before transfer resumes
+	if a.update(1000, 0, 250*time.Millisecond) {
+		t.Fatal("expected transfer still paused")
+	}
+	if !a.update(1000, 0, 500*time.Millisecond) {
+		t.Fatal("expected transfer resumed")
+	}
+	if !a.update(0, 1<<30, time.Second) {
+		t.Fatal("expected no limit for 0")
+	}
+}
+
+func TestRateLimiters(t *testing.T) {
+	e := New()
+	down, up := e.rateLimiters(Config{MaxDownloadRate: 1000})
+	if down.Limit() != 1000 || up.Limit() != rate.Inf {
+		t.Fatalf("unexpected limits %v/%v", down.Limit(), up.Limit())
+	}
+	// limiters are shared with running clients, so they are updated in place
+	down2, up2 := e.rateLimiters(Config{MaxUploadRate: 50})
+	if down2 != down || up2 != up {
+		t.Fatal("expected the same limiters to be reused")
+	}
+	if down.Limit() != rate.Inf || up.Limit() != 50 {
+		t.Fatalf("unexpected limits after update %v/%v", down.Limit(), up.Limit())
+	}
+}
+
+func TestConfigureKeepsClientForLimits(t *testing.T) {
+	e := newTestEngine(t)
+	e.config.IncomingPort = 50007
+	mi, _ := newTestMetaInfo(t, "limits", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+	addTestTorrent(t, e, mi)
+	client := e.client
+
+	c := e.config
+	c.MaxDownloadRate, c.MaxUploadRate = 2000, 1000
+	if err := e.Configure(c); err != nil {
+		t.Fatalf("configure failed: %v", err)
+	}
+	if e.client != client || len(e.GetTorrents()) != 1 {
+		t.Fatal("changing rate limits restarted the client")
+	}
+	if e.downLimit.Limit() != 2000 || e.upLimit.Limit() != 1000 {
+		t.Fatalf("limits not applied: %v/%v", e.downLimit.Limit(), e.upLimit.Limit())
+	}
+	c.MaxUploadRate = -1
+	if err := e.Configure(c); err == nil {
+		t.Fatal("expected error for a negative limit")
+	}
+}
+
+func TestSetTorrentLimits(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "limits", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+	tor := addTestTorrent(t, e, mi)
+
+	if err := e.SetTorrentLimits(tor.InfoHash, 4000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if tor.MaxDownloadRate != 4000 || tor.MaxUploadRate != 0 || tor.stopLimits == nil {
+		t.Fatalf("limits not set: %d/%d", tor.MaxDownloadRate, tor.MaxUploadRate)
+	}
+	if err := e.SetTorrentLimits(tor.InfoHash, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if tor.stopLimits != nil {
+		t.Fatal("limits still enforced after removing them")
+	}
+	if err := e.SetTorrentLimits(tor.InfoHash, -1, 0); err == nil {
+		t.Fatal("expected error for a negative limit")
+	}
+	if err := e.SetTorrentLimits("0123456789abcdef0123456789abcdef01234567", 1, 1); err == nil {
+		t.Fatal("expected error for an unknown torrent")
+	}
+}
+
+func TestPeerRequestQueue(t *testing.T) {
+	if n := clientConfig(Config{}).MaxAllocPeerRequestDataPerConn; n != 256*blockSize {
+		t.Fatalf("expected 256 blocks buffered per peer by default, got %d bytes", n)
+	}
+	if n := clientConfig(Config{PeerRequestQueue: 4}).MaxAllocPeerRequestDataPerConn; n != 4*blockSize {
+		t.Fatalf("expected 4 blocks buffered per peer, got %d bytes", n)
+	}
+	if needsRestart(Config{}, Config{PeerRequestQueue: defaultPeerRequestQueue}) {
+		t.Fatal("expected 0 and the default queue treated alike")
+	}
+	if !needsRestart(Config{}, Config{PeerRequestQueue: 4}) {
+		t.Fatal("expected a new queue size to need a new client")
+	}
+	e := newTestEngine(t)
+	c := e.config
+	c.IncomingPort = 50007
+	c.PeerRequestQueue = -1
+	if err := e.Configure(c); err == nil {
+		t.Fatal("expected error for a negative queue")
+	}
+}
@@ -2,24 +2,64 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
 )
 
+// RemoteEngine forwards to a daemon over its HTTP API. Each method making a
+// request has a Ctx variant taking a context, for cancellation and
+// per-call deadlines; the plain methods use context.Background().
 type RemoteEngine struct {
 	baseURL    string
+	token      string // API token, see ServerToken
+	socket     string // Unix socket path, if the daemon listens on one
 	httpClient *http.Client
 }
 
+const (
+	// remoteTimeout bounds requests whose context has no deadline.
+	remoteTimeout = 10 * time.Second
+	// remoteRetries is how many times a failed GET is retried, for a
+	// daemon that is briefly unavailable, e.g. restarting.
+	remoteRetries = 1
+	// remoteRetryDelay is the wait before the first retry, doubled for each
+	// one after.
+	remoteRetryDelay = 250 * time.Millisecond
+	// remoteVerifyTimeout bounds VerifyReport, which waits for the daemon
+	// to rehash the whole torrent.
+	remoteVerifyTimeout = time.Hour
+)
+
+// NewRemoteEngine returns an engine forwarding to the daemon at baseURL,
+// authenticated with the token the daemon left at TokenPath. A baseURL of
+// unix:// and a path reaches a daemon listening on that Unix socket, see
+// Config.APISocket.
 func NewRemoteEngine(baseURL string) *RemoteEngine {
+	token := readToken()
+	transport := http.DefaultTransport
+	var socket string
+	if path, ok := strings.CutPrefix(baseURL, unixScheme); ok {
+		socket = path
+		transport = socketTransport(socket)
+		// only a placeholder, every request goes to the socket
+		baseURL = "http://intunja"
+	}
 	return &RemoteEngine{
-		baseURL:    baseURL,
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+		baseURL: baseURL,
+		token:   token,
+		socket:  socket,
+		httpClient: &http.Client{
+			Transport: bearerTransport{token: token, base: transport},
+		},
 	}
 }
 
@@ -28,124 +68,341 @@ func (r *RemoteEngine) Config() Config {
 }
 
 func (r *RemoteEngine) Configure(c Config) error {
+	return r.ConfigureCtx(context.Background(), c)
+}
+
+func (r *RemoteEngine) ConfigureCtx(ctx context.Context, c Config) error {
 	b, _ := json.Marshal(&c)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/configure", "application/json", bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("configure failed: %s", string(data))
-	}
-	return nil
+	_, err := r.do(ctx, http.MethodPost, "/api/configure", "application/json", b, "configure")
+	return err
+}
+
+func (r *RemoteEngine) ListenPort() (int, error) {
+	return 0, fmt.Errorf("ListenPort not implemented for remote engine")
 }
 
 func (r *RemoteEngine) NewMagnet(magnetURI string) error {
-	resp, err := r.httpClient.Post(r.baseURL+"/api/magnet", "text/plain", bytes.NewReader([]byte(magnetURI)))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("magnet failed: %s", string(data))
-	}
-	return nil
+	return r.NewMagnetCtx(context.Background(), magnetURI)
 }
 
+func (r *RemoteEngine) NewMagnetCtx(ctx context.Context, magnetURI string) error {
+	_, err := r.do(ctx, http.MethodPost, "/api/magnet", "text/plain", []byte(magnetURI), "magnet")
+	return err
+}
+
+// NewTorrent uploads the spec's metainfo to POST /api/torrentfile as a
+// .torrent file. Specs without info bytes go through NewMagnet instead.
 func (r *RemoteEngine) NewTorrent(spec *torrent.TorrentSpec) error {
-	return fmt.Errorf("NewTorrent not implemented for remote engine")
+	return r.NewTorrentCtx(context.Background(), spec)
 }
 
-func (r *RemoteEngine) GetTorrents() map[string]*Torrent {
-	resp, err := r.httpClient.Get(r.baseURL + "/api/torrents")
-	if err != nil {
-		return nil
+func (r *RemoteEngine) NewTorrentCtx(ctx context.Context, spec *torrent.TorrentSpec) error {
+	if len(spec.InfoBytes) == 0 {
+		return fmt.Errorf("torrent has no info bytes to upload")
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil
+	mi := metainfo.MetaInfo{
+		InfoBytes:    spec.InfoBytes,
+		AnnounceList: spec.Trackers,
+		UrlList:      spec.Webseeds,
 	}
-	data, err := ioutil.ReadAll(resp.Body)
+	var b bytes.Buffer
+	if err := mi.Write(&b); err != nil {
+		return err
+	}
+	_, err := r.do(ctx, http.MethodPost, "/api/torrentfile", "application/x-bittorrent", b.Bytes(), "torrent upload")
+	return err
+}
+
+func (r *RemoteEngine) AddTorrentURL(url string) error {
+	return r.AddTorrentURLCtx(context.Background(), url)
+}
+
+func (r *RemoteEngine) AddTorrentURLCtx(ctx context.Context, url string) error {
+	_, err := r.do(ctx, http.MethodPost, "/api/url", "text/plain", []byte(url), "url")
+	return err
+}
+
+func (r *RemoteEngine) AddTorrentFile(path string) error {
+	return fmt.Errorf("AddTorrentFile not implemented for remote engine")
+}
+
+func (r *RemoteEngine) AddTorrentTo(source, dir string) error {
+	return fmt.Errorf("AddTorrentTo not implemented for remote engine")
+}
+
+// GetTorrents returns nil if the daemon could not be reached; use
+// GetTorrentsCtx for the error.
+func (r *RemoteEngine) GetTorrents() map[string]*Torrent {
+	ts, _ := r.GetTorrentsCtx(context.Background())
+	return ts
+}
+
+func (r *RemoteEngine) GetTorrentsCtx(ctx context.Context) (map[string]*Torrent, error) {
+	data, err := r.do(ctx, http.MethodGet, "/api/torrents", "", nil, "torrents")
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	var ts map[string]*Torrent
 	if err := json.Unmarshal(data, &ts); err != nil {
-		return nil
+		return nil, err
 	}
-	return ts
+	return ts, nil
 }
 
-func (r *RemoteEngine) StartTorrent(infohash string) error {
-	body := []byte("start:" + infohash)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/torrent", "text/plain", bytes.NewReader(body))
+// ListTorrents returns no summaries if the daemon could not be reached;
+// use ListTorrentsCtx for the error.
+func (r *RemoteEngine) ListTorrents() []TorrentSummary {
+	return SummarizeTorrents(r.GetTorrents())
+}
+
+func (r *RemoteEngine) ListTorrentsCtx(ctx context.Context) ([]TorrentSummary, error) {
+	ts, err := r.GetTorrentsCtx(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("start failed: %s", string(data))
-	}
-	return nil
+	return SummarizeTorrents(ts), nil
 }
 
-func (r *RemoteEngine) StopTorrent(infohash string) error {
-	body := []byte("stop:" + infohash)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/torrent", "text/plain", bytes.NewReader(body))
+// InspectTorrent fetches the torrent's metadata from
+// GET /api/torrents/{hash}/info.
+func (r *RemoteEngine) InspectTorrent(infohash string) (*TorrentInfo, error) {
+	return r.InspectTorrentCtx(context.Background(), infohash)
+}
+
+func (r *RemoteEngine) InspectTorrentCtx(ctx context.Context, infohash string) (*TorrentInfo, error) {
+	data, err := r.do(ctx, http.MethodGet, torrentPath(infohash, "info"), "", nil, "inspect")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("stop failed: %s", string(data))
+	var ti TorrentInfo
+	if err := json.Unmarshal(data, &ti); err != nil {
+		return nil, err
 	}
-	return nil
+	return &ti, nil
+}
+
+func (r *RemoteEngine) RateHistory(infohash string) []RateSample { return nil }
+
+func (r *RemoteEngine) Scrape(infohash string) (ScrapeResult, error) {
+	return ScrapeResult{}, fmt.Errorf("Scrape not implemented for remote engine")
 }
 
-func (r *RemoteEngine) DeleteTorrent(infohash string) error {
-	body := []byte("delete:" + infohash)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/torrent", "text/plain", bytes.NewReader(body))
+func (r *RemoteEngine) SwarmSize(infohash string) (SwarmEstimate, error) {
+	return SwarmEstimate{}, fmt.Errorf("SwarmSize not implemented for remote engine")
+}
+
+func (r *RemoteEngine) ReannounceTorrent(infohash string) error {
+	return r.ReannounceTorrentCtx(context.Background(), infohash)
+}
+
+func (r *RemoteEngine) ReannounceTorrentCtx(ctx context.Context, infohash string) error {
+	return r.postJSON(ctx, "/api/torrent", TorrentRequest{Op: "reannounce", InfoHash: infohash}, "reannounce")
+}
+
+// VerifyReport has the daemon rehash the torrent through
+// POST /api/torrents/{hash}/verify, waiting up to an hour for the result.
+func (r *RemoteEngine) VerifyReport(infohash string) (*VerifyResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteVerifyTimeout)
+	defer cancel()
+	return r.VerifyReportCtx(ctx, infohash)
+}
+
+func (r *RemoteEngine) VerifyReportCtx(ctx context.Context, infohash string) (*VerifyResult, error) {
+	data, err := r.do(ctx, http.MethodPost, torrentPath(infohash, "verify"), "", nil, "verify")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("delete failed: %s", string(data))
+	var res VerifyResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
 	}
-	return nil
+	return &res, nil
+}
+
+func (r *RemoteEngine) SetTorrentDebug(infohash string, on bool) error {
+	return fmt.Errorf("SetTorrentDebug not implemented for remote engine")
+}
+
+func (r *RemoteEngine) TorrentDebug(infohash string) bool { return false }
+
+func (r *RemoteEngine) SetSequential(infohash string, on bool) error {
+	return fmt.Errorf("SetSequential not implemented for remote engine")
+}
+
+func (r *RemoteEngine) StartTorrent(infohash string) error {
+	return r.StartTorrentCtx(context.Background(), infohash)
+}
+
+func (r *RemoteEngine) StartTorrentCtx(ctx context.Context, infohash string) error {
+	return r.postJSON(ctx, "/api/torrent", TorrentRequest{Op: "start", InfoHash: infohash}, "start")
+}
+
+func (r *RemoteEngine) StopTorrent(infohash string) error {
+	return r.StopTorrentCtx(context.Background(), infohash)
+}
+
+func (r *RemoteEngine) StopTorrentCtx(ctx context.Context, infohash string) error {
+	return r.postJSON(ctx, "/api/torrent", TorrentRequest{Op: "stop", InfoHash: infohash}, "stop")
+}
+
+func (r *RemoteEngine) SetSeedOnly(infohash string, on bool) error {
+	return fmt.Errorf("SetSeedOnly not implemented for remote engine")
+}
+
+func (r *RemoteEngine) SetCompletionPolicy(infohash string, p CompletionPolicy) error {
+	return fmt.Errorf("SetCompletionPolicy not implemented for remote engine")
+}
+
+func (r *RemoteEngine) SetTorrentLimits(infohash string, down, up int64) error {
+	return r.SetTorrentLimitsCtx(context.Background(), infohash, down, up)
+}
+
+func (r *RemoteEngine) SetTorrentLimitsCtx(ctx context.Context, infohash string, down, up int64) error {
+	return r.postJSON(ctx, torrentPath(infohash, "limits"), TorrentLimits{Down: down, Up: up}, "set limits")
+}
+
+func (r *RemoteEngine) DeleteTorrent(infohash string, deleteData bool) error {
+	return r.DeleteTorrentCtx(context.Background(), infohash, deleteData)
+}
+
+func (r *RemoteEngine) DeleteTorrentCtx(ctx context.Context, infohash string, deleteData bool) error {
+	op := "delete"
+	if deleteData {
+		op = "delete-data"
+	}
+	return r.postJSON(ctx, "/api/torrent", TorrentRequest{Op: op, InfoHash: infohash}, "delete")
 }
 
 func (r *RemoteEngine) StartFile(infohash, filepath string) error {
-	body := []byte("start:" + infohash + ":" + filepath)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/file", "text/plain", bytes.NewReader(body))
+	return r.StartFileCtx(context.Background(), infohash, filepath)
+}
+
+func (r *RemoteEngine) StartFileCtx(ctx context.Context, infohash, filepath string) error {
+	return r.postJSON(ctx, "/api/file", FileRequest{Op: "start", InfoHash: infohash, Path: filepath}, "start file")
+}
+
+func (r *RemoteEngine) StopFile(infohash, filepath string) error {
+	return r.StopFileCtx(context.Background(), infohash, filepath)
+}
+
+func (r *RemoteEngine) StopFileCtx(ctx context.Context, infohash, filepath string) error {
+	return r.postJSON(ctx, "/api/file", FileRequest{Op: "stop", InfoHash: infohash, Path: filepath}, "stop file")
+}
+
+// postJSON posts v as JSON to the daemon, what naming the action in errors.
+func (r *RemoteEngine) postJSON(ctx context.Context, path string, v any, what string) error {
+	b, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("start file failed: %s", string(data))
+	_, err = r.do(ctx, http.MethodPost, path, "application/json", b, what)
+	return err
+}
+
+// torrentPath returns the path of the API endpoint for action on the
+// torrent infohash.
+func torrentPath(infohash, action string) string {
+	return "/api/torrents/" + url.PathEscape(infohash) + "/" + action
+}
+
+// do sends a request to the daemon and returns the body of its 200 OK
+// response, what naming the action in errors. GETs are retried with backoff
+// after connection errors and 502, 503 and 504 responses; other methods
+// may have taken effect, so they are not.
+func (r *RemoteEngine) do(ctx context.Context, method, path, contentType string, body []byte, what string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, remoteTimeout)
+		defer cancel()
+	}
+	delay := remoteRetryDelay
+	for attempt := 0; ; attempt++ {
+		data, status, err := r.send(ctx, method, path, contentType, body)
+		unavailable := err != nil || status == http.StatusBadGateway ||
+			status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+		if unavailable && method == http.MethodGet && attempt < remoteRetries && ctx.Err() == nil {
+			select {
+			case <-time.After(delay):
+				delay *= 2
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("%s failed: %s", what, string(data))
+		}
+		return data, nil
 	}
-	return nil
 }
 
-func (r *RemoteEngine) StopFile(infohash, filepath string) error {
-	body := []byte("stop:" + infohash + ":" + filepath)
-	resp, err := r.httpClient.Post(r.baseURL+"/api/file", "text/plain", bytes.NewReader(body))
+// send makes one attempt of a request for do.
+func (r *RemoteEngine) send(ctx context.Context, method, path, contentType string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("stop file failed: %s", string(data))
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
 	}
-	return nil
+	return data, resp.StatusCode, nil
+}
+
+// MoveTorrent has the daemon move the torrent's data to newDir, a path
+// on the daemon's host.
+func (r *RemoteEngine) MoveTorrent(infohash, newDir string) error {
+	return r.MoveTorrentCtx(context.Background(), infohash, newDir)
+}
+
+func (r *RemoteEngine) MoveTorrentCtx(ctx context.Context, infohash, newDir string) error {
+	_, err := r.do(ctx, http.MethodPost, torrentPath(infohash, "move"), "text/plain", []byte(newDir), "move")
+	return err
+}
+
+func (r *RemoteEngine) PauseAll() error {
+	return r.PauseAllCtx(context.Background())
+}
+
+func (r *RemoteEngine) PauseAllCtx(ctx context.Context) error {
+	_, err := r.do(ctx, http.MethodPost, "/api/pause-all", "", nil, "pause all")
+	return err
+}
+
+func (r *RemoteEngine) ResumeAll() error {
+	return r.ResumeAllCtx(context.Background())
+}
+
+func (r *RemoteEngine) ResumeAllCtx(ctx context.Context) error {
+	_, err := r.do(ctx, http.MethodPost, "/api/resume-all", "", nil, "resume all")
+	return err
+}
+
+func (r *RemoteEngine) SetLabels(infohash string, labels []string) error {
+	return r.SetLabelsCtx(context.Background(), infohash, labels)
+}
+
+func (r *RemoteEngine) SetLabelsCtx(ctx context.Context, infohash string, labels []string) error {
+	return r.postJSON(ctx, torrentPath(infohash, "labels"), labels, "set labels")
+}
+
+func (r *RemoteEngine) SetFilePriority(infohash, filepath string, prio FilePriority) error {
+	return fmt.Errorf("SetFilePriority not implemented for remote engine")
+}
+
+func (r *RemoteEngine) SelectFilesByPattern(infohash, glob string) error {
+	return fmt.Errorf("SelectFilesByPattern not implemented for remote engine")
 }
 
 // AttachPersister is a no-op for RemoteEngine (persistence handled by daemon)
@@ -153,4 +410,13 @@ func (r *RemoteEngine) AttachPersister(p *Persister) {}
 
 func (r *RemoteEngine) DetachPersister() {}
 
+func (r *RemoteEngine) PersistErr() error { return nil }
+
 func (r *RemoteEngine) RehydrateFromPersister() {}
+
+// Subscribe returns a subscription that receives no events: remote clients
+// follow the daemon with StreamTorrents, or by polling GetTorrents.
+func (r *RemoteEngine) Subscribe() (<-chan Event, func()) {
+	var none events
+	return none.subscribe()
+}
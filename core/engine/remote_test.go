@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteContextAndRetry(t *testing.T) {
+	var gets, posts atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/torrents", func(w http.ResponseWriter, r *http.Request) {
+		// unavailable once, as while the daemon restarts
+		if gets.Add(1) == 1 {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"abc":{"Name":"x"}}`))
+	})
+	mux.HandleFunc("/api/torrent", func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		http.Error(w, "restarting", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/api/torrents/slow/info", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+
+	ts, err := r.GetTorrentsCtx(context.Background())
+	if err != nil || ts["abc"] == nil || gets.Load() != 2 {
+		t.Fatalf("expected the GET retried once, got %v, %v after %d requests", ts, err, gets.Load())
+	}
+	if err := r.StopTorrent("abc"); err == nil || posts.Load() != 1 {
+		t.Fatalf("expected the POST to fail unretried, got %v after %d requests", err, posts.Load())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := r.InspectTorrentCtx(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("expected the call cut short by its deadline, took %s", d)
+	}
+}
@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// TorrentRequest is the body of POST /api/torrent.
+type TorrentRequest struct {
+	Op       string `json:"op"` // "start", "stop", "delete", "delete-data" or "reannounce"
+	InfoHash string `json:"infohash"`
+}
+
+// TorrentLimits is the body of POST /api/torrents/{hash}/limits, in bytes
+// per second, 0 for unlimited.
+type TorrentLimits struct {
+	Down int64 `json:"down"`
+	Up   int64 `json:"up"`
+}
+
+// FileRequest is the body of POST /api/file.
+type FileRequest struct {
+	Op       string `json:"op"` // "start" or "stop"
+	InfoHash string `json:"infohash"`
+	Path     string `json:"path"`
+}
+
+// ParseTorrentRequest decodes a /api/torrent body, either JSON or the
+// older "op:infohash" text form.
+func ParseTorrentRequest(body []byte) (TorrentRequest, error) {
+	var req TorrentRequest
+	if isJSON(body) {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("invalid torrent request: %w", err)
+		}
+	} else {
+		op, ih, ok := strings.Cut(string(body), ":")
+		if !ok {
+			return req, fmt.Errorf("invalid torrent request %q", body)
+		}
+		req = TorrentRequest{Op: op, InfoHash: ih}
+	}
+	if req.InfoHash == "" {
+		return req, fmt.Errorf("torrent request without an infohash")
+	}
+	return req, nil
+}
+
+// ParseFileRequest decodes a /api/file body, either JSON or the older
+// "op:infohash:path" text form. Info-hashes have no colons, so the path is
+// everything after the second one.
+func ParseFileRequest(body []byte) (FileRequest, error) {
+	var req FileRequest
+	if isJSON(body) {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("invalid file request: %w", err)
+		}
+	} else {
+		parts := strings.SplitN(string(body), ":", 3)
+		if len(parts) != 3 {
+			return req, fmt.Errorf("invalid file request %q", body)
+		}
+		req = FileRequest{Op: parts[0], InfoHash: parts[1], Path: parts[2]}
+	}
+	if req.InfoHash == "" || req.Path == "" {
+		return req, fmt.Errorf("file request without an infohash or path")
+	}
+	return req, nil
+}
+
+func isJSON(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+}
+
+// TorrentHandler serves POST /api/torrent for RemoteEngine clients.
+func TorrentHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ParseTorrentRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.Op {
+		case "start":
+			err = e.StartTorrent(req.InfoHash)
+		case "stop":
+			err = e.StopTorrent(req.InfoHash)
+		case "delete":
+			err = e.DeleteTorrent(req.InfoHash, false)
+		case "delete-data":
+			err = e.DeleteTorrent(req.InfoHash, true)
+		case "reannounce":
+			err = e.ReannounceTorrent(req.InfoHash)
+		default:
+			http.Error(w, fmt.Sprintf("unknown torrent op %q", req.Op), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// FileHandler serves POST /api/file for RemoteEngine clients.
+func FileHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ParseFileRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.Op {
+		case "start":
+			err = e.StartFile(req.InfoHash, req.Path)
+		case "stop":
+			err = e.StopFile(req.InfoHash, req.Path)
+		default:
+			http.Error(w, fmt.Sprintf("unknown file op %q", req.Op), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// TorrentFileHandler serves POST /api/torrentfile, adding the uploaded
+// .torrent file for RemoteEngine clients.
+func TorrentFileHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mi, err := metainfo.Load(http.MaxBytesReader(w, r.Body, maxTorrentFileSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid torrent file: %v", err), http.StatusBadRequest)
+			return
+		}
+		spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid torrent file: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := e.NewTorrent(spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// ConfigureHandler serves POST /api/configure, applying the JSON Config
+// RemoteEngine clients send. OnCompleteCommand runs on the daemon's host,
+// so it can only be set in the daemon's config file: a config changing it
+// is refused.
+func ConfigureHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c Config
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %v", err), http.StatusBadRequest)
+			return
+		}
+		if c.OnCompleteCommand != e.Config().OnCompleteCommand {
+			http.Error(w, "OnCompleteCommand can only be set in the daemon's config file", http.StatusForbidden)
+			return
+		}
+		if err := e.Configure(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// MagnetHandler serves POST /api/magnet, adding the magnet URI in the
+// body for RemoteEngine clients.
+func MagnetHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := e.NewMagnet(strings.TrimSpace(string(body))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// URLHandler serves POST /api/url, adding the .torrent file at the URL in
+// the body for RemoteEngine clients.
+func URLHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := e.AddTorrentURL(strings.TrimSpace(string(body))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// TorrentsHandler serves GET /api/torrents, every torrent as JSON, for
+// RemoteEngine clients.
+func TorrentsHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, e.GetTorrents())
+	})
+}
+
+// InfoHandler serves GET /api/torrents/{hash}/info, the torrent's metadata
+// as JSON, for RemoteEngine clients.
+func InfoHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ti, err := e.InspectTorrent(r.PathValue("hash"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, ti)
+	})
+}
+
+// VerifyHandler serves POST /api/torrents/{hash}/verify, rehashing the
+// torrent and answering with its VerifyResult as JSON once done.
+func VerifyHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := e.VerifyReport(r.PathValue("hash"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, res)
+	})
+}
+
+// LabelsHandler serves POST /api/torrents/{hash}/labels, replacing the
+// torrent's labels with the JSON list of strings in the body.
+func LabelsHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var labels []string
+		if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+			http.Error(w, fmt.Sprintf("invalid labels: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := e.SetLabels(r.PathValue("hash"), labels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// LimitsHandler serves POST /api/torrents/{hash}/limits, capping the
+// torrent's rates to the JSON TorrentLimits in the body.
+func LimitsHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var l TorrentLimits
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, fmt.Sprintf("invalid limits: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := e.SetTorrentLimits(r.PathValue("hash"), l.Down, l.Up); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// MoveHandler serves POST /api/torrents/{hash}/move, moving the torrent's
+// data to the directory in the body.
+func MoveHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := e.MoveTorrent(r.PathValue("hash"), string(body)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// PauseAllHandler serves POST /api/pause-all for RemoteEngine clients.
+func PauseAllHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := e.PauseAll(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// ResumeAllHandler serves POST /api/resume-all for RemoteEngine clients.
+func ResumeAllHandler(e EngineInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := e.ResumeAll(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
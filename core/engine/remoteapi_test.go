@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestRemoteFileRequests(t *testing.T) {
+	m := NewMemoryEngine()
+	m.Configure(Config{AutoStart: true})
+	info := metainfo.Info{Name: "show", PieceLength: 16384, Pieces: make([]byte, 20), Files: []metainfo.FileInfo{
+		{Path: []string{"s01:e01", "12:30:00.mkv"}, Length: 100},
+		{Path: []string{"notes.txt"}, Length: 100},
+	}}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.NewTorrent(spec); err != nil {
+		t.Fatal(err)
+	}
+	ih := spec.InfoHash.HexString()
+	mux := http.NewServeMux()
+	mux.Handle("/api/torrent", TorrentHandler(m))
+	mux.Handle("/api/file", FileHandler(m))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+
+	const path = "show/s01:e01/12:30:00.mkv"
+	if err := r.StopFile(ih, path); err != nil {
+		t.Fatal(err)
+	}
+	files := m.GetTorrents()[ih].Files
+	if files[0].Path != path || files[0].Priority != FilePrioritySkip || files[1].Priority == FilePrioritySkip {
+		t.Fatalf("expected only %s skipped, got %+v, %+v", path, files[0], files[1])
+	}
+	if err := r.StartFile(ih, path); err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Priority != FilePriorityNormal {
+		t.Fatalf("expected %s downloaded again, got %v", path, files[0].Priority)
+	}
+	if err := r.StartFile(ih, "show/missing"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	if err := r.StopTorrent(ih); err != nil || m.GetTorrents()[ih].Started {
+		t.Fatalf("expected the torrent stopped, got %v", err)
+	}
+	if err := r.DeleteTorrent(ih, false); err != nil || len(m.GetTorrents()) != 0 {
+		t.Fatalf("expected the torrent deleted, got %v", err)
+	}
+}
+
+func TestParseLegacyRequests(t *testing.T) {
+	fr, err := ParseFileRequest([]byte("stop:abc:show/s01:e01/12:30:00.mkv"))
+	if err != nil || fr != (FileRequest{Op: "stop", InfoHash: "abc", Path: "show/s01:e01/12:30:00.mkv"}) {
+		t.Fatalf("unexpected file request %+v, %v", fr, err)
+	}
+	tr, err := ParseTorrentRequest([]byte("delete-data:abc"))
+	if err != nil || tr != (TorrentRequest{Op: "delete-data", InfoHash: "abc"}) {
+		t.Fatalf("unexpected torrent request %+v, %v", tr, err)
+	}
+	for _, body := range []string{"start", "start:abc", `{"op":"start","infohash":"abc"}`, `{"op":`} {
+		if _, err := ParseFileRequest([]byte(body)); err == nil {
+			t.Errorf("expected %q refused", body)
+		}
+	}
+	if _, err := ParseTorrentRequest([]byte("start")); err == nil {
+		t.Error("expected a request without an infohash refused")
+	}
+}
+
+func TestRemoteNewTorrent(t *testing.T) {
+	m := NewMemoryEngine()
+	srv := httptest.NewServer(TorrentFileHandler(m))
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+
+	info := metainfo.Info{Name: "album", PieceLength: 16384, Pieces: make([]byte, 20), Files: []metainfo.FileInfo{
+		{Path: []string{"01.flac"}, Length: 100},
+		{Path: []string{"02.flac"}, Length: 100},
+	}}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const tracker = "udp://tracker.example.com:80/announce"
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(&metainfo.MetaInfo{
+		InfoBytes:    infoBytes,
+		AnnounceList: [][]string{{tracker}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.NewTorrent(spec); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := m.GetTorrents()[spec.InfoHash.HexString()]
+	if !ok {
+		t.Fatal("uploaded torrent not added")
+	}
+	if got.Name != "album" || len(got.Files) != 2 || len(got.Trackers) != 1 || got.Trackers[0] != tracker {
+		t.Fatalf("unexpected torrent %+v", got)
+	}
+
+	err = r.NewTorrent(spec)
+	if err == nil || !strings.Contains(err.Error(), ErrDuplicateTorrent.Error()) {
+		t.Fatalf("expected the daemon's duplicate error, got %v", err)
+	}
+	if err := r.NewTorrent(&torrent.TorrentSpec{DisplayName: "album"}); err == nil {
+		t.Fatal("expected a spec without info bytes refused")
+	}
+}
+
+func TestAPIHandlerRoutes(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	token, err := ServerToken(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemoryEngine()
+	srv := httptest.NewServer(APIHandler(m, token))
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+
+	if err := r.Configure(Config{DownloadDirectory: "remote", AutoStart: true}); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.Config(); c.DownloadDirectory != "remote" || !c.AutoStart {
+		t.Fatalf("expected the config applied, got %+v", c)
+	}
+	// the command runs on the daemon's host, so it stays with its config
+	err = r.Configure(Config{DownloadDirectory: "remote", OnCompleteCommand: "touch /tmp/pwned"})
+	if err == nil || !strings.Contains(err.Error(), "OnCompleteCommand") {
+		t.Fatalf("expected a changed OnCompleteCommand refused, got %v", err)
+	}
+	if c := m.Config(); c.OnCompleteCommand != "" || !c.AutoStart {
+		t.Fatalf("expected the refused config not applied, got %+v", c)
+	}
+	const ih = "0123456789abcdef0123456789abcdef01234567"
+	if err := r.NewMagnet("magnet:?xt=urn:btih:" + ih + "&dn=remote"); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := r.GetTorrentsCtx(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 1 || ts[ih] == nil || ts[ih].Name != "remote" {
+		t.Fatalf("expected the magnet's torrent listed, got %v", ts)
+	}
+	ti, err := r.InspectTorrent(ih)
+	if err != nil || ti.InfoHash != ih {
+		t.Fatalf("expected the torrent's info, got %+v, %v", ti, err)
+	}
+	if _, err := r.InspectTorrent("missing"); err == nil {
+		t.Fatal("expected an error for a missing torrent")
+	}
+	if err := r.AddTorrentURL("http://example.invalid/x.torrent"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected the engine's error passed back, got %v", err)
+	}
+
+	if err := r.SetLabels(ih, []string{"Linux", "iso"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetTorrentLimits(ih, 1000, 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetTorrentLimits(ih, -1, 0); err == nil {
+		t.Fatal("expected negative limits refused")
+	}
+	if err := r.MoveTorrent(ih, "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReannounceTorrent(ih); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReannounceTorrent("missing"); err == nil {
+		t.Fatal("expected an error reannouncing a missing torrent")
+	}
+	got := m.GetTorrents()[ih]
+	if strings.Join(got.Labels, ",") != "Linux,iso" || got.MaxDownloadRate != 1000 || got.MaxUploadRate != 500 || got.DownloadDir != "elsewhere" {
+		t.Fatalf("expected labels, limits and directory set, got %+v", got)
+	}
+	if err := r.PauseAll(); err != nil || got.Started || !got.PausedAll {
+		t.Fatalf("expected the torrent paused, got %v", err)
+	}
+	if err := r.ResumeAll(); err != nil || !got.Started {
+		t.Fatalf("expected the torrent resumed, got %v", err)
+	}
+	if _, err := r.VerifyReport(ih); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected the engine's verify error passed back, got %v", err)
+	}
+}
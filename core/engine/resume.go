@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// resumeVersion is the ResumeData format written by ExportResume.
+const resumeVersion = 1
+
+// ResumeData is intunja's resume format, for moving a torrent and its
+// progress to another engine, or converting it for another client. As JSON:
+//
+//	{
+//	  "version": 1,
+//	  "infohash": "<40 hex digits>",
+//	  "name": "<torrent name>",
+//	  "info": "<base64 of the bencoded info dictionary>",
+//	  "trackers": [["<announce URL>", ...], ...],
+//	  "save_path": "<absolute directory the torrent's files are in>",
+//	  "pieces": "<base64 bitfield, as in BEP 3>",
+//	  "uploaded": <bytes uploaded so far>,
+//	  "started": <true to download or seed after import>
+//	}
+//
+// The bitfield has a bit per piece, set for pieces held; the high bit of
+// the first byte is piece 0.
+type ResumeData struct {
+	Version  int        `json:"version"`
+	InfoHash string     `json:"infohash"`
+	Name     string     `json:"name"`
+	Info     []byte     `json:"info"`
+	Trackers [][]string `json:"trackers,omitempty"`
+	SavePath string     `json:"save_path"`
+	Pieces   []byte     `json:"pieces"`
+	Uploaded int64      `json:"uploaded"`
+	Started  bool       `json:"started"`
+}
+
+// HasPiece reports whether piece i is held according to the bitfield.
+func (rd *ResumeData) HasPiece(i int) bool {
+	return i/8 < len(rd.Pieces) && rd.Pieces[i/8]&(0x80>>(i%8)) != 0
+}
+
+// ExportResume returns the resume data of a torrent whose metadata is
+// known.
+func (e *Engine) ExportResume(infohash string) (*ResumeData, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		return nil, err
+	}
+	tt := t.t
+	if tt.Info() == nil {
+		return nil, fmt.Errorf("torrent metadata not yet available")
+	}
+	dir := t.DownloadDir
+	if dir == "" {
+		dir = e.config.DownloadDirectory
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	mi := tt.Metainfo()
+	rd := &ResumeData{
+		Version:  resumeVersion,
+		InfoHash: t.InfoHash,
+		Name:     tt.Name(),
+		Info:     mi.InfoBytes,
+		Trackers: mi.UpvertedAnnounceList(),
+		SavePath: abs,
+		Pieces:   make([]byte, (tt.NumPieces()+7)/8),
+		Uploaded: t.Uploaded,
+		Started:  t.Started,
+	}
+	for i := range tt.NumPieces() {
+		if tt.PieceState(i).Complete {
+			rd.Pieces[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return rd, nil
+}
+
+// ImportResume adds the torrent of rd, finding its data in rd.SavePath.
+// The pieces the bitfield holds are hashed rather than trusted, and the
+// others left as the storage finds them, so progress is restored without
+// rechecking the whole torrent. It blocks until those pieces are verified.
+func (e *Engine) ImportResume(rd *ResumeData) error {
+	if rd.Version != resumeVersion {
+		return fmt.Errorf("unsupported resume data version %d", rd.Version)
+	}
+	if rd.SavePath == "" {
+		return errors.New("resume data without a save path")
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: rd.Info, AnnounceList: rd.Trackers}
+	if ih := mi.HashInfoBytes().HexString(); ih != rd.InfoHash {
+		return fmt.Errorf("resume data info is for %s, not %s", ih, rd.InfoHash)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return fmt.Errorf("invalid torrent info: %w", err)
+	}
+	if len(rd.Pieces) != (info.NumPieces()+7)/8 {
+		return fmt.Errorf("resume data has a bitfield of %d bytes for %d pieces", len(rd.Pieces), info.NumPieces())
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return err
+	}
+	spec.DisableInitialPieceCheck = true
+
+	dir := rd.SavePath
+	if abs, err := filepath.Abs(e.config.DownloadDirectory); err == nil && abs == filepath.Clean(dir) {
+		dir = ""
+	}
+	if err := e.addTorrentSpec(spec, "", dir); err != nil {
+		return err
+	}
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(rd.InfoHash)
+	var started bool
+	if err == nil {
+		t.uploadedBase = rd.Uploaded
+		started = t.Started
+	}
+	e.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	tt := t.t
+	for i := range tt.NumPieces() {
+		if rd.HasPiece(i) && !tt.PieceState(i).Complete {
+			if err := tt.Piece(i).VerifyData(); err != nil {
+				return fmt.Errorf("verify piece %d: %w", i, err)
+			}
+		}
+	}
+	switch {
+	case rd.Started && !started:
+		return e.StartTorrent(rd.InfoHash)
+	case !rd.Started && started:
+		return e.StopTorrent(rd.InfoHash)
+	}
+	return nil
+}
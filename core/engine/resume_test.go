@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeRoundTrip(t *testing.T) {
+	const pieceLength = 16 << 10
+	files := map[string][]byte{
+		"a.bin": bytes.Repeat([]byte("a"), 2*pieceLength),
+		"b.bin": bytes.Repeat([]byte("b"), 2*pieceLength),
+	}
+	mi, dir := newTestMetaInfo(t, "multi", files, pieceLength)
+	// only a.bin downloaded so far
+	if err := os.Remove(filepath.Join(dir, "multi", "b.bin")); err != nil {
+		t.Fatal(err)
+	}
+	src := newTestEngineIn(t, dir)
+	tor := addTestTorrent(t, src, mi)
+	if !waitFor(t, 5*time.Second, func() bool { return src.GetTorrents()[tor.InfoHash].BytesCompleted == 2*pieceLength }) {
+		t.Fatalf("expected half the torrent found, got %d bytes", src.GetTorrents()[tor.InfoHash].BytesCompleted)
+	}
+
+	rd, err := src.ExportResume(tor.InfoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rd.Pieces, []byte{0xc0}) {
+		t.Fatalf("expected pieces 0 and 1 in the bitfield, got %08b", rd.Pieces)
+	}
+	data, err := json.Marshal(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back ResumeData
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh engine downloading elsewhere finds the data at the save path
+	dst := newTestEngine(t)
+	if err := dst.ImportResume(&back); err != nil {
+		t.Fatal(err)
+	}
+	got := dst.GetTorrents()[tor.InfoHash]
+	if got == nil || got.BytesCompleted != 2*pieceLength {
+		t.Fatalf("expected progress kept, got %+v", got)
+	}
+	for i := range 4 {
+		if complete := got.t.PieceState(i).Complete; complete != back.HasPiece(i) {
+			t.Errorf("piece %d complete=%v, bitfield says %v", i, complete, back.HasPiece(i))
+		}
+	}
+	if got.DownloadDir != rd.SavePath {
+		t.Fatalf("expected data kept in %s, got %q", rd.SavePath, got.DownloadDir)
+	}
+
+	bad := back
+	bad.Pieces = []byte{0xc0, 0}
+	if err := newTestEngine(t).ImportResume(&bad); err == nil {
+		t.Error("expected a bitfield of the wrong length refused")
+	}
+	bad = back
+	bad.InfoHash = "0123456789abcdef0123456789abcdef01234567"
+	if err := newTestEngine(t).ImportResume(&bad); err == nil {
+		t.Error("expected info of another torrent refused")
+	}
+}
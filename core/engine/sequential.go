@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// sequentialWindow is how many of the lowest missing pieces are raised
+// above normal priority in sequential mode.
+const sequentialWindow = 8
+
+// SetSequential switches a torrent between sequential (lowest missing piece
+// first, for streaming) and the default rarest-first piece order.
+func (e *Engine) SetSequential(infohash string, on bool) error {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		e.mut.Unlock()
+		return err
+	}
+	if t.Sequential == on {
+		e.mut.Unlock()
+		return nil
+	}
+	t.Sequential = on
+	tt := t.t
+	if on {
+		t.stopSequential = make(chan struct{})
+		t.sequentialDone = make(chan struct{})
+		go e.runSequential(t.InfoHash, tt, t.stopSequential, t.sequentialDone)
+		e.mut.Unlock()
+		return nil
+	}
+	close(t.stopSequential)
+	done := t.sequentialDone
+	t.stopSequential, t.sequentialDone = nil, nil
+	e.mut.Unlock()
+	<-done
+	// file priorities alone decide what is downloaded again
+	if tt.Info() != nil {
+		for i := 0; i < tt.NumPieces(); i++ {
+			tt.Piece(i).SetPriority(types.PiecePriorityNone)
+		}
+	}
+	return nil
+}
+
+// runSequential keeps the sequential window on the lowest missing pieces
+// until stop is closed or the torrent is dropped.
+func (e *Engine) runSequential(infohash string, tt *torrent.Torrent, stop, done chan struct{}) {
+	defer close(done)
+	select {
+	case <-tt.GotInfo():
+	case <-stop:
+		return
+	case <-tt.Closed():
+		return
+	}
+	sub := tt.SubscribePieceStateChanges()
+	defer sub.Close()
+	for {
+		e.mut.Lock()
+		t := e.ts[infohash]
+		started := t != nil && t.Started && !t.SeedOnly
+		e.mut.Unlock()
+		if started {
+			applySequential(tt)
+		}
+		select {
+		case <-stop:
+			return
+		case <-tt.Closed():
+			return
+		case _, ok := <-sub.Values:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// applySequential gives the first missing piece "now" priority and the
+// next ones "readahead", so they are requested ahead of everything else.
+// Pieces that only belong to skipped files are passed over.
+func applySequential(tt *torrent.Torrent) {
+	files := tt.Files()
+	n := 0
+	for i, fs := range pieceFiles(tt) {
+		if n == sequentialWindow {
+			break
+		}
+		p := tt.Piece(i)
+		if p.State().Complete || !wanted(files, fs) {
+			continue
+		}
+		prio := types.PiecePriorityReadahead
+		if n == 0 {
+			prio = types.PiecePriorityNow
+		}
+		p.SetPriority(prio)
+		n++
+	}
+}
+
+// wanted reports whether any of the files is being downloaded.
+func wanted(files []*torrent.File, indexes []int) bool {
+	for _, i := range indexes {
+		if files[i].Priority() != types.PiecePriorityNone {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/types"
+)
+
+func TestSequential(t *testing.T) {
+	const pieceLength = 16384
+	const numPieces = sequentialWindow + 2
+	data := bytes.Repeat([]byte("intunja!"), numPieces*pieceLength/8)
+	mi, _ := newTestMetaInfo(t, "seq", map[string][]byte{"data.bin": data}, pieceLength)
+
+	// piece 0 is already on disk, so the window starts at piece 1
+	e := newTestEngineIn(t, newPartialDataDir(t, "seq", "data.bin", data, pieceLength, 0))
+	tor := addTestTorrent(t, e, mi)
+	if err := tor.t.VerifyData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	prio := func(i int) types.PiecePriority { return tor.t.Piece(i).State().Priority }
+
+	if err := e.SetSequential(tor.InfoHash, true); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return prio(1) == types.PiecePriorityNow }) {
+		t.Fatalf("first missing piece has priority %v, want now", prio(1))
+	}
+	for i := 2; i <= sequentialWindow; i++ {
+		if p := prio(i); p != types.PiecePriorityReadahead {
+			t.Errorf("piece %d has priority %v, want readahead", i, p)
+		}
+	}
+	if p := prio(numPieces - 1); p != types.PiecePriorityNormal {
+		t.Errorf("piece past the window has priority %v, want normal", p)
+	}
+
+	// toggling repeatedly must not block
+	for i := 0; i < 5; i++ {
+		if err := e.SetSequential(tor.InfoHash, i%2 == 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tor.Sequential {
+		t.Fatal("expected sequential mode off")
+	}
+	if p := prio(1); p != types.PiecePriorityNormal {
+		t.Fatalf("piece 1 has priority %v after disabling, want normal", p)
+	}
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// unixScheme prefixes RemoteEngine base URLs naming a Unix socket, as in
+// unix:///run/user/1000/intunja.sock.
+const unixScheme = "unix://"
+
+// ListenSocket listens for the daemon's API on the Unix socket at path,
+// which only the current user may connect to. A socket left behind by a
+// daemon that crashed is replaced; one still in use is an error.
+func ListenSocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another daemon", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict API socket: %w", err)
+	}
+	return l, nil
+}
+
+// dialSocket returns a dial function connecting to the Unix socket at path
+// whatever address it is asked for.
+func dialSocket(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// socketTransport is the transport of a RemoteEngine reaching the daemon
+// on the Unix socket at path.
+func socketTransport(path string) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = dialSocket(path)
+	return t
+}
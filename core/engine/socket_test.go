@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketAPI(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	token, err := ServerToken(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMemoryEngine()
+	ih, err := m.AddFake("fake", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "api.sock")
+	l, err := ListenSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: APIHandler(m, token)}
+	go srv.Serve(l)
+	defer srv.Close()
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket open to others: %v", fi.Mode())
+	}
+	if _, err := ListenSocket(path); err == nil {
+		t.Fatal("expected a socket in use refused")
+	}
+
+	r := NewRemoteEngine("unix://" + path)
+	if err := r.StartTorrent(ih); err != nil || !m.GetTorrents()[ih].Started {
+		t.Fatalf("expected the torrent started over the socket, got %v", err)
+	}
+	if err := r.StopTorrent(ih); err != nil || m.GetTorrents()[ih].Started {
+		t.Fatalf("expected the torrent stopped over the socket, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := r.StreamTorrents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := <-stream; ts[ih] == nil {
+		t.Fatalf("expected the torrent streamed over the socket, got %v", ts)
+	}
+
+	// a crashed daemon's socket is replaced
+	srv.Close()
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Type() != os.ModeSocket {
+		// closing the listener removed it, leave one behind by hand
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+	}
+	l, err = ListenSocket(path)
+	if err != nil {
+		t.Fatalf("expected a stale socket replaced, got %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,285 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamInterval is how often progress is sampled for /api/stream
+	// clients. Engine events are sent on without waiting for it.
+	streamInterval = time.Second
+	// streamHeartbeat is how often idle /api/stream connections are
+	// pinged, well within the idle timeouts of common proxies.
+	streamHeartbeat = 30 * time.Second
+	// streamWriteTimeout bounds each write to a stream client.
+	streamWriteTimeout = 10 * time.Second
+	// streamBuffer is how many deltas a client can fall behind by before
+	// it is disconnected, to resync on reconnecting.
+	streamBuffer = 16
+)
+
+// TorrentDelta is a message of /api/stream: the torrents added and updated
+// since the previous message, as JSON Torrents, and the info-hashes of
+// those removed. The first message of a stream adds every torrent.
+type TorrentDelta struct {
+	Added   map[string]json.RawMessage `json:"added,omitempty"`
+	Updated map[string]json.RawMessage `json:"updated,omitempty"`
+	Removed []string                   `json:"removed,omitempty"`
+}
+
+func (d TorrentDelta) empty() bool {
+	return len(d.Added) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// streamHub samples the engine for all /api/stream clients, so any number
+// of them cost one engine subscription and one GetTorrents per change.
+type streamHub struct {
+	e EngineInterface
+
+	mut     sync.Mutex
+	clients map[chan TorrentDelta]struct{}
+	last    map[string]json.RawMessage // each torrent as last sent
+	done    chan struct{}              // closed to stop run
+}
+
+// join returns a channel receiving deltas, starting with every torrent.
+// The channel is closed if the client falls behind.
+func (h *streamHub) join() chan TorrentDelta {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if len(h.clients) == 0 {
+		h.clients = map[chan TorrentDelta]struct{}{}
+		h.last = nil
+		h.sample()
+		h.done = make(chan struct{})
+		events, unsubscribe := h.e.Subscribe()
+		go h.run(events, unsubscribe, h.done)
+	}
+	ch := make(chan TorrentDelta, streamBuffer)
+	ch <- TorrentDelta{Added: maps.Clone(h.last)}
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+// leave ends the subscription of ch. The last client to leave stops the
+// sampling.
+func (h *streamHub) leave(ch chan TorrentDelta) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if _, ok := h.clients[ch]; !ok {
+		return // already dropped
+	}
+	delete(h.clients, ch)
+	if len(h.clients) == 0 {
+		close(h.done)
+	}
+}
+
+func (h *streamHub) run(events <-chan Event, unsubscribe func(), done chan struct{}) {
+	defer unsubscribe()
+	ticker := time.NewTicker(streamInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		case <-ticker.C:
+		}
+		h.mut.Lock()
+		select {
+		case <-done:
+			// the last client left while we waited
+		default:
+			h.broadcast(h.sample())
+		}
+		h.mut.Unlock()
+	}
+}
+
+// sample records the engine's torrents and returns what changed since the
+// previous sample. h.mut must be held.
+func (h *streamHub) sample() TorrentDelta {
+	var d TorrentDelta
+	cur := map[string]json.RawMessage{}
+	for ih, t := range h.e.GetTorrents() {
+		b, err := json.Marshal(t)
+		if err != nil {
+			continue
+		}
+		cur[ih] = b
+		prev, ok := h.last[ih]
+		switch {
+		case !ok:
+			setDelta(&d.Added, ih, b)
+		case string(prev) != string(b):
+			setDelta(&d.Updated, ih, b)
+		}
+	}
+	for ih := range h.last {
+		if _, ok := cur[ih]; !ok {
+			d.Removed = append(d.Removed, ih)
+		}
+	}
+	h.last = cur
+	return d
+}
+
+func setDelta(m *map[string]json.RawMessage, ih string, b json.RawMessage) {
+	if *m == nil {
+		*m = map[string]json.RawMessage{}
+	}
+	(*m)[ih] = b
+}
+
+// broadcast sends d to every client, dropping those with no room for it.
+// h.mut must be held.
+func (h *streamHub) broadcast(d TorrentDelta) {
+	if d.empty() {
+		return
+	}
+	for ch := range h.clients {
+		select {
+		case ch <- d:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+	if len(h.clients) == 0 {
+		close(h.done)
+	}
+}
+
+// StreamHandler serves GET /api/stream, a WebSocket pushing TorrentDelta
+// messages to RemoteEngine.StreamTorrents as torrents change.
+func StreamHandler(e EngineInterface) http.Handler {
+	hub := &streamHub{e: e}
+	var upgrader websocket.Upgrader
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade has replied
+		}
+		defer conn.Close()
+		deltas := hub.join()
+		defer hub.leave(deltas)
+
+		// clients send nothing, but reading handles their pongs and notices
+		// them hang up
+		gone := make(chan struct{})
+		conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(streamHeartbeat)
+		defer ping.Stop()
+		for {
+			select {
+			case d, ok := <-deltas:
+				if !ok {
+					msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow")
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteTimeout))
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := conn.WriteJSON(d); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			case <-gone:
+				return
+			}
+		}
+	})
+}
+
+// StreamTorrents follows the daemon's torrents over the /api/stream
+// WebSocket instead of polling GetTorrents. Each value received is every
+// torrent after an update. The channel is closed when ctx ends or the
+// connection is lost; callers can fall back to polling or stream again.
+func (r *RemoteEngine) StreamTorrents(ctx context.Context) (<-chan map[string]*Torrent, error) {
+	u, err := url.Parse(r.baseURL + "/api/stream")
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	header := http.Header{"Authorization": {"Bearer " + r.token}}
+	dialer := *websocket.DefaultDialer
+	if r.socket != "" {
+		dialer.NetDialContext = dialSocket(r.socket)
+	}
+	conn, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return nil, fmt.Errorf("stream failed: %w", err)
+	}
+	ch := make(chan map[string]*Torrent)
+	go func() {
+		defer close(ch)
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		// the daemon pings every streamHeartbeat, so a longer silence means
+		// the connection is gone
+		conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+		conn.SetPingHandler(func(data string) error {
+			conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(streamWriteTimeout))
+		})
+		ts := map[string]*Torrent{}
+		for {
+			var d TorrentDelta
+			if err := conn.ReadJSON(&d); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+			for _, changed := range []map[string]json.RawMessage{d.Added, d.Updated} {
+				for ih, b := range changed {
+					var t Torrent
+					if err := json.Unmarshal(b, &t); err == nil {
+						ts[ih] = &t
+					}
+				}
+			}
+			for _, ih := range d.Removed {
+				delete(ts, ih)
+			}
+			select {
+			case ch <- maps.Clone(ts):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamTorrents(t *testing.T) {
+	m := NewMemoryEngine()
+	m.Configure(Config{AutoStart: true})
+	first, err := m.AddFake("first", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(StreamHandler(m))
+	defer srv.Close()
+	r := NewRemoteEngine(srv.URL)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	s1, err := r.StreamTorrents(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	s2, err := r.StreamTorrents(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// next waits for an update of s matching cond
+	next := func(s <-chan map[string]*Torrent, what string, cond func(map[string]*Torrent) bool) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ts, ok := <-s:
+				if !ok {
+					t.Fatalf("stream closed waiting for %s", what)
+				}
+				if cond(ts) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	for _, s := range []<-chan map[string]*Torrent{s1, s2} {
+		next(s, "the initial torrents", func(ts map[string]*Torrent) bool {
+			return len(ts) == 1 && ts[first] != nil && ts[first].Name == "first"
+		})
+	}
+
+	second, err := m.AddFake("second", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []<-chan map[string]*Torrent{s1, s2} {
+		next(s, "the added torrent", func(ts map[string]*Torrent) bool {
+			return len(ts) == 2 && ts[second] != nil
+		})
+	}
+
+	// a client hanging up leaves the others streaming
+	cancel1()
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-s1:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("stream not closed after its context ended")
+		}
+	}
+
+	// progress arrives with the next sample, without an event
+	if err := m.SetProgress(first, 500); err != nil {
+		t.Fatal(err)
+	}
+	next(s2, "the progress", func(ts map[string]*Torrent) bool {
+		return ts[first] != nil && ts[first].Downloaded == 500
+	})
+	if err := m.DeleteTorrent(second, false); err != nil {
+		t.Fatal(err)
+	}
+	next(s2, "the removal", func(ts map[string]*Torrent) bool {
+		return len(ts) == 1 && ts[second] == nil
+	})
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TorrentSummary is a stable, read-only view of a torrent for listings.
+type TorrentSummary struct {
+	Name         string
+	InfoHash     string
+	Percent      float32
+	DownloadRate float32 // bytes per second
+	UploadRate   float32 // bytes per second
+	State        string  // "loading", "stopped", "seeding complete", "seed-only", "seeding" or "downloading"
+	Size         int64
+	Downloaded   int64
+	Ratio        float64       // uploaded/downloaded, InfiniteRatio if nothing was downloaded
+	ETA          time.Duration // zero when complete or unknown
+}
+
+// Summarize builds the summary of a single torrent.
+func Summarize(t *Torrent) TorrentSummary {
+	s := TorrentSummary{
+		Name:         t.Name,
+		InfoHash:     t.InfoHash,
+		Percent:      t.Percent,
+		DownloadRate: t.DownloadRate,
+		UploadRate:   t.UploadRate,
+		Size:         t.Size,
+		Downloaded:   t.Downloaded,
+		Ratio:        t.Ratio,
+	}
+	switch {
+	case !t.Loaded:
+		s.State = "loading"
+	case !t.Started && t.SeedingComplete:
+		s.State = "seeding complete"
+	case !t.Started:
+		s.State = "stopped"
+	case t.SeedOnly:
+		s.State = "seed-only"
+	case t.Size > 0 && t.BytesCompleted >= t.Size:
+		s.State = "seeding"
+	default:
+		s.State = "downloading"
+	}
+	if s.State == "downloading" && t.DownloadRate > 0 {
+		left := float64(t.Size - t.BytesCompleted)
+		s.ETA = time.Duration(left / float64(t.DownloadRate) * float64(time.Second))
+	}
+	return s
+}
+
+// SummarizeTorrents returns summaries of ts in canonical order: by name
+// (case-insensitive), then by info-hash.
+func SummarizeTorrents(ts map[string]*Torrent) []TorrentSummary {
+	out := make([]TorrentSummary, 0, len(ts))
+	for _, t := range ts {
+		if t == nil {
+			continue
+		}
+		out = append(out, Summarize(t))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := strings.ToLower(out[i].Name), strings.ToLower(out[j].Name)
+		if a != b {
+			return a < b
+		}
+		return out[i].InfoHash < out[j].InfoHash
+	})
+	return out
+}
+
+// TotalRatio returns the share ratio over all of ts: their summed uploads
+// over their summed downloads.
+func TotalRatio(ts map[string]*Torrent) float64 {
+	var up, down int64
+	for _, t := range ts {
+		if t != nil {
+			up += t.Uploaded
+			down += t.Downloaded
+		}
+	}
+	return ratio(up, down)
+}
+
+// ListTorrents returns summaries of all torrents in canonical order.
+func (e *Engine) ListTorrents() []TorrentSummary {
+	ts := e.GetTorrents()
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return SummarizeTorrents(ts)
+}
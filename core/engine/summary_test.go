@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarizeTorrents(t *testing.T) {
+	ts := map[string]*Torrent{
+		"b": {InfoHash: "b", Name: "bravo", Loaded: true, Started: true, Size: 1000, Downloaded: 500, BytesCompleted: 500, Percent: 50, DownloadRate: 100, UploadRate: 10},
+		"a": {InfoHash: "a", Name: "Alpha", Loaded: true, Started: true, Size: 1000, Downloaded: 1000, BytesCompleted: 1000, Percent: 100},
+		"c": {InfoHash: "c", Name: "charlie", Loaded: true},
+		"d": {InfoHash: "d", Name: "delta"},
+		"e": nil,
+	}
+	got := SummarizeTorrents(ts)
+	if len(got) != 4 {
+		t.Fatalf("expected 4 summaries, got %d", len(got))
+	}
+	order := []string{"a", "b", "c", "d"}
+	states := []string{"seeding", "downloading", "stopped", "loading"}
+	for i, s := range got {
+		if s.InfoHash != order[i] || s.State != states[i] {
+			t.Fatalf("summary %d: expected %s/%s, got %s/%s", i, order[i], states[i], s.InfoHash, s.State)
+		}
+		src := ts[s.InfoHash]
+		if s.Name != src.Name || s.Size != src.Size || s.Downloaded != src.Downloaded || s.Percent != src.Percent ||
+			s.DownloadRate != src.DownloadRate || s.UploadRate != src.UploadRate {
+			t.Fatalf("summary %+v does not match torrent %+v", s, src)
+		}
+	}
+	if got[1].ETA != 5*time.Second {
+		t.Fatalf("expected 5s ETA, got %v", got[1].ETA)
+	}
+	if got[0].ETA != 0 {
+		t.Fatalf("expected no ETA for a complete torrent, got %v", got[0].ETA)
+	}
+}
+
+func TestTotalRatio(t *testing.T) {
+	ts := map[string]*Torrent{
+		"a": {Uploaded: 1500, Downloaded: 1000},
+		"b": {Uploaded: 500, Downloaded: 3000},
+		"c": nil,
+	}
+	if r := TotalRatio(ts); r != 0.5 {
+		t.Fatalf("expected total ratio 0.5, got %v", r)
+	}
+	if r := TotalRatio(map[string]*Torrent{"a": {Uploaded: 1}}); r != InfiniteRatio {
+		t.Fatalf("expected infinite ratio with nothing downloaded, got %v", r)
+	}
+	if r := TotalRatio(nil); r != 0 {
+		t.Fatalf("expected zero ratio without torrents, got %v", r)
+	}
+}
@@ -1,24 +1,77 @@
 package engine
 
 import (
+	"slices"
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/storage"
 )
 
 type Torrent struct {
-	InfoHash     string
-	Name         string
-	Loaded       bool
-	Downloaded   int64
-	Size         int64
-	Files        []*File
-	Started      bool
-	Dropped      bool
-	Percent      float32
-	DownloadRate float32
-	t            *torrent.Torrent
-	updatedAt    time.Time
+	InfoHash   string
+	Name       string
+	Loaded     bool
+	Downloaded int64   // over all sessions, see downloadedBase
+	Uploaded   int64   // over all sessions, see uploadedBase
+	Ratio      float64 // Uploaded/Downloaded, InfiniteRatio if uploading with nothing downloaded
+	Size       int64
+	Files      []*File
+	Started    bool
+	SeedOnly   bool
+	Private    bool // BEP 27: no DHT or PEX
+	Sequential bool // download pieces in order, for streaming
+	Dropped    bool
+	// stopped after seeding past Config.SeedRatioLimit
+	SeedingComplete bool
+	// stopped by PauseAll, to be restarted by ResumeAll
+	PausedAll bool
+	// stopped while its disk was nearly full, restarted once space is
+	// freed, see Config.MinFreeSpace
+	PausedDiskFull bool
+	Percent        float32
+	BytesCompleted int64 // verified data, including data on disk when added
+	DownloadRate   float32
+	UploadRate     float32
+	Seeds          int
+	Leechers       int
+	DHTPeers       int // connected peers found through the DHT
+	AddedAt        time.Time
+	CompletedAt    time.Time
+	OnComplete     CompletionPolicy
+	Trackers       []string // announce URLs, tier by tier
+	Warnings       []string // problems found while adding, such as dropped trackers
+	Labels         []string // sorted, see SetLabels
+	DownloadDir    string   // set when the data is not in the download directory
+	// rate limits in bytes per second, 0 for unlimited, see SetTorrentLimits
+	MaxDownloadRate int64
+	MaxUploadRate   int64
+	t               *torrent.Torrent
+	store           storage.ClientImplCloser // storage for DownloadDir
+	updatedAt       time.Time
+	rates           rateHistory // see RateHistory
+	// downloaded and uploaded in earlier sessions, restored by the persister
+	downloadedBase int64
+	uploadedBase   int64
+	header         header // see InspectTorrent
+	// when each tracker may be announced to again, see ReannounceTorrent
+	reannounceAt map[string]time.Time
+	// last DHT lookup and whether it is still running, see refreshDHT
+	lastDHTLookup time.Time
+	dhtLookup     bool
+	// the peers seen by the last idle check, see disconnectIdlePeers
+	peerActivity map[*torrent.PeerConn]peerActivity
+	// closed once the last start or stop was sent to the trackers, see
+	// notifyTrackers
+	notified chan struct{}
+	// closed to stop enforcing the rate limits
+	stopLimits chan struct{}
+	// closed to stop the sequential window goroutine, which then closes done
+	stopSequential chan struct{}
+	sequentialDone chan struct{}
+	// closed and renewed when t is swapped for a new torrent, and closed
+	// when the torrent is deleted, see replacement
+	replaced chan struct{}
 }
 
 type File struct {
@@ -28,14 +81,17 @@ type File struct {
 	Chunks    int
 	Completed int
 	//cloud torrent
-	Started bool
-	Percent float32
-	f       *torrent.File
+	Started  bool
+	Priority FilePriority
+	Percent  float32
+	f        *torrent.File
 }
 
 func (torrent *Torrent) Update(t *torrent.Torrent) {
 	torrent.Name = t.Name()
 	torrent.Loaded = t.Info() != nil
+	mi := t.Metainfo()
+	torrent.Trackers = flattenTrackers(mi.UpvertedAnnounceList())
 	if torrent.Loaded {
 		torrent.updateLoaded(t)
 	}
@@ -43,8 +99,14 @@ func (torrent *Torrent) Update(t *torrent.Torrent) {
 }
 
 func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
-
+	if blankName(t.Info()) {
+		torrent.Name = dataName(t.Info(), t.InfoHash())
+		if !slices.Contains(torrent.Warnings, noNameWarning) {
+			torrent.Warnings = append(torrent.Warnings, noNameWarning)
+		}
+	}
 	torrent.Size = t.Length()
+	torrent.Private = isPrivate(t.Info())
 	totalChunks := 0
 	totalCompleted := 0
 
@@ -82,16 +144,90 @@ func (torrent *Torrent) updateLoaded(t *torrent.Torrent) {
 	now := time.Now()
 	bytes := t.BytesCompleted()
 	torrent.Percent = percent(bytes, torrent.Size)
+	stats := t.Stats()
+	downloaded := torrent.downloadedBase + stats.BytesReadUsefulData.Int64()
 	if !torrent.updatedAt.IsZero() {
 		dt := float32(now.Sub(torrent.updatedAt))
-		db := float32(bytes - torrent.Downloaded)
-		rate := db * (float32(time.Second) / dt)
+		rate := float32(downloaded-torrent.Downloaded) * (float32(time.Second) / dt)
 		if rate >= 0 {
 			torrent.DownloadRate = rate
 		}
 	}
-	torrent.Downloaded = bytes
+	uploaded := torrent.uploadedBase + stats.BytesWrittenData.Int64()
+	if !torrent.updatedAt.IsZero() {
+		dt := float32(now.Sub(torrent.updatedAt))
+		rate := float32(uploaded-torrent.Uploaded) * (float32(time.Second) / dt)
+		if rate >= 0 {
+			torrent.UploadRate = rate
+		}
+	}
+	torrent.BytesCompleted = bytes
+	torrent.Downloaded = downloaded
+	torrent.Uploaded = uploaded
+	torrent.Ratio = ratio(uploaded, downloaded)
 	torrent.updatedAt = now
+	if torrent.CompletedAt.IsZero() && torrent.Size > 0 && bytes == torrent.Size {
+		if stats.BytesReadUsefulData.Int64() == 0 {
+			//nothing was downloaded, the data was already complete when added
+			torrent.CompletedAt = torrent.AddedAt
+		} else {
+			torrent.CompletedAt = now
+		}
+	}
+
+	//split connected peers into seeds and leechers
+	conns := t.PeerConns()
+	pieceCounts := make([]int, len(conns))
+	torrent.DHTPeers = 0
+	for i, pc := range conns {
+		pieceCounts[i] = pc.Stats().RemotePieceCount
+		if isDHTPeer(pc) {
+			torrent.DHTPeers++
+		}
+	}
+	torrent.Seeds, torrent.Leechers = countSeeds(pieceCounts, t.NumPieces())
+}
+
+// flattenTrackers lists the trackers of all tiers in order, without
+// duplicates.
+func flattenTrackers(tiers [][]string) []string {
+	var trackers []string
+	for _, tier := range tiers {
+		for _, tr := range tier {
+			if !slices.Contains(trackers, tr) {
+				trackers = append(trackers, tr)
+			}
+		}
+	}
+	return trackers
+}
+
+// countSeeds splits peers into seeds (peers holding every piece) and
+// leechers, given how many pieces each peer has.
+func countSeeds(pieceCounts []int, numPieces int) (seeds, leechers int) {
+	for _, n := range pieceCounts {
+		if numPieces > 0 && n >= numPieces {
+			seeds++
+		} else {
+			leechers++
+		}
+	}
+	return seeds, leechers
+}
+
+// InfiniteRatio is the share ratio of uploading without having downloaded
+// anything. It stands in for +Inf, which JSON can't encode.
+const InfiniteRatio = -1
+
+// ratio returns the share ratio uploaded/downloaded, or InfiniteRatio.
+func ratio(uploaded, downloaded int64) float64 {
+	if downloaded == 0 {
+		if uploaded > 0 {
+			return InfiniteRatio
+		}
+		return 0
+	}
+	return float64(uploaded) / float64(downloaded)
 }
 
 func percent(n, total int64) float32 {
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCountSeeds(t *testing.T) {
+	seeds, leechers := countSeeds([]int{10, 0, 10, 3, 9, 10}, 10)
+	if seeds != 3 || leechers != 3 {
+		t.Fatalf("expected 3 seeds and 3 leechers, got %d/%d", seeds, leechers)
+	}
+}
+
+func TestCountSeedsNoPieces(t *testing.T) {
+	// without info every peer counts as a leecher
+	seeds, leechers := countSeeds([]int{0, 0}, 0)
+	if seeds != 0 || leechers != 2 {
+		t.Fatalf("expected 0 seeds and 2 leechers, got %d/%d", seeds, leechers)
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		uploaded, downloaded int64
+		want                 float64
+	}{
+		{0, 0, 0},
+		{0, 1000, 0},
+		{500, 1000, 0.5},
+		{3000, 1000, 3},
+		{10, 0, InfiniteRatio},
+	}
+	for _, tt := range tests {
+		if got := ratio(tt.uploaded, tt.downloaded); got != tt.want {
+			t.Errorf("ratio(%d, %d) = %v, want %v", tt.uploaded, tt.downloaded, got, tt.want)
+		}
+	}
+}
+
+func TestInfiniteRatioJSON(t *testing.T) {
+	in := &Torrent{InfoHash: "a", Uploaded: 10, Ratio: ratio(10, 0)}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("expected an infinite ratio to marshal: %v", err)
+	}
+	var out Torrent
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Ratio != InfiniteRatio {
+		t.Fatalf("expected InfiniteRatio back, got %v", out.Ratio)
+	}
+	if _, err := json.Marshal(Summarize(in)); err != nil {
+		t.Fatalf("expected the summary to marshal: %v", err)
+	}
+}
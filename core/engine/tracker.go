@@ -0,0 +1,434 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// minReannounceInterval is the least ReannounceTorrent waits between forced
+// announces to the same tracker, so impatient users can't hammer trackers.
+// Trackers that ask for a longer interval get it, and trackers that fail to
+// answer are retried after this.
+const minReannounceInterval = time.Minute
+
+// ErrReannounceTooSoon is returned when a torrent is reannounced before any
+// of its trackers' intervals have passed.
+var ErrReannounceTooSoon = errors.New("torrent was reannounced too recently, wait for the tracker interval")
+
+// ScrapeResult holds the swarm counts a tracker reports for one torrent.
+type ScrapeResult struct {
+	Tracker    string
+	Complete   int // seeders
+	Downloaded int // completed downloads
+	Incomplete int // leechers
+}
+
+// TrackerClient talks to a single HTTP(S) tracker.
+type TrackerClient struct {
+	Announce string
+	Client   *http.Client
+}
+
+// NewTrackerClient returns a client for the given announce URL.
+func NewTrackerClient(announce string) *TrackerClient {
+	return &TrackerClient{
+		Announce: announce,
+		Client:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// ScrapeURL derives the scrape URL from the announce URL by replacing the
+// last "announce" path segment with "scrape". Trackers whose announce path
+// does not follow that convention don't support scraping.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("scrape not supported for %s trackers", u.Scheme)
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", fmt.Errorf("tracker %s does not support scrape", announce)
+	}
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// escapeBytes percent-encodes every byte of b. url.Values would leave
+// unreserved bytes as-is and turn 0x20 into '+', which strict trackers
+// decode differently from the raw info-hash.
+func escapeBytes(b []byte) string {
+	const hex = "0123456789ABCDEF"
+	out := make([]byte, 0, len(b)*3)
+	for _, c := range b {
+		out = append(out, '%', hex[c>>4], hex[c&0xf])
+	}
+	return string(out)
+}
+
+type scrapeResponse struct {
+	Files         map[string]scrapeFile `bencode:"files"`
+	FailureReason string                `bencode:"failure reason"`
+}
+
+type scrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
+}
+
+// Scrape asks the tracker for the swarm counts of a single info-hash.
+func (c *TrackerClient) Scrape(ctx context.Context, ih metainfo.Hash) (ScrapeResult, error) {
+	su, err := ScrapeURL(c.Announce)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	u, _ := url.Parse(su)
+	query := "info_hash=" + escapeBytes(ih[:])
+	if u.RawQuery != "" {
+		query = u.RawQuery + "&" + query
+	}
+	u.RawQuery = query
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ScrapeResult{}, fmt.Errorf("scrape failed: %s", resp.Status)
+	}
+	var sr scrapeResponse
+	if err := bencode.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return ScrapeResult{}, fmt.Errorf("invalid scrape response: %w", err)
+	}
+	if sr.FailureReason != "" {
+		return ScrapeResult{}, fmt.Errorf("scrape failed: %s", sr.FailureReason)
+	}
+	f, ok := sr.Files[string(ih[:])]
+	if !ok {
+		return ScrapeResult{}, fmt.Errorf("tracker has no entry for %s", ih.HexString())
+	}
+	return ScrapeResult{
+		Tracker:    c.Announce,
+		Complete:   f.Complete,
+		Downloaded: f.Downloaded,
+		Incomplete: f.Incomplete,
+	}, nil
+}
+
+// Scrape returns the swarm counts from the first of the torrent's trackers
+// that answers a scrape request.
+func (e *Engine) Scrape(infohash string) (ScrapeResult, error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	var trackers [][]string
+	if err == nil {
+		mi := t.t.Metainfo()
+		trackers = mi.UpvertedAnnounceList()
+	}
+	proxyURL := e.proxy()
+	e.mut.Unlock()
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	ih := t.t.InfoHash()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	errs := []error{}
+	for _, tier := range trackers {
+		for _, tr := range tier {
+			tc := NewTrackerClient(tr)
+			tc.Client.Transport = proxyTransport(proxyURL)
+			r, err := tc.Scrape(ctx, ih)
+			if err == nil {
+				return r, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return ScrapeResult{}, errors.New("torrent has no trackers")
+	}
+	return ScrapeResult{}, errors.Join(errs...)
+}
+
+// SwarmEstimate is an estimate of the size of a torrent's whole swarm,
+// including the peers we aren't connected to.
+type SwarmEstimate struct {
+	Size       int            // estimated number of seeders and leechers
+	Scrapes    []ScrapeResult // the trackers that answered a scrape
+	KnownPeers int            // distinct peers found by trackers, DHT and PEX
+}
+
+// estimateSwarm combines the scrape counts of several trackers with the
+// peers we know of. Trackers of one torrent mostly see the same peers, and
+// neither they nor the known peers can be matched up against each other, so
+// the largest of the counts is taken rather than their sum.
+func estimateSwarm(scrapes []ScrapeResult, knownPeers int) int {
+	size := knownPeers
+	for _, r := range scrapes {
+		size = max(size, r.Complete+r.Incomplete)
+	}
+	return size
+}
+
+// SwarmSize scrapes all of the torrent's trackers and estimates the size of
+// its swarm from their counts and the peers found so far. Trackers that
+// fail to answer are left out.
+func (e *Engine) SwarmSize(infohash string) (SwarmEstimate, error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	var trackers [][]string
+	var tt *torrent.Torrent
+	if err == nil {
+		tt = t.t
+		mi := tt.Metainfo()
+		trackers = mi.UpvertedAnnounceList()
+	}
+	proxyURL := e.proxy()
+	e.mut.Unlock()
+	if err != nil {
+		return SwarmEstimate{}, err
+	}
+	ih := tt.InfoHash()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		se SwarmEstimate
+	)
+	for _, tier := range trackers {
+		for _, tr := range tier {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tc := NewTrackerClient(tr)
+				tc.Client.Transport = proxyTransport(proxyURL)
+				r, err := tc.Scrape(ctx, ih)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				se.Scrapes = append(se.Scrapes, r)
+				mu.Unlock()
+			}()
+		}
+	}
+	// a peer can be both pending and connected, or found by several sources
+	known := map[string]bool{}
+	for _, p := range tt.KnownSwarm() {
+		if p.Addr != nil {
+			known[p.Addr.String()] = true
+		}
+	}
+	wg.Wait()
+	se.KnownPeers = len(known)
+	se.Size = estimateSwarm(se.Scrapes, se.KnownPeers)
+	return se, nil
+}
+
+// ReannounceTorrent announces the torrent to its HTTP and UDP trackers right
+// away instead of waiting for the next regular announce, and adds the peers
+// they return. Trackers whose interval since the last forced announce hasn't
+// passed are skipped. WebSocket trackers are left to the client.
+func (e *Engine) ReannounceTorrent(infohash string) error {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	if err != nil {
+		e.mut.Unlock()
+		return err
+	}
+	tt := t.t
+	client := e.clientOf(tt)
+	proxyURL := e.proxy()
+	all := announceTrackers(tt)
+	now := time.Now()
+	var trackers []string
+	for _, tr := range all {
+		if !now.Before(t.reannounceAt[tr]) {
+			trackers = append(trackers, tr)
+		}
+	}
+	if t.reannounceAt == nil {
+		t.reannounceAt = map[string]time.Time{}
+	}
+	// hold the trackers back while the announce is in flight
+	for _, tr := range trackers {
+		t.reannounceAt[tr] = now.Add(minReannounceInterval)
+	}
+	e.mut.Unlock()
+	if len(all) == 0 {
+		return errors.New("torrent has no trackers to announce to")
+	}
+	if len(trackers) == 0 {
+		return ErrReannounceTooSoon
+	}
+
+	req := announceRequest(client, tt, tracker.None)
+	ctx, cancel := context.WithTimeout(context.Background(), tracker.DefaultTrackerAnnounceTimeout)
+	defer cancel()
+	peers, intervals, errs := announceAll(ctx, trackers, req, proxyURL)
+	tt.AddPeers(peers)
+	e.mut.Lock()
+	for tr, interval := range intervals {
+		t.reannounceAt[tr] = now.Add(max(interval, minReannounceInterval))
+	}
+	e.mut.Unlock()
+	if len(errs) == len(trackers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// notifyTrackers tells the torrent's HTTP and UDP trackers in the background
+// that it started or stopped, so they count it in or out of the swarm now
+// rather than at its next regular announce. Notifications for a torrent are
+// sent in the order they were made. tt must still be open, so a stop is
+// notified before dropping the torrent.
+func (e *Engine) notifyTrackers(t *Torrent, event tracker.AnnounceEvent) {
+	tt := t.t
+	trackers := announceTrackers(tt)
+	if len(trackers) == 0 {
+		return
+	}
+	req := announceRequest(e.clientOf(tt), tt, event)
+	proxyURL := e.proxy()
+	prev, done := t.notified, make(chan struct{})
+	t.notified = done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tracker.DefaultTrackerAnnounceTimeout)
+		defer cancel()
+		peers, _, errs := announceAll(ctx, trackers, req, proxyURL)
+		for _, err := range errs {
+			log.Printf("announce %s %s: %v", event, t.InfoHash, err)
+		}
+		if event == tracker.Started {
+			tt.AddPeers(peers)
+		}
+	}()
+}
+
+// clientOf returns the client tt was added to.
+func (e *Engine) clientOf(tt *torrent.Torrent) *torrent.Client {
+	if e.private != nil {
+		if pt, ok := e.private.Torrent(tt.InfoHash()); ok && pt == tt {
+			return e.private
+		}
+	}
+	return e.client
+}
+
+// announceTrackers returns the torrent's HTTP and UDP trackers.
+func announceTrackers(tt *torrent.Torrent) []string {
+	mi := tt.Metainfo()
+	var trackers []string
+	for _, tier := range mi.UpvertedAnnounceList() {
+		for _, tr := range tier {
+			if u, err := url.Parse(tr); err == nil && u.Scheme != "ws" && u.Scheme != "wss" {
+				trackers = append(trackers, tr)
+			}
+		}
+	}
+	return trackers
+}
+
+func announceRequest(client *torrent.Client, tt *torrent.Torrent, event tracker.AnnounceEvent) tracker.AnnounceRequest {
+	stats := tt.Stats()
+	left := int64(-1)
+	if tt.Info() != nil {
+		left = tt.BytesMissing()
+	}
+	return tracker.AnnounceRequest{
+		InfoHash:   tt.InfoHash(),
+		PeerId:     client.PeerID(),
+		Downloaded: stats.BytesReadUsefulData.Int64(),
+		Uploaded:   stats.BytesWrittenData.Int64(),
+		Left:       left,
+		Event:      event,
+		NumWant:    200,
+		Port:       uint16(client.LocalPort()),
+	}
+}
+
+// announceAll sends req to all trackers at once, through proxyURL unless it
+// is nil, and returns the peers they gave, the announce intervals of those
+// that answered and the errors of those that failed.
+func announceAll(ctx context.Context, trackers []string, req tracker.AnnounceRequest, proxyURL *url.URL) ([]torrent.PeerInfo, map[string]time.Duration, []error) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		peers     []torrent.PeerInfo
+		intervals = map[string]time.Duration{}
+		errs      []error
+	)
+	for _, tr := range trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := announce(ctx, tr, req, proxyURL)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", tr, err))
+				return
+			}
+			peers = append(peers, trackerPeers(resp)...)
+			intervals[tr] = time.Duration(resp.Interval) * time.Second
+		}()
+	}
+	wg.Wait()
+	return peers, intervals, errs
+}
+
+// trackerPeers returns the peers of an announce response.
+func trackerPeers(resp tracker.AnnounceResponse) []torrent.PeerInfo {
+	peers := make([]torrent.PeerInfo, len(resp.Peers))
+	for i, p := range resp.Peers {
+		peers[i] = torrent.PeerInfo{
+			Addr:   &net.TCPAddr{IP: p.IP, Port: p.Port},
+			Source: torrent.PeerSourceTracker,
+		}
+	}
+	return peers
+}
+
+func announce(ctx context.Context, announceURL string, req tracker.AnnounceRequest, proxyURL *url.URL) (tracker.AnnounceResponse, error) {
+	opts := tracker.NewClientOpts{}
+	if proxyURL != nil {
+		if u, err := url.Parse(announceURL); err == nil && strings.HasPrefix(u.Scheme, "udp") {
+			return tracker.AnnounceResponse{}, errProxyUDP
+		}
+		opts.Http.Proxy = http.ProxyURL(proxyURL)
+	}
+	c, err := tracker.NewClient(announceURL, opts)
+	if err != nil {
+		return tracker.AnnounceResponse{}, err
+	}
+	defer c.Close()
+	return c.Announce(ctx, req, tracker.AnnounceOpt{})
+}
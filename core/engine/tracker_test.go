@@ -0,0 +1,319 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestScrapeURL(t *testing.T) {
+	tests := []struct {
+		announce string
+		want     string
+		wantErr  bool
+	}{
+		{"http://example.com/announce", "http://example.com/scrape", false},
+		{"http://example.com/x/announce", "http://example.com/x/scrape", false},
+		{"http://example.com/announce.php", "http://example.com/scrape.php", false},
+		{"http://example.com/announce?passkey=abc", "http://example.com/scrape?passkey=abc", false},
+		{"http://example.com/a", "", true},
+		{"http://example.com/announce/x", "", true},
+		{"udp://example.com:80/announce", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ScrapeURL(tt.announce)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ScrapeURL(%q) error = %v, wantErr %v", tt.announce, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ScrapeURL(%q) = %q, want %q", tt.announce, got, tt.want)
+		}
+	}
+}
+
+func TestEngineScrape(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "scrape", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+	ih := mi.HashInfoBytes()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(ih[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		bencode.NewEncoder(w).Encode(map[string]any{
+			"files": map[string]any{
+				string(ih[:]): map[string]int{"complete": 5, "downloaded": 12, "incomplete": 3},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	mi.Announce = srv.URL + "/announce"
+	addTestTorrent(t, e, mi)
+
+	r, err := e.Scrape(ih.HexString())
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	if r.Complete != 5 || r.Downloaded != 12 || r.Incomplete != 3 {
+		t.Fatalf("unexpected scrape result: %+v", r)
+	}
+	if r.Tracker != mi.Announce {
+		t.Fatalf("expected tracker %q, got %q", mi.Announce, r.Tracker)
+	}
+}
+
+func TestSwarmSize(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "swarm", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+	ih := mi.HashInfoBytes()
+	scrapeServer := func(complete, incomplete int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/scrape" {
+				http.NotFound(w, r)
+				return
+			}
+			bencode.NewEncoder(w).Encode(map[string]any{
+				"files": map[string]any{
+					string(ih[:]): map[string]int{"complete": complete, "incomplete": incomplete},
+				},
+			})
+		}))
+	}
+	small := scrapeServer(4, 2)
+	defer small.Close()
+	large := scrapeServer(10, 5)
+	defer large.Close()
+
+	mi.AnnounceList = [][]string{{small.URL + "/announce"}, {large.URL + "/announce", "http://127.0.0.1:1/announce"}}
+	addTestTorrent(t, e, mi)
+
+	se, err := e.SwarmSize(ih.HexString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(se.Scrapes) != 2 {
+		t.Fatalf("expected the two answering trackers, got %+v", se.Scrapes)
+	}
+	// the trackers overlap, so the larger count stands for both
+	if se.Size != 15 {
+		t.Fatalf("expected a swarm of 15, got %+v", se)
+	}
+	if got := estimateSwarm(se.Scrapes, 20); got != 20 {
+		t.Fatalf("expected more known peers to raise the estimate, got %d", got)
+	}
+}
+
+func TestReannounceTorrent(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "announce", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+	ih := mi.HashInfoBytes()
+
+	var announces atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/announce" || r.URL.Query().Get("info_hash") != string(ih[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		announces.Add(1)
+		bencode.NewEncoder(w).Encode(map[string]any{"interval": 1800, "peers": ""})
+	}))
+	defer srv.Close()
+
+	// the test client has its own announcing disabled, so every request
+	// the tracker sees is a forced one
+	mi.Announce = srv.URL + "/announce"
+	tor := addTestTorrent(t, e, mi)
+
+	if err := e.ReannounceTorrent(tor.InfoHash); err != nil {
+		t.Fatalf("reannounce failed: %v", err)
+	}
+	if n := announces.Load(); n != 1 {
+		t.Fatalf("tracker saw %d announces, want 1", n)
+	}
+	if err := e.ReannounceTorrent(tor.InfoHash); err != ErrReannounceTooSoon {
+		t.Fatalf("expected ErrReannounceTooSoon, got %v", err)
+	}
+	if n := announces.Load(); n != 1 {
+		t.Fatalf("tracker saw %d announces after a refused reannounce, want 1", n)
+	}
+}
+
+func TestReannounceHonorsTrackerInterval(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "interval", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+
+	tracker := func(interval int, announces *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			announces.Add(1)
+			bencode.NewEncoder(w).Encode(map[string]any{"interval": interval, "peers": ""})
+		}))
+	}
+	var slowAnnounces, fastAnnounces atomic.Int32
+	slow := tracker(1800, &slowAnnounces)
+	defer slow.Close()
+	// asks for less than minReannounceInterval, which still applies
+	fast := tracker(10, &fastAnnounces)
+	defer fast.Close()
+
+	mi.AnnounceList = [][]string{{slow.URL + "/announce"}, {fast.URL + "/announce"}}
+	tor := addTestTorrent(t, e, mi)
+
+	if err := e.ReannounceTorrent(tor.InfoHash); err != nil {
+		t.Fatalf("reannounce failed: %v", err)
+	}
+	if err := e.ReannounceTorrent(tor.InfoHash); err != ErrReannounceTooSoon {
+		t.Fatalf("expected ErrReannounceTooSoon within the floor, got %v", err)
+	}
+
+	// pretend two minutes have passed
+	e.mut.Lock()
+	for tr, at := range tor.reannounceAt {
+		tor.reannounceAt[tr] = at.Add(-2 * time.Minute)
+	}
+	e.mut.Unlock()
+	if err := e.ReannounceTorrent(tor.InfoHash); err != nil {
+		t.Fatalf("reannounce failed: %v", err)
+	}
+	if n := fastAnnounces.Load(); n != 2 {
+		t.Fatalf("fast tracker saw %d announces, want 2", n)
+	}
+	if n := slowAnnounces.Load(); n != 1 {
+		t.Fatalf("slow tracker saw %d announces within its interval, want 1", n)
+	}
+}
+
+func TestPauseResumeNotifiesTrackers(t *testing.T) {
+	e := newTestEngine(t)
+	mi, _ := newTestMetaInfo(t, "notify", map[string][]byte{"a.bin": make([]byte, 32<<10)}, 16<<10)
+
+	var mu sync.Mutex
+	var events []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.URL.Query().Get("event"))
+		mu.Unlock()
+		bencode.NewEncoder(w).Encode(map[string]any{"interval": 1800, "peers": ""})
+	}))
+	defer srv.Close()
+	seen := func(want ...string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Equal(events, want)
+	}
+
+	// the test client doesn't announce by itself, so the tracker only sees
+	// the engine's notifications
+	mi.Announce = srv.URL + "/announce"
+	tor := addTestTorrent(t, e, mi)
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StopTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartTorrent(tor.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return seen("started", "stopped", "started") }) {
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("expected started, stopped, started; tracker saw %q", events)
+	}
+	// resuming brought the torrent back
+	if tt, ok := e.client.Torrent(mi.HashInfoBytes()); !ok || tt != tor.t || isClosed(tt.Closed()) {
+		t.Fatal("expected the torrent added back to the client")
+	}
+}
+
+func TestScrapeEncodesInfoHash(t *testing.T) {
+	var ih metainfo.Hash
+	copy(ih[:], "AZaz09-_.~ +%&=\x00\x01\xfe\xff")
+	const want = "info_hash=%41%5A%61%7A%30%39%2D%5F%2E%7E%20%2B%25%26%3D%00%01%FE%FF%00"
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+		bencode.NewEncoder(w).Encode(map[string]any{
+			"files": map[string]any{
+				string(ih[:]): map[string]int{"complete": 1},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	r, err := NewTrackerClient(srv.URL+"/announce").Scrape(context.Background(), ih)
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	if got != want {
+		t.Fatalf("query = %q, want %q", got, want)
+	}
+	if r.Complete != 1 {
+		t.Fatalf("unexpected scrape result: %+v", r)
+	}
+}
+
+func TestScrapeIPv6Literal(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	var ih metainfo.Hash
+	copy(ih[:], "ipv6-literal-tracker")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bencode.NewEncoder(w).Encode(map[string]any{
+			"files": map[string]any{
+				string(ih[:]): map[string]int{"complete": 2, "incomplete": 1},
+			},
+		})
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	announce := "http://" + l.Addr().String() + "/announce"
+	if !strings.HasPrefix(announce, "http://[::1]:") {
+		t.Fatalf("expected a bracketed IPv6 announce URL, got %q", announce)
+	}
+	su, err := ScrapeURL(announce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://" + l.Addr().String() + "/scrape"; su != want {
+		t.Fatalf("ScrapeURL = %q, want %q", su, want)
+	}
+
+	safe, dropped, err := SanitizeMagnet(testMagnet + "&tr=" + url.QueryEscape(announce))
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("IPv6 tracker not kept: dropped=%v err=%v", dropped, err)
+	}
+	m, err := metainfo.ParseMagnetUri(safe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Trackers) != 1 || m.Trackers[0] != announce {
+		t.Fatalf("IPv6 tracker changed by sanitizing: %v", m.Trackers)
+	}
+
+	r, err := NewTrackerClient(m.Trackers[0]).Scrape(context.Background(), ih)
+	if err != nil {
+		t.Fatalf("scrape over IPv6 failed: %v", err)
+	}
+	if r.Complete != 2 || r.Incomplete != 1 {
+		t.Fatalf("unexpected scrape result: %+v", r)
+	}
+}
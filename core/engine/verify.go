@@ -0,0 +1,69 @@
+package engine
+
+import "fmt"
+
+// FileVerify is the verification outcome for one file of a torrent. Pieces
+// that straddle a file boundary are counted against every file they touch.
+type FileVerify struct {
+	Path       string
+	GoodPieces int
+	BadPieces  int
+}
+
+// VerifyResult reports a full hash check of a torrent's data.
+type VerifyResult struct {
+	InfoHash    string
+	TotalPieces int
+	ValidPieces int
+	Files       []FileVerify
+}
+
+// Damaged returns the files that have at least one bad piece.
+func (r *VerifyResult) Damaged() []FileVerify {
+	var out []FileVerify
+	for _, f := range r.Files {
+		if f.BadPieces > 0 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// VerifyReport rehashes every piece of the torrent and reports which pieces,
+// and which files, hold valid data. It blocks until verification finishes.
+func (e *Engine) VerifyReport(infohash string) (*VerifyResult, error) {
+	e.mut.Lock()
+	t, err := e.getOpenTorrent(infohash)
+	e.mut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if t.t.Info() == nil {
+		return nil, fmt.Errorf("torrent metadata not yet available")
+	}
+	if err := t.t.VerifyData(); err != nil {
+		return nil, err
+	}
+
+	n := t.t.NumPieces()
+	good := make([]bool, n)
+	r := &VerifyResult{InfoHash: t.InfoHash, TotalPieces: n}
+	for i := 0; i < n; i++ {
+		if t.t.PieceState(i).Complete {
+			good[i] = true
+			r.ValidPieces++
+		}
+	}
+	for _, f := range t.t.Files() {
+		fv := FileVerify{Path: f.Path()}
+		for i := f.BeginPieceIndex(); i < f.EndPieceIndex(); i++ {
+			if good[i] {
+				fv.GoodPieces++
+			} else {
+				fv.BadPieces++
+			}
+		}
+		r.Files = append(r.Files, fv)
+	}
+	return r, nil
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyReport(t *testing.T) {
+	const pieceLength = 16 << 10
+	files := map[string][]byte{
+		"a.bin": bytes.Repeat([]byte("a"), 2*pieceLength),
+		"b.bin": bytes.Repeat([]byte("b"), 2*pieceLength),
+	}
+	mi, dir := newTestMetaInfo(t, "multi", files, pieceLength)
+
+	// corrupt the second piece of b.bin
+	bad := filepath.Join(dir, "multi", "b.bin")
+	data := bytes.Clone(files["b.bin"])
+	data[pieceLength+1] = 'x'
+	if err := os.WriteFile(bad, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngineIn(t, dir)
+	tor := addTestTorrent(t, e, mi)
+
+	r, err := e.VerifyReport(tor.InfoHash)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if r.TotalPieces != 4 || r.ValidPieces != 3 {
+		t.Fatalf("expected 3/4 valid pieces, got %d/%d", r.ValidPieces, r.TotalPieces)
+	}
+	damaged := r.Damaged()
+	if len(damaged) != 1 || filepath.Base(damaged[0].Path) != "b.bin" {
+		t.Fatalf("expected only b.bin damaged, got %+v", damaged)
+	}
+	if damaged[0].GoodPieces != 1 || damaged[0].BadPieces != 1 {
+		t.Fatalf("unexpected piece counts for b.bin: %+v", damaged[0])
+	}
+
+	// a daemon's clients get the same report
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/torrents/{hash}/verify", VerifyHandler(e))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	remote, err := NewRemoteEngine(srv.URL).VerifyReportCtx(t.Context(), tor.InfoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.TotalPieces != 4 || remote.ValidPieces != 3 || len(remote.Damaged()) != 1 {
+		t.Fatalf("expected the daemon's report, got %+v", remote)
+	}
+}
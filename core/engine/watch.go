@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	// watchInterval is how often the watch directory is scanned.
+	watchInterval = 2 * time.Second
+	// watchAddedDir is the subdirectory of the watch directory that added
+	// .torrent files are moved to, so they aren't added again.
+	watchAddedDir = ".added"
+)
+
+// fileStamp tells versions of a file apart between scans.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// dirWatcher follows a Config.WatchDirectory. Files are only read once they
+// look the same in two scans in a row, so one still being written isn't
+// parsed half-way.
+type dirWatcher struct {
+	dir     string
+	stop    chan struct{}
+	seen    map[string]fileStamp // as of the last scan
+	skipped map[string]fileStamp // failed to load, until they change
+}
+
+// watchDirectory starts adding the .torrent files that appear in dir,
+// stopping the watcher of any other directory. An empty dir only stops it.
+// Called with e.mut held.
+func (e *Engine) watchDirectory(dir string) {
+	if e.watcher != nil {
+		if e.watcher.dir == dir {
+			return
+		}
+		close(e.watcher.stop)
+		e.watcher = nil
+	}
+	if dir == "" {
+		return
+	}
+	w := &dirWatcher{
+		dir:     dir,
+		stop:    make(chan struct{}),
+		seen:    map[string]fileStamp{},
+		skipped: map[string]fileStamp{},
+	}
+	e.watcher = w
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				e.scanWatchDir(w)
+			}
+		}
+	}()
+}
+
+// scanWatchDir adds the .torrent files of w.dir that haven't changed since
+// the previous scan, moving each into the .added subdirectory first, so the
+// path kept for restoring the torrent stays valid. Files that fail to load
+// are logged and left alone until they change.
+func (e *Engine) scanWatchDir(w *dirWatcher) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		log.Printf("watch directory: %v", err)
+		return
+	}
+	seen := map[string]fileStamp{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.EqualFold(filepath.Ext(name), ".torrent") {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		stamp := fileStamp{fi.Size(), fi.ModTime()}
+		if prev, ok := w.skipped[name]; ok && prev == stamp {
+			seen[name] = stamp
+			continue
+		}
+		delete(w.skipped, name)
+		if prev, ok := w.seen[name]; !ok || prev != stamp || stamp.size == 0 {
+			// new or still being written
+			seen[name] = stamp
+			continue
+		}
+		path := filepath.Join(w.dir, name)
+		if _, err := metainfo.LoadFromFile(path); err != nil {
+			log.Printf("watch directory: skipping %s: %v", name, err)
+			w.skipped[name] = stamp
+			seen[name] = stamp
+			continue
+		}
+		added, err := moveToAdded(w.dir, name)
+		if err != nil {
+			log.Printf("watch directory: %s: %v", name, err)
+			continue
+		}
+		if err := e.AddTorrentFile(added); err != nil && !errors.Is(err, ErrDuplicateTorrent) {
+			log.Printf("watch directory: failed to add %s: %v", name, err)
+		}
+	}
+	w.seen = seen
+	for name := range w.skipped {
+		if _, ok := seen[name]; !ok {
+			delete(w.skipped, name) // gone
+		}
+	}
+}
+
+// moveToAdded moves dir/name into the .added subdirectory of dir, under a
+// name of its own should an earlier file have had the same one, and returns
+// its new path.
+func moveToAdded(dir, name string) (string, error) {
+	addedDir := filepath.Join(dir, watchAddedDir)
+	if err := os.MkdirAll(addedDir, 0755); err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	dst := filepath.Join(addedDir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dst); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dst = filepath.Join(addedDir, fmt.Sprintf("%s-%d%s", base, i, filepath.Ext(name)))
+	}
+	if err := os.Rename(filepath.Join(dir, name), dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchDirectory(t *testing.T) {
+	e := newTestEngine(t)
+	dir := t.TempDir()
+	w := &dirWatcher{dir: dir, seen: map[string]fileStamp{}, skipped: map[string]fileStamp{}}
+
+	torrentFile := func(name string) []byte {
+		mi, _ := newTestMetaInfo(t, name, map[string][]byte{"a.bin": []byte(name)}, 16384)
+		var b bytes.Buffer
+		if err := mi.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	whole := torrentFile("whole")
+	partial := torrentFile("partial")
+	write("whole.torrent", whole)
+	write("partial.torrent", partial[:len(partial)/2])
+	write("broken.torrent", []byte("not bencode"))
+	write("notes.txt", []byte("ignored"))
+
+	// files are only read once they stop changing
+	e.scanWatchDir(w)
+	if n := len(e.GetTorrents()); n != 0 {
+		t.Fatalf("expected nothing added on first sight, got %d torrents", n)
+	}
+	write("partial.torrent", partial)
+	e.scanWatchDir(w)
+	if n := len(e.GetTorrents()); n != 1 {
+		t.Fatalf("expected the whole file added, got %d torrents", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, watchAddedDir, "whole.torrent")); err != nil {
+		t.Fatalf("expected the added file moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.torrent")); err != nil {
+		t.Fatalf("expected the broken file left in place: %v", err)
+	}
+	e.scanWatchDir(w)
+	if n := len(e.GetTorrents()); n != 2 {
+		t.Fatalf("expected the partial file added once complete, got %d torrents", n)
+	}
+
+	// the same name again doesn't overwrite the first
+	write("whole.torrent", torrentFile("another"))
+	e.scanWatchDir(w)
+	e.scanWatchDir(w)
+	if _, err := os.Stat(filepath.Join(dir, watchAddedDir, "whole-1.torrent")); err != nil {
+		t.Fatalf("expected the second file kept apart: %v", err)
+	}
+	if n := len(e.GetTorrents()); n != 3 {
+		t.Fatalf("expected 3 torrents, got %d", n)
+	}
+	if _, ok := w.skipped["broken.torrent"]; !ok {
+		t.Fatal("expected the broken file remembered as skipped")
+	}
+}
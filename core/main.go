@@ -13,6 +13,11 @@ const version = "0.0.1"
 func main() {
 	configPath := flag.String("config", "config.json", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version information")
+	noConfirm := flag.Bool("no-confirm", false, "Quit without confirmation while downloads are active")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [file.torrent | magnet URI | URL]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
@@ -21,7 +26,7 @@ func main() {
 		os.Exit(0)
 	}
 
-	if err := cmd.Run(*configPath, version); err != nil {
+	if err := cmd.Run(*configPath, version, flag.Args(), *noConfirm); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}